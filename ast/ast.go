@@ -40,6 +40,25 @@ type ReturnStatement struct {
 type Identifier struct {
 	Token token.Token // the token.IDENT token
 	Value string
+	// Binding is set by the resolver for variables of a function scope. It
+	// is nil for globals and in programs that have not been resolved.
+	Binding *Binding
+	// Type is the annotation of a let or parameter name, if any.
+	Type TypeExpr
+	// Default is the value of a parameter when its argument is omitted.
+	Default Expression
+}
+
+// Binding locates a resolved variable: it is in slot Slot of the function
+// scope Depth scopes out from the one it is used in.
+type Binding struct {
+	Depth int
+	Slot  int
+}
+
+// Scope lists the variables of a function by slot, parameters first.
+type Scope struct {
+	Names []string
 }
 
 type Boolean struct {
@@ -103,21 +122,24 @@ type BlockStatement struct {
 type FunctionLiteral struct {
 	Token      token.Token
 	Parameters []*Identifier
+	Variadic   bool // the last parameter collects the remaining arguments
 	Body       *BlockStatement
+	ReturnType TypeExpr // nil if not annotated
+	Scope      *Scope   // set by the resolver
 }
 
 func (fl *FunctionLiteral) ExpressionNode()      {}
 func (fl *FunctionLiteral) TokenLiteral() string { return fl.Token.Literal }
 func (fl *FunctionLiteral) String() string {
 	var out bytes.Buffer
-	params := []string{}
-	for _, p := range fl.Parameters {
-		params = append(params, p.String())
-	}
 	out.WriteString(fl.TokenLiteral())
 	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
-	out.WriteString(") ")
+	out.WriteString(ParameterList(fl.Parameters, fl.Variadic))
+	out.WriteString(")")
+	if fl.ReturnType != nil {
+		out.WriteString(": " + fl.ReturnType.String())
+	}
+	out.WriteString(" ")
 	out.WriteString(fl.Body.String())
 	return out.String()
 }
@@ -136,6 +158,9 @@ type CallExpression struct {
 	Token     token.Token
 	Function  Expression
 	Arguments []Expression
+	// Tail is set on calls whose value the calling function returns, by
+	// MarkTailCalls.
+	Tail bool
 }
 
 func (ce *CallExpression) ExpressionNode()      {}
@@ -249,7 +274,43 @@ func (ls *LetStatement) String() string {
 
 func (i *Identifier) ExpressionNode()      {}
 func (i *Identifier) TokenLiteral() string { return i.Token.Literal }
-func (i *Identifier) String() string       { return i.Value }
+func (i *Identifier) String() string {
+	out := i.Value
+	if i.Type != nil {
+		out += ": " + i.Type.String()
+	}
+	if i.Default != nil {
+		out += " = " + i.Default.String()
+	}
+	return out
+}
+
+// ParameterList returns the parameters of a function separated by commas,
+// marking the last one as a rest parameter if variadic is set.
+func ParameterList(params []*Identifier, variadic bool) string {
+	list := make([]string, len(params))
+	for i, p := range params {
+		list[i] = p.String()
+	}
+	if variadic && len(list) > 0 {
+		list[len(list)-1] = "..." + list[len(list)-1]
+	}
+	return strings.Join(list, ", ")
+}
+
+// Arity returns the least and the most number of arguments a function with
+// params accepts. The most is -1 if variadic is set.
+func Arity(params []*Identifier, variadic bool) (min, max int) {
+	for _, p := range params {
+		if p.Default == nil {
+			min++
+		}
+	}
+	if variadic {
+		return min - 1, -1
+	}
+	return min, len(params)
+}
 
 func (rs *ReturnStatement) StatementNode()       {}
 func (rs *ReturnStatement) TokenLiteral() string { return rs.Token.Literal }
@@ -270,3 +331,293 @@ func (p *Program) TokenLiteral() string {
 		return ""
 	}
 }
+
+type ArrayLiteral struct {
+	Token    token.Token // the '[' token
+	Elements []Expression
+}
+
+func (al *ArrayLiteral) ExpressionNode()      {}
+func (al *ArrayLiteral) TokenLiteral() string { return al.Token.Literal }
+func (al *ArrayLiteral) String() string {
+	var out bytes.Buffer
+	elements := []string{}
+	for _, el := range al.Elements {
+		elements = append(elements, el.String())
+	}
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+	return out.String()
+}
+
+type IndexExpression struct {
+	Token token.Token // the '[' or '?[' token
+	Left  Expression
+	Index Expression
+	// Optional is set for x?[i], which is null if x is, without evaluating
+	// the index.
+	Optional bool
+}
+
+func (ie *IndexExpression) ExpressionNode()      {}
+func (ie *IndexExpression) TokenLiteral() string { return ie.Token.Literal }
+func (ie *IndexExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("(")
+	out.WriteString(ie.Left.String())
+	if ie.Optional {
+		out.WriteString("?")
+	}
+	out.WriteString("[")
+	out.WriteString(ie.Index.String())
+	out.WriteString("])")
+	return out.String()
+}
+
+type HashLiteral struct {
+	Token token.Token // the '{' token
+	// Keys holds the keys of Pairs in source order, so that they are
+	// evaluated, and charged, deterministically.
+	Keys  []Expression
+	Pairs map[Expression]Expression
+}
+
+func (hl *HashLiteral) ExpressionNode()      {}
+func (hl *HashLiteral) TokenLiteral() string { return hl.Token.Literal }
+func (hl *HashLiteral) String() string {
+	var out bytes.Buffer
+	pairs := []string{}
+	for _, key := range hl.Keys {
+		pairs = append(pairs, key.String()+": "+hl.Pairs[key].String())
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+type NullLiteral struct {
+	Token token.Token
+}
+
+func (nl *NullLiteral) ExpressionNode()      {}
+func (nl *NullLiteral) TokenLiteral() string { return nl.Token.Literal }
+func (nl *NullLiteral) String() string       { return nl.Token.Literal }
+
+// IsNullExpression is written `x is null`.
+type IsNullExpression struct {
+	Token token.Token // the 'is' token
+	Value Expression
+}
+
+func (in *IsNullExpression) ExpressionNode()      {}
+func (in *IsNullExpression) TokenLiteral() string { return in.Token.Literal }
+func (in *IsNullExpression) String() string {
+	return "(" + in.Value.String() + " is null)"
+}
+
+// BadExpression stands in for an expression that failed to parse, so that a
+// program with syntax errors still contains no nil nodes.
+type BadExpression struct {
+	Token token.Token // the token at which parsing failed
+}
+
+func (be *BadExpression) ExpressionNode()      {}
+func (be *BadExpression) TokenLiteral() string { return be.Token.Literal }
+func (be *BadExpression) String() string       { return "<bad expression>" }
+
+// BadStatement stands in for a statement that failed to parse.
+type BadStatement struct {
+	Token token.Token // the first token of the statement
+}
+
+func (bs *BadStatement) StatementNode()       {}
+func (bs *BadStatement) TokenLiteral() string { return bs.Token.Literal }
+func (bs *BadStatement) String() string       { return "<bad statement>" }
+
+// FoldedExpression is an expression computed before running, such as by
+// constant folding. Evaluating it charges Ops, the opcodes of the operations
+// it replaces, in order, so that it costs as much gas as the original.
+type FoldedExpression struct {
+	Token token.Token // the first token of the original expression
+	Value Expression  // a literal
+	Ops   []int
+}
+
+func (fe *FoldedExpression) ExpressionNode()      {}
+func (fe *FoldedExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *FoldedExpression) String() string       { return fe.Value.String() }
+
+// ThrowStatement raises Value as an error, which the nearest enclosing try
+// can catch.
+type ThrowStatement struct {
+	Token token.Token // the 'throw' token
+	Value Expression
+}
+
+func (ts *ThrowStatement) StatementNode()       {}
+func (ts *ThrowStatement) TokenLiteral() string { return ts.Token.Literal }
+func (ts *ThrowStatement) String() string {
+	return ts.TokenLiteral() + " " + ts.Value.String() + ";"
+}
+
+// TryExpression evaluates Body and, if it fails with an error that can be
+// caught, Handler with the error bound to Catch. Finally, if any, is
+// evaluated afterwards in every case. A try has a Handler, a Finally or both.
+type TryExpression struct {
+	Token   token.Token // the 'try' token
+	Body    *BlockStatement
+	Catch   *Identifier // nil if there is no handler
+	Handler *BlockStatement
+	Finally *BlockStatement
+}
+
+func (te *TryExpression) ExpressionNode()      {}
+func (te *TryExpression) TokenLiteral() string { return te.Token.Literal }
+func (te *TryExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("try ")
+	out.WriteString(te.Body.String())
+	if te.Handler != nil {
+		out.WriteString("catch(" + te.Catch.String() + ") ")
+		out.WriteString(te.Handler.String())
+	}
+	if te.Finally != nil {
+		out.WriteString("finally ")
+		out.WriteString(te.Finally.String())
+	}
+	return out.String()
+}
+
+// StructStatement declares a struct type with the given fields, as in
+// struct Point { x, y }, and binds it to Name like a let. Fields may be
+// annotated with their types.
+type StructStatement struct {
+	Token  token.Token // the 'struct' token
+	Name   *Identifier
+	Fields []*Identifier
+}
+
+func (ss *StructStatement) StatementNode()       {}
+func (ss *StructStatement) TokenLiteral() string { return ss.Token.Literal }
+func (ss *StructStatement) String() string {
+	if len(ss.Fields) == 0 {
+		return "struct " + ss.Name.String() + " {}"
+	}
+	fields := make([]string, len(ss.Fields))
+	for i, f := range ss.Fields {
+		fields[i] = f.String()
+	}
+	return "struct " + ss.Name.String() + " { " + strings.Join(fields, ", ") + " }"
+}
+
+// StructLiteral builds a value of the struct type Name, as in
+// Point { x: 1, y: 2 }. Fields that are not given are null, or, if Base is
+// set as in Point { ...p, x: 1 }, copied from it.
+type StructLiteral struct {
+	Token  token.Token // the '{' token
+	Name   *Identifier
+	Base   Expression // nil if there is none
+	Fields []*Identifier
+	Values []Expression
+}
+
+func (sl *StructLiteral) ExpressionNode()      {}
+func (sl *StructLiteral) TokenLiteral() string { return sl.Token.Literal }
+func (sl *StructLiteral) String() string {
+	entries := []string{}
+	if sl.Base != nil {
+		entries = append(entries, "..."+sl.Base.String())
+	}
+	for i, f := range sl.Fields {
+		entries = append(entries, f.String()+": "+sl.Values[i].String())
+	}
+	return sl.Name.String() + "{" + strings.Join(entries, ", ") + "}"
+}
+
+// FieldExpression reads the field of a struct, or the string key of a hash,
+// written after a dot as in p.x.
+type FieldExpression struct {
+	Token token.Token // the '.' or '?.' token
+	Left  Expression
+	Field *Identifier
+	// Optional is set for p?.x, which is null if p is.
+	Optional bool
+}
+
+func (fe *FieldExpression) ExpressionNode()      {}
+func (fe *FieldExpression) TokenLiteral() string { return fe.Token.Literal }
+func (fe *FieldExpression) String() string {
+	dot := "."
+	if fe.Optional {
+		dot = "?."
+	}
+	return "(" + fe.Left.String() + dot + fe.Field.String() + ")"
+}
+
+// TypeExpr is a type annotation.
+type TypeExpr interface {
+	Node
+	TypeNode()
+}
+
+// NamedType is a type referred to by name, such as int.
+type NamedType struct {
+	Token token.Token // the token.IDENT token
+	Name  string
+}
+
+func (nt *NamedType) TypeNode()            {}
+func (nt *NamedType) TokenLiteral() string { return nt.Token.Literal }
+func (nt *NamedType) String() string       { return nt.Name }
+
+// ArrayType is written [elem].
+type ArrayType struct {
+	Token token.Token // the '[' token
+	Elem  TypeExpr
+}
+
+func (at *ArrayType) TypeNode()            {}
+func (at *ArrayType) TokenLiteral() string { return at.Token.Literal }
+func (at *ArrayType) String() string       { return "[" + at.Elem.String() + "]" }
+
+// HashType is written {key: value}.
+type HashType struct {
+	Token token.Token // the '{' token
+	Key   TypeExpr
+	Value TypeExpr
+}
+
+func (ht *HashType) TypeNode()            {}
+func (ht *HashType) TokenLiteral() string { return ht.Token.Literal }
+func (ht *HashType) String() string {
+	return "{" + ht.Key.String() + ": " + ht.Value.String() + "}"
+}
+
+// FunctionType is written fn(params): result, where the last parameter may
+// be prefixed by ... to accept any number of arguments of its type. Result
+// is nil if not given.
+type FunctionType struct {
+	Token      token.Token // the 'fn' token
+	Parameters []TypeExpr
+	Variadic   bool
+	Result     TypeExpr
+}
+
+func (ft *FunctionType) TypeNode()            {}
+func (ft *FunctionType) TokenLiteral() string { return ft.Token.Literal }
+func (ft *FunctionType) String() string {
+	params := []string{}
+	for _, p := range ft.Parameters {
+		params = append(params, p.String())
+	}
+	if ft.Variadic {
+		params[len(params)-1] = "..." + params[len(params)-1]
+	}
+	s := "fn(" + strings.Join(params, ", ") + ")"
+	if ft.Result != nil {
+		s += ": " + ft.Result.String()
+	}
+	return s
+}
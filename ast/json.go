@@ -0,0 +1,768 @@
+package ast
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// EncodingVersion is the version of the JSON encoding written by Encode. It
+// changes whenever the encoding of a node does, and Decode rejects trees
+// encoded by any other version rather than misread them.
+const EncodingVersion = 1
+
+// Encode returns the JSON encoding of the tree rooted at node, tokens and
+// their positions included. The encoding is canonical, equal trees having
+// equal encodings, so it can be hashed to identify a program. Resolver
+// annotations are not encoded, nor which calls are tail calls, which Decode
+// marks again.
+//
+// Every node is an object whose "kind" is the name of its type, with its
+// children, and its token if it has one, as further members.
+func Encode(node Node) ([]byte, error) {
+	e := &encoder{}
+	encoded := e.node(node)
+	if e.err != nil {
+		return nil, e.err
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(envelope{Version: EncodingVersion, Node: encoded}); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// Decode returns the tree encoded by Encode in data.
+func Decode(data []byte) (Node, error) {
+	var env struct {
+		Version int             `json:"version"`
+		Node    json.RawMessage `json:"node"`
+	}
+	if err := json.Unmarshal(data, &env); err != nil {
+		return nil, fmt.Errorf("ast: %v", err)
+	}
+	if env.Version != EncodingVersion {
+		return nil, fmt.Errorf("ast: unsupported encoding version %d, want %d", env.Version, EncodingVersion)
+	}
+	if len(env.Node) == 0 {
+		return nil, fmt.Errorf("ast: no node encoded")
+	}
+	d := &decoder{}
+	node := d.node(env.Node)
+	if d.err != nil {
+		return nil, d.err
+	}
+	return node, nil
+}
+
+// DecodeProgram returns the program encoded by Encode in data.
+func DecodeProgram(data []byte) (*Program, error) {
+	node, err := Decode(data)
+	if err != nil {
+		return nil, err
+	}
+	program, ok := node.(*Program)
+	if !ok {
+		return nil, fmt.Errorf("ast: expected a Program, got %s", kindOf(node))
+	}
+	return program, nil
+}
+
+type envelope struct {
+	Version int        `json:"version"`
+	Node    jsonObject `json:"node"`
+}
+
+// jsonObject is an encoded node. Its members are sorted when marshaled,
+// which makes the encoding canonical.
+type jsonObject map[string]interface{}
+
+// set adds a member for a child, leaving out those a node does not have.
+func (o jsonObject) set(key string, value interface{}) {
+	if value != nil {
+		o[key] = value
+	}
+}
+
+type jsonToken struct {
+	Type    token.TokenType `json:"type"`
+	Literal string          `json:"literal"`
+	Pos     [3]int          `json:"pos"` // offset, line and column
+	End     [3]int          `json:"end"`
+}
+
+func kindOf(node Node) string {
+	return strings.TrimPrefix(fmt.Sprintf("%T", node), "*ast.")
+}
+
+type encoder struct {
+	err error
+}
+
+func (e *encoder) node(node Node) jsonObject {
+	o := jsonObject{"kind": kindOf(node)}
+	switch n := node.(type) {
+	case *Program:
+		o["statements"] = e.statements(n.Statements)
+	case *BlockStatement:
+		o.token(n.Token)
+		o["statements"] = e.statements(n.Statements)
+
+	// Statements
+	case *LetStatement:
+		o.token(n.Token)
+		o.set("name", e.ident(n.Name))
+		o.set("value", e.expression(n.Value))
+	case *ReturnStatement:
+		o.token(n.Token)
+		o.set("value", e.expression(n.ReturnValue))
+	case *ThrowStatement:
+		o.token(n.Token)
+		o.set("value", e.expression(n.Value))
+	case *ExpressionStatement:
+		o.token(n.Token)
+		o.set("expression", e.expression(n.Expression))
+	case *StructStatement:
+		o.token(n.Token)
+		o.set("name", e.ident(n.Name))
+		o["fields"] = e.idents(n.Fields)
+	case *BadStatement:
+		o.token(n.Token)
+
+	// Expressions
+	case *Identifier:
+		o.token(n.Token)
+		o["value"] = n.Value
+		o.set("type", e.typ(n.Type))
+		o.set("default", e.expression(n.Default))
+	case *IntegerLiteral:
+		o.token(n.Token)
+		o["value"] = n.Value
+	case *StringLiteral:
+		o.token(n.Token)
+		o["value"] = n.Value
+	case *Boolean:
+		o.token(n.Token)
+		o["value"] = n.Value
+	case *NullLiteral:
+		o.token(n.Token)
+	case *BadExpression:
+		o.token(n.Token)
+	case *FoldedExpression:
+		o.token(n.Token)
+		o.set("value", e.expression(n.Value))
+		o["ops"] = append([]int{}, n.Ops...)
+	case *PrefixExpression:
+		o.token(n.Token)
+		o["operator"] = n.Operator
+		o.set("right", e.expression(n.Right))
+	case *InfixExpression:
+		o.token(n.Token)
+		o["operator"] = n.Operator
+		o.set("left", e.expression(n.Left))
+		o.set("right", e.expression(n.Right))
+	case *IsNullExpression:
+		o.token(n.Token)
+		o.set("value", e.expression(n.Value))
+	case *IfExpression:
+		o.token(n.Token)
+		o.set("condition", e.expression(n.Condition))
+		o.set("consequence", e.block(n.Consequence))
+		o.set("alternative", e.block(n.Alternative))
+	case *ForExpression:
+		o.token(n.Token)
+		o["variable"] = e.node(&n.Variable)
+		o.set("condition", e.expression(n.Condition))
+		o["update"] = e.node(&n.Update)
+		o.set("loop", e.block(n.Loop))
+	case *TryExpression:
+		o.token(n.Token)
+		o.set("body", e.block(n.Body))
+		o.set("catch", e.ident(n.Catch))
+		o.set("handler", e.block(n.Handler))
+		o.set("finally", e.block(n.Finally))
+	case *MatchExpression:
+		o.token(n.Token)
+		o.set("subject", e.expression(n.Subject))
+		arms := []interface{}{}
+		for _, arm := range n.Arms {
+			a := jsonObject{}
+			a.set("pattern", e.pattern(arm.Pattern))
+			a.set("guard", e.expression(arm.Guard))
+			a.set("value", e.expression(arm.Value))
+			a.set("body", e.block(arm.Body))
+			arms = append(arms, a)
+		}
+		o["arms"] = arms
+	case *FunctionLiteral:
+		o.token(n.Token)
+		o["parameters"] = e.idents(n.Parameters)
+		o["variadic"] = n.Variadic
+		o.set("returnType", e.typ(n.ReturnType))
+		o.set("body", e.block(n.Body))
+	case *MacroLiteral:
+		o.token(n.Token)
+		o["parameters"] = e.idents(n.Parameters)
+		o.set("body", e.block(n.Body))
+	case *CallExpression:
+		o.token(n.Token)
+		o.set("function", e.expression(n.Function))
+		o["arguments"] = e.expressions(n.Arguments)
+	case *ArrayLiteral:
+		o.token(n.Token)
+		o["elements"] = e.expressions(n.Elements)
+	case *IndexExpression:
+		o.token(n.Token)
+		o.set("left", e.expression(n.Left))
+		o.set("index", e.expression(n.Index))
+		o["optional"] = n.Optional
+	case *HashLiteral:
+		o.token(n.Token)
+		pairs := []interface{}{}
+		for _, key := range n.Keys {
+			pairs = append(pairs, []interface{}{e.expression(key), e.expression(n.Pairs[key])})
+		}
+		o["pairs"] = pairs
+	case *StructLiteral:
+		o.token(n.Token)
+		o.set("name", e.ident(n.Name))
+		o.set("base", e.expression(n.Base))
+		o["fields"] = e.idents(n.Fields)
+		o["values"] = e.expressions(n.Values)
+	case *FieldExpression:
+		o.token(n.Token)
+		o.set("left", e.expression(n.Left))
+		o.set("field", e.ident(n.Field))
+		o["optional"] = n.Optional
+
+	// Patterns
+	case *LiteralPattern:
+		o.set("value", e.expression(n.Value))
+	case *WildcardPattern:
+		o.token(n.Token)
+	case *BindingPattern:
+		o.set("name", e.ident(n.Name))
+	case *ArrayPattern:
+		o.token(n.Token)
+		elements := []interface{}{}
+		for _, el := range n.Elements {
+			elements = append(elements, e.pattern(el))
+		}
+		o["elements"] = elements
+		o.set("rest", e.ident(n.Rest))
+	case *HashPattern:
+		o.token(n.Token)
+		o["keys"] = e.expressions(n.Keys)
+		values := []interface{}{}
+		for _, v := range n.Values {
+			values = append(values, e.pattern(v))
+		}
+		o["values"] = values
+
+	// Types
+	case *NamedType:
+		o.token(n.Token)
+		o["name"] = n.Name
+	case *ArrayType:
+		o.token(n.Token)
+		o.set("elem", e.typ(n.Elem))
+	case *HashType:
+		o.token(n.Token)
+		o.set("key", e.typ(n.Key))
+		o.set("value", e.typ(n.Value))
+	case *FunctionType:
+		o.token(n.Token)
+		params := []interface{}{}
+		for _, param := range n.Parameters {
+			params = append(params, e.typ(param))
+		}
+		o["parameters"] = params
+		o["variadic"] = n.Variadic
+		o.set("result", e.typ(n.Result))
+
+	default:
+		if e.err == nil {
+			e.err = fmt.Errorf("ast: cannot encode %T", node)
+		}
+	}
+	return o
+}
+
+func (o jsonObject) token(tok token.Token) {
+	o["token"] = jsonToken{
+		Type:    tok.Type,
+		Literal: tok.Literal,
+		Pos:     [3]int{tok.Pos.Offset, tok.Pos.Line, tok.Pos.Column},
+		End:     [3]int{tok.End.Offset, tok.End.Line, tok.End.Column},
+	}
+}
+
+// The encoder helpers return nil for the children a node does not have, so
+// that set leaves them out.
+
+func (e *encoder) statements(stmts []Statement) []interface{} {
+	encoded := []interface{}{}
+	for _, stmt := range stmts {
+		encoded = append(encoded, e.node(stmt))
+	}
+	return encoded
+}
+
+func (e *encoder) expression(exp Expression) interface{} {
+	if exp == nil {
+		return nil
+	}
+	return e.node(exp)
+}
+
+func (e *encoder) expressions(exps []Expression) []interface{} {
+	encoded := []interface{}{}
+	for _, exp := range exps {
+		encoded = append(encoded, e.expression(exp))
+	}
+	return encoded
+}
+
+func (e *encoder) ident(ident *Identifier) interface{} {
+	if ident == nil {
+		return nil
+	}
+	return e.node(ident)
+}
+
+func (e *encoder) idents(idents []*Identifier) []interface{} {
+	encoded := []interface{}{}
+	for _, ident := range idents {
+		encoded = append(encoded, e.ident(ident))
+	}
+	return encoded
+}
+
+func (e *encoder) block(block *BlockStatement) interface{} {
+	if block == nil {
+		return nil
+	}
+	return e.node(block)
+}
+
+func (e *encoder) pattern(p Pattern) interface{} {
+	if p == nil {
+		return nil
+	}
+	return e.node(p)
+}
+
+func (e *encoder) typ(typ TypeExpr) interface{} {
+	if typ == nil {
+		return nil
+	}
+	return e.node(typ)
+}
+
+// decoder keeps the first error it runs into, after which its methods
+// return zero values.
+type decoder struct {
+	err error
+}
+
+func (d *decoder) errorf(format string, a ...interface{}) {
+	if d.err == nil {
+		d.err = fmt.Errorf("ast: "+format, a...)
+	}
+}
+
+// members are the members of an encoded node.
+type members map[string]json.RawMessage
+
+// has reports whether the child key is present.
+func (m members) has(key string) bool {
+	raw, ok := m[key]
+	return ok && string(raw) != "null"
+}
+
+func (d *decoder) value(raw json.RawMessage, v interface{}) {
+	if d.err != nil || len(raw) == 0 {
+		return
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		d.errorf("%v", err)
+	}
+}
+
+func (d *decoder) node(raw json.RawMessage) Node {
+	if d.err != nil {
+		return nil
+	}
+	var m members
+	if d.value(raw, &m); d.err != nil {
+		return nil
+	}
+	var kind string
+	d.value(m["kind"], &kind)
+	switch kind {
+	case "Program":
+		return &Program{Statements: d.statements(m, "statements")}
+	case "BlockStatement":
+		return &BlockStatement{Token: d.token(m), Statements: d.statements(m, "statements")}
+
+	// Statements
+	case "LetStatement":
+		return d.let(m)
+	case "ReturnStatement":
+		return &ReturnStatement{Token: d.token(m), ReturnValue: d.expression(m, "value", true)}
+	case "ThrowStatement":
+		return &ThrowStatement{Token: d.token(m), Value: d.expression(m, "value", true)}
+	case "ExpressionStatement":
+		return &ExpressionStatement{Token: d.token(m), Expression: d.expression(m, "expression", true)}
+	case "StructStatement":
+		return &StructStatement{Token: d.token(m), Name: d.ident(m, "name", true), Fields: d.idents(m, "fields")}
+	case "BadStatement":
+		return &BadStatement{Token: d.token(m)}
+
+	// Expressions
+	case "Identifier":
+		ident := &Identifier{Token: d.token(m), Type: d.typ(m, "type", false), Default: d.expression(m, "default", false)}
+		d.value(m["value"], &ident.Value)
+		return ident
+	case "IntegerLiteral":
+		lit := &IntegerLiteral{Token: d.token(m)}
+		d.value(m["value"], &lit.Value)
+		return lit
+	case "StringLiteral":
+		lit := &StringLiteral{Token: d.token(m)}
+		d.value(m["value"], &lit.Value)
+		return lit
+	case "Boolean":
+		lit := &Boolean{Token: d.token(m)}
+		d.value(m["value"], &lit.Value)
+		return lit
+	case "NullLiteral":
+		return &NullLiteral{Token: d.token(m)}
+	case "BadExpression":
+		return &BadExpression{Token: d.token(m)}
+	case "FoldedExpression":
+		folded := &FoldedExpression{Token: d.token(m), Value: d.expression(m, "value", true)}
+		d.value(m["ops"], &folded.Ops)
+		return folded
+	case "PrefixExpression":
+		exp := &PrefixExpression{Token: d.token(m), Right: d.expression(m, "right", true)}
+		d.value(m["operator"], &exp.Operator)
+		return exp
+	case "InfixExpression":
+		exp := &InfixExpression{Token: d.token(m), Left: d.expression(m, "left", true), Right: d.expression(m, "right", true)}
+		d.value(m["operator"], &exp.Operator)
+		return exp
+	case "IsNullExpression":
+		return &IsNullExpression{Token: d.token(m), Value: d.expression(m, "value", true)}
+	case "IfExpression":
+		return &IfExpression{
+			Token:       d.token(m),
+			Condition:   d.expression(m, "condition", true),
+			Consequence: d.block(m, "consequence", true),
+			Alternative: d.block(m, "alternative", false),
+		}
+	case "ForExpression":
+		exp := &ForExpression{Token: d.token(m), Condition: d.expression(m, "condition", true), Loop: d.block(m, "loop", true)}
+		if variable := d.forLet(m, "variable"); variable != nil {
+			exp.Variable = *variable
+		}
+		if update := d.forLet(m, "update"); update != nil {
+			exp.Update = *update
+		}
+		return exp
+	case "TryExpression":
+		return &TryExpression{
+			Token:   d.token(m),
+			Body:    d.block(m, "body", true),
+			Catch:   d.ident(m, "catch", false),
+			Handler: d.block(m, "handler", false),
+			Finally: d.block(m, "finally", false),
+		}
+	case "MatchExpression":
+		exp := &MatchExpression{Token: d.token(m), Subject: d.expression(m, "subject", true)}
+		for _, a := range d.list(m, "arms") {
+			var am members
+			d.value(a, &am)
+			arm := &MatchArm{
+				Pattern: d.pattern(am, "pattern"),
+				Guard:   d.expression(am, "guard", false),
+				Value:   d.expression(am, "value", false),
+				Body:    d.block(am, "body", false),
+			}
+			if (arm.Value == nil) == (arm.Body == nil) && d.err == nil {
+				d.errorf("a match arm needs either a value or a body")
+			}
+			exp.Arms = append(exp.Arms, arm)
+		}
+		return exp
+	case "FunctionLiteral":
+		fn := &FunctionLiteral{
+			Token:      d.token(m),
+			Parameters: d.idents(m, "parameters"),
+			ReturnType: d.typ(m, "returnType", false),
+			Body:       d.block(m, "body", true),
+		}
+		d.value(m["variadic"], &fn.Variadic)
+		// Which calls are tail calls follows from the body, and is not
+		// taken from the encoding, which could claim any call is one.
+		MarkTailCalls(fn)
+		return fn
+	case "MacroLiteral":
+		return &MacroLiteral{Token: d.token(m), Parameters: d.idents(m, "parameters"), Body: d.block(m, "body", true)}
+	case "CallExpression":
+		return &CallExpression{Token: d.token(m), Function: d.expression(m, "function", true), Arguments: d.expressions(m, "arguments")}
+	case "ArrayLiteral":
+		return &ArrayLiteral{Token: d.token(m), Elements: d.expressions(m, "elements")}
+	case "IndexExpression":
+		exp := &IndexExpression{Token: d.token(m), Left: d.expression(m, "left", true), Index: d.expression(m, "index", true)}
+		d.value(m["optional"], &exp.Optional)
+		return exp
+	case "HashLiteral":
+		hash := &HashLiteral{Token: d.token(m), Pairs: map[Expression]Expression{}}
+		for _, p := range d.list(m, "pairs") {
+			var pair []json.RawMessage
+			if d.value(p, &pair); len(pair) != 2 {
+				d.errorf("a hash pair must have a key and a value")
+				return nil
+			}
+			key, value := d.expressionOf(pair[0]), d.expressionOf(pair[1])
+			hash.Keys = append(hash.Keys, key)
+			hash.Pairs[key] = value
+		}
+		return hash
+	case "StructLiteral":
+		lit := &StructLiteral{
+			Token:  d.token(m),
+			Name:   d.ident(m, "name", true),
+			Base:   d.expression(m, "base", false),
+			Fields: d.idents(m, "fields"),
+			Values: d.expressions(m, "values"),
+		}
+		if len(lit.Fields) != len(lit.Values) {
+			d.errorf("a struct literal needs a value for each field")
+		}
+		return lit
+	case "FieldExpression":
+		exp := &FieldExpression{Token: d.token(m), Left: d.expression(m, "left", true), Field: d.ident(m, "field", true)}
+		d.value(m["optional"], &exp.Optional)
+		return exp
+
+	// Patterns
+	case "LiteralPattern":
+		return &LiteralPattern{Value: d.expression(m, "value", true)}
+	case "WildcardPattern":
+		return &WildcardPattern{Token: d.token(m)}
+	case "BindingPattern":
+		return &BindingPattern{Name: d.ident(m, "name", true)}
+	case "ArrayPattern":
+		p := &ArrayPattern{Token: d.token(m), Rest: d.ident(m, "rest", false)}
+		for _, el := range d.list(m, "elements") {
+			p.Elements = append(p.Elements, d.patternOf(el))
+		}
+		return p
+	case "HashPattern":
+		p := &HashPattern{Token: d.token(m), Keys: d.expressions(m, "keys")}
+		for _, v := range d.list(m, "values") {
+			p.Values = append(p.Values, d.patternOf(v))
+		}
+		if len(p.Keys) != len(p.Values) {
+			d.errorf("a hash pattern needs a pattern for each key")
+		}
+		return p
+
+	// Types
+	case "NamedType":
+		typ := &NamedType{Token: d.token(m)}
+		d.value(m["name"], &typ.Name)
+		return typ
+	case "ArrayType":
+		return &ArrayType{Token: d.token(m), Elem: d.typ(m, "elem", true)}
+	case "HashType":
+		return &HashType{Token: d.token(m), Key: d.typ(m, "key", true), Value: d.typ(m, "value", true)}
+	case "FunctionType":
+		typ := &FunctionType{Token: d.token(m), Result: d.typ(m, "result", false)}
+		for _, param := range d.list(m, "parameters") {
+			typ.Parameters = append(typ.Parameters, d.typeOf(param))
+		}
+		d.value(m["variadic"], &typ.Variadic)
+		if typ.Variadic && len(typ.Parameters) == 0 {
+			d.errorf("a variadic function type needs a parameter")
+		}
+		return typ
+	}
+	d.errorf("unknown node kind %q", kind)
+	return nil
+}
+
+func (d *decoder) token(m members) token.Token {
+	var t jsonToken
+	if !m.has("token") {
+		d.errorf("%s has no token", m.kind())
+	}
+	d.value(m["token"], &t)
+	return token.Token{
+		Type:    t.Type,
+		Literal: t.Literal,
+		Pos:     token.Position{Offset: t.Pos[0], Line: t.Pos[1], Column: t.Pos[2]},
+		End:     token.Position{Offset: t.End[0], Line: t.End[1], Column: t.End[2]},
+	}
+}
+
+// kind returns the kind of an encoded node, for errors.
+func (m members) kind() string {
+	var kind string
+	json.Unmarshal(m["kind"], &kind)
+	return kind
+}
+
+// child decodes the child key of an encoded node, which must be present if
+// required. It returns nil if the child is absent.
+func (d *decoder) child(m members, key string, required bool) Node {
+	if !m.has(key) {
+		if required {
+			d.errorf("%s has no %s", m.kind(), key)
+		}
+		return nil
+	}
+	return d.node(m[key])
+}
+
+func (d *decoder) list(m members, key string) []json.RawMessage {
+	var list []json.RawMessage
+	d.value(m[key], &list)
+	return list
+}
+
+func (d *decoder) let(m members) *LetStatement {
+	return &LetStatement{Token: d.token(m), Name: d.ident(m, "name", true), Value: d.expression(m, "value", true)}
+}
+
+func (d *decoder) forLet(m members, key string) *LetStatement {
+	let, ok := d.child(m, key, true).(*LetStatement)
+	if !ok {
+		d.errorf("the %s of a ForExpression must be a LetStatement", key)
+	}
+	return let
+}
+
+func (d *decoder) statements(m members, key string) []Statement {
+	var stmts []Statement
+	for _, raw := range d.list(m, key) {
+		node := d.node(raw)
+		stmt, ok := node.(Statement)
+		if !ok {
+			d.errorf("expected a statement, got %s", kindOf(node))
+		}
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
+
+func (d *decoder) expression(m members, key string, required bool) Expression {
+	node := d.child(m, key, required)
+	if node == nil {
+		return nil
+	}
+	return d.asExpression(node)
+}
+
+func (d *decoder) expressionOf(raw json.RawMessage) Expression {
+	return d.asExpression(d.node(raw))
+}
+
+func (d *decoder) asExpression(node Node) Expression {
+	exp, ok := node.(Expression)
+	if !ok {
+		d.errorf("expected an expression, got %s", kindOf(node))
+	}
+	return exp
+}
+
+func (d *decoder) expressions(m members, key string) []Expression {
+	var exps []Expression
+	for _, raw := range d.list(m, key) {
+		exps = append(exps, d.expressionOf(raw))
+	}
+	return exps
+}
+
+func (d *decoder) ident(m members, key string, required bool) *Identifier {
+	node := d.child(m, key, required)
+	if node == nil {
+		return nil
+	}
+	return d.asIdent(node)
+}
+
+func (d *decoder) asIdent(node Node) *Identifier {
+	ident, ok := node.(*Identifier)
+	if !ok {
+		d.errorf("expected an Identifier, got %s", kindOf(node))
+	}
+	return ident
+}
+
+func (d *decoder) idents(m members, key string) []*Identifier {
+	var idents []*Identifier
+	for _, raw := range d.list(m, key) {
+		idents = append(idents, d.asIdent(d.node(raw)))
+	}
+	return idents
+}
+
+func (d *decoder) block(m members, key string, required bool) *BlockStatement {
+	node := d.child(m, key, required)
+	if node == nil {
+		return nil
+	}
+	block, ok := node.(*BlockStatement)
+	if !ok {
+		d.errorf("expected a BlockStatement, got %s", kindOf(node))
+	}
+	return block
+}
+
+func (d *decoder) pattern(m members, key string) Pattern {
+	node := d.child(m, key, true)
+	if node == nil {
+		return nil
+	}
+	return d.asPattern(node)
+}
+
+func (d *decoder) patternOf(raw json.RawMessage) Pattern {
+	return d.asPattern(d.node(raw))
+}
+
+func (d *decoder) asPattern(node Node) Pattern {
+	p, ok := node.(Pattern)
+	if !ok {
+		d.errorf("expected a pattern, got %s", kindOf(node))
+	}
+	return p
+}
+
+func (d *decoder) typ(m members, key string, required bool) TypeExpr {
+	node := d.child(m, key, required)
+	if node == nil {
+		return nil
+	}
+	return d.asType(node)
+}
+
+func (d *decoder) typeOf(raw json.RawMessage) TypeExpr {
+	return d.asType(d.node(raw))
+}
+
+func (d *decoder) asType(node Node) TypeExpr {
+	typ, ok := node.(TypeExpr)
+	if !ok {
+		d.errorf("expected a type, got %s", kindOf(node))
+	}
+	return typ
+}
@@ -0,0 +1,130 @@
+package ast_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/format"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+var encodeTests = append([]string{
+	`let add = fn(a: int, b: int = 2, ...rest: [int]): int { a + b };`,
+	`let h: {string: fn(int, ...bool): [int]} = {"a": 1}; h?["a"] ?? null`,
+	`struct P { x }; let p = P { x: 1 }; p?.x ?? p.x`,
+	`let m = macro(x) { quote(unquote(x) + 1) }; m(2)`,
+	`match (x) { -1 => "neg", [a, ...r] => a, {"k": v} if v => v, true => { null }, _ => 0 }`,
+	`let f = fn(n) { if (n < 1) { n } else { f(n - 1) } };`,
+	`let s = "<a b>";`,
+}, rewriteTests...)
+
+func TestEncodeRoundTrip(t *testing.T) {
+	for _, input := range encodeTests {
+		program := parse(t, input)
+		data, err := ast.Encode(program)
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		decoded, err := ast.DecodeProgram(data)
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if got, expected := format.Node(decoded), format.Node(program); got != expected {
+			t.Errorf("%q: wrong tree decoded. expected=%q, got=%q", input, expected, got)
+		}
+		again, err := ast.Encode(decoded)
+		if err != nil {
+			t.Fatalf("%q: %v", input, err)
+		}
+		if string(again) != string(data) {
+			t.Errorf("%q: decoded tree encodes differently.\nfirst= %s\nagain= %s", input, data, again)
+		}
+	}
+}
+
+func TestEncodedForm(t *testing.T) {
+	data, err := ast.Encode(parse(t, `-x`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"version":1,"node":{"kind":"Program","statements":[{"expression":{"kind":"PrefixExpression",` +
+		`"operator":"-","right":{"kind":"Identifier","token":{"type":"IDENT","literal":"x","pos":[1,1,2],"end":[2,1,3]},"value":"x"},` +
+		`"token":{"type":"-","literal":"-","pos":[0,1,1],"end":[1,1,2]}},` +
+		`"kind":"ExpressionStatement","token":{"type":"-","literal":"-","pos":[0,1,1],"end":[1,1,2]}}]}}`
+	if string(data) != expected {
+		t.Errorf("wrong encoding.\nexpected=%s\ngot=     %s", expected, data)
+	}
+}
+
+func TestDecodeKeepsPositions(t *testing.T) {
+	program := parse(t, "let x = 1;\nlet y = x;")
+	data, err := ast.Encode(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := ast.DecodeProgram(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ident := decoded.Statements[1].(*ast.LetStatement).Value.(*ast.Identifier)
+	expected := token.Token{Type: token.IDENT, Literal: "x",
+		Pos: token.Position{Offset: 19, Line: 2, Column: 9}, End: token.Position{Offset: 20, Line: 2, Column: 10}}
+	if ident.Token != expected {
+		t.Errorf("wrong token. expected=%+v, got=%+v", expected, ident.Token)
+	}
+}
+
+func TestDecodeMarksTailCalls(t *testing.T) {
+	data, err := ast.Encode(parse(t, `let f = fn(n) { g(n); f(n - 1) };`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// An encoding claiming every call is a tail call, with the member older
+	// encodings had, is not believed.
+	forged := strings.ReplaceAll(string(data), `"tail":false`, `"tail":true`)
+	forged = strings.ReplaceAll(forged, `"arguments":`, `"tail":true,"arguments":`)
+	decoded, err := ast.DecodeProgram([]byte(forged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := decoded.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Body
+	for i, expected := range []bool{false, true} {
+		call := body.Statements[i].(*ast.ExpressionStatement).Expression.(*ast.CallExpression)
+		if call.Tail != expected {
+			t.Errorf("call %d: wrong tail. expected=%t, got=%t", i, expected, call.Tail)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	tok := `"token":{"type":"INT","literal":"1","pos":[0,1,1],"end":[1,1,2]}`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{`, "unexpected end of JSON input"},
+		{`{"version":2,"node":{"kind":"Program","statements":[]}}`, "unsupported encoding version 2, want 1"},
+		{`{"version":1}`, "no node encoded"},
+		{`{"version":1,"node":{"kind":"Program","statements":[{"kind":"Loop"}]}}`, `unknown node kind "Loop"`},
+		{`{"version":1,"node":{"kind":"Program","statements":[{"kind":"ReturnStatement",` + tok + `}]}}`,
+			"ReturnStatement has no value"},
+		{`{"version":1,"node":{"kind":"Program","statements":[{"kind":"IntegerLiteral",` + tok + `,"value":1}]}}`,
+			"expected a statement, got IntegerLiteral"},
+		{`{"version":1,"node":{"kind":"ArrayLiteral",` + tok + `,"elements":[{"kind":"BadStatement",` + tok + `}]}}`,
+			"expected an expression, got BadStatement"},
+		{`{"version":1,"node":{"kind":"IntegerLiteral","value":1}}`, "IntegerLiteral has no token"},
+		{`{"version":1,"node":{"kind":"IntegerLiteral",` + tok + `,"value":"one"}}`, "cannot unmarshal string"},
+		{`{"version":1,"node":{"kind":"IntegerLiteral",` + tok + `,"value":1}}`, "expected a Program, got IntegerLiteral"},
+	}
+	for _, tt := range tests {
+		_, err := ast.DecodeProgram([]byte(tt.input))
+		if err == nil {
+			t.Errorf("%s: no error", tt.input)
+			continue
+		}
+		if !strings.HasPrefix(err.Error(), "ast: ") || !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: wrong error. expected=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// MacroLiteral is written like a function literal, with macro instead of
+// fn. Macros bound by a let at the top level of a program are called while
+// expanding it, before it runs, with their arguments quoted, and the call
+// is replaced by the quote they return.
+type MacroLiteral struct {
+	Token      token.Token // the 'macro' token
+	Parameters []*Identifier
+	Body       *BlockStatement
+}
+
+func (ml *MacroLiteral) ExpressionNode()      {}
+func (ml *MacroLiteral) TokenLiteral() string { return ml.Token.Literal }
+func (ml *MacroLiteral) String() string {
+	var out bytes.Buffer
+	params := []string{}
+	for _, p := range ml.Parameters {
+		params = append(params, p.String())
+	}
+	out.WriteString(ml.TokenLiteral())
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	out.WriteString(ml.Body.String())
+	return out.String()
+}
+
+// IsCallTo reports whether node calls the function named name, as quote(x)
+// and unquote(x) do.
+func IsCallTo(node Node, name string) (*CallExpression, bool) {
+	call, ok := node.(*CallExpression)
+	if !ok {
+		return nil, false
+	}
+	ident, ok := call.Function.(*Identifier)
+	return call, ok && ident.Value == name
+}
+
+// Unquotes returns the arguments of the unquote calls in the quoted tree
+// node, which are all of it that is evaluated when it is quoted.
+func Unquotes(node Node) []Expression {
+	var exps []Expression
+	Inspect(node, func(n Node) bool {
+		if call, ok := IsCallTo(n, "unquote"); ok {
+			exps = append(exps, call.Arguments...)
+			return false
+		}
+		return true
+	})
+	return exps
+}
@@ -0,0 +1,159 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// MatchExpression evaluates to the value of the first arm whose pattern
+// matches Subject and whose guard, if any, holds. As with let, the names a
+// pattern binds are bound in the enclosing function, before the guard is
+// evaluated.
+type MatchExpression struct {
+	Token   token.Token // the 'match' token
+	Subject Expression
+	Arms    []*MatchArm
+}
+
+// MatchArm is written pattern => value, or pattern if guard => value. The
+// value is either an expression or a block.
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression // nil if there is none
+	Value   Expression // nil if Body is set
+	Body    *BlockStatement
+}
+
+func (me *MatchExpression) ExpressionNode()      {}
+func (me *MatchExpression) TokenLiteral() string { return me.Token.Literal }
+func (me *MatchExpression) String() string {
+	var out bytes.Buffer
+	out.WriteString("match (" + me.Subject.String() + ") {")
+	for i, arm := range me.Arms {
+		if i > 0 {
+			out.WriteString(",")
+		}
+		out.WriteString(" " + arm.String())
+	}
+	out.WriteString(" }")
+	return out.String()
+}
+
+func (ma *MatchArm) String() string {
+	s := ma.Pattern.String()
+	if ma.Guard != nil {
+		s += " if " + ma.Guard.String()
+	}
+	if ma.Body != nil {
+		return s + " => { " + ma.Body.String() + " }"
+	}
+	return s + " => " + ma.Value.String()
+}
+
+// Pattern is the left-hand side of a match arm.
+type Pattern interface {
+	Node
+	PatternNode()
+}
+
+// LiteralPattern matches values equal to an integer, string, boolean or null
+// literal.
+type LiteralPattern struct {
+	Value Expression
+}
+
+func (lp *LiteralPattern) PatternNode()         {}
+func (lp *LiteralPattern) TokenLiteral() string { return lp.Value.TokenLiteral() }
+func (lp *LiteralPattern) String() string {
+	if s, ok := lp.Value.(*StringLiteral); ok {
+		return `"` + s.Value + `"`
+	}
+	return lp.Value.String()
+}
+
+// WildcardPattern, written _, matches any value.
+type WildcardPattern struct {
+	Token token.Token // the '_' token
+}
+
+func (wp *WildcardPattern) PatternNode()         {}
+func (wp *WildcardPattern) TokenLiteral() string { return wp.Token.Literal }
+func (wp *WildcardPattern) String() string       { return "_" }
+
+// BindingPattern matches any value and binds Name to it.
+type BindingPattern struct {
+	Name *Identifier
+}
+
+func (bp *BindingPattern) PatternNode()         {}
+func (bp *BindingPattern) TokenLiteral() string { return bp.Name.TokenLiteral() }
+func (bp *BindingPattern) String() string       { return bp.Name.String() }
+
+// ArrayPattern matches arrays whose elements match Elements. Without Rest
+// the lengths must be equal; with it, as in [x, ...rest], Rest is bound to
+// an array of the remaining elements.
+type ArrayPattern struct {
+	Token    token.Token // the '[' token
+	Elements []Pattern
+	Rest     *Identifier // nil if there is none
+}
+
+func (ap *ArrayPattern) PatternNode()         {}
+func (ap *ArrayPattern) TokenLiteral() string { return ap.Token.Literal }
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, el := range ap.Elements {
+		elements = append(elements, el.String())
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern matches hashes that have each of Keys, which are literals,
+// with a value matching the pattern at the same index of Values. Other keys
+// are ignored.
+type HashPattern struct {
+	Token  token.Token // the '{' token
+	Keys   []Expression
+	Values []Pattern
+}
+
+func (hp *HashPattern) PatternNode()         {}
+func (hp *HashPattern) TokenLiteral() string { return hp.Token.Literal }
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for i, key := range hp.Keys {
+		pairs = append(pairs, (&LiteralPattern{Value: key}).String()+": "+hp.Values[i].String())
+	}
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+// PatternBindings returns the names p binds, in order. A rest written
+// ..._ binds nothing.
+func PatternBindings(p Pattern) []*Identifier {
+	var names []*Identifier
+	var walk func(Pattern)
+	walk = func(p Pattern) {
+		switch p := p.(type) {
+		case *BindingPattern:
+			names = append(names, p.Name)
+		case *ArrayPattern:
+			for _, el := range p.Elements {
+				walk(el)
+			}
+			if p.Rest != nil && p.Rest.Value != "_" {
+				names = append(names, p.Rest)
+			}
+		case *HashPattern:
+			for _, v := range p.Values {
+				walk(v)
+			}
+		}
+	}
+	walk(p)
+	return names
+}
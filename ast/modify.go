@@ -0,0 +1,27 @@
+package ast
+
+// ModifierFunc returns the node that replaces node.
+type ModifierFunc func(Node) Node
+
+// Modify replaces every node of the tree rooted at node, children first, by
+// what modifier returns for it, as Rewrite does, and returns the replacement
+// of node itself. If node is a program or a block, its statements are
+// replaced in place as well, so that the tree passed in is the modified one.
+// A replacement of the wrong kind for its place, such as a statement for an
+// expression, is ignored.
+func Modify(node Node, modifier ModifierFunc) Node {
+	modified := Rewrite(node, modifier)
+	switch n := node.(type) {
+	case *Program:
+		if m, ok := modified.(*Program); ok && m != n {
+			n.Statements = m.Statements
+			return n
+		}
+	case *BlockStatement:
+		if m, ok := modified.(*BlockStatement); ok && m != n {
+			n.Statements = m.Statements
+			return n
+		}
+	}
+	return modified
+}
@@ -0,0 +1,62 @@
+package ast_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/format"
+)
+
+func turnOneIntoTwo(node ast.Node) ast.Node {
+	integer, ok := node.(*ast.IntegerLiteral)
+	if !ok || integer.Value != 1 {
+		return node
+	}
+	integer.Value = 2
+	integer.Token.Literal = "2"
+	return integer
+}
+
+func TestModify(t *testing.T) {
+	for _, input := range rewriteTests {
+		program := parse(t, input)
+		expected := strings.ReplaceAll(format.Node(parse(t, input)), "1", "2")
+		modified := ast.Modify(program, turnOneIntoTwo)
+		if modified != program {
+			t.Errorf("%q: Modify did not return the program", input)
+		}
+		if got := format.Node(program); got != expected {
+			t.Errorf("%q: not every node modified. expected=%q, got=%q", input, expected, got)
+		}
+	}
+}
+
+func TestModifyReplacesNodes(t *testing.T) {
+	program := parse(t, `let f = fn() { for (let i = 0; i < n; let i = i + 1) { x } };`)
+	ast.Modify(program, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.Identifier:
+			if node.Value == "x" || node.Value == "n" {
+				return &ast.IntegerLiteral{Value: 7}
+			}
+		case *ast.BlockStatement:
+			// Statements cannot stand in for expressions, and are ignored
+			// there.
+			stmts := append(append([]ast.Statement(nil), node.Statements...), &ast.ReturnStatement{ReturnValue: &ast.Boolean{Value: true}})
+			return &ast.BlockStatement{Statements: stmts}
+		}
+		return node
+	})
+	fn := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if len(fn.Body.Statements) != 2 {
+		t.Fatalf("function body not replaced. got=%q", fn.Body.String())
+	}
+	loop := fn.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ForExpression)
+	if len(loop.Loop.Statements) != 2 {
+		t.Errorf("loop body not replaced. got=%q", loop.Loop.String())
+	}
+	if _, ok := loop.Condition.(*ast.InfixExpression).Right.(*ast.IntegerLiteral); !ok {
+		t.Errorf("loop condition not modified. got=%s", loop.Condition)
+	}
+}
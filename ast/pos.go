@@ -0,0 +1,102 @@
+package ast
+
+import "github.com/SebastiaanWouters/verigo/token"
+
+// Pos returns the position of the first character of node in the source,
+// which for an infix, call or index expression is that of its left operand
+// rather than that of its token. It is the zero Position for an empty
+// program.
+func Pos(node Node) token.Position {
+	switch n := node.(type) {
+	case *Program:
+		if len(n.Statements) == 0 {
+			return token.Position{}
+		}
+		return Pos(n.Statements[0])
+	case *BlockStatement:
+		return n.Token.Pos
+
+	// Statements
+	case *LetStatement:
+		return n.Token.Pos
+	case *ReturnStatement:
+		return n.Token.Pos
+	case *ThrowStatement:
+		return n.Token.Pos
+	case *ExpressionStatement:
+		return n.Token.Pos
+	case *StructStatement:
+		return n.Token.Pos
+	case *BadStatement:
+		return n.Token.Pos
+
+	// Expressions
+	case *Identifier:
+		return n.Token.Pos
+	case *IntegerLiteral:
+		return n.Token.Pos
+	case *StringLiteral:
+		return n.Token.Pos
+	case *Boolean:
+		return n.Token.Pos
+	case *NullLiteral:
+		return n.Token.Pos
+	case *BadExpression:
+		return n.Token.Pos
+	case *FoldedExpression:
+		return n.Token.Pos
+	case *PrefixExpression:
+		return n.Token.Pos
+	case *InfixExpression:
+		return Pos(n.Left)
+	case *IsNullExpression:
+		return Pos(n.Value)
+	case *IfExpression:
+		return n.Token.Pos
+	case *ForExpression:
+		return n.Token.Pos
+	case *TryExpression:
+		return n.Token.Pos
+	case *MatchExpression:
+		return n.Token.Pos
+	case *FunctionLiteral:
+		return n.Token.Pos
+	case *MacroLiteral:
+		return n.Token.Pos
+	case *CallExpression:
+		return Pos(n.Function)
+	case *ArrayLiteral:
+		return n.Token.Pos
+	case *IndexExpression:
+		return Pos(n.Left)
+	case *HashLiteral:
+		return n.Token.Pos
+	case *StructLiteral:
+		return n.Name.Token.Pos
+	case *FieldExpression:
+		return Pos(n.Left)
+
+	// Patterns
+	case *LiteralPattern:
+		return Pos(n.Value)
+	case *WildcardPattern:
+		return n.Token.Pos
+	case *BindingPattern:
+		return n.Name.Token.Pos
+	case *ArrayPattern:
+		return n.Token.Pos
+	case *HashPattern:
+		return n.Token.Pos
+
+	// Types
+	case *NamedType:
+		return n.Token.Pos
+	case *ArrayType:
+		return n.Token.Pos
+	case *HashType:
+		return n.Token.Pos
+	case *FunctionType:
+		return n.Token.Pos
+	}
+	return token.Position{}
+}
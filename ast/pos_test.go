@@ -0,0 +1,27 @@
+package ast_test
+
+import (
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+)
+
+func TestPos(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"", "0:0"},
+		{"  x", "1:3"},
+		{"\n a + b * c", "2:2"},
+		{"f(1)[0].x", "1:1"},
+		{"-a", "1:1"},
+		{"a is null", "1:1"},
+		{"P { x: 1 }", "1:1"},
+	}
+	for _, tt := range tests {
+		if got := ast.Pos(parse(t, tt.input)).String(); got != tt.expected {
+			t.Errorf("%q: wrong position. expected=%s, got=%s", tt.input, tt.expected, got)
+		}
+	}
+}
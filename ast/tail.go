@@ -0,0 +1,66 @@
+package ast
+
+// MarkTailCalls sets Tail on the calls in tail position in the body of fn:
+// those whose value is returned by a return statement or is the value of the
+// body, directly or as that of an if or a match arm. A return in a loop does not leave the
+// function, so calls in loops are never in tail position. Nested function
+// literals are not visited.
+func MarkTailCalls(fn *FunctionLiteral) {
+	if fn.Body != nil {
+		markTailBlock(fn.Body, true)
+	}
+}
+
+// markTailBlock marks the calls returned in block, and its value if it is in
+// tail position.
+func markTailBlock(block *BlockStatement, tail bool) {
+	for i, stmt := range block.Statements {
+		last := i == len(block.Statements)-1
+		switch stmt := stmt.(type) {
+		case *ReturnStatement:
+			markTail(stmt.ReturnValue)
+		case *ExpressionStatement:
+			switch exp := stmt.Expression.(type) {
+			case *IfExpression:
+				markTailIf(exp, tail && last)
+			case *MatchExpression:
+				markTailMatch(exp, tail && last)
+			default:
+				if tail && last {
+					markTail(exp)
+				}
+			}
+		}
+	}
+}
+
+// markTailMatch marks the calls returned in the arms of me, and the values
+// of the arms if me is in tail position. Guards never are.
+func markTailMatch(me *MatchExpression, tail bool) {
+	for _, arm := range me.Arms {
+		if arm.Body != nil {
+			markTailBlock(arm.Body, tail)
+		} else if tail {
+			markTail(arm.Value)
+		}
+	}
+}
+
+func markTailIf(ie *IfExpression, tail bool) {
+	markTailBlock(ie.Consequence, tail)
+	if ie.Alternative != nil {
+		markTailBlock(ie.Alternative, tail)
+	}
+}
+
+// markTail marks exp, which is in tail position.
+func markTail(exp Expression) {
+	switch exp := exp.(type) {
+	case *CallExpression:
+		exp.Tail = true
+	case *IfExpression:
+		markTailIf(exp, true)
+	case *MatchExpression:
+		markTailMatch(exp, true)
+	}
+}
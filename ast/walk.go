@@ -0,0 +1,673 @@
+package ast
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// A Visitor's Visit method is called by Walk for every node it reaches. If
+// the visitor w it returns is not nil, Walk visits each child of the node
+// with w, followed by a call of w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree rooted at node depth-first, visiting children in
+// the order they are written. It reaches type annotations and the names
+// bound by lets, parameters, structs and patterns. The variable
+// and update of a for loop are visited as *LetStatement nodes, and each arm
+// of a match as its pattern, guard and value or body.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	switch n := node.(type) {
+	case *Program:
+		for _, stmt := range n.Statements {
+			Walk(v, stmt)
+		}
+	case *BlockStatement:
+		for _, stmt := range n.Statements {
+			Walk(v, stmt)
+		}
+
+	// Statements
+	case *LetStatement:
+		walkIdent(v, n.Name)
+		walkExpression(v, n.Value)
+	case *ReturnStatement:
+		walkExpression(v, n.ReturnValue)
+	case *ThrowStatement:
+		walkExpression(v, n.Value)
+	case *ExpressionStatement:
+		walkExpression(v, n.Expression)
+	case *StructStatement:
+		walkIdent(v, n.Name)
+		walkIdents(v, n.Fields)
+	case *BadStatement:
+		// nothing to do
+
+	// Expressions
+	case *Identifier:
+		walkType(v, n.Type)
+		walkExpression(v, n.Default)
+	case *IntegerLiteral, *StringLiteral, *Boolean, *NullLiteral, *BadExpression:
+		// nothing to do
+	case *FoldedExpression:
+		walkExpression(v, n.Value)
+	case *PrefixExpression:
+		walkExpression(v, n.Right)
+	case *InfixExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Right)
+	case *IsNullExpression:
+		walkExpression(v, n.Value)
+	case *IfExpression:
+		walkExpression(v, n.Condition)
+		walkBlock(v, n.Consequence)
+		walkBlock(v, n.Alternative)
+	case *ForExpression:
+		Walk(v, &n.Variable)
+		walkExpression(v, n.Condition)
+		Walk(v, &n.Update)
+		walkBlock(v, n.Loop)
+	case *TryExpression:
+		walkBlock(v, n.Body)
+		walkIdent(v, n.Catch)
+		walkBlock(v, n.Handler)
+		walkBlock(v, n.Finally)
+	case *MatchExpression:
+		walkExpression(v, n.Subject)
+		for _, arm := range n.Arms {
+			Walk(v, arm.Pattern)
+			walkExpression(v, arm.Guard)
+			walkExpression(v, arm.Value)
+			walkBlock(v, arm.Body)
+		}
+	case *FunctionLiteral:
+		walkIdents(v, n.Parameters)
+		walkType(v, n.ReturnType)
+		walkBlock(v, n.Body)
+	case *MacroLiteral:
+		walkIdents(v, n.Parameters)
+		walkBlock(v, n.Body)
+	case *CallExpression:
+		walkExpression(v, n.Function)
+		walkExpressions(v, n.Arguments)
+	case *ArrayLiteral:
+		walkExpressions(v, n.Elements)
+	case *IndexExpression:
+		walkExpression(v, n.Left)
+		walkExpression(v, n.Index)
+	case *HashLiteral:
+		for _, key := range n.Keys {
+			Walk(v, key)
+			walkExpression(v, n.Pairs[key])
+		}
+	case *StructLiteral:
+		walkIdent(v, n.Name)
+		walkExpression(v, n.Base)
+		for i, field := range n.Fields {
+			walkIdent(v, field)
+			walkExpression(v, n.Values[i])
+		}
+	case *FieldExpression:
+		walkExpression(v, n.Left)
+		walkIdent(v, n.Field)
+
+	// Patterns
+	case *LiteralPattern:
+		walkExpression(v, n.Value)
+	case *WildcardPattern:
+		// nothing to do
+	case *BindingPattern:
+		walkIdent(v, n.Name)
+	case *ArrayPattern:
+		for _, el := range n.Elements {
+			Walk(v, el)
+		}
+		walkIdent(v, n.Rest)
+	case *HashPattern:
+		for i, key := range n.Keys {
+			Walk(v, key)
+			Walk(v, n.Values[i])
+		}
+
+	// Types
+	case *NamedType:
+		// nothing to do
+	case *ArrayType:
+		walkType(v, n.Elem)
+	case *HashType:
+		walkType(v, n.Key)
+		walkType(v, n.Value)
+	case *FunctionType:
+		for _, param := range n.Parameters {
+			Walk(v, param)
+		}
+		walkType(v, n.Result)
+
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+	v.Visit(nil)
+}
+
+// The walk helpers skip the children a node does not have, which are nil
+// pointers or interfaces.
+
+func walkExpression(v Visitor, exp Expression) {
+	if exp != nil {
+		Walk(v, exp)
+	}
+}
+
+func walkExpressions(v Visitor, exps []Expression) {
+	for _, exp := range exps {
+		walkExpression(v, exp)
+	}
+}
+
+func walkIdent(v Visitor, ident *Identifier) {
+	if ident != nil {
+		Walk(v, ident)
+	}
+}
+
+func walkIdents(v Visitor, idents []*Identifier) {
+	for _, ident := range idents {
+		walkIdent(v, ident)
+	}
+}
+
+func walkBlock(v Visitor, block *BlockStatement) {
+	if block != nil {
+		Walk(v, block)
+	}
+}
+
+func walkType(v Visitor, typ TypeExpr) {
+	if typ != nil {
+		Walk(v, typ)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree rooted at node like Walk, calling f for every
+// node, and for the children of a node only if f returned true for it. Each
+// node is followed by a call of f(nil) once its children are done.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Lets calls fn, in the order they are written, for the lets of stmts that
+// bind in their scope, wherever they are nested in them but in function and
+// macro literals. Struct declarations, the error a try catches and the
+// names a match pattern binds are bound like lets, so fn is called for lets
+// made up for them. Only the unquoted parts of quotes are searched.
+func Lets(stmts []Statement, fn func(*LetStatement)) {
+	for _, stmt := range stmts {
+		lets(stmt, fn)
+	}
+}
+
+func lets(node Node, fn func(*LetStatement)) {
+	Inspect(node, func(n Node) bool {
+		switch n := n.(type) {
+		case *FunctionLiteral, *MacroLiteral:
+			return false
+		case *LetStatement:
+			fn(n)
+		case *StructStatement:
+			fn(&LetStatement{Token: n.Token, Name: n.Name})
+		case *TryExpression:
+			lets(n.Body, fn)
+			if n.Handler != nil {
+				fn(&LetStatement{Token: n.Token, Name: n.Catch})
+				lets(n.Handler, fn)
+			}
+			if n.Finally != nil {
+				lets(n.Finally, fn)
+			}
+			return false
+		case *MatchExpression:
+			lets(n.Subject, fn)
+			for _, arm := range n.Arms {
+				for _, name := range PatternBindings(arm.Pattern) {
+					fn(&LetStatement{Token: name.Token, Name: name})
+				}
+				if arm.Guard != nil {
+					lets(arm.Guard, fn)
+				}
+				if arm.Body != nil {
+					lets(arm.Body, fn)
+				} else if arm.Value != nil {
+					lets(arm.Value, fn)
+				}
+			}
+			return false
+		case *CallExpression:
+			if _, ok := IsCallTo(n, "quote"); ok {
+				for _, arg := range n.Arguments {
+					for _, unquoted := range Unquotes(arg) {
+						lets(unquoted, fn)
+					}
+				}
+				return false
+			}
+		}
+		return true
+	})
+}
+
+// Rewrite returns the tree rooted at node with every node that Walk reaches
+// replaced, children first, by what f returns for it. The original tree is
+// not changed: a node is copied when one of its children is replaced, and
+// everything else is shared with the original, so f must return a new node
+// rather than change the one it is given. A replacement of the wrong kind
+// for its place, such as a statement for an expression, is ignored.
+func Rewrite(node Node, f func(Node) Node) Node {
+	return (&rewriter{f: f}).node(node)
+}
+
+// Copy returns a deep copy of the tree rooted at node, made by Rewrite
+// replacing every node by a copy of itself. Resolver annotations are shared
+// with the original.
+func Copy(node Node) Node {
+	return Rewrite(node, func(n Node) Node {
+		c := reflect.New(reflect.TypeOf(n).Elem())
+		c.Elem().Set(reflect.ValueOf(n).Elem())
+		return c.Interface().(Node)
+	})
+}
+
+type rewriter struct {
+	f func(Node) Node
+}
+
+func (r *rewriter) node(node Node) Node {
+	switch n := node.(type) {
+	case *Program:
+		if stmts, ok := r.statements(n.Statements); ok {
+			c := *n
+			c.Statements = stmts
+			node = &c
+		}
+	case *BlockStatement:
+		if stmts, ok := r.statements(n.Statements); ok {
+			c := *n
+			c.Statements = stmts
+			node = &c
+		}
+
+	// Statements
+	case *LetStatement:
+		name, value := r.ident(n.Name), r.expression(n.Value)
+		if name != n.Name || value != n.Value {
+			c := *n
+			c.Name, c.Value = name, value
+			node = &c
+		}
+	case *ReturnStatement:
+		if value := r.expression(n.ReturnValue); value != n.ReturnValue {
+			c := *n
+			c.ReturnValue = value
+			node = &c
+		}
+	case *ThrowStatement:
+		if value := r.expression(n.Value); value != n.Value {
+			c := *n
+			c.Value = value
+			node = &c
+		}
+	case *ExpressionStatement:
+		if exp := r.expression(n.Expression); exp != n.Expression {
+			c := *n
+			c.Expression = exp
+			node = &c
+		}
+	case *StructStatement:
+		name := r.ident(n.Name)
+		fields, ok := r.idents(n.Fields)
+		if ok || name != n.Name {
+			c := *n
+			c.Name, c.Fields = name, fields
+			node = &c
+		}
+	case *BadStatement:
+		// nothing to do
+
+	// Expressions
+	case *Identifier:
+		typ, def := r.typ(n.Type), r.expression(n.Default)
+		if typ != n.Type || def != n.Default {
+			c := *n
+			c.Type, c.Default = typ, def
+			node = &c
+		}
+	case *IntegerLiteral, *StringLiteral, *Boolean, *NullLiteral, *BadExpression:
+		// nothing to do
+	case *FoldedExpression:
+		if value := r.expression(n.Value); value != n.Value {
+			c := *n
+			c.Value = value
+			node = &c
+		}
+	case *PrefixExpression:
+		if right := r.expression(n.Right); right != n.Right {
+			c := *n
+			c.Right = right
+			node = &c
+		}
+	case *InfixExpression:
+		left, right := r.expression(n.Left), r.expression(n.Right)
+		if left != n.Left || right != n.Right {
+			c := *n
+			c.Left, c.Right = left, right
+			node = &c
+		}
+	case *IsNullExpression:
+		if value := r.expression(n.Value); value != n.Value {
+			c := *n
+			c.Value = value
+			node = &c
+		}
+	case *IfExpression:
+		cond := r.expression(n.Condition)
+		cons, alt := r.block(n.Consequence), r.block(n.Alternative)
+		if cond != n.Condition || cons != n.Consequence || alt != n.Alternative {
+			c := *n
+			c.Condition, c.Consequence, c.Alternative = cond, cons, alt
+			node = &c
+		}
+	case *ForExpression:
+		variable, cond := r.let(&n.Variable), r.expression(n.Condition)
+		update, loop := r.let(&n.Update), r.block(n.Loop)
+		if variable != &n.Variable || cond != n.Condition || update != &n.Update || loop != n.Loop {
+			c := *n
+			c.Variable, c.Condition, c.Update, c.Loop = *variable, cond, *update, loop
+			node = &c
+		}
+	case *TryExpression:
+		body, catch := r.block(n.Body), r.ident(n.Catch)
+		handler, finally := r.block(n.Handler), r.block(n.Finally)
+		if body != n.Body || catch != n.Catch || handler != n.Handler || finally != n.Finally {
+			c := *n
+			c.Body, c.Catch, c.Handler, c.Finally = body, catch, handler, finally
+			node = &c
+		}
+	case *MatchExpression:
+		subject := r.expression(n.Subject)
+		arms, changed := n.Arms, false
+		for i, arm := range n.Arms {
+			a := *arm
+			a.Pattern, a.Guard = r.pattern(arm.Pattern), r.expression(arm.Guard)
+			a.Value, a.Body = r.expression(arm.Value), r.block(arm.Body)
+			if a == *arm {
+				continue
+			}
+			if !changed {
+				arms, changed = append([]*MatchArm(nil), n.Arms...), true
+			}
+			arms[i] = &a
+		}
+		if changed || subject != n.Subject {
+			c := *n
+			c.Subject, c.Arms = subject, arms
+			node = &c
+		}
+	case *FunctionLiteral:
+		params, ok := r.idents(n.Parameters)
+		ret, body := r.typ(n.ReturnType), r.block(n.Body)
+		if ok || ret != n.ReturnType || body != n.Body {
+			c := *n
+			c.Parameters, c.ReturnType, c.Body = params, ret, body
+			node = &c
+		}
+	case *MacroLiteral:
+		params, ok := r.idents(n.Parameters)
+		if body := r.block(n.Body); ok || body != n.Body {
+			c := *n
+			c.Parameters, c.Body = params, body
+			node = &c
+		}
+	case *CallExpression:
+		function := r.expression(n.Function)
+		if args, ok := r.expressions(n.Arguments); ok || function != n.Function {
+			c := *n
+			c.Function, c.Arguments = function, args
+			node = &c
+		}
+	case *ArrayLiteral:
+		if elements, ok := r.expressions(n.Elements); ok {
+			c := *n
+			c.Elements = elements
+			node = &c
+		}
+	case *IndexExpression:
+		left, index := r.expression(n.Left), r.expression(n.Index)
+		if left != n.Left || index != n.Index {
+			c := *n
+			c.Left, c.Index = left, index
+			node = &c
+		}
+	case *HashLiteral:
+		keys := make([]Expression, len(n.Keys))
+		pairs := make(map[Expression]Expression, len(n.Pairs))
+		changed := false
+		for i, key := range n.Keys {
+			value := n.Pairs[key]
+			keys[i] = r.expression(key)
+			pairs[keys[i]] = r.expression(value)
+			changed = changed || keys[i] != key || pairs[keys[i]] != value
+		}
+		if changed {
+			c := *n
+			c.Keys, c.Pairs = keys, pairs
+			node = &c
+		}
+	case *StructLiteral:
+		name, base := r.ident(n.Name), r.expression(n.Base)
+		fields, fieldsChanged := r.idents(n.Fields)
+		values, valuesChanged := r.expressions(n.Values)
+		if name != n.Name || base != n.Base || fieldsChanged || valuesChanged {
+			c := *n
+			c.Name, c.Base, c.Fields, c.Values = name, base, fields, values
+			node = &c
+		}
+	case *FieldExpression:
+		left, field := r.expression(n.Left), r.ident(n.Field)
+		if left != n.Left || field != n.Field {
+			c := *n
+			c.Left, c.Field = left, field
+			node = &c
+		}
+
+	// Patterns
+	case *LiteralPattern:
+		if value := r.expression(n.Value); value != n.Value {
+			c := *n
+			c.Value = value
+			node = &c
+		}
+	case *WildcardPattern:
+		// nothing to do
+	case *BindingPattern:
+		if name := r.ident(n.Name); name != n.Name {
+			c := *n
+			c.Name = name
+			node = &c
+		}
+	case *ArrayPattern:
+		elements, ok := r.patterns(n.Elements)
+		if rest := r.ident(n.Rest); ok || rest != n.Rest {
+			c := *n
+			c.Elements, c.Rest = elements, rest
+			node = &c
+		}
+	case *HashPattern:
+		keys, keysChanged := r.expressions(n.Keys)
+		values, valuesChanged := r.patterns(n.Values)
+		if keysChanged || valuesChanged {
+			c := *n
+			c.Keys, c.Values = keys, values
+			node = &c
+		}
+
+	// Types
+	case *NamedType:
+		// nothing to do
+	case *ArrayType:
+		if elem := r.typ(n.Elem); elem != n.Elem {
+			c := *n
+			c.Elem = elem
+			node = &c
+		}
+	case *HashType:
+		key, value := r.typ(n.Key), r.typ(n.Value)
+		if key != n.Key || value != n.Value {
+			c := *n
+			c.Key, c.Value = key, value
+			node = &c
+		}
+	case *FunctionType:
+		params, changed := n.Parameters, false
+		for i, param := range n.Parameters {
+			if p := r.typ(param); p != param {
+				if !changed {
+					params, changed = append([]TypeExpr(nil), n.Parameters...), true
+				}
+				params[i] = p
+			}
+		}
+		if result := r.typ(n.Result); changed || result != n.Result {
+			c := *n
+			c.Parameters, c.Result = params, result
+			node = &c
+		}
+
+	default:
+		panic(fmt.Sprintf("ast.Rewrite: unexpected node type %T", n))
+	}
+	return r.f(node)
+}
+
+// The rewriter helpers return their argument itself if nothing in it was
+// replaced, and those for slices report whether a new slice was made.
+
+func (r *rewriter) expression(exp Expression) Expression {
+	if exp == nil {
+		return nil
+	}
+	if rewritten, ok := r.node(exp).(Expression); ok {
+		return rewritten
+	}
+	return exp
+}
+
+func (r *rewriter) expressions(exps []Expression) ([]Expression, bool) {
+	rewritten, changed := exps, false
+	for i, exp := range exps {
+		if e := r.expression(exp); e != exp {
+			if !changed {
+				rewritten, changed = append([]Expression(nil), exps...), true
+			}
+			rewritten[i] = e
+		}
+	}
+	return rewritten, changed
+}
+
+func (r *rewriter) statements(stmts []Statement) ([]Statement, bool) {
+	rewritten, changed := stmts, false
+	for i, stmt := range stmts {
+		s, ok := r.node(stmt).(Statement)
+		if !ok || s == stmt {
+			continue
+		}
+		if !changed {
+			rewritten, changed = append([]Statement(nil), stmts...), true
+		}
+		rewritten[i] = s
+	}
+	return rewritten, changed
+}
+
+func (r *rewriter) ident(ident *Identifier) *Identifier {
+	if ident == nil {
+		return nil
+	}
+	if rewritten, ok := r.node(ident).(*Identifier); ok {
+		return rewritten
+	}
+	return ident
+}
+
+func (r *rewriter) idents(idents []*Identifier) ([]*Identifier, bool) {
+	rewritten, changed := idents, false
+	for i, ident := range idents {
+		if id := r.ident(ident); id != ident {
+			if !changed {
+				rewritten, changed = append([]*Identifier(nil), idents...), true
+			}
+			rewritten[i] = id
+		}
+	}
+	return rewritten, changed
+}
+
+func (r *rewriter) block(block *BlockStatement) *BlockStatement {
+	if block == nil {
+		return nil
+	}
+	if rewritten, ok := r.node(block).(*BlockStatement); ok {
+		return rewritten
+	}
+	return block
+}
+
+// let rewrites the variable or update of a for loop.
+func (r *rewriter) let(let *LetStatement) *LetStatement {
+	if rewritten, ok := r.node(let).(*LetStatement); ok {
+		return rewritten
+	}
+	return let
+}
+
+func (r *rewriter) pattern(p Pattern) Pattern {
+	if rewritten, ok := r.node(p).(Pattern); ok {
+		return rewritten
+	}
+	return p
+}
+
+func (r *rewriter) patterns(ps []Pattern) ([]Pattern, bool) {
+	rewritten, changed := ps, false
+	for i, p := range ps {
+		if rp := r.pattern(p); rp != p {
+			if !changed {
+				rewritten, changed = append([]Pattern(nil), ps...), true
+			}
+			rewritten[i] = rp
+		}
+	}
+	return rewritten, changed
+}
+
+func (r *rewriter) typ(typ TypeExpr) TypeExpr {
+	if typ == nil {
+		return nil
+	}
+	if rewritten, ok := r.node(typ).(TypeExpr); ok {
+		return rewritten
+	}
+	return typ
+}
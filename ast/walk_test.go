@@ -0,0 +1,370 @@
+package ast_test
+
+import (
+	"fmt"
+	goast "go/ast"
+	goparser "go/parser"
+	"go/token"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/format"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parse errors: %v", input, p.Errors())
+	}
+	return program
+}
+
+// rewriteTests hold programs with the integer 1 in every place an
+// expression can be.
+var rewriteTests = []string{
+	`1`,
+	`1;`,
+	`let x = 1;`,
+	`return 1;`,
+	`throw 1;`,
+	`-1; !1; 1 + 1; 1 is null; 1 ?? 1`,
+	`if (1) { 1 } else { 1 }`,
+	`for (let i = 1; i < 1; let i = i + 1) { 1 }`,
+	`try { 1 } catch (e) { 1 } finally { 1 }`,
+	`match (1) { 1 if 1 => 1, [1, {"k": 1}] => { 1 } }`,
+	`fn(a = 1, ...b) { 1 }`,
+	`macro(a) { 1 }`,
+	`f(1, 1)(1)`,
+	`[1, 1][1]; x?[1]`,
+	`{1: 1, "a": 1}`,
+	`struct P { x }; P { ...1, x: 1 }.x; (1).y`,
+}
+
+// nodeTypes returns the names of the node types declared in this package,
+// and whether each is a type annotation.
+func nodeTypes(t *testing.T) map[string]bool {
+	t.Helper()
+	fset := token.NewFileSet()
+	pkgs, err := goparser.ParseDir(fset, ".", func(fi os.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	methods := map[string]map[string]bool{}
+	for _, file := range pkgs["ast"].Files {
+		for _, decl := range file.Decls {
+			fn, ok := decl.(*goast.FuncDecl)
+			if !ok || fn.Recv == nil {
+				continue
+			}
+			star, ok := fn.Recv.List[0].Type.(*goast.StarExpr)
+			if !ok {
+				continue
+			}
+			name := star.X.(*goast.Ident).Name
+			if methods[name] == nil {
+				methods[name] = map[string]bool{}
+			}
+			methods[name][fn.Name.Name] = true
+		}
+	}
+	types := map[string]bool{}
+	for name, m := range methods {
+		if m["TokenLiteral"] && m["String"] {
+			types[name] = m["TypeNode"]
+		}
+	}
+	return types
+}
+
+// handledTypes returns the node types named by the cases of the switches
+// in the function of file with the given name, written recv.name for
+// methods, or in every function if name is empty. Cases are either pointer
+// types or, for the decoder, kinds.
+func handledTypes(t *testing.T, file, name string) map[string]bool {
+	t.Helper()
+	f, err := goparser.ParseFile(token.NewFileSet(), file, nil, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handled := map[string]bool{}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*goast.FuncDecl)
+		if !ok {
+			continue
+		}
+		fnName := fn.Name.Name
+		if fn.Recv != nil {
+			recv := fn.Recv.List[0].Type
+			if star, ok := recv.(*goast.StarExpr); ok {
+				recv = star.X
+			}
+			fnName = fmt.Sprintf("%s.%s", recv, fnName)
+		}
+		if name != "" && fnName != name {
+			continue
+		}
+		goast.Inspect(fn, func(n goast.Node) bool {
+			clause, ok := n.(*goast.CaseClause)
+			if !ok {
+				return true
+			}
+			for _, exp := range clause.List {
+				switch exp := exp.(type) {
+				case *goast.StarExpr:
+					if ident, ok := exp.X.(*goast.Ident); ok {
+						handled[ident.Name] = true
+					}
+				case *goast.BasicLit:
+					if kind, err := strconv.Unquote(exp.Value); err == nil {
+						handled[kind] = true
+					}
+				}
+			}
+			return true
+		})
+	}
+	return handled
+}
+
+func TestTraversalsHandleEveryNodeType(t *testing.T) {
+	types := nodeTypes(t)
+	if len(types) == 0 {
+		t.Fatal("no node types found")
+	}
+	tests := []struct {
+		name     string
+		file     string
+		function string
+	}{
+		{"Walk", "walk.go", "Walk"},
+		{"Rewrite", "walk.go", "rewriter.node"},
+		{"Encode", "json.go", "encoder.node"},
+		{"Decode", "json.go", "decoder.node"},
+		{"Pos", "pos.go", "Pos"},
+	}
+	for _, tt := range tests {
+		handled := handledTypes(t, tt.file, tt.function)
+		for name := range types {
+			if !handled[name] {
+				t.Errorf("%s does not handle *ast.%s", tt.name, name)
+			}
+		}
+	}
+}
+
+func TestInspect(t *testing.T) {
+	program := parse(t, `let x: int = -y; f(x)`)
+	var visited []string
+	depth := 0
+	ast.Inspect(program, func(node ast.Node) bool {
+		if node == nil {
+			depth--
+			return true
+		}
+		visited = append(visited, fmt.Sprintf("%s%T", strings.Repeat(" ", depth), node))
+		depth++
+		return true
+	})
+	expected := []string{
+		"*ast.Program",
+		" *ast.LetStatement",
+		"  *ast.Identifier",
+		"   *ast.NamedType",
+		"  *ast.PrefixExpression",
+		"   *ast.Identifier",
+		" *ast.ExpressionStatement",
+		"  *ast.CallExpression",
+		"   *ast.Identifier",
+		"   *ast.Identifier",
+	}
+	if depth != 0 {
+		t.Errorf("every node not followed by nil. depth=%d", depth)
+	}
+	if got := strings.Join(visited, "\n"); got != strings.Join(expected, "\n") {
+		t.Errorf("wrong traversal. expected=\n%s\ngot=\n%s", strings.Join(expected, "\n"), got)
+	}
+}
+
+func TestInspectPrunes(t *testing.T) {
+	program := parse(t, `let f = fn(a) { a + b }; c`)
+	var idents []string
+	ast.Inspect(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok {
+			idents = append(idents, ident.Value)
+		}
+		_, isFunction := node.(*ast.FunctionLiteral)
+		return !isFunction
+	})
+	if got := strings.Join(idents, " "); got != "f c" {
+		t.Errorf("wrong identifiers. expected=%q, got=%q", "f c", got)
+	}
+}
+
+func TestInspectReachesEveryExpression(t *testing.T) {
+	for _, input := range rewriteTests {
+		ones := 0
+		ast.Inspect(parse(t, input), func(node ast.Node) bool {
+			if integer, ok := node.(*ast.IntegerLiteral); ok && integer.Value == 1 {
+				ones++
+			}
+			return true
+		})
+		if expected := strings.Count(input, "1"); ones != expected {
+			t.Errorf("%q: wrong number of nodes visited. expected=%d, got=%d", input, expected, ones)
+		}
+	}
+}
+
+func TestWalkReachesNamesAndTypes(t *testing.T) {
+	program := parse(t, `
+struct P { x }
+let f = fn(a: [int], b: {string: fn(int, ...bool): P} = {}): int {
+	match (a) { [c, ...d] => c, {"k": e} => e, _ => try { 0 } catch (err) { 1 } }
+};`)
+	var idents []string
+	types := 0
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.Identifier:
+			idents = append(idents, node.Value)
+		case ast.TypeExpr:
+			types++
+		}
+		return true
+	})
+	expected := "P x f a b a c d c e e err"
+	if got := strings.Join(idents, " "); got != expected {
+		t.Errorf("wrong identifiers. expected=%q, got=%q", expected, got)
+	}
+	if types != 9 {
+		t.Errorf("wrong number of type annotations. expected=9, got=%d", types)
+	}
+}
+
+func TestLets(t *testing.T) {
+	program := parse(t, `
+struct P { x }
+let a = f(if (true) { let b = 1; b } + match (a) { [c] => { let d = c; d }, _ => 0 });
+for (let i = 0; i < 2; let i = i + 1) { try { let e = 1; } catch (err) { let g = fn(h) { let j = h; j }; } }
+quote(unquote(if (true) { let k = 1; k }) + if (true) { let l = 1; l });`)
+	var names []string
+	ast.Lets(program.Statements, func(let *ast.LetStatement) {
+		names = append(names, let.Name.Value)
+	})
+	expected := "P a b c d i i e err g k"
+	if got := strings.Join(names, " "); got != expected {
+		t.Errorf("wrong lets. expected=%q, got=%q", expected, got)
+	}
+}
+
+func oneToTwo(node ast.Node) ast.Node {
+	integer, ok := node.(*ast.IntegerLiteral)
+	if !ok || integer.Value != 1 {
+		return node
+	}
+	two := &ast.IntegerLiteral{Token: integer.Token, Value: 2}
+	two.Token.Literal = "2"
+	return two
+}
+
+func TestRewrite(t *testing.T) {
+	for _, input := range rewriteTests {
+		program := parse(t, input)
+		original := format.Node(program)
+		rewritten := ast.Rewrite(program, oneToTwo)
+		if got := format.Node(program); got != original {
+			t.Errorf("%q: Rewrite changed the original. got=%q", input, got)
+		}
+		if expected := strings.ReplaceAll(original, "1", "2"); format.Node(rewritten) != expected {
+			t.Errorf("%q: not every node rewritten. expected=%q, got=%q", input, expected, format.Node(rewritten))
+		}
+		if same := ast.Rewrite(program, func(node ast.Node) ast.Node { return node }); same != ast.Node(program) {
+			t.Errorf("%q: Rewrite copied a tree with nothing replaced", input)
+		}
+	}
+}
+
+func TestRewriteSharesUnchangedNodes(t *testing.T) {
+	program := parse(t, `let f = fn(x) { x * 2 }; for (let i = 0; i < 1; let i = i + 1) { f(i) }`)
+	rewritten := ast.Rewrite(program, oneToTwo).(*ast.Program)
+	if rewritten == program {
+		t.Fatal("Rewrite did not copy the program")
+	}
+	if rewritten.Statements[0] != program.Statements[0] {
+		t.Errorf("unchanged statement copied")
+	}
+	loop := program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.ForExpression)
+	copied := rewritten.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.ForExpression)
+	if copied == loop {
+		t.Fatal("changed loop not copied")
+	}
+	if copied.Loop != loop.Loop || copied.Variable.Value != loop.Variable.Value {
+		t.Errorf("unchanged parts of the loop copied")
+	}
+	if copied.Condition.String() != "(i < 2)" || copied.Update.Value.String() != "(i + 2)" {
+		t.Errorf("wrong loop. got=%s", copied)
+	}
+	if loop.Condition.String() != "(i < 1)" {
+		t.Errorf("original loop changed. got=%s", loop)
+	}
+}
+
+func TestRewriteReplacesNodes(t *testing.T) {
+	program := parse(t, `let f = fn() { for (let i = 0; i < n; let i = i + 1) { x } };`)
+	rewritten := ast.Rewrite(program, func(node ast.Node) ast.Node {
+		switch node := node.(type) {
+		case *ast.Identifier:
+			if node.Value == "x" || node.Value == "n" {
+				return &ast.IntegerLiteral{Value: 7}
+			}
+		case *ast.BlockStatement:
+			// Statements cannot stand in for expressions, and are ignored
+			// there.
+			stmts := append(append([]ast.Statement(nil), node.Statements...), &ast.ReturnStatement{ReturnValue: &ast.Boolean{Value: true}})
+			return &ast.BlockStatement{Statements: stmts}
+		}
+		return node
+	}).(*ast.Program)
+	fn := rewritten.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if len(fn.Body.Statements) != 2 {
+		t.Fatalf("function body not replaced. got=%q", fn.Body.String())
+	}
+	loop := fn.Body.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.ForExpression)
+	if len(loop.Loop.Statements) != 2 {
+		t.Errorf("loop body not replaced. got=%q", loop.Loop.String())
+	}
+	if _, ok := loop.Condition.(*ast.InfixExpression).Right.(*ast.IntegerLiteral); !ok {
+		t.Errorf("loop condition not rewritten. got=%s", loop.Condition)
+	}
+}
+
+func TestCopy(t *testing.T) {
+	for _, input := range rewriteTests {
+		program := parse(t, input)
+		original := format.Node(program)
+		copied := ast.Copy(program)
+		shared := map[ast.Node]bool{}
+		ast.Inspect(program, func(node ast.Node) bool {
+			shared[node] = true
+			return true
+		})
+		ast.Inspect(copied, func(node ast.Node) bool {
+			if node != nil && shared[node] {
+				t.Errorf("%q: %T shared with the original", input, node)
+			}
+			return true
+		})
+		if got := format.Node(copied); got != original {
+			t.Errorf("%q: wrong copy. expected=%q, got=%q", input, original, got)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+// Package bundle reads and writes programs in a compact binary format, for
+// submitting them to be run elsewhere without their source.
+//
+// A bundle starts with a header:
+//
+//	magic     4 bytes  "VRGO"
+//	version   uint16   FormatVersion
+//	kind      uint8    what the payload holds, KindAST
+//	schedule  uint8 length, then that many bytes: the version of the gas
+//	          schedule the program was written for
+//	hash      32 bytes the program hash, as computed by format.Hash
+//	length    uint32   the length of the payload
+//
+// followed by the payload: the ast encoding of the program, compressed with
+// DEFLATE. Integers are big-endian.
+package bundle
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/format"
+	"github.com/SebastiaanWouters/verigo/gas"
+)
+
+// FormatVersion is the version of the bundle format written by Encode.
+const FormatVersion = 1
+
+// Kind tells what the payload of a bundle holds.
+type Kind uint8
+
+// KindAST is a program syntax tree. Other kinds are reserved for compiled
+// forms.
+const KindAST Kind = 1
+
+// MaxPayload bounds the size of a decompressed payload, so that a small
+// bundle cannot exhaust memory when loaded.
+const MaxPayload = 64 << 20
+
+var magic = [4]byte{'V', 'R', 'G', 'O'}
+
+var (
+	// ErrFormat is returned for data that is not a well-formed bundle.
+	ErrFormat = errors.New("malformed bundle")
+	// ErrVersion is returned for bundles of another format version or
+	// payload kind.
+	ErrVersion = errors.New("unsupported bundle version")
+	// ErrSchedule is returned for bundles written for another gas schedule.
+	ErrSchedule = errors.New("gas schedule mismatch")
+	// ErrTampered is returned for bundles whose payload does not match the
+	// program hash in their header.
+	ErrTampered = errors.New("program hash mismatch")
+)
+
+// Header describes a bundle.
+type Header struct {
+	Version  uint16
+	Kind     Kind
+	Schedule string // version of the gas schedule the program was written for
+	Hash     [sha256.Size]byte
+}
+
+// HashString returns the program hash in hex.
+func (h Header) HashString() string {
+	return hex.EncodeToString(h.Hash[:])
+}
+
+// Encode returns program bundled for schedule, or gas.DefaultSchedule if
+// schedule is nil.
+func Encode(program *ast.Program, schedule *gas.Schedule) ([]byte, error) {
+	if schedule == nil {
+		schedule = gas.DefaultSchedule
+	}
+	if len(schedule.Version) > 255 {
+		return nil, fmt.Errorf("bundle: schedule version %q is too long", schedule.Version)
+	}
+	encoded, err := ast.Encode(program)
+	if err != nil {
+		return nil, err
+	}
+	var payload bytes.Buffer
+	zw, err := flate.NewWriter(&payload, flate.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	zw.Write(encoded)
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Write(magic[:])
+	binary.Write(&buf, binary.BigEndian, uint16(FormatVersion))
+	buf.WriteByte(byte(KindAST))
+	buf.WriteByte(byte(len(schedule.Version)))
+	buf.WriteString(schedule.Version)
+	sum := format.Hash(program)
+	buf.Write(sum[:])
+	binary.Write(&buf, binary.BigEndian, uint32(payload.Len()))
+	buf.Write(payload.Bytes())
+	return buf.Bytes(), nil
+}
+
+// DecodeHeader returns the header of the bundle in data, and the payload
+// that follows it. It checks the format but neither the versions nor the
+// hash.
+func DecodeHeader(data []byte) (Header, []byte, error) {
+	var h Header
+	r := bytes.NewReader(data)
+	var m [4]byte
+	if _, err := io.ReadFull(r, m[:]); err != nil || m != magic {
+		return h, nil, fmt.Errorf("%w: not a bundle", ErrFormat)
+	}
+	var scheduleLen uint8
+	if err := binary.Read(r, binary.BigEndian, &h.Version); err != nil {
+		return h, nil, truncated()
+	}
+	if err := binary.Read(r, binary.BigEndian, &h.Kind); err != nil {
+		return h, nil, truncated()
+	}
+	if err := binary.Read(r, binary.BigEndian, &scheduleLen); err != nil {
+		return h, nil, truncated()
+	}
+	schedule := make([]byte, scheduleLen)
+	if _, err := io.ReadFull(r, schedule); err != nil {
+		return h, nil, truncated()
+	}
+	h.Schedule = string(schedule)
+	if _, err := io.ReadFull(r, h.Hash[:]); err != nil {
+		return h, nil, truncated()
+	}
+	var length uint32
+	if err := binary.Read(r, binary.BigEndian, &length); err != nil {
+		return h, nil, truncated()
+	}
+	if int64(length) != int64(r.Len()) {
+		return h, nil, fmt.Errorf("%w: payload is %d bytes, header says %d", ErrFormat, r.Len(), length)
+	}
+	return h, data[len(data)-r.Len():], nil
+}
+
+func truncated() error {
+	return fmt.Errorf("%w: truncated header", ErrFormat)
+}
+
+// Decode returns the program bundled in data, which must have been
+// bundled for schedule, or gas.DefaultSchedule if schedule is nil. It
+// checks the header before decoding the program and the program hash before
+// returning it, so that no tampered or mismatched program is ever run. The
+// hash ignores positions, so a bundle whose program was only laid out
+// differently is accepted.
+func Decode(data []byte, schedule *gas.Schedule) (*ast.Program, error) {
+	if schedule == nil {
+		schedule = gas.DefaultSchedule
+	}
+	h, payload, err := DecodeHeader(data)
+	if err != nil {
+		return nil, err
+	}
+	if h.Version != FormatVersion {
+		return nil, fmt.Errorf("%w: format version %d, want %d", ErrVersion, h.Version, FormatVersion)
+	}
+	if h.Kind != KindAST {
+		return nil, fmt.Errorf("%w: payload kind %d", ErrVersion, h.Kind)
+	}
+	if h.Schedule != schedule.Version {
+		return nil, fmt.Errorf("%w: bundled for schedule version %q, running %q", ErrSchedule, h.Schedule, schedule.Version)
+	}
+
+	zr := flate.NewReader(bytes.NewReader(payload))
+	defer zr.Close()
+	encoded, err := io.ReadAll(io.LimitReader(zr, MaxPayload+1))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if len(encoded) > MaxPayload {
+		return nil, fmt.Errorf("%w: payload exceeds %d bytes", ErrFormat, MaxPayload)
+	}
+	program, err := ast.DecodeProgram(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrFormat, err)
+	}
+	if format.Hash(program) != h.Hash {
+		return nil, ErrTampered
+	}
+	return program, nil
+}
+
+// IsBundle reports whether data starts like a bundle.
+func IsBundle(data []byte) bool {
+	return bytes.HasPrefix(data, magic[:])
+}
@@ -0,0 +1,134 @@
+package bundle
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/format"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/parser"
+)
+
+const source = `
+let fib = fn(n: int): int { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+let point = {"x": fib(10), "y": [1, 2, 3][1]};
+match (point) { {"x": x} if x > 50 => x, _ => 0 }`
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	return program
+}
+
+func TestRoundTrip(t *testing.T) {
+	program := parse(t, source)
+	data, err := Encode(program, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := Decode(data, gas.DefaultSchedule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format.Node(decoded) != format.Node(program) {
+		t.Errorf("wrong program decoded. got=%q", format.Node(decoded))
+	}
+
+	h, _, err := DecodeHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.Version != FormatVersion || h.Kind != KindAST || h.Schedule != gas.DefaultSchedule.Version || h.Hash != format.Hash(program) {
+		t.Errorf("wrong header. got=%+v", h)
+	}
+
+	encoded, _ := ast.Encode(program)
+	if len(data) >= len(encoded)/4 {
+		t.Errorf("bundle not compact: %d bytes, %d encoded", len(data), len(encoded))
+	}
+}
+
+// rebundle returns data with its payload replaced by the compressed
+// encoding of program, keeping the rest of the header.
+func rebundle(t *testing.T, data []byte, program *ast.Program) []byte {
+	t.Helper()
+	_, payload, err := DecodeHeader(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := ast.Encode(program)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	zw.Write(encoded)
+	zw.Close()
+	header := append([]byte(nil), data[:len(data)-len(payload)]...)
+	binary.BigEndian.PutUint32(header[len(header)-4:], uint32(buf.Len()))
+	return append(header, buf.Bytes()...)
+}
+
+func TestHashIgnoresLayout(t *testing.T) {
+	data, err := Encode(parse(t, source), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same program laid out differently has other positions, but is
+	// the program the header names.
+	relaid := parse(t, string(format.Node(parse(t, source))))
+	program, err := Decode(rebundle(t, data, relaid), nil)
+	if err != nil {
+		t.Fatalf("relaid program rejected: %s", err)
+	}
+	if format.Node(program) != format.Node(relaid) {
+		t.Errorf("wrong program decoded. got=%q", format.Node(program))
+	}
+}
+
+func TestDecodeRejects(t *testing.T) {
+	data, err := Encode(parse(t, source), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	modified := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), data...))
+	}
+	tests := []struct {
+		name     string
+		data     []byte
+		schedule *gas.Schedule
+		expected error
+	}{
+		{"empty", nil, nil, ErrFormat},
+		{"source", []byte(source), nil, ErrFormat},
+		{"truncated header", data[:12], nil, ErrFormat},
+		{"truncated payload", data[:len(data)-1], nil, ErrFormat},
+		{"trailing data", append(append([]byte(nil), data...), 0), nil, ErrFormat},
+		{"format version", modified(func(b []byte) []byte { b[5] = 2; return b }), nil, ErrVersion},
+		{"payload kind", modified(func(b []byte) []byte { b[6] = 2; return b }), nil, ErrVersion},
+		{"schedule", data, &gas.Schedule{Version: "2", Default: 1}, ErrSchedule},
+		{"hash", modified(func(b []byte) []byte { b[10] ^= 1; return b }), nil, ErrTampered},
+		{"payload", rebundle(t, data, parse(t, `let fib = fn(n) { 0 }; 55`)), nil, ErrTampered},
+		{"corrupt payload", modified(func(b []byte) []byte { b[len(b)-3] ^= 0xff; return b }), nil, nil},
+	}
+	for _, tt := range tests {
+		program, err := Decode(tt.data, tt.schedule)
+		if program != nil || err == nil {
+			t.Errorf("%s: not rejected", tt.name)
+			continue
+		}
+		if tt.expected != nil && !errors.Is(err, tt.expected) {
+			t.Errorf("%s: wrong error. expected=%v, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,731 @@
+// Command verigo runs, checks and formats Monkey programs.
+//
+// Usage:
+//
+//	verigo run [-mode full|middle|simple] [-gas n] [-O preserve|reduce] [-results path] file.mk|file.mkb
+//	verigo trace [-mode full|middle] [-gas n] file.mk|file.mkb
+//	verigo build [-o file.mkb] file.mk
+//	verigo debug [-mode full|middle|simple] [-break line]... file.mk
+//	verigo profile [-mode full|middle] [-gas n] [-lines] [-tree] [-o file.pb.gz] file.mk
+//	verigo cover [-mode full|middle|simple] [-merge file.json]... [-format text|json|lcov] [-o file] file.mk...
+//	verigo dap
+//	verigo lsp
+//	verigo test [-mode full|middle|simple] [-gas n] [-run regexp] [-v] [file.mk|dir]...
+//	verigo check file.mk...
+//	verigo cost file.mk
+//	verigo fmt [-w] file.mk...
+//	verigo repl [-results path]
+//
+// debug runs a program under the debugger of package debugger, reading its
+// commands from stdin. dap serves the Debug Adapter Protocol on stdin and
+// stdout, for editors to debug programs with, and lsp serves the Language
+// Server Protocol, for editors to check programs as they are written.
+//
+// profile runs a program under the profiler of package profile and reports
+// the ops, gas and time charged to its functions, its lines or its call
+// tree, and with -o writes a profile for go tool pprof. cover runs programs
+// and reports the statements and branches that ran, added up over its runs
+// and the JSON reports given with -merge, as text, JSON or LCOV.
+//
+// repl prints the value of every line and the results it saves, and with
+// -results also appends those results to a JSON file.
+//
+// test runs the test_ functions of the *_test.mk files given, or found in
+// the directories given or the current one, as described in package mktest,
+// and fails with status 3 if any of them fails.
+//
+// run and trace also accept programs bundled by build, which they refuse to
+// run if the bundle was tampered with or written for another gas schedule.
+//
+// The exit status is 0 on success, 1 for usage and I/O errors, 2 when a
+// program does not parse or its bundle is rejected and 3 when it fails at
+// runtime.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/SebastiaanWouters/verigo/bundle"
+	"github.com/SebastiaanWouters/verigo/cover"
+	"github.com/SebastiaanWouters/verigo/dap"
+	"github.com/SebastiaanWouters/verigo/debugger"
+	"github.com/SebastiaanWouters/verigo/format"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/interpreter"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/lsp"
+	"github.com/SebastiaanWouters/verigo/mktest"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/optimize"
+	"github.com/SebastiaanWouters/verigo/parser"
+	"github.com/SebastiaanWouters/verigo/profile"
+	"github.com/SebastiaanWouters/verigo/repl"
+)
+
+const (
+	exitOK = iota
+	exitUsage
+	exitParse
+	exitRuntime
+)
+
+const usage = `usage: verigo <command> [arguments]
+
+commands:
+	run      run a program
+	trace    run a program, printing every charged opcode to stderr
+	build    bundle a program in binary form, to be run without its source
+	debug    run a program step by step, reading debugger commands from stdin
+	profile  run a program, reporting where it spends its gas and time
+	cover    run programs, reporting the statements and branches that ran
+	dap      serve the Debug Adapter Protocol on stdin and stdout
+	lsp      serve the Language Server Protocol on stdin and stdout
+	test     run the tests in *_test.mk files
+	check    report parse, name and type errors and unused variables
+	cost     estimate the gas a program will be charged, without running it
+	fmt      format programs
+	repl     start an interactive session
+`
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return exitUsage
+	}
+
+	switch args[0] {
+	case "run":
+		return runCmd(args[1:], stdout, stderr, false)
+	case "trace":
+		return runCmd(args[1:], stdout, stderr, true)
+	case "build":
+		return buildCmd(args[1:], stdout, stderr)
+	case "debug":
+		return debugCmd(args[1:], stdin, stdout, stderr)
+	case "profile":
+		return profileCmd(args[1:], stdout, stderr)
+	case "cover":
+		return coverCmd(args[1:], stdout, stderr)
+	case "dap":
+		if len(args) != 1 {
+			fmt.Fprintln(stderr, "usage: verigo dap")
+			return exitUsage
+		}
+		if err := dap.NewServer(stdin, stdout).Serve(); err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		return exitOK
+	case "lsp":
+		if len(args) != 1 {
+			fmt.Fprintln(stderr, "usage: verigo lsp")
+			return exitUsage
+		}
+		if err := lsp.NewServer(stdin, stdout).Serve(); err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		return exitOK
+	case "test":
+		return testCmd(args[1:], stdout, stderr)
+	case "check":
+		return checkCmd(args[1:], stdout, stderr)
+	case "cost":
+		return costCmd(args[1:], stdout, stderr)
+	case "fmt":
+		return fmtCmd(args[1:], stdout, stderr)
+	case "repl":
+		return replCmd(args[1:], stdin, stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		fmt.Fprint(stdout, usage)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "verigo: unknown command %q\n\n%s", args[0], usage)
+		return exitUsage
+	}
+}
+
+func runCmd(args []string, stdout, stderr io.Writer, trace bool) int {
+	name := "run"
+	if trace {
+		name = "trace"
+	}
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	mode := flags.String("mode", "full", "evaluator to use: full, middle or simple")
+	gasLimit := flags.Uint64("gas", 0, "abort after charging this much gas (0 is unlimited)")
+	resultsPath := flags.String("results", "", "write saved results as JSON to this file")
+	optimizeMode := flags.String("O", "", "optimize before running, preserving or reducing the gas charged: preserve or reduce")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintf(stderr, "usage: verigo %s [flags] file.mk|file.mkb\n", name)
+		return exitUsage
+	}
+
+	m, err := parseMode(*mode)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	if m == interpreter.Simple && (trace || *gasLimit != 0) {
+		fmt.Fprintln(stderr, "verigo: the simple evaluator charges no gas to limit or trace")
+		return exitUsage
+	}
+
+	src, err := os.ReadFile(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+
+	results := []object.Result{}
+	opts := []interpreter.Option{
+		interpreter.WithMode(m),
+		interpreter.WithGas(*gasLimit),
+		interpreter.WithStdout(stdout),
+		interpreter.WithResultSink(func(r object.Result) {
+			results = append(results, r)
+		}),
+	}
+	switch *optimizeMode {
+	case "":
+	case "preserve":
+		opts = append(opts, interpreter.WithOptimizer(optimize.PreserveCost))
+	case "reduce":
+		opts = append(opts, interpreter.WithOptimizer(optimize.ReduceCost))
+	default:
+		fmt.Fprintf(stderr, "verigo: unknown optimization %q\n", *optimizeMode)
+		return exitUsage
+	}
+	if trace {
+		var used uint64
+		opts = append(opts, interpreter.WithOpSink(func(op int) {
+			used += gas.DefaultSchedule.Cost(op)
+			fmt.Fprintf(stderr, "%-8s %d\n", gas.Name(op), used)
+		}))
+	}
+	in := interpreter.New(opts...)
+
+	if bundle.IsBundle(src) {
+		program, berr := bundle.Decode(src, gas.DefaultSchedule)
+		if berr != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", flags.Arg(0), berr)
+			return exitParse
+		}
+		_, err = in.RunProgram(program)
+	} else {
+		_, err = in.Run(string(src))
+	}
+
+	if *resultsPath != "" {
+		if werr := writeResults(*resultsPath, results); werr != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", werr)
+			return exitUsage
+		}
+	}
+	if trace {
+		fmt.Fprintf(stderr, "gas used: %d\n", in.GasUsed())
+	}
+
+	return reportError(stderr, flags.Arg(0), err)
+}
+
+func buildCmd(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("build", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	out := flags.String("o", "", "write the bundle to this file instead of file.mkb")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: verigo build [-o file.mkb] file.mk")
+		return exitUsage
+	}
+	path := flags.Arg(0)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	program, err := interpreter.Parse(string(src))
+	if err != nil {
+		return reportError(stderr, path, err)
+	}
+	data, err := bundle.Encode(program, gas.DefaultSchedule)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	if *out == "" {
+		*out = strings.TrimSuffix(path, filepath.Ext(path)) + ".mkb"
+	}
+	if err := os.WriteFile(*out, data, 0644); err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	return exitOK
+}
+
+// lines collects the values of a flag given once per line.
+type lines []int
+
+func (l *lines) String() string { return fmt.Sprint(*l) }
+
+func (l *lines) Set(s string) error {
+	line, err := strconv.Atoi(s)
+	if err != nil || line < 1 {
+		return fmt.Errorf("invalid line %q", s)
+	}
+	*l = append(*l, line)
+	return nil
+}
+
+func debugCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("debug", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	mode := flags.String("mode", "full", "evaluator to use: full, middle or simple")
+	var breakpoints lines
+	flags.Var(&breakpoints, "break", "set a breakpoint on this line (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: verigo debug [flags] file.mk")
+		return exitUsage
+	}
+	m, err := parseMode(*mode)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	path := flags.Arg(0)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	program, err := interpreter.Parse(string(src))
+	if err != nil {
+		return reportError(stderr, path, err)
+	}
+
+	d := debugger.New(path, string(src), stdin, stdout)
+	for _, line := range breakpoints {
+		d.Break(line)
+	}
+	in := interpreter.New(interpreter.WithMode(m), interpreter.WithStdout(stdout), interpreter.WithHook(d))
+	if _, err := d.Run(in, program); !errors.Is(err, debugger.ErrQuit) {
+		return reportError(stderr, path, err)
+	}
+	return exitOK
+}
+
+func profileCmd(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("profile", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	mode := flags.String("mode", "full", "evaluator to use: full or middle")
+	gasLimit := flags.Uint64("gas", 0, "abort after charging this much gas (0 is unlimited)")
+	byLine := flags.Bool("lines", false, "report lines rather than functions")
+	tree := flags.Bool("tree", false, "report the call tree rather than functions")
+	out := flags.String("o", "", "also write a profile for go tool pprof to this file")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 {
+		fmt.Fprintln(stderr, "usage: verigo profile [flags] file.mk")
+		return exitUsage
+	}
+	m, err := parseMode(*mode)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	if m == interpreter.Simple {
+		fmt.Fprintln(stderr, "verigo: the simple evaluator charges no gas to profile")
+		return exitUsage
+	}
+	path := flags.Arg(0)
+	src, err := os.ReadFile(path)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	program, err := interpreter.Parse(string(src))
+	if err != nil {
+		return reportError(stderr, path, err)
+	}
+
+	p := profile.New(path, gas.DefaultSchedule)
+	in := interpreter.New(
+		interpreter.WithMode(m),
+		interpreter.WithGas(*gasLimit),
+		interpreter.WithStdout(stdout),
+		interpreter.WithHook(p),
+		interpreter.WithOpSink(p.Op),
+	)
+	_, runErr := in.RunProgram(program)
+	p.Stop()
+
+	switch {
+	case *tree:
+		err = p.WriteTree(stderr)
+	case *byLine:
+		err = p.WriteLines(stderr)
+	default:
+		err = p.WriteFunctions(stderr)
+	}
+	if err == nil && *out != "" {
+		var f *os.File
+		if f, err = os.Create(*out); err == nil {
+			err = p.WritePprof(f)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	return reportError(stderr, path, runErr)
+}
+
+// paths collects the values of a flag given once per file.
+type paths []string
+
+func (p *paths) String() string { return fmt.Sprint(*p) }
+
+func (p *paths) Set(s string) error {
+	*p = append(*p, s)
+	return nil
+}
+
+func coverCmd(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("cover", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	mode := flags.String("mode", "full", "evaluator to use: full, middle or simple")
+	reportFormat := flags.String("format", "text", "format of the report: text, json or lcov")
+	out := flags.String("o", "", "write the report to this file instead of stderr")
+	var merge paths
+	flags.Var(&merge, "merge", "add the coverage in this JSON report (repeatable)")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 && len(merge) == 0 {
+		fmt.Fprintln(stderr, "usage: verigo cover [flags] file.mk...")
+		return exitUsage
+	}
+	m, err := parseMode(*mode)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	var write func(*cover.Profile, io.Writer) error
+	switch *reportFormat {
+	case "text":
+		write = (*cover.Profile).WriteText
+	case "json":
+		write = (*cover.Profile).WriteJSON
+	case "lcov":
+		write = (*cover.Profile).WriteLCOV
+	default:
+		fmt.Fprintf(stderr, "verigo: unknown report format %q\n", *reportFormat)
+		return exitUsage
+	}
+
+	p := cover.New()
+	for _, path := range merge {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		q, err := cover.ReadJSON(f)
+		f.Close()
+		if err == nil {
+			err = p.Merge(q)
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %s\n", path, err)
+			return exitUsage
+		}
+	}
+
+	code := exitOK
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		program, err := interpreter.Parse(string(src))
+		if err == nil {
+			in := interpreter.New(interpreter.WithMode(m), interpreter.WithStdout(stdout),
+				interpreter.WithHook(p.Track(path, program)))
+			_, err = in.RunProgram(program)
+		}
+		if c := reportError(stderr, path, err); c > code {
+			code = c
+		}
+	}
+
+	w := stderr
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		defer f.Close()
+		w = f
+	}
+	if err := write(p, w); err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	return code
+}
+
+func replCmd(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("repl", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	resultsPath := flags.String("results", "", "append saved results as JSON to this file")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 0 {
+		fmt.Fprintln(stderr, "usage: verigo repl [-results path]")
+		return exitUsage
+	}
+	repl.StartWithResults(stdin, stdout, *resultsPath)
+	return exitOK
+}
+
+func testCmd(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	mode := flags.String("mode", "full", "evaluator to use: full, middle or simple")
+	gasLimit := flags.Uint64("gas", 0, "abort a test after charging this much gas (0 is unlimited)")
+	pattern := flags.String("run", "", "only run the tests whose names match this regular expression")
+	verbose := flags.Bool("v", false, "also list the tests that pass")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	m, err := parseMode(*mode)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	if m == interpreter.Simple && *gasLimit != 0 {
+		fmt.Fprintln(stderr, "verigo: the simple evaluator charges no gas to limit")
+		return exitUsage
+	}
+	var match func(string) bool
+	if *pattern != "" {
+		re, err := regexp.Compile(*pattern)
+		if err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		match = re.MatchString
+	}
+	roots := flags.Args()
+	if len(roots) == 0 {
+		roots = []string{"."}
+	}
+	files, err := mktest.Files(roots)
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	if len(files) == 0 {
+		fmt.Fprintln(stdout, "no test files")
+		return exitOK
+	}
+
+	code := exitOK
+	for _, path := range files {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		results, err := mktest.Run(string(src), match,
+			interpreter.WithMode(m), interpreter.WithGas(*gasLimit), interpreter.WithStdout(stdout))
+		if err != nil {
+			if c := reportError(stderr, path, err); c > code {
+				code = c
+			}
+			continue
+		}
+		failed, err := mktest.Report(stdout, path, results, *verbose)
+		if err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		if failed > 0 && code < exitRuntime {
+			code = exitRuntime
+		}
+	}
+	return code
+}
+
+func checkCmd(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprintln(stderr, "usage: verigo check file.mk...")
+		return exitUsage
+	}
+	status := exitOK
+	for _, path := range args {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		p := parser.New(lexer.New(string(src)))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			status = reportError(stdout, path, &interpreter.ParseError{Errors: p.Errors(), Diagnostics: p.Diagnostics()})
+			continue
+		}
+		// Parsing succeeded, so the parser only reported warnings.
+		in := interpreter.New()
+		diagnostics := append(p.Diagnostics(), in.Expand(program)...)
+		diagnostics = append(diagnostics, in.Check(program)...)
+		sort.SliceStable(diagnostics, func(i, j int) bool {
+			return diagnostics[i].Pos.Offset < diagnostics[j].Pos.Offset
+		})
+		for _, d := range diagnostics {
+			fmt.Fprintf(stdout, "%s:%s\n", path, d)
+			if d.Severity == parser.Error {
+				status = exitParse
+			}
+		}
+	}
+	return status
+}
+
+func costCmd(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "usage: verigo cost file.mk")
+		return exitUsage
+	}
+	src, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "verigo: %s\n", err)
+		return exitUsage
+	}
+	program, err := interpreter.Parse(string(src))
+	if err != nil {
+		return reportError(stderr, args[0], err)
+	}
+	in := interpreter.New()
+	status := exitOK
+	for _, d := range append(in.Expand(program), in.Check(program)...) {
+		if d.Severity == parser.Error {
+			fmt.Fprintf(stderr, "%s:%s\n", args[0], d)
+			status = exitParse
+		}
+	}
+	if status != exitOK {
+		return status
+	}
+	fmt.Fprintf(stdout, "%s: %s\n", args[0], in.Estimate(program))
+	return exitOK
+}
+
+func fmtCmd(args []string, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("fmt", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	write := flags.Bool("w", false, "write the result to the file instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() == 0 {
+		fmt.Fprintln(stderr, "usage: verigo fmt [-w] file.mk...")
+		return exitUsage
+	}
+
+	for _, path := range flags.Args() {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "verigo: %s\n", err)
+			return exitUsage
+		}
+		program, err := interpreter.Parse(string(src))
+		if err != nil {
+			return reportError(stderr, path, err)
+		}
+		out := []byte(format.Node(program))
+		if *write {
+			if err := os.WriteFile(path, out, 0644); err != nil {
+				fmt.Fprintf(stderr, "verigo: %s\n", err)
+				return exitUsage
+			}
+			continue
+		}
+		stdout.Write(out)
+	}
+	return exitOK
+}
+
+// reportError prints err, if any, and returns the matching exit status.
+func reportError(w io.Writer, path string, err error) int {
+	var parseErr *interpreter.ParseError
+	var runtimeErr *interpreter.RuntimeError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &parseErr):
+		for _, d := range parseErr.Diagnostics {
+			fmt.Fprintf(w, "%s:%s\n", path, d)
+		}
+		return exitParse
+	case errors.As(err, &runtimeErr):
+		if runtimeErr.Pos.Line > 0 {
+			path += ":" + runtimeErr.Pos.String()
+		}
+		fmt.Fprintf(w, "%s: runtime error: %s\n", path, runtimeErr.Message)
+		return exitRuntime
+	default:
+		fmt.Fprintf(w, "%s: %s\n", path, err)
+		return exitUsage
+	}
+}
+
+func parseMode(s string) (interpreter.Mode, error) {
+	switch s {
+	case "full":
+		return interpreter.Full, nil
+	case "middle":
+		return interpreter.Middle, nil
+	case "simple":
+		return interpreter.Simple, nil
+	default:
+		return 0, fmt.Errorf("unknown mode %q", s)
+	}
+}
+
+func writeResults(path string, results []object.Result) error {
+	data, err := json.Marshal(results)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeProgram(t *testing.T, src string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "prog.mk")
+	if err := os.WriteFile(path, []byte(src), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestExitCodes(t *testing.T) {
+	ok := writeProgram(t, `print(1 + 2);`)
+	bad := writeProgram(t, `let = 1;`)
+	failing := writeProgram(t, `let f = fn(x) { x + 1 }; f(true);`)
+	undefined := writeProgram(t, `let f = fn() { y };`)
+	illTyped := writeProgram(t, `let x: int = pow("a", 2);`)
+
+	tests := []struct {
+		args     []string
+		expected int
+	}{
+		{[]string{}, exitUsage},
+		{[]string{"nope"}, exitUsage},
+		{[]string{"run", ok}, exitOK},
+		{[]string{"run", "-mode", "middle", ok}, exitOK},
+		{[]string{"run", "-mode", "bogus", ok}, exitUsage},
+		{[]string{"run", "-O", "reduce", ok}, exitOK},
+		{[]string{"run", "-O", "bogus", ok}, exitUsage},
+		{[]string{"run", "-mode", "simple", ok}, exitOK},
+		{[]string{"run", "-mode", "simple", "-gas", "10", ok}, exitUsage},
+		{[]string{"trace", "-mode", "simple", ok}, exitUsage},
+		{[]string{"test", "-mode", "simple", "-gas", "10"}, exitUsage},
+		{[]string{"repl", "extra"}, exitUsage},
+		{[]string{"run", bad}, exitParse},
+		{[]string{"run", failing}, exitRuntime},
+		{[]string{"run", undefined}, exitParse},
+		{[]string{"run", filepath.Join(t.TempDir(), "missing.mk")}, exitUsage},
+		{[]string{"check", ok, failing}, exitOK},
+		{[]string{"check", ok, bad}, exitParse},
+		{[]string{"check", undefined}, exitParse},
+		{[]string{"check", illTyped}, exitParse},
+		{[]string{"cost", ok}, exitOK},
+		{[]string{"cost", undefined}, exitParse},
+		{[]string{"fmt", ok}, exitOK},
+		{[]string{"fmt", bad}, exitParse},
+		{[]string{"build"}, exitUsage},
+		{[]string{"build", bad}, exitParse},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		if got := run(tt.args, strings.NewReader(""), &stdout, &stderr); got != tt.expected {
+			t.Errorf("run(%v) wrong exit code. expected=%d, got=%d (stderr=%q)",
+				tt.args, tt.expected, got, stderr.String())
+		}
+	}
+}
+
+func TestRunWritesResults(t *testing.T) {
+	path := writeProgram(t, `print("hi"); save("x", 7); save("h", {"a": [1, "b", null]});`)
+	results := filepath.Join(t.TempDir(), "results.json")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"run", "-results", results, path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "hi\n" {
+		t.Errorf("wrong output. got=%q", stdout.String())
+	}
+
+	data, err := os.ReadFile(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `[{"Key":"x","Value":7},{"Key":"h","Value":{"a":[1,"b",null]}}]` {
+		t.Errorf("wrong results. got=%s", data)
+	}
+}
+
+func TestREPL(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	input := "let x = 1 + 2;\nx * 2\nsave(\"x\", x);\nx + true\n"
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"repl"}, strings.NewReader(input), &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	expected := ">> >> 6\n>> saved x = 3\nnull\n>> ERROR: unknown operator: INTEGER + BOOLEAN\n>> "
+	if stdout.String() != expected {
+		t.Errorf("wrong output.\nexpected=%q\ngot=     %q", expected, stdout.String())
+	}
+	// Results are only written to a file when asked for.
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("repl wrote files without -results: %v", entries)
+	}
+
+	results := filepath.Join(dir, "results.json")
+	for i := 0; i < 2; i++ {
+		stdout.Reset()
+		if code := run([]string{"repl", "-results", results}, strings.NewReader(input), &stdout, &stderr); code != exitOK {
+			t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+		}
+	}
+	data, err := os.ReadFile(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `[{"Key":"x","Value":3},{"Key":"x","Value":3}]` {
+		t.Errorf("wrong results. got=%s", data)
+	}
+}
+
+func TestRunWritesStructsAsJSON(t *testing.T) {
+	path := writeProgram(t, `struct P { name, tags, next }; save("p", P { name: "a", tags: [1, true], next: P { name: "b" } });`)
+	results := filepath.Join(t.TempDir(), "results.json")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"run", "-results", results, path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	data, err := os.ReadFile(results)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `[{"Key":"p","Value":{"name":"a","tags":[1,true],"next":{"name":"b","tags":null,"next":null}}}]`
+	if string(data) != expected {
+		t.Errorf("wrong results.\nexpected=%s\ngot=     %s", expected, data)
+	}
+}
+
+func TestTraceAndGas(t *testing.T) {
+	path := writeProgram(t, `1 + 2 * 3;`)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"trace", path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	expected := "mul      1\nadd      2\ngas used: 2\n"
+	if stderr.String() != expected {
+		t.Errorf("wrong trace. expected=%q, got=%q", expected, stderr.String())
+	}
+
+	stderr.Reset()
+	if code := run([]string{"run", "-gas", "1", path}, nil, &stdout, &stderr); code != exitRuntime {
+		t.Errorf("expected runtime error, got exit code %d", code)
+	}
+	if !strings.Contains(stderr.String(), "out of gas") {
+		t.Errorf("expected out of gas. got=%q", stderr.String())
+	}
+}
+
+func TestBuildAndRunBundle(t *testing.T) {
+	path := writeProgram(t, "let f = fn(x) { x * 2 };\nprint(f(21));\nf(true);")
+	bundled := filepath.Join(t.TempDir(), "prog.mkb")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"build", "-o", bundled, path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	// Runtime errors keep the positions of the source.
+	if code := run([]string{"run", bundled}, nil, &stdout, &stderr); code != exitRuntime {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "42\n" {
+		t.Errorf("wrong output. got=%q", stdout.String())
+	}
+	if !strings.HasPrefix(stderr.String(), bundled+":1:19: runtime error") {
+		t.Errorf("wrong error. got=%q", stderr.String())
+	}
+
+	data, err := os.ReadFile(bundled)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(bundled, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout.Reset()
+	stderr.Reset()
+	if code := run([]string{"run", bundled}, nil, &stdout, &stderr); code != exitParse {
+		t.Errorf("tampered bundle: unexpected exit code %d", code)
+	}
+	if stdout.Len() != 0 || !strings.HasPrefix(stderr.String(), bundled+": ") {
+		t.Errorf("tampered bundle ran. stdout=%q, stderr=%q", stdout.String(), stderr.String())
+	}
+}
+
+func TestDebug(t *testing.T) {
+	path := writeProgram(t, "let x = 2;\nlet y = x * 3;\nprint(y);")
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"debug", "-break", "3", path}, strings.NewReader("c\np y\nc\n"), &stdout, &stderr)
+	if code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	expected := "stopped at " + path + ":1:1 in main\n   1 | let x = 2;\n" +
+		"(debug) stopped at " + path + ":3:1 in main\n   3 | print(y);\n" +
+		"(debug) y = 6\n(debug) 6\nprogram finished\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong session.\nexpected=%q\ngot=     %q", expected, stdout.String())
+	}
+
+	// Quitting is not a failure.
+	if code := run([]string{"debug", path}, strings.NewReader("q\n"), &stdout, &stderr); code != exitOK {
+		t.Errorf("quit: unexpected exit code %d", code)
+	}
+	if code := run([]string{"debug", "-break", "x", path}, strings.NewReader(""), &stdout, &stderr); code != exitUsage {
+		t.Errorf("bad breakpoint: unexpected exit code %d", code)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	path := writeProgram(t, "let sq = fn(x) { x * x };\nprint(sq(2) + sq(3));")
+	out := filepath.Join(t.TempDir(), "prog.pb.gz")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"profile", "-o", out, path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if stdout.String() != "13\n" {
+		t.Errorf("wrong output. got=%q", stdout.String())
+	}
+	report := strings.Split(stderr.String(), "\n")
+	if len(report) != 4 || !strings.HasSuffix(report[0], "function") ||
+		!strings.HasSuffix(report[1], "  main") || !strings.HasSuffix(report[2], "  sq 1:16") {
+		t.Errorf("wrong report.\n%s", stderr.String())
+	}
+	if data, err := os.ReadFile(out); err != nil || len(data) < 2 || data[0] != 0x1f || data[1] != 0x8b {
+		t.Errorf("no gzipped profile written (%v)", err)
+	}
+
+	if code := run([]string{"profile", "-mode", "simple", path}, nil, &stdout, &stderr); code != exitUsage {
+		t.Errorf("simple mode: unexpected exit code %d", code)
+	}
+}
+
+func TestCover(t *testing.T) {
+	path := writeProgram(t, "let x = 1;\nif (x > 1) { print(x); }")
+	report := filepath.Join(t.TempDir(), "cover.json")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"cover", "-format", "json", "-o", report, path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	stderr.Reset()
+	if code := run([]string{"cover", "-merge", report, path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	expected := path + ": 2 of 3 statements run (66.7%), 1 of 2 branches taken (50.0%)\n" +
+		path + ":2:14: statement never run\n" + path + ":2:1: then of if never taken\n"
+	if stderr.String() != expected {
+		t.Errorf("wrong report.\nexpected=%q\ngot=     %q", expected, stderr.String())
+	}
+
+	stderr.Reset()
+	if code := run([]string{"cover", "-format", "lcov", path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "BRDA:2,0,0,0\nBRDA:2,0,1,1\n") {
+		t.Errorf("wrong tracefile.\n%s", stderr.String())
+	}
+	if code := run([]string{"cover", "-format", "xml", path}, nil, &stdout, &stderr); code != exitUsage {
+		t.Errorf("bad format: unexpected exit code %d", code)
+	}
+}
+
+func TestTest(t *testing.T) {
+	dir := t.TempDir()
+	passing := filepath.Join(dir, "a_test.mk")
+	failing := filepath.Join(dir, "sub", "b_test.mk")
+	if err := os.MkdirAll(filepath.Dir(failing), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(passing, []byte("let test_ok = fn() { assert_eq(1 + 1, 2) };"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(failing, []byte("let test_bad = fn() {\n\tassert(false, \"no\")\n};"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"test", dir}, nil, &stdout, &stderr); code != exitRuntime {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	expected := "ok  \t" + passing + "\t1 passed, 0 failed\n" +
+		"--- FAIL: test_bad (" + failing + ":1:5)\n\t" + failing + ":2:8: assertion failed: no\n" +
+		"FAIL\t" + failing + "\t0 passed, 1 failed\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong report.\nexpected=%q\ngot=     %q", expected, stdout.String())
+	}
+
+	stdout.Reset()
+	if code := run([]string{"test", "-v", "-run", "ok", passing}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if !strings.HasPrefix(stdout.String(), "--- PASS: test_ok") {
+		t.Errorf("passing test not listed. got=%q", stdout.String())
+	}
+	if code := run([]string{"test", "-run", "(", dir}, nil, &stdout, &stderr); code != exitUsage {
+		t.Errorf("bad pattern: unexpected exit code %d", code)
+	}
+}
+
+// frame frames a protocol message as package wire does.
+func frame(content string) string {
+	return fmt.Sprintf("Content-Length: %d\r\n\r\n%s", len(content), content)
+}
+
+func TestDAP(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	input := frame(`{"seq":1,"type":"request","command":"threads"}`)
+	if code := run([]string{"dap"}, strings.NewReader(input), &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), `"threads":[{"id":1,"name":"main"}]`) {
+		t.Errorf("no threads response. got=%q", stdout.String())
+	}
+	if code := run([]string{"dap"}, strings.NewReader("Content-Length: x\r\n\r\n"), &stdout, &stderr); code != exitUsage {
+		t.Errorf("bad message: unexpected exit code %d", code)
+	}
+}
+
+func TestLSP(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	input := frame(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`) +
+		frame(`{"jsonrpc":"2.0","id":2,"method":"shutdown"}`) +
+		frame(`{"jsonrpc":"2.0","method":"exit"}`)
+	if code := run([]string{"lsp"}, strings.NewReader(input), &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if !strings.Contains(stdout.String(), `"hoverProvider":true`) {
+		t.Errorf("no initialize response. got=%q", stdout.String())
+	}
+	// Exiting without shutting down is an error.
+	input = frame(`{"jsonrpc":"2.0","method":"exit"}`)
+	if code := run([]string{"lsp"}, strings.NewReader(input), &stdout, &stderr); code != exitUsage {
+		t.Errorf("exit without shutdown: unexpected exit code %d", code)
+	}
+}
+
+func TestFmt(t *testing.T) {
+	path := writeProgram(t, "let x=1;if(x<2){print(x)}")
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"fmt", path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	expected := "let x = 1;\nif (x < 2) {\n\tprint(x);\n}\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong output. expected=%q, got=%q", expected, stdout.String())
+	}
+
+	if code := run([]string{"fmt", "-w", path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+	}
+	if data, _ := os.ReadFile(path); string(data) != expected {
+		t.Errorf("wrong file contents. got=%q", data)
+	}
+}
+
+func TestCost(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{`let x = 1 + 2 * 3; print(x);`, "2\n"},
+		{`for (let i = 0; i < 3; let i = i + 1) { print(i * i); }`, "10\n"},
+		{`let f = fn(n) { f(n) }; f(1);`, "unbounded: 1:17: cannot show that the recursion of f terminates\n"},
+	}
+
+	for _, tt := range tests {
+		path := writeProgram(t, tt.src)
+		var stdout, stderr bytes.Buffer
+		if code := run([]string{"cost", path}, nil, &stdout, &stderr); code != exitOK {
+			t.Fatalf("unexpected exit code %d: %s", code, stderr.String())
+		}
+		if expected := path + ": " + tt.expected; stdout.String() != expected {
+			t.Errorf("wrong estimate. expected=%q, got=%q", expected, stdout.String())
+		}
+	}
+}
+
+func TestCheckReportsParserWarnings(t *testing.T) {
+	path := writeProgram(t, "let b = 1 < 2;\nlet f = fn() { 1 };\nmatch (b) { true => f() };")
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"check", path}, nil, &stdout, &stderr); code != exitOK {
+		t.Fatalf("unexpected exit code %d: %s", code, stdout.String())
+	}
+	expected := path + ":3:1: warning[P007]: match on a boolean does not cover false (hint: add an arm for false or _)\n"
+	if stdout.String() != expected {
+		t.Errorf("wrong output. expected=%q, got=%q", expected, stdout.String())
+	}
+}
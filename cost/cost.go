@@ -0,0 +1,1106 @@
+// Package cost estimates the gas a program will be charged before it runs.
+//
+// The estimate charges the opcodes the metered evaluators charge, weighted
+// by a gas.Schedule. It is exact for straight-line code and for loops with
+// constant bounds. Otherwise it is an upper bound,
+// expressed in symbols for the values it depends on: globals defined by the
+// host, such as n, or len(xs) for arrays. Loops must count a variable up to
+// a bound, or down, and recursive functions must decrease an argument that
+// they compare against a constant before recursing; a program with other
+// loops or recursion is reported as unbounded.
+package cost
+
+import (
+	"fmt"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/evaluator"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// Estimate is the gas a program may be charged.
+type Estimate struct {
+	// Gas is an upper bound on the gas charged, exact if Exact is set. It
+	// is meaningless if Unbounded is set.
+	Gas       Poly
+	Exact     bool
+	Unbounded bool
+	Reason    string         // why no bound was found
+	Pos       token.Position // where no bound was found
+}
+
+func (e Estimate) String() string {
+	switch {
+	case e.Unbounded:
+		return fmt.Sprintf("unbounded: %s: %s", e.Pos, e.Reason)
+	case e.Exact:
+		return e.Gas.String()
+	default:
+		return "at most " + e.Gas.String()
+	}
+}
+
+// Analyze estimates the gas charged for running program. Calls to host
+// builtins, which the estimate cannot see into, are charged the opcode
+// given by builtins; calling any other unknown function is unbounded.
+func Analyze(program *ast.Program, schedule *gas.Schedule, builtins map[string]int) Estimate {
+	if schedule == nil {
+		schedule = gas.DefaultSchedule
+	}
+	a := &analyzer{
+		schedule:  schedule,
+		builtins:  builtins,
+		summaries: map[summaryKey]*summary{},
+	}
+	a.scopes = []scope{{}}
+	r := a.statements(program.Statements)
+	if a.unbounded != nil {
+		return *a.unbounded
+	}
+	return Estimate{Gas: r.cost, Exact: r.exact}
+}
+
+type kind int
+
+const (
+	unknownKind kind = iota
+	intKind
+	stringKind
+	boolKind
+	otherKind
+)
+
+// binding is what is known about a variable.
+type binding struct {
+	kind  kind
+	value *Poly // the value of an integer, if known
+	// length is the length of an array, if known.
+	length *Poly
+	fn     *ast.FunctionLiteral
+	// scopes are those fn was defined in.
+	scopes []scope
+}
+
+type scope map[string]*binding
+
+// result is the cost of evaluating a node.
+type result struct {
+	cost  Poly
+	exact bool
+	// calls counts the recursive calls on the costliest path.
+	calls int
+}
+
+var free = result{exact: true}
+
+func seq(a, b result) result {
+	return result{cost: a.cost.Add(b.cost), exact: a.exact && b.exact, calls: a.calls + b.calls}
+}
+
+func either(a, b result) result {
+	r := result{cost: Max(a.cost, b.cost), exact: a.exact && b.exact && a.cost.Equal(b.cost)}
+	r.calls = a.calls
+	if b.calls > r.calls {
+		r.calls = b.calls
+	}
+	if a.calls != b.calls {
+		r.exact = false
+	}
+	return r
+}
+
+type summary struct {
+	cost  Poly
+	exact bool
+	// kind is that of the result, if the function has a single exit.
+	kind kind
+}
+
+// summaryKey identifies the summary of a function called with arguments of
+// the given kinds.
+type summaryKey struct {
+	fn    *ast.FunctionLiteral
+	kinds string
+}
+
+// frame is a function whose summary is being computed.
+type frame struct {
+	fn     *ast.FunctionLiteral
+	guards []guard
+	// depth bounds the depth of recursion, once a recursive call is seen.
+	depth *Poly
+}
+
+// guard records that parameter param is at least min on the current path.
+type guard struct {
+	param string
+	min   int64
+}
+
+type analyzer struct {
+	schedule  *gas.Schedule
+	builtins  map[string]int
+	scopes    []scope
+	frames    []*frame
+	summaries map[summaryKey]*summary
+	unbounded *Estimate
+}
+
+func (a *analyzer) fail(tok token.Token, format string, args ...interface{}) result {
+	if a.unbounded == nil {
+		a.unbounded = &Estimate{Unbounded: true, Reason: fmt.Sprintf(format, args...), Pos: tok.Pos}
+	}
+	return free
+}
+
+func (a *analyzer) op(op int) result {
+	return result{cost: Const(int64(a.schedule.Cost(op))), exact: true}
+}
+
+func (a *analyzer) lookup(name string) (*binding, bool) {
+	for i := len(a.scopes) - 1; i >= 0; i-- {
+		if b, ok := a.scopes[i][name]; ok {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+func (a *analyzer) bind(name string, b *binding) {
+	a.scopes[len(a.scopes)-1][name] = b
+}
+
+func (a *analyzer) frame() *frame {
+	if len(a.frames) == 0 {
+		return nil
+	}
+	return a.frames[len(a.frames)-1]
+}
+
+func (a *analyzer) statements(stmts []ast.Statement) result {
+	r := free
+	guards := 0
+	for i, stmt := range stmts {
+		r = seq(r, a.statement(stmt))
+		if leaves(stmt) && i < len(stmts)-1 {
+			r.exact = false
+		}
+		// After `if (n < k) { return ...; }`, n is at least k.
+		if g, ok := a.earlyReturnGuard(stmt); ok {
+			a.frame().guards = append(a.frame().guards, g)
+			guards++
+		}
+		if a.unbounded != nil {
+			break
+		}
+	}
+	if guards > 0 {
+		f := a.frame()
+		f.guards = f.guards[:len(f.guards)-guards]
+	}
+	return r
+}
+
+func (a *analyzer) statement(stmt ast.Statement) result {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		r := a.expression(stmt.Value)
+		a.bind(stmt.Name.Value, a.describe(stmt.Value, stmt.Name.Type))
+		return r
+	case *ast.ReturnStatement:
+		return a.expression(stmt.ReturnValue)
+	case *ast.ThrowStatement:
+		return a.expression(stmt.Value)
+	case *ast.StructStatement:
+		a.bind(stmt.Name.Value, &binding{kind: otherKind})
+		return free
+	case *ast.ExpressionStatement:
+		return a.expression(stmt.Expression)
+	}
+	return free
+}
+
+// describe returns what is known about the value of exp, which may be
+// annotated with typ.
+func (a *analyzer) describe(exp ast.Expression, typ ast.TypeExpr) *binding {
+	b := &binding{kind: a.kindOf(exp)}
+	if named, ok := typ.(*ast.NamedType); ok {
+		b.kind = namedKind(named.Name)
+	}
+	if v, ok := a.value(exp); ok {
+		b.value = &v
+	}
+	switch exp := exp.(type) {
+	case *ast.FunctionLiteral:
+		b.fn = exp
+		b.scopes = append([]scope{}, a.scopes...)
+	case *ast.ArrayLiteral:
+		n := Const(int64(len(exp.Elements)))
+		b.length = &n
+	case *ast.Identifier:
+		if other, ok := a.lookup(exp.Value); ok {
+			return other
+		}
+	}
+	return b
+}
+
+func namedKind(name string) kind {
+	switch name {
+	case "int":
+		return intKind
+	case "string":
+		return stringKind
+	case "bool":
+		return boolKind
+	case "any":
+		return unknownKind
+	}
+	return otherKind
+}
+
+func (a *analyzer) expression(exp ast.Expression) result {
+	switch exp := exp.(type) {
+	case *ast.PrefixExpression:
+		return a.expression(exp.Right)
+	case *ast.IsNullExpression:
+		return a.expression(exp.Value)
+	case *ast.InfixExpression:
+		if exp.Operator == "??" {
+			return seq(a.expression(exp.Left), either(free, a.expression(exp.Right)))
+		}
+		r := seq(a.expression(exp.Left), a.expression(exp.Right))
+		return seq(r, a.infix(exp))
+	case *ast.IfExpression:
+		return a.ifExpression(exp)
+	case *ast.ForExpression:
+		return a.forExpression(exp)
+	case *ast.TryExpression:
+		return a.tryExpression(exp)
+	case *ast.MatchExpression:
+		return a.matchExpression(exp)
+	case *ast.CallExpression:
+		return a.call(exp)
+	case *ast.ArrayLiteral:
+		r := free
+		for _, el := range exp.Elements {
+			r = seq(r, a.expression(el))
+		}
+		return r
+	case *ast.HashLiteral:
+		r := free
+		for _, key := range exp.Keys {
+			r = seq(r, a.expression(key))
+			r = seq(r, a.expression(exp.Pairs[key]))
+		}
+		return r
+	case *ast.IndexExpression:
+		if exp.Optional {
+			return seq(a.expression(exp.Left), either(free, a.expression(exp.Index)))
+		}
+		return seq(a.expression(exp.Left), a.expression(exp.Index))
+	case *ast.StructLiteral:
+		r := free
+		if exp.Base != nil {
+			r = a.expression(exp.Base)
+		}
+		for _, value := range exp.Values {
+			r = seq(r, a.expression(value))
+		}
+		return r
+	case *ast.FieldExpression:
+		return a.expression(exp.Left)
+	case *ast.FoldedExpression:
+		r := free
+		for _, op := range exp.Ops {
+			r = seq(r, a.op(op))
+		}
+		return r
+	}
+	return free
+}
+
+// infix returns the cost of applying the operator of exp, which depends on
+// the kinds of its operands.
+func (a *analyzer) infix(exp *ast.InfixExpression) result {
+	left, right := a.kindOf(exp.Left), a.kindOf(exp.Right)
+	intOp, isIntOp := evaluator.IntegerOpcode(exp.Operator)
+	switch {
+	case left == intKind && right == intKind:
+		return a.op(intOp)
+	case left == stringKind && right == stringKind && exp.Operator == "+":
+		return a.op(gas.OpConcat)
+	case left == unknownKind || right == unknownKind:
+		r := free
+		if isIntOp && left != stringKind && right != stringKind {
+			r = a.op(intOp)
+		}
+		if exp.Operator == "+" && left != intKind && right != intKind {
+			r = either(r, a.op(gas.OpConcat))
+		}
+		r.exact = false
+		return r
+	}
+	return free
+}
+
+func (a *analyzer) kindOf(exp ast.Expression) kind {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return intKind
+	case *ast.StringLiteral:
+		return stringKind
+	case *ast.Boolean:
+		return boolKind
+	case *ast.FoldedExpression:
+		return a.kindOf(exp.Value)
+	case *ast.Identifier:
+		if b, ok := a.lookup(exp.Value); ok {
+			return b.kind
+		}
+		return unknownKind
+	case *ast.PrefixExpression:
+		if exp.Operator == "!" {
+			return boolKind
+		}
+		return intKind
+	case *ast.IsNullExpression:
+		return boolKind
+	case *ast.InfixExpression:
+		switch exp.Operator {
+		case "<", ">", "==", "!=":
+			return boolKind
+		case "??":
+			return unknownKind
+		case "+":
+			left, right := a.kindOf(exp.Left), a.kindOf(exp.Right)
+			if left == right {
+				return left
+			}
+			return unknownKind
+		}
+		return intKind
+	case *ast.CallExpression:
+		if ident, ok := exp.Function.(*ast.Identifier); ok {
+			if b, bound := a.lookup(ident.Value); bound && b.fn != nil {
+				return a.callKind(exp, b.fn)
+			} else if !bound {
+				switch evaluator.BuiltinOpcode(ident.Value) {
+				case gas.OpIsPrime:
+					return boolKind
+				case gas.OpNone:
+				default:
+					return intKind
+				}
+			}
+		}
+	case *ast.ArrayLiteral, *ast.HashLiteral, *ast.FunctionLiteral, *ast.NullLiteral, *ast.StructLiteral:
+		return otherKind
+	}
+	return unknownKind
+}
+
+// callKind returns the kind of the result of a call to fn, if it has been
+// summarised for the kinds of the arguments of exp.
+func (a *analyzer) callKind(exp *ast.CallExpression, fn *ast.FunctionLiteral) kind {
+	_, key := a.argumentKinds(exp, fn)
+	if s, ok := a.summaries[key]; ok {
+		return s.kind
+	}
+	return unknownKind
+}
+
+// argumentKinds returns the kinds of the arguments exp passes to the
+// parameters of fn but its rest parameter, and the key of the summary of
+// the call. Defaulted parameters left out have no kind.
+func (a *analyzer) argumentKinds(exp *ast.CallExpression, fn *ast.FunctionLiteral) ([]kind, summaryKey) {
+	n := len(exp.Arguments)
+	if fixed := fixedParameters(fn); n > fixed {
+		n = fixed
+	}
+	kinds := make([]kind, n)
+	for i := range kinds {
+		kinds[i] = a.kindOf(exp.Arguments[i])
+	}
+	return kinds, summaryKey{fn, fmt.Sprint(kinds)}
+}
+
+// fixedParameters returns the number of parameters of fn but its rest
+// parameter.
+func fixedParameters(fn *ast.FunctionLiteral) int {
+	if fn.Variadic {
+		return len(fn.Parameters) - 1
+	}
+	return len(fn.Parameters)
+}
+
+// resultKind returns the kind of the value of body, which has just been
+// analysed, if it does not return early.
+func (a *analyzer) resultKind(body *ast.BlockStatement) kind {
+	n := len(body.Statements)
+	if n == 0 || returns(&ast.BlockStatement{Statements: body.Statements[:n-1]}) {
+		return unknownKind
+	}
+	switch last := body.Statements[n-1].(type) {
+	case *ast.ReturnStatement:
+		return a.kindOf(last.ReturnValue)
+	case *ast.ExpressionStatement:
+		if _, ok := last.Expression.(*ast.IfExpression); !ok {
+			return a.kindOf(last.Expression)
+		}
+	}
+	return unknownKind
+}
+
+// value returns the value of an integer expression, in terms of symbols,
+// if it can be determined before running.
+func (a *analyzer) value(exp ast.Expression) (Poly, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return Const(exp.Value), true
+	case *ast.FoldedExpression:
+		return a.value(exp.Value)
+	case *ast.Identifier:
+		b, ok := a.lookup(exp.Value)
+		switch {
+		case !ok:
+			return Sym(exp.Value), true
+		case b.value != nil:
+			return *b.value, true
+		}
+	case *ast.PrefixExpression:
+		if v, ok := a.value(exp.Right); ok && exp.Operator == "-" {
+			return v.Scale(-1), true
+		}
+	case *ast.InfixExpression:
+		left, lok := a.value(exp.Left)
+		right, rok := a.value(exp.Right)
+		if !lok || !rok {
+			return Poly{}, false
+		}
+		switch exp.Operator {
+		case "+":
+			return left.Add(right), true
+		case "-":
+			return left.Sub(right), true
+		case "*":
+			return left.Mul(right), true
+		}
+	case *ast.CallExpression:
+		ident, ok := exp.Function.(*ast.Identifier)
+		if ok && len(exp.Arguments) == 1 && evaluator.BuiltinOpcode(ident.Value) == gas.OpLen {
+			if _, bound := a.lookup(ident.Value); !bound {
+				return a.length(exp.Arguments[0])
+			}
+		}
+	}
+	return Poly{}, false
+}
+
+// length returns the length of an array expression, if it can be
+// determined before running.
+func (a *analyzer) length(exp ast.Expression) (Poly, bool) {
+	switch exp := exp.(type) {
+	case *ast.ArrayLiteral:
+		return Const(int64(len(exp.Elements))), true
+	case *ast.StringLiteral:
+		return Const(int64(len(exp.Value))), true
+	case *ast.Identifier:
+		b, ok := a.lookup(exp.Value)
+		switch {
+		case !ok:
+			return Sym("len(" + exp.Value + ")"), true
+		case b.length != nil:
+			return *b.length, true
+		}
+	}
+	return Poly{}, false
+}
+
+func (a *analyzer) ifExpression(exp *ast.IfExpression) result {
+	r := a.expression(exp.Condition)
+	g, guarded := a.guard(exp.Condition)
+
+	var consequence, alternative result
+	if guarded && !g.thenSide {
+		consequence = a.block(exp.Consequence, nil)
+	} else {
+		consequence = a.block(exp.Consequence, g.ifGuarded(guarded))
+	}
+	alternative = free
+	if exp.Alternative != nil {
+		if guarded && !g.thenSide {
+			alternative = a.block(exp.Alternative, &g.guard)
+		} else {
+			alternative = a.block(exp.Alternative, nil)
+		}
+	}
+	r = seq(r, either(consequence, alternative))
+	if returns(exp.Consequence) || (exp.Alternative != nil && returns(exp.Alternative)) {
+		// What follows the if may not run.
+		r.exact = false
+	}
+	return r
+}
+
+// tryExpression bounds the cost of a try by that of running all its blocks,
+// as the body may fail anywhere.
+func (a *analyzer) tryExpression(exp *ast.TryExpression) result {
+	r := a.block(exp.Body, nil)
+	if exp.Handler != nil {
+		a.bind(exp.Catch.Value, &binding{kind: otherKind})
+		r = seq(r, a.block(exp.Handler, nil))
+		r.exact = false
+	}
+	if exp.Finally != nil {
+		r = seq(r, a.block(exp.Finally, nil))
+	}
+	if returns(exp.Body) || (exp.Handler != nil && returns(exp.Handler)) {
+		// What follows the try may not run.
+		r.exact = false
+	}
+	return r
+}
+
+// matchExpression bounds the cost of a match by that of its subject, the
+// guards of all its arms and the costliest arm, as the arm that is taken is
+// not known before running. Patterns are tested for free.
+func (a *analyzer) matchExpression(exp *ast.MatchExpression) result {
+	r := a.expression(exp.Subject)
+	arms := free
+	for i, arm := range exp.Arms {
+		if bp, ok := arm.Pattern.(*ast.BindingPattern); ok {
+			// The whole subject is bound, as by a let.
+			a.bind(bp.Name.Value, a.describe(exp.Subject, nil))
+		} else {
+			for _, name := range ast.PatternBindings(arm.Pattern) {
+				a.bind(name.Value, &binding{kind: unknownKind})
+			}
+		}
+		if arm.Guard != nil {
+			r = seq(r, a.expression(arm.Guard))
+		}
+		var value result
+		if arm.Body != nil {
+			value = a.block(arm.Body, nil)
+			if returns(arm.Body) {
+				// What follows the match may not run.
+				r.exact = false
+			}
+		} else {
+			value = a.expression(arm.Value)
+		}
+		if i == 0 {
+			arms = value
+		} else {
+			arms = either(arms, value)
+		}
+	}
+	r = seq(r, arms)
+	if len(exp.Arms) != 1 || exp.Arms[0].Guard != nil || !matchesAll(exp.Arms[0].Pattern) {
+		r.exact = false
+	}
+	return r
+}
+
+// matchesAll reports whether p matches every value.
+func matchesAll(p ast.Pattern) bool {
+	switch p.(type) {
+	case *ast.WildcardPattern, *ast.BindingPattern:
+		return true
+	}
+	return false
+}
+
+// returns reports whether block may return from the enclosing function, or
+// throw.
+func returns(block *ast.BlockStatement) bool {
+	found := false
+	ast.Inspect(block, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.FunctionLiteral, *ast.MacroLiteral:
+			return false
+		case ast.Statement:
+			found = found || leaves(node)
+		}
+		return !found
+	})
+	return found
+}
+
+// leaves reports whether stmt leaves the statements it is part of.
+func leaves(stmt ast.Statement) bool {
+	switch stmt.(type) {
+	case *ast.ReturnStatement, *ast.ThrowStatement:
+		return true
+	}
+	return false
+}
+
+// sidedGuard is a guard that holds in one branch of an if.
+type sidedGuard struct {
+	guard
+	thenSide bool
+}
+
+func (g sidedGuard) ifGuarded(ok bool) *guard {
+	if !ok {
+		return nil
+	}
+	return &g.guard
+}
+
+// guard recognises conditions comparing a parameter of the function being
+// summarised with a constant.
+func (a *analyzer) guard(cond ast.Expression) (sidedGuard, bool) {
+	f := a.frame()
+	infix, ok := cond.(*ast.InfixExpression)
+	if f == nil || !ok {
+		return sidedGuard{}, false
+	}
+	param, k, op, ok := a.comparison(f, infix)
+	if !ok {
+		return sidedGuard{}, false
+	}
+	switch op {
+	case "<": // param >= k in the alternative
+		return sidedGuard{guard{param, k}, false}, true
+	case ">": // param >= k+1 in the consequence
+		return sidedGuard{guard{param, k + 1}, true}, true
+	}
+	return sidedGuard{}, false
+}
+
+// comparison matches `param op k` and `k op param`, returning the former.
+func (a *analyzer) comparison(f *frame, infix *ast.InfixExpression) (string, int64, string, bool) {
+	isParam := func(exp ast.Expression) (string, bool) {
+		ident, ok := exp.(*ast.Identifier)
+		if !ok {
+			return "", false
+		}
+		for _, p := range f.fn.Parameters {
+			if p.Value == ident.Value {
+				return ident.Value, true
+			}
+		}
+		return "", false
+	}
+	if param, ok := isParam(infix.Left); ok {
+		if k, ok := a.constant(infix.Right); ok {
+			return param, k, infix.Operator, true
+		}
+	}
+	if param, ok := isParam(infix.Right); ok {
+		if k, ok := a.constant(infix.Left); ok {
+			switch infix.Operator {
+			case "<":
+				return param, k, ">", true
+			case ">":
+				return param, k, "<", true
+			}
+		}
+	}
+	return "", 0, "", false
+}
+
+func (a *analyzer) constant(exp ast.Expression) (int64, bool) {
+	v, ok := a.value(exp)
+	if !ok {
+		return 0, false
+	}
+	return v.Constant()
+}
+
+// earlyReturnGuard recognises `if (n < k) { ...; return x; }`, after which n
+// is at least k.
+func (a *analyzer) earlyReturnGuard(stmt ast.Statement) (guard, bool) {
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok || a.frame() == nil {
+		return guard{}, false
+	}
+	ie, ok := es.Expression.(*ast.IfExpression)
+	if !ok || ie.Alternative != nil || len(ie.Consequence.Statements) == 0 {
+		return guard{}, false
+	}
+	last := ie.Consequence.Statements[len(ie.Consequence.Statements)-1]
+	if _, ok := last.(*ast.ReturnStatement); !ok {
+		return guard{}, false
+	}
+	g, ok := a.guard(ie.Condition)
+	if !ok || g.thenSide {
+		return guard{}, false
+	}
+	return g.guard, true
+}
+
+// block returns the cost of block, in which g holds if it is not nil.
+func (a *analyzer) block(block *ast.BlockStatement, g *guard) result {
+	f := a.frame()
+	if g != nil && f != nil {
+		f.guards = append(f.guards, *g)
+		defer func() { f.guards = f.guards[:len(f.guards)-1] }()
+	}
+	return a.statements(block.Statements)
+}
+
+func (a *analyzer) forExpression(exp *ast.ForExpression) result {
+	init := a.statement(&exp.Variable)
+	name := exp.Variable.Name.Value
+
+	start, ok := a.value(exp.Variable.Value)
+	if !ok {
+		return a.fail(exp.Token, "the initial value of loop variable %s is not known", name)
+	}
+	step, increasing, ok := a.step(name, &exp.Update)
+	if !ok {
+		return a.fail(exp.Token, "the loop does not count %s up or down by a constant", name)
+	}
+	infix, ok := exp.Condition.(*ast.InfixExpression)
+	if !ok {
+		return a.fail(exp.Token, "the loop condition does not compare %s with a bound", name)
+	}
+	var boundExp ast.Expression
+	switch {
+	case isIdent(infix.Left, name) && (infix.Operator == "<") == increasing && infix.Operator != "==" && infix.Operator != "!=":
+		boundExp = infix.Right
+	case isIdent(infix.Right, name) && (infix.Operator == ">") == increasing && infix.Operator != "==" && infix.Operator != "!=":
+		boundExp = infix.Left
+	default:
+		return a.fail(exp.Token, "the loop condition does not compare %s with a bound", name)
+	}
+
+	assigned := map[string]bool{}
+	ast.Lets(exp.Loop.Statements, func(let *ast.LetStatement) {
+		assigned[let.Name.Value] = true
+	})
+	if assigned[name] {
+		return a.fail(exp.Token, "loop variable %s is assigned in the loop", name)
+	}
+	for _, ident := range identifiers(boundExp) {
+		if assigned[ident] {
+			return a.fail(exp.Token, "loop bound %s is assigned in the loop", boundExp)
+		}
+	}
+	bound, ok := a.value(boundExp)
+	if !ok {
+		return a.fail(exp.Token, "loop bound %s is not known before running", boundExp)
+	}
+
+	var iterations Poly
+	exact := true
+	if increasing {
+		iterations = bound.Sub(start)
+	} else {
+		iterations = start.Sub(bound)
+	}
+	if n, ok := iterations.Constant(); ok {
+		n = (n + step - 1) / step
+		if n < 0 {
+			n = 0
+		}
+		iterations = Const(n)
+	} else {
+		// The bound is not divided by step, which polynomials cannot
+		// express, and the loop may not run at all.
+		exact = false
+	}
+
+	// Variables assigned in the loop take values that are not known, but
+	// are assumed to keep their kind, which is checked below.
+	kinds := map[string]kind{}
+	for assignedName := range assigned {
+		b, _ := a.lookup(assignedName)
+		if b == nil {
+			b = &binding{}
+		}
+		kinds[assignedName] = b.kind
+		a.bind(assignedName, &binding{kind: b.kind})
+	}
+	a.bind(name, &binding{kind: intKind})
+
+	body := seq(seq(a.expression(exp.Condition), a.block(exp.Loop, nil)), a.statement(&exp.Update))
+	changed := false
+	for assignedName, k := range kinds {
+		if b, _ := a.lookup(assignedName); b.kind != k {
+			kinds[assignedName] = unknownKind
+			changed = true
+		}
+	}
+	if changed {
+		// Analyse the body again with the kinds that changed unknown.
+		for assignedName, k := range kinds {
+			a.bind(assignedName, &binding{kind: k})
+		}
+		a.bind(name, &binding{kind: intKind})
+		body = seq(seq(a.expression(exp.Condition), a.block(exp.Loop, nil)), a.statement(&exp.Update))
+		body.exact = false
+		for assignedName, k := range kinds {
+			if k == unknownKind {
+				a.bind(assignedName, &binding{})
+			}
+		}
+	}
+	if body.calls > 0 {
+		return a.fail(exp.Token, "recursion inside a loop")
+	}
+	a.bind(name, &binding{kind: intKind})
+	r := seq(init, a.expression(exp.Condition))
+	r = seq(r, result{cost: iterations.Mul(body.cost), exact: exact && body.exact})
+	return r
+}
+
+// step matches updates of the form `let name = name + c` or `name - c`.
+func (a *analyzer) step(name string, update *ast.LetStatement) (int64, bool, bool) {
+	if update.Name.Value != name {
+		return 0, false, false
+	}
+	infix, ok := update.Value.(*ast.InfixExpression)
+	if !ok {
+		return 0, false, false
+	}
+	var other ast.Expression
+	switch {
+	case isIdent(infix.Left, name):
+		other = infix.Right
+	case isIdent(infix.Right, name) && infix.Operator == "+":
+		other = infix.Left
+	default:
+		return 0, false, false
+	}
+	c, ok := a.constant(other)
+	if !ok || c <= 0 {
+		return 0, false, false
+	}
+	switch infix.Operator {
+	case "+":
+		return c, true, true
+	case "-":
+		return c, false, true
+	}
+	return 0, false, false
+}
+
+func (a *analyzer) call(exp *ast.CallExpression) result {
+	if _, ok := ast.IsCallTo(exp, "quote"); ok {
+		// Only the unquoted parts of a quote are evaluated.
+		r := free
+		for _, arg := range exp.Arguments {
+			for _, unquoted := range ast.Unquotes(arg) {
+				r = seq(r, a.expression(unquoted))
+			}
+		}
+		return r
+	}
+	args := free
+	for _, arg := range exp.Arguments {
+		args = seq(args, a.expression(arg))
+	}
+
+	switch callee := exp.Function.(type) {
+	case *ast.FunctionLiteral:
+		return seq(args, a.apply(exp, callee, a.scopes))
+	case *ast.Identifier:
+		b, ok := a.lookup(callee.Value)
+		if !ok {
+			if op, ok := a.builtins[callee.Value]; ok {
+				return seq(args, a.op(op))
+			}
+			if evaluator.IsCaller(callee.Value) {
+				return a.fail(callee.Token, "cannot bound the calls %s makes to the functions it is passed", callee.Value)
+			}
+			if evaluator.IsBuiltin(callee.Value) {
+				return seq(args, a.op(evaluator.BuiltinOpcode(callee.Value)))
+			}
+			return a.fail(callee.Token, "call to unknown function %s", callee.Value)
+		}
+		if b.fn == nil {
+			return a.fail(callee.Token, "%s is not known to be a function before running", callee.Value)
+		}
+		if f := a.frame(); f != nil && f.fn == b.fn {
+			return seq(args, a.recursiveCall(exp, callee, f))
+		}
+		for _, f := range a.frames {
+			if f.fn == b.fn {
+				return a.fail(callee.Token, "mutual recursion through %s", callee.Value)
+			}
+		}
+		return seq(args, a.apply(exp, b.fn, b.scopes))
+	}
+	return a.fail(tokenOf(exp.Function), "call to a function not known before running")
+}
+
+// recursiveCall checks that a recursive call decreases a parameter that is
+// bounded from below on the current path.
+func (a *analyzer) recursiveCall(exp *ast.CallExpression, callee *ast.Identifier, f *frame) result {
+	for _, g := range f.guards {
+		for i, p := range f.fn.Parameters {
+			if p.Value != g.param || i >= len(exp.Arguments) {
+				continue
+			}
+			infix, ok := exp.Arguments[i].(*ast.InfixExpression)
+			if !ok || infix.Operator != "-" || !isIdent(infix.Left, g.param) {
+				continue
+			}
+			if c, ok := a.constant(infix.Right); !ok || c <= 0 {
+				continue
+			}
+			// The parameter decreases by at least 1 from its value down
+			// to min, and there is one more call that stops.
+			depth := Sym(g.param).Sub(Const(g.min)).Add(Const(2))
+			if f.depth != nil {
+				depth = Max(*f.depth, depth)
+			}
+			f.depth = &depth
+			return result{exact: false, calls: 1}
+		}
+	}
+	return a.fail(callee.Token, "cannot show that the recursion of %s terminates", callee.Value)
+}
+
+// apply returns the cost of calling fn, defined in scopes, with the
+// arguments of exp.
+func (a *analyzer) apply(exp *ast.CallExpression, fn *ast.FunctionLiteral, scopes []scope) result {
+	kinds, key := a.argumentKinds(exp, fn)
+	fixed := fixedParameters(fn)
+	for _, p := range fn.Parameters[len(kinds):fixed] {
+		if p.Default == nil {
+			return a.fail(exp.Token, "too few arguments")
+		}
+	}
+	s, ok := a.summaries[key]
+	if !ok {
+		s = a.summarize(fn, scopes, kinds)
+		a.summaries[key] = s
+	}
+
+	values := map[string]Poly{}
+	if fn.Variadic {
+		rest := fn.Parameters[fixed].Value
+		values["len("+rest+")"] = Const(int64(len(exp.Arguments) - len(kinds)))
+	}
+	for i, p := range fn.Parameters[:len(kinds)] {
+		if v, ok := a.value(exp.Arguments[i]); ok {
+			values[p.Value] = v
+		}
+		if n, ok := a.length(exp.Arguments[i]); ok {
+			values["len("+p.Value+")"] = n
+		}
+	}
+	for _, sym := range s.cost.Symbols() {
+		if _, ok := values[sym]; !ok && isParamSymbol(fn, sym) {
+			return a.fail(exp.Token, "the cost of the call depends on %s, which is not known before running", sym)
+		}
+	}
+	c, err := s.cost.Subst(values)
+	if err != nil {
+		return a.fail(exp.Token, "%s", err)
+	}
+	return result{cost: c, exact: s.exact}
+}
+
+// summarize returns the cost of a call to fn in terms of its parameters,
+// given the kinds of its arguments. The parameters after those it is given
+// arguments for take their default values, and its rest parameter is an
+// array of the length of the same name.
+func (a *analyzer) summarize(fn *ast.FunctionLiteral, scopes []scope, kinds []kind) *summary {
+	saved := a.scopes
+	a.scopes = append(append([]scope{}, scopes...), scope{})
+	defer func() { a.scopes = saved }()
+
+	f := &frame{fn: fn}
+	a.frames = append(a.frames, f)
+	r := a.op(gas.OpCall)
+	for i, p := range fn.Parameters {
+		if fn.Variadic && i == len(fn.Parameters)-1 {
+			n := Sym("len(" + p.Value + ")")
+			a.bind(p.Value, &binding{kind: otherKind, length: &n})
+			continue
+		}
+		if i >= len(kinds) {
+			// Defaults are evaluated in the call, after the parameters
+			// before them are bound.
+			r = seq(r, a.expression(p.Default))
+			a.bind(p.Value, a.describe(p.Default, p.Type))
+			continue
+		}
+		b := &binding{kind: kinds[i]}
+		if named, ok := p.Type.(*ast.NamedType); ok {
+			b.kind = namedKind(named.Name)
+		}
+		v := Sym(p.Value)
+		b.value = &v
+		n := Sym("len(" + p.Value + ")")
+		b.length = &n
+		a.bind(p.Value, b)
+	}
+
+	r = seq(r, a.statements(fn.Body.Statements))
+	a.frames = a.frames[:len(a.frames)-1]
+
+	if r.calls == 0 {
+		return &summary{cost: r.cost, exact: r.exact, kind: a.resultKind(fn.Body)}
+	}
+	// Each call makes at most r.calls recursive calls, down to f.depth.
+	if r.calls == 1 {
+		return &summary{cost: f.depth.Mul(r.cost)}
+	}
+	if n, ok := f.depth.Constant(); ok {
+		return &summary{cost: r.cost.Scale(satPow(int64(r.calls), n))}
+	}
+	param := f.depth.Symbols()[0]
+	shift, _ := f.depth.Sub(Sym(param)).Constant()
+	if shift < 0 {
+		shift = 0
+	}
+	calls := Exp(int64(r.calls), param).Scale(satPow(int64(r.calls), shift))
+	return &summary{cost: calls.Mul(r.cost)}
+}
+
+func isParamSymbol(fn *ast.FunctionLiteral, sym string) bool {
+	for _, p := range fn.Parameters {
+		if sym == p.Value || sym == "len("+p.Value+")" {
+			return true
+		}
+	}
+	return false
+}
+
+func isIdent(exp ast.Expression, name string) bool {
+	ident, ok := exp.(*ast.Identifier)
+	return ok && ident.Value == name
+}
+
+func identifiers(exp ast.Expression) []string {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		return []string{exp.Value}
+	case *ast.PrefixExpression:
+		return identifiers(exp.Right)
+	case *ast.InfixExpression:
+		return append(identifiers(exp.Left), identifiers(exp.Right)...)
+	case *ast.CallExpression:
+		var names []string
+		for _, arg := range exp.Arguments {
+			names = append(names, identifiers(arg)...)
+		}
+		return names
+	}
+	return nil
+}
+
+func tokenOf(exp ast.Expression) token.Token {
+	switch exp := exp.(type) {
+	case *ast.CallExpression:
+		return exp.Token
+	case *ast.IndexExpression:
+		return exp.Token
+	case *ast.InfixExpression:
+		return exp.Token
+	case *ast.PrefixExpression:
+		return exp.Token
+	case *ast.IfExpression:
+		return exp.Token
+	}
+	return token.Token{}
+}
@@ -0,0 +1,204 @@
+package cost
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/evaluator"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	return program
+}
+
+// run returns the gas charged for running input with globals bound.
+func run(t *testing.T, input string, schedule *gas.Schedule, globals map[string]int64) uint64 {
+	t.Helper()
+	meter := gas.NewMeter(1000000)
+	meter.Schedule = schedule
+	env := object.NewMeteredEnvironment(meter)
+	for name, v := range globals {
+		env.Set(name, &object.Integer{Value: v})
+	}
+	if result := evaluator.Eval(parse(t, input), env, nil, nil); result != nil {
+		if errObj, ok := result.(*object.Error); ok {
+			t.Fatalf("%q: %s", input, errObj.Message)
+		}
+	}
+	return meter.Used()
+}
+
+func TestPoly(t *testing.T) {
+	n, m := Sym("n"), Sym("m")
+	tests := []struct {
+		poly     Poly
+		expected string
+	}{
+		{Poly{}, "0"},
+		{Const(3).Add(Const(4)), "7"},
+		{n.Scale(3).Add(Const(1)).Add(n.Mul(n).Scale(2)), "2*n*n + 3*n + 1"},
+		{n.Sub(n), "0"},
+		{Const(2).Sub(n), "-n + 2"},
+		{n.Add(Const(1)).Mul(m.Add(Const(1))), "m*n + m + n + 1"},
+		{Max(n.Scale(2), m.Add(Const(1))), "m + 2*n + 1"},
+		{Max(n.Scale(2), n.Scale(3).Sub(Const(1))), "3*n"},
+		{Exp(2, "n").Scale(3).Add(n), "3*2^n + n"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.poly.String(); got != tt.expected {
+			t.Errorf("wrong polynomial. expected=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	p, err := Exp(2, "n").Mul(m).Subst(map[string]Poly{"n": Const(3), "m": n})
+	if err != nil || p.String() != "8*n" {
+		t.Errorf("wrong substitution. got=%s, %v", p, err)
+	}
+	if _, err := Exp(2, "n").Subst(map[string]Poly{"n": n.Add(Const(1))}); err == nil {
+		t.Errorf("expected an error raising to n + 1")
+	}
+	if c, ok := Const(1 << 62).Scale(4).Constant(); !ok || c <= 0 {
+		t.Errorf("expected saturation, got %d", c)
+	}
+}
+
+func TestExact(t *testing.T) {
+	schedule := &gas.Schedule{Version: "test", Default: 1, Weights: map[int]uint64{
+		gas.OpMul:    3,
+		gas.OpConcat: 5,
+		gas.OpPow:    7,
+	}}
+	tests := []string{
+		`1 + 2 * 3`,
+		`let a = 4; let b = a * a - 1; b / 3 < 5`,
+		`let s = "a" + "b"; len(s + "c")`,
+		`-5 * 2; !true; true == false`,
+		`pow(2, 3) + sqrt(16) + len([1, 2, 3])`,
+		`let f = fn(x) { x * 2 + 1 }; f(3) + f(4)`,
+		`let s = 0; for (let i = 0; i < 10; let i = i + 1) { let s = s + i * 2; }; s`,
+		`for (let i = 10; i > 0; let i = i - 3) { i * i }`,
+		`for (let i = 5; i < 3; let i = i + 1) { i * i }`,
+		`let add = fn(a, b) { let c = a + b; c * c }; let g = fn(x) { add(x, 1) + add(x, x) }; g(2)`,
+		`let s = ""; for (let i = 0; i < 3; let i = i + 1) { let s = s + "x"; }; s`,
+		`if (1 < 2) { 3 + 4 } else { 5 - 6 }`,
+		`match (3) { x => x * 2 + 1 }`,
+		`let f = fn(x, y = x * 2) { x + y }; f(3) + f(3, 4)`,
+		`let g = fn(a, b = 1, ...cs) { a * b + len(cs) }; g(2) + g(2, 3) + g(2, 3, 4, 5)`,
+	}
+
+	for _, input := range tests {
+		est := Analyze(parse(t, input), schedule, nil)
+		if est.Unbounded || !est.Exact {
+			t.Errorf("%q: expected an exact estimate, got %s", input, est)
+			continue
+		}
+		got, _ := est.Gas.Constant()
+		if used := run(t, input, schedule, nil); uint64(got) != used {
+			t.Errorf("%q: wrong estimate. expected=%d, got=%d", input, used, got)
+		}
+	}
+}
+
+func TestBounds(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		globals  map[string]int64
+	}{
+		{`if (n < 2) { 1 } else { n * 2 + 1 }`, "at most 3", map[string]int64{"n": 5}},
+		{`for (let i = 0; i < n; let i = i + 1) { i * i }`, "at most 3*n + 1", map[string]int64{"n": 7}},
+		{`for (let i = 0; i < n; let i = i + 1) { for (let j = 0; j < n; let j = j + 1) { i * j } }`,
+			"at most 3*n*n + 3*n + 1", map[string]int64{"n": 4}},
+		{`let sum = fn(n) { let s = 0; for (let i = 0; i < n; let i = i + 1) { let s = s + i; }; s }; sum(m)`,
+			"at most 3*m + 2", map[string]int64{"m": 20}},
+		{`let fact = fn(n) { if (n < 2) { return 1; } n * fact(n - 1) }; fact(k)`,
+			"at most 4*k", map[string]int64{"k": 10}},
+		{`let count = fn(n) { if (n > 0) { count(n - 1) + 1 } else { 0 } }; count(k)`,
+			"at most 4*k + 4", map[string]int64{"k": 7}},
+		{`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(k)`,
+			"at most 5*2^k", map[string]int64{"k": 10}},
+		{`match (n) { 0 => 1, m if m > 5 => m * m * m, _ => n + 1 }`, "at most 3", map[string]int64{"n": 7}},
+		{`let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(10)`,
+			"at most 5120", nil},
+		{`let sum = fn(...xs) { let s = 0; for (let i = 0; i < len(xs); let i = i + 1) { let s = s + xs[i]; }; s }; sum(1, 2, 3) + sum(n)`,
+			"at most 23", map[string]int64{"n": 4}},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		est := Analyze(program, nil, nil)
+		if est.String() != tt.expected {
+			t.Errorf("%q: wrong estimate. expected=%q, got=%q", tt.input, tt.expected, est)
+			continue
+		}
+		values := map[string]Poly{}
+		for name, v := range tt.globals {
+			values[name] = Const(v)
+		}
+		bound, err := est.Gas.Subst(values)
+		if err != nil {
+			t.Fatalf("%q: %s", tt.input, err)
+		}
+		max, ok := bound.Constant()
+		if !ok {
+			t.Fatalf("%q: bound %s is not constant", tt.input, bound)
+		}
+		if used := run(t, tt.input, nil, tt.globals); used > uint64(max) {
+			t.Errorf("%q: bound %d below gas used %d", tt.input, max, used)
+		}
+	}
+}
+
+func TestUnbounded(t *testing.T) {
+	tests := []struct {
+		input  string
+		reason string
+	}{
+		{`let f = fn(n) { f(n) }; f(1)`, "1:17: cannot show that the recursion of f terminates"},
+		{`let f = fn(n) { if (n < 1) { 0 } else { f(n + 1) } }; f(1)`, "cannot show that the recursion of f terminates"},
+		{`let f = fn(n) { g(n) }; let g = fn(n) { f(n) }; f(1)`, "mutual recursion through f"},
+		{`for (let i = 0; i < 10; let i = i * 2) { 1 }`, "the loop does not count i up or down by a constant"},
+		{`for (let i = 0; i < 10; let i = i - 1) { 1 }`, "the loop condition does not compare i with a bound"},
+		{`for (let i = 0; i < 10; let i = i + 1) { let i = 0; }`, "loop variable i is assigned in the loop"},
+		{`let n = 3; for (let i = 0; i < n; let i = i + 1) { let n = n + 1; }`, "loop bound n is assigned in the loop"},
+		{`let n = 3; for (let i = 0; i < n; let i = i + 1) { len([if (true) { let n = n + 1; n }]); }`, "loop bound n is assigned in the loop"},
+		{`let n = 3; for (let i = 0; i < n; let i = i + 1) { let x = if (true) { let n = n + 1; n }; }`, "loop bound n is assigned in the loop"},
+		{`let n = 3; for (let i = 0; i < n; let i = i + 1) { 1 + match (i) { _ => { let n = n + 1; n } }; }`, "loop bound n is assigned in the loop"},
+		{`let xs = [fn() { 1 }]; xs[0]()`, "call to a function not known before running"},
+		{`unknown(1)`, "call to unknown function unknown"},
+		{`let f = fn(x, y = 1) { x + y }; f()`, "too few arguments"},
+		{`let apply = fn(f) { f(1) }; apply(fn(x) { x })`, "f is not known to be a function before running"},
+		{`let f = fn(n) { for (let i = 0; i < n; let i = i + 1) { f(n - 1) } }; f(3)`, "cannot show that the recursion of f terminates"},
+	}
+
+	for _, tt := range tests {
+		est := Analyze(parse(t, tt.input), nil, nil)
+		if !est.Unbounded {
+			t.Errorf("%q: expected unbounded, got %s", tt.input, est)
+			continue
+		}
+		if !strings.Contains(est.String(), tt.reason) {
+			t.Errorf("%q: wrong reason. expected=%q, got=%q", tt.input, tt.reason, est)
+		}
+	}
+}
+
+func TestHostBuiltins(t *testing.T) {
+	program := parse(t, `log(1); free(2); 1 + 2`)
+	est := Analyze(program, nil, map[string]int{"log": gas.OpPow, "free": gas.OpNone})
+	if !est.Exact || est.String() != "2" {
+		t.Errorf("wrong estimate. got=%s", est)
+	}
+}
@@ -0,0 +1,284 @@
+package cost
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Factor is a symbol, or a constant raised to a symbol if Base is set.
+// Symbols stand for values that are only known when the program runs.
+type Factor struct {
+	Symbol string
+	Base   int64
+}
+
+func (f Factor) String() string {
+	if f.Base != 0 {
+		return fmt.Sprintf("%d^%s", f.Base, f.Symbol)
+	}
+	return f.Symbol
+}
+
+type term struct {
+	factors []Factor // sorted
+	coef    int64
+}
+
+func (t term) key() string {
+	parts := make([]string, len(t.factors))
+	for i, f := range t.factors {
+		parts[i] = f.String()
+	}
+	return strings.Join(parts, "*")
+}
+
+// Poly is a polynomial over symbols with integer coefficients. The zero
+// value is 0. Arithmetic saturates instead of overflowing.
+type Poly struct {
+	terms map[string]term
+}
+
+func Const(c int64) Poly {
+	return Poly{}.add(term{coef: c})
+}
+
+func Sym(name string) Poly {
+	return Poly{}.add(term{factors: []Factor{{Symbol: name}}, coef: 1})
+}
+
+// Exp returns base raised to the symbol name.
+func Exp(base int64, name string) Poly {
+	return Poly{}.add(term{factors: []Factor{{Symbol: name, Base: base}}, coef: 1})
+}
+
+func (p Poly) add(t term) Poly {
+	if t.coef == 0 {
+		return p
+	}
+	terms := make(map[string]term, len(p.terms)+1)
+	for k, v := range p.terms {
+		terms[k] = v
+	}
+	k := t.key()
+	if old, ok := terms[k]; ok {
+		t.coef = satAdd(old.coef, t.coef)
+	}
+	if t.coef == 0 {
+		delete(terms, k)
+	} else {
+		terms[k] = t
+	}
+	return Poly{terms: terms}
+}
+
+func (p Poly) Add(q Poly) Poly {
+	for _, t := range q.terms {
+		p = p.add(t)
+	}
+	return p
+}
+
+func (p Poly) Sub(q Poly) Poly {
+	return p.Add(q.Scale(-1))
+}
+
+func (p Poly) Scale(k int64) Poly {
+	var r Poly
+	for _, t := range p.terms {
+		r = r.add(term{factors: t.factors, coef: satMul(t.coef, k)})
+	}
+	return r
+}
+
+func (p Poly) Mul(q Poly) Poly {
+	var r Poly
+	for _, a := range p.terms {
+		for _, b := range q.terms {
+			factors := append(append([]Factor{}, a.factors...), b.factors...)
+			sort.Slice(factors, func(i, j int) bool {
+				return factors[i].String() < factors[j].String()
+			})
+			r = r.add(term{factors: factors, coef: satMul(a.coef, b.coef)})
+		}
+	}
+	return r
+}
+
+// Max returns a polynomial that is at least p and at least q for all
+// non-negative values of the symbols.
+func Max(p, q Poly) Poly {
+	var r Poly
+	seen := map[string]bool{}
+	for k, a := range p.terms {
+		c := a.coef
+		if b, ok := q.terms[k]; ok && b.coef > c {
+			c = b.coef
+		} else if !ok && c < 0 {
+			c = 0
+		}
+		seen[k] = true
+		r = r.add(term{factors: a.factors, coef: c})
+	}
+	for k, b := range q.terms {
+		if !seen[k] && b.coef > 0 {
+			r = r.add(b)
+		}
+	}
+	return r
+}
+
+// Constant returns the value of p if it has no symbols.
+func (p Poly) Constant() (int64, bool) {
+	switch len(p.terms) {
+	case 0:
+		return 0, true
+	case 1:
+		if t, ok := p.terms[""]; ok {
+			return t.coef, true
+		}
+	}
+	return 0, false
+}
+
+func (p Poly) Equal(q Poly) bool {
+	return p.String() == q.String()
+}
+
+// Symbols returns the symbols p depends on, sorted.
+func (p Poly) Symbols() []string {
+	seen := map[string]bool{}
+	var syms []string
+	for _, t := range p.terms {
+		for _, f := range t.factors {
+			if !seen[f.Symbol] {
+				seen[f.Symbol] = true
+				syms = append(syms, f.Symbol)
+			}
+		}
+	}
+	sort.Strings(syms)
+	return syms
+}
+
+// Subst replaces the symbols in values by their polynomials. A symbol that
+// is an exponent can only be replaced by a constant or a single symbol.
+func (p Poly) Subst(values map[string]Poly) (Poly, error) {
+	var r Poly
+	for _, t := range p.terms {
+		product := Const(t.coef)
+		for _, f := range t.factors {
+			v, ok := values[f.Symbol]
+			if !ok {
+				product = product.Mul(Poly{}.add(term{factors: []Factor{f}, coef: 1}))
+				continue
+			}
+			if f.Base == 0 {
+				product = product.Mul(v)
+				continue
+			}
+			if c, ok := v.Constant(); ok {
+				product = product.Mul(Const(satPow(f.Base, c)))
+				continue
+			}
+			syms := v.Symbols()
+			if len(v.terms) != 1 || len(syms) != 1 || !v.Equal(Sym(syms[0])) {
+				return Poly{}, fmt.Errorf("cannot raise %d to %s", f.Base, v)
+			}
+			product = product.Mul(Exp(f.Base, syms[0]))
+		}
+		r = r.Add(product)
+	}
+	return r, nil
+}
+
+// String formats p with its highest degree terms first, as in 2*n*n + 3*n + 1.
+func (p Poly) String() string {
+	if len(p.terms) == 0 {
+		return "0"
+	}
+	terms := make([]term, 0, len(p.terms))
+	for _, t := range p.terms {
+		terms = append(terms, t)
+	}
+	sort.Slice(terms, func(i, j int) bool {
+		if di, dj := degree(terms[i]), degree(terms[j]); di != dj {
+			return di > dj
+		}
+		return terms[i].key() < terms[j].key()
+	})
+
+	var out strings.Builder
+	for i, t := range terms {
+		c := t.coef
+		switch {
+		case i == 0 && c < 0:
+			out.WriteString("-")
+			c = -c
+		case i > 0 && c < 0:
+			out.WriteString(" - ")
+			c = -c
+		case i > 0:
+			out.WriteString(" + ")
+		}
+		k := t.key()
+		switch {
+		case k == "":
+			fmt.Fprintf(&out, "%d", c)
+		case c == 1:
+			out.WriteString(k)
+		default:
+			fmt.Fprintf(&out, "%d*%s", c, k)
+		}
+	}
+	return out.String()
+}
+
+// degree orders terms by growth: exponentials first, then by the number of
+// factors.
+func degree(t term) int {
+	d := len(t.factors)
+	for _, f := range t.factors {
+		if f.Base != 0 {
+			d += 1 << 16
+		}
+	}
+	return d
+}
+
+func satAdd(a, b int64) int64 {
+	s := a + b
+	switch {
+	case a > 0 && b > 0 && s < 0:
+		return math.MaxInt64
+	case a < 0 && b < 0 && s >= 0:
+		return math.MinInt64
+	}
+	return s
+}
+
+func satMul(a, b int64) int64 {
+	if a == 0 || b == 0 {
+		return 0
+	}
+	p := a * b
+	if p/b != a || (a == -1 && b == math.MinInt64) || (b == -1 && a == math.MinInt64) {
+		if (a > 0) == (b > 0) {
+			return math.MaxInt64
+		}
+		return math.MinInt64
+	}
+	return p
+}
+
+func satPow(base, exp int64) int64 {
+	r := int64(1)
+	for ; exp > 0; exp-- {
+		r = satMul(r, base)
+		if r == math.MaxInt64 {
+			break
+		}
+	}
+	return r
+}
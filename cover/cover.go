@@ -0,0 +1,266 @@
+// Package cover measures which statements and branches of programs run. A
+// Profile holds the coverage of the files it has tracked, added up over
+// every run recorded in it, and Track returns the hook that records a run:
+//
+//	p := cover.New()
+//	in := interpreter.New(interpreter.WithHook(p.Track("prog.mk", program)))
+//	in.RunProgram(program)
+//	p.WriteText(os.Stdout)
+//
+// Statements and branches are keyed by their positions in their files, so
+// that profiles of runs of the same files, read back with ReadJSON, can be
+// merged. A branch is an arm of an if expression, including a missing else,
+// entering or leaving a for loop, or an arm of a match expression.
+package cover
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// Version is the version of the JSON encoding of profiles.
+const Version = 1
+
+// Statement is a statement of a file and how often it ran.
+type Statement struct {
+	Line   int    `json:"line"`
+	Column int    `json:"column"`
+	Count  uint64 `json:"count"`
+}
+
+// Branch is an if, for or match expression of a file and how often each of
+// its branches was taken.
+type Branch struct {
+	Line   int      `json:"line"`
+	Column int      `json:"column"`
+	Kind   string   `json:"kind"` // "if", "for" or "match"
+	Counts []uint64 `json:"counts"`
+}
+
+// Name returns the name of branch i of b.
+func (b *Branch) Name(i int) string {
+	switch b.Kind {
+	case "if":
+		if i == 0 {
+			return "then"
+		}
+		return "else"
+	case "for":
+		if i == 0 {
+			return "body"
+		}
+		return "exit"
+	}
+	return fmt.Sprintf("arm %d", i+1)
+}
+
+// File is the coverage of a file. Its statements and branches are in the
+// order of their positions.
+type File struct {
+	Path       string       `json:"path"`
+	Statements []*Statement `json:"statements"`
+	Branches   []*Branch    `json:"branches"`
+}
+
+// Profile is the coverage of files, in the order of their paths.
+type Profile struct {
+	Files []*File
+}
+
+// New returns an empty profile.
+func New() *Profile {
+	return &Profile{}
+}
+
+type position struct{ line, column int }
+
+func key(pos token.Position) position {
+	return position{pos.Line, pos.Column}
+}
+
+// file returns the coverage of path, adding it if it is new.
+func (p *Profile) file(path string) *File {
+	i := sort.Search(len(p.Files), func(i int) bool { return p.Files[i].Path >= path })
+	if i < len(p.Files) && p.Files[i].Path == path {
+		return p.Files[i]
+	}
+	f := &File{Path: path, Statements: []*Statement{}, Branches: []*Branch{}}
+	p.Files = append(p.Files, nil)
+	copy(p.Files[i+1:], p.Files[i:])
+	p.Files[i] = f
+	return f
+}
+
+// index returns the statements and branches of f by position.
+func (f *File) index() (map[position]*Statement, map[position]*Branch) {
+	statements := map[position]*Statement{}
+	for _, s := range f.Statements {
+		statements[position{s.Line, s.Column}] = s
+	}
+	branches := map[position]*Branch{}
+	for _, b := range f.Branches {
+		branches[position{b.Line, b.Column}] = b
+	}
+	return statements, branches
+}
+
+func (f *File) sort() {
+	sort.SliceStable(f.Statements, func(i, j int) bool {
+		a, b := f.Statements[i], f.Statements[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+	sort.SliceStable(f.Branches, func(i, j int) bool {
+		a, b := f.Branches[i], f.Branches[j]
+		return a.Line < b.Line || a.Line == b.Line && a.Column < b.Column
+	})
+}
+
+// Track adds the statements and branches of program, read from path, to
+// the profile, and returns the hook that records the runs of program in
+// it. Track program before running it: the lets binding macros are left
+// out, and the code that macros expand to is not tracked.
+func (p *Profile) Track(path string, program *ast.Program) *Tracker {
+	f := p.file(path)
+	statements, branches := f.index()
+	add := func(stmts []ast.Statement) {
+		for _, stmt := range stmts {
+			if let, ok := stmt.(*ast.LetStatement); ok {
+				if _, ok := let.Value.(*ast.MacroLiteral); ok {
+					continue
+				}
+			}
+			pos := key(ast.Pos(stmt))
+			if statements[pos] == nil {
+				s := &Statement{Line: pos.line, Column: pos.column}
+				statements[pos] = s
+				f.Statements = append(f.Statements, s)
+			}
+		}
+	}
+	branch := func(node ast.Expression, kind string, n int) {
+		pos := key(ast.Pos(node))
+		if b := branches[pos]; b == nil || b.Kind != kind || len(b.Counts) != n {
+			b = &Branch{Line: pos.line, Column: pos.column, Kind: kind, Counts: make([]uint64, n)}
+			if branches[pos] != nil {
+				// The file has changed since, so its old counts are lost.
+				*branches[pos] = *b
+			} else {
+				branches[pos] = b
+				f.Branches = append(f.Branches, b)
+			}
+		}
+	}
+	ast.Inspect(program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.MacroLiteral:
+			return false
+		case *ast.Program:
+			add(node.Statements)
+		case *ast.BlockStatement:
+			add(node.Statements)
+		case *ast.IfExpression:
+			branch(node, "if", 2)
+		case *ast.ForExpression:
+			branch(node, "for", 2)
+		case *ast.MatchExpression:
+			branch(node, "match", len(node.Arms))
+		}
+		return true
+	})
+	f.sort()
+	return &Tracker{statements: statements, branches: branches}
+}
+
+// Tracker is the object.BranchHook that records runs in a profile.
+type Tracker struct {
+	statements map[position]*Statement
+	branches   map[position]*Branch
+}
+
+func (t *Tracker) Statement(stmt ast.Statement, env *object.Environment) {
+	if s := t.statements[key(ast.Pos(stmt))]; s != nil {
+		s.Count++
+	}
+}
+
+func (t *Tracker) Call(fn *object.Function, env *object.Environment) {}
+
+func (t *Tracker) Return(fn *object.Function, result object.Object) {}
+
+func (t *Tracker) Branch(node ast.Expression, branch int) {
+	if b := t.branches[key(ast.Pos(node))]; b != nil && branch < len(b.Counts) {
+		b.Counts[branch]++
+	}
+}
+
+// Merge adds the counts of q to p.
+func (p *Profile) Merge(q *Profile) error {
+	for _, qf := range q.Files {
+		f := p.file(qf.Path)
+		statements, branches := f.index()
+		for _, s := range qf.Statements {
+			pos := position{s.Line, s.Column}
+			if statements[pos] == nil {
+				statements[pos] = &Statement{Line: s.Line, Column: s.Column}
+				f.Statements = append(f.Statements, statements[pos])
+			}
+			statements[pos].Count += s.Count
+		}
+		for _, b := range qf.Branches {
+			pos := position{b.Line, b.Column}
+			mine := branches[pos]
+			if mine == nil {
+				mine = &Branch{Line: b.Line, Column: b.Column, Kind: b.Kind, Counts: make([]uint64, len(b.Counts))}
+				branches[pos] = mine
+				f.Branches = append(f.Branches, mine)
+			}
+			if mine.Kind != b.Kind || len(mine.Counts) != len(b.Counts) {
+				return fmt.Errorf("cover: %s:%d:%d: %s with %d branches does not match %s with %d",
+					f.Path, b.Line, b.Column, b.Kind, len(b.Counts), mine.Kind, len(mine.Counts))
+			}
+			for i, n := range b.Counts {
+				mine.Counts[i] += n
+			}
+		}
+		f.sort()
+	}
+	return nil
+}
+
+type jsonProfile struct {
+	Version int     `json:"version"`
+	Files   []*File `json:"files"`
+}
+
+// WriteJSON writes p as JSON, to be read back by ReadJSON.
+func (p *Profile) WriteJSON(w io.Writer) error {
+	files := p.Files
+	if files == nil {
+		files = []*File{}
+	}
+	return json.NewEncoder(w).Encode(jsonProfile{Version: Version, Files: files})
+}
+
+// ReadJSON reads a profile written by WriteJSON.
+func ReadJSON(r io.Reader) (*Profile, error) {
+	var decoded jsonProfile
+	if err := json.NewDecoder(r).Decode(&decoded); err != nil {
+		return nil, fmt.Errorf("cover: %w", err)
+	}
+	if decoded.Version != Version {
+		return nil, fmt.Errorf("cover: unsupported version %d", decoded.Version)
+	}
+	// Merging sorts the files and whatever is in them, and checks that the
+	// branches of a position agree.
+	p := New()
+	if err := p.Merge(&Profile{Files: decoded.Files}); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
@@ -0,0 +1,117 @@
+package cover
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/interpreter"
+)
+
+const program = `let abs = fn(x) {
+	if (x < 0) { return -x; }
+	x
+};
+let sign = fn(x) { match (x) { 0 => "zero", _ if x > 0 => "pos", _ => "neg" } };
+let m = macro(a) { quote(unquote(a)) };
+for (let i = 0; i < 2; let i = i + 1) { m(abs(i)); }
+sign(3);`
+
+// run runs source once in mode, recording it in p as prog.mk.
+func run(t *testing.T, p *Profile, source string, mode interpreter.Mode) {
+	t.Helper()
+	program, err := interpreter.Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := interpreter.New(interpreter.WithMode(mode), interpreter.WithStdout(io.Discard),
+		interpreter.WithHook(p.Track("prog.mk", program)))
+	if _, err := in.RunProgram(program); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestText(t *testing.T) {
+	expected := `prog.mk: 8 of 9 statements run (88.9%), 4 of 7 branches taken (57.1%)
+prog.mk:2:15: statement never run
+prog.mk:2:2: then of if never taken
+prog.mk:5:20: arm 1 of match never taken
+prog.mk:5:20: arm 3 of match never taken
+`
+	for _, mode := range []interpreter.Mode{interpreter.Full, interpreter.Middle, interpreter.Simple} {
+		p := New()
+		run(t, p, program, mode)
+		var out bytes.Buffer
+		if err := p.WriteText(&out); err != nil {
+			t.Fatal(err)
+		}
+		if out.String() != expected {
+			t.Errorf("mode %d: wrong report.\nexpected=%q\ngot=     %q", mode, expected, out.String())
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	p := New()
+	run(t, p, program, interpreter.Full)
+	run(t, p, strings.Replace(program, "sign(3)", "sign(0)", 1), interpreter.Full)
+
+	// A report read back adds up with the runs after it.
+	var buf bytes.Buffer
+	if err := p.WriteJSON(&buf); err != nil {
+		t.Fatal(err)
+	}
+	q, err := ReadJSON(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run(t, q, strings.Replace(program, "i < 2", "i < 0", 1), interpreter.Full)
+
+	f := q.Files[0]
+	if s := f.Summary(); s != (Summary{Statements: 9, StatementsRun: 8, Branches: 7, BranchesTaken: 5}) {
+		t.Errorf("wrong summary %+v", s)
+	}
+	b := f.Branches[2]
+	if b.Kind != "for" || b.Counts[0] != 4 || b.Counts[1] != 3 {
+		t.Errorf("wrong counts of the for loop %+v", *b)
+	}
+	if first := f.Statements[0]; first.Line != 1 || first.Count != 3 {
+		t.Errorf("wrong count of the first statement %+v", *first)
+	}
+
+	other := &Profile{Files: []*File{{Path: "prog.mk", Branches: []*Branch{{Line: 2, Column: 2, Kind: "for", Counts: []uint64{1, 1}}}}}}
+	if err := q.Merge(other); err == nil || err.Error() != "cover: prog.mk:2:2: for with 2 branches does not match if with 2" {
+		t.Errorf("wrong error merging different branches: %v", err)
+	}
+	if _, err := ReadJSON(strings.NewReader(`{"version":2,"files":[]}`)); err == nil || err.Error() != "cover: unsupported version 2" {
+		t.Errorf("wrong error reading a newer version: %v", err)
+	}
+}
+
+func TestLCOV(t *testing.T) {
+	p := New()
+	run(t, p, "let f = fn(x) {\n\tif (x) { 1 } else { 2 }\n};\nif (false) { f(true); }", interpreter.Full)
+	var out bytes.Buffer
+	if err := p.WriteLCOV(&out); err != nil {
+		t.Fatal(err)
+	}
+	expected := `TN:
+SF:prog.mk
+BRDA:2,0,0,-
+BRDA:2,0,1,-
+BRDA:4,1,0,0
+BRDA:4,1,1,1
+BRF:4
+BRH:1
+DA:1,1
+DA:2,0
+DA:4,1
+LF:3
+LH:2
+end_of_record
+`
+	if out.String() != expected {
+		t.Errorf("wrong tracefile.\nexpected=%q\ngot=     %q", expected, out.String())
+	}
+}
@@ -0,0 +1,136 @@
+package cover
+
+import (
+	"fmt"
+	"io"
+)
+
+// Summary counts the statements of a file that ran and the branches that
+// were taken.
+type Summary struct {
+	Statements, StatementsRun int
+	Branches, BranchesTaken   int
+}
+
+// Summary returns the summary of f.
+func (f *File) Summary() Summary {
+	var s Summary
+	for _, stmt := range f.Statements {
+		s.Statements++
+		if stmt.Count > 0 {
+			s.StatementsRun++
+		}
+	}
+	for _, b := range f.Branches {
+		for _, n := range b.Counts {
+			s.Branches++
+			if n > 0 {
+				s.BranchesTaken++
+			}
+		}
+	}
+	return s
+}
+
+// Line is a line on which statements start, and how often the statement on
+// it that ran most often ran.
+type Line struct {
+	Number int
+	Count  uint64
+}
+
+// Lines returns the lines of f on which statements start, in order.
+func (f *File) Lines() []Line {
+	var lines []Line
+	for _, s := range f.Statements {
+		if n := len(lines); n > 0 && lines[n-1].Number == s.Line {
+			if s.Count > lines[n-1].Count {
+				lines[n-1].Count = s.Count
+			}
+			continue
+		}
+		lines = append(lines, Line{Number: s.Line, Count: s.Count})
+	}
+	return lines
+}
+
+func percent(part, whole int) float64 {
+	if whole == 0 {
+		return 100
+	}
+	return 100 * float64(part) / float64(whole)
+}
+
+// WriteText writes a summary of every file, followed by the statements
+// that never ran and the branches never taken.
+func (p *Profile) WriteText(w io.Writer) error {
+	for _, f := range p.Files {
+		s := f.Summary()
+		if _, err := fmt.Fprintf(w, "%s: %d of %d statements run (%.1f%%), %d of %d branches taken (%.1f%%)\n",
+			f.Path, s.StatementsRun, s.Statements, percent(s.StatementsRun, s.Statements),
+			s.BranchesTaken, s.Branches, percent(s.BranchesTaken, s.Branches)); err != nil {
+			return err
+		}
+		for _, stmt := range f.Statements {
+			if stmt.Count == 0 {
+				if _, err := fmt.Fprintf(w, "%s:%d:%d: statement never run\n", f.Path, stmt.Line, stmt.Column); err != nil {
+					return err
+				}
+			}
+		}
+		for _, b := range f.Branches {
+			for i, n := range b.Counts {
+				if n == 0 {
+					if _, err := fmt.Fprintf(w, "%s:%d:%d: %s of %s never taken\n", f.Path, b.Line, b.Column, b.Name(i), b.Kind); err != nil {
+						return err
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WriteLCOV writes p in the tracefile format of LCOV, for genhtml and the
+// coverage tools that read it. Every if, for and match expression is a
+// block of branches; branches of blocks that were never reached are
+// written as not taken with "-".
+func (p *Profile) WriteLCOV(w io.Writer) error {
+	for _, f := range p.Files {
+		if _, err := fmt.Fprintf(w, "TN:\nSF:%s\n", f.Path); err != nil {
+			return err
+		}
+		s := f.Summary()
+		for block, b := range f.Branches {
+			reached := false
+			for _, n := range b.Counts {
+				reached = reached || n > 0
+			}
+			for i, n := range b.Counts {
+				taken := "-"
+				if reached {
+					taken = fmt.Sprint(n)
+				}
+				if _, err := fmt.Fprintf(w, "BRDA:%d,%d,%d,%s\n", b.Line, block, i, taken); err != nil {
+					return err
+				}
+			}
+		}
+		if _, err := fmt.Fprintf(w, "BRF:%d\nBRH:%d\n", s.Branches, s.BranchesTaken); err != nil {
+			return err
+		}
+		lines, hit := f.Lines(), 0
+		for _, line := range lines {
+			if line.Count > 0 {
+				hit++
+			}
+			if _, err := fmt.Fprintf(w, "DA:%d,%d\n", line.Number, line.Count); err != nil {
+				return err
+			}
+		}
+		if _, err := fmt.Fprintf(w, "LF:%d\nLH:%d\nend_of_record\n", len(lines), hit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,617 @@
+// Package dap serves the Debug Adapter Protocol, so that editors such as VS
+// Code can debug programs: set breakpoints, step, look at the call stack and
+// at the variables of every environment in scope, and follow the gas and
+// operations charged so far.
+//
+// A session debugs one program, started by a launch request with the
+// arguments
+//
+//	program      the path of the program to debug
+//	mode         the evaluator to use: "full" (the default), "middle" or
+//	             "simple", which charges no gas
+//	gas          the gas limit, 0 for none
+//	stopOnEntry  stop before the first statement
+//	noDebug      run without stopping
+//
+// There is a single thread. Each stack frame has a scope for each
+// environment of its chain, innermost first, and a Meter scope with the gas
+// and operations charged so far. Evaluating a variable name shows its value
+// in the selected frame; evaluating gas or ops shows the meter, even while
+// the program runs.
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/debugger"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/interpreter"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/wire"
+)
+
+// threadID is the id of the only thread.
+const threadID = 1
+
+// Exit codes reported when the program finishes, as by the verigo command.
+const (
+	exitOK      = 0
+	exitParse   = 2
+	exitRuntime = 3
+)
+
+type launchArguments struct {
+	Program     string `json:"program"`
+	Mode        string `json:"mode"`
+	Gas         uint64 `json:"gas"`
+	StopOnEntry bool   `json:"stopOnEntry"`
+	NoDebug     bool   `json:"noDebug"`
+}
+
+// meter is the reference of the Meter scope.
+type meter struct{}
+
+// Server is a debug adapter talking to one client.
+type Server struct {
+	r *bufio.Reader
+
+	outMu sync.Mutex // guards w and seq
+	w     io.Writer
+	seq   int
+
+	lineBase, columnBase int // of the client, 0 or 1
+
+	path       string
+	program    *ast.Program
+	statements map[int]bool // the lines statements start on
+	mode       interpreter.Mode
+	limit      uint64
+	noDebug    bool
+	configured bool
+	started    bool
+
+	debugger *debugger.Debugger
+	resume   chan debugger.Action
+	done     chan struct{} // closed once the program has finished
+
+	stoppedMu sync.Mutex // guards stopped
+	stopped   bool
+
+	// refs holds the environments and values whose variables the client
+	// may ask for, by reference minus one. It is only valid while stopped.
+	refs []interface{}
+
+	gas, ops atomic.Uint64
+}
+
+// NewServer returns a server reading requests from r and writing responses
+// and events to w.
+func NewServer(r io.Reader, w io.Writer) *Server {
+	s := &Server{
+		r:          bufio.NewReader(r),
+		w:          w,
+		lineBase:   1,
+		columnBase: 1,
+		resume:     make(chan debugger.Action),
+		done:       make(chan struct{}),
+	}
+	s.debugger = debugger.NewFunc(s.stop)
+	return s
+}
+
+// Serve answers requests until the client disconnects or closes r, then
+// stops the program if it still runs.
+func (s *Server) Serve() error {
+	defer s.quit()
+	for {
+		content, err := wire.Read(s.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var req request
+		if err := json.Unmarshal(content, &req); err != nil {
+			return fmt.Errorf("dap: %w", err)
+		}
+		if req.Type != "request" {
+			continue
+		}
+		body, err := s.handle(&req)
+		if err != nil {
+			s.send(&response{Type: "response", RequestSeq: req.Seq, Command: req.Command, Message: err.Error()})
+			continue
+		}
+		s.send(&response{Type: "response", RequestSeq: req.Seq, Success: true, Command: req.Command, Body: body})
+		if req.Command == "disconnect" {
+			return nil
+		}
+		s.after(&req)
+	}
+}
+
+// handle answers req, returning the body of its response.
+func (s *Server) handle(req *request) (interface{}, error) {
+	switch req.Command {
+	case "initialize":
+		var args struct {
+			LinesStartAt1   *bool `json:"linesStartAt1"`
+			ColumnsStartAt1 *bool `json:"columnsStartAt1"`
+		}
+		if err := s.arguments(req, &args); err != nil {
+			return nil, err
+		}
+		if args.LinesStartAt1 != nil && !*args.LinesStartAt1 {
+			s.lineBase = 0
+		}
+		if args.ColumnsStartAt1 != nil && !*args.ColumnsStartAt1 {
+			s.columnBase = 0
+		}
+		return map[string]bool{
+			"supportsConfigurationDoneRequest": true,
+			"supportsEvaluateForHovers":        true,
+			"supportsTerminateRequest":         true,
+		}, nil
+	case "launch":
+		return nil, s.launch(req)
+	case "setBreakpoints":
+		return s.setBreakpoints(req)
+	case "configurationDone":
+		s.configured = true
+		return nil, nil
+	case "threads":
+		return map[string][]thread{"threads": {{ID: threadID, Name: "main"}}}, nil
+	case "stackTrace":
+		return s.stackTrace()
+	case "scopes":
+		return s.scopes(req)
+	case "variables":
+		return s.variables(req)
+	case "evaluate":
+		return s.evaluate(req)
+	case "continue", "next", "stepIn", "stepOut":
+		if !s.isStopped() {
+			return nil, errors.New("the program is not stopped")
+		}
+		if req.Command == "continue" {
+			return map[string]bool{"allThreadsContinued": true}, nil
+		}
+		return nil, nil
+	case "pause":
+		s.debugger.Pause()
+		return nil, nil
+	case "disconnect", "terminate":
+		return nil, nil
+	}
+	return nil, fmt.Errorf("unsupported request %q", req.Command)
+}
+
+// after acts on req once it has been answered successfully.
+func (s *Server) after(req *request) {
+	switch req.Command {
+	case "launch":
+		s.event("initialized", nil)
+		s.startIfReady()
+	case "configurationDone":
+		s.startIfReady()
+	case "continue":
+		s.resumeWith(debugger.Continue)
+	case "next":
+		s.resumeWith(debugger.StepOver)
+	case "stepIn":
+		s.resumeWith(debugger.StepIn)
+	case "stepOut":
+		s.resumeWith(debugger.StepOut)
+	case "terminate":
+		s.quit()
+	}
+}
+
+func (s *Server) arguments(req *request, args interface{}) error {
+	if len(req.Arguments) == 0 {
+		return nil
+	}
+	return json.Unmarshal(req.Arguments, args)
+}
+
+func (s *Server) launch(req *request) error {
+	if s.program != nil {
+		return errors.New("a program is already launched")
+	}
+	var args launchArguments
+	if err := s.arguments(req, &args); err != nil {
+		return err
+	}
+	if args.Program == "" {
+		return errors.New("no program to launch")
+	}
+	switch args.Mode {
+	case "", "full":
+		s.mode = interpreter.Full
+	case "middle":
+		s.mode = interpreter.Middle
+	case "simple":
+		s.mode = interpreter.Simple
+	default:
+		return fmt.Errorf("unknown mode %q", args.Mode)
+	}
+	src, err := os.ReadFile(args.Program)
+	if err != nil {
+		return err
+	}
+	program, err := interpreter.Parse(string(src))
+	if err != nil {
+		var parseErr *interpreter.ParseError
+		if errors.As(err, &parseErr) {
+			for _, d := range parseErr.Diagnostics {
+				s.output("stderr", fmt.Sprintf("%s:%s\n", args.Program, d))
+			}
+			return fmt.Errorf("%s does not parse", args.Program)
+		}
+		return err
+	}
+	if s.path, err = filepath.Abs(args.Program); err != nil {
+		return err
+	}
+	s.program, s.limit, s.noDebug = program, args.Gas, args.NoDebug
+	s.debugger.StopOnEntry = args.StopOnEntry && !args.NoDebug
+	s.statements = map[int]bool{}
+	ast.Inspect(program, func(node ast.Node) bool {
+		if stmt, ok := node.(ast.Statement); ok {
+			s.statements[ast.Pos(stmt).Line] = true
+		}
+		return true
+	})
+	return nil
+}
+
+func (s *Server) setBreakpoints(req *request) (interface{}, error) {
+	if s.program == nil {
+		return nil, errors.New("no program launched")
+	}
+	var args struct {
+		Source      source `json:"source"`
+		Breakpoints []struct {
+			Line int `json:"line"`
+		} `json:"breakpoints"`
+	}
+	if err := s.arguments(req, &args); err != nil {
+		return nil, err
+	}
+	path, err := filepath.Abs(args.Source.Path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []int
+	breakpoints := make([]breakpoint, len(args.Breakpoints))
+	for i, b := range args.Breakpoints {
+		line := b.Line - s.lineBase + 1
+		breakpoints[i] = breakpoint{Line: b.Line}
+		switch {
+		case path != s.path:
+			breakpoints[i].Message = "not the program being debugged"
+		case !s.statements[line]:
+			breakpoints[i].Message = "no statement starts on this line"
+		default:
+			breakpoints[i].Verified = true
+			lines = append(lines, line)
+		}
+	}
+	if path == s.path && !s.noDebug {
+		s.debugger.SetBreakpoints(lines)
+	}
+	return map[string][]breakpoint{"breakpoints": breakpoints}, nil
+}
+
+// startIfReady runs the program in its own goroutine once it is launched
+// and configured.
+func (s *Server) startIfReady() {
+	if s.program == nil || !s.configured || s.started {
+		return
+	}
+	s.started = true
+	opts := []interpreter.Option{
+		interpreter.WithMode(s.mode),
+		interpreter.WithGas(s.limit),
+		interpreter.WithStdout(&output{s, "stdout"}),
+		interpreter.WithOpSink(func(op int) {
+			s.ops.Add(1)
+			s.gas.Add(gas.DefaultSchedule.Cost(op))
+		}),
+	}
+	if !s.noDebug {
+		opts = append(opts, interpreter.WithHook(s.debugger))
+	}
+	in := interpreter.New(opts...)
+	go func() {
+		defer close(s.done)
+		var err error
+		if s.noDebug {
+			_, err = in.RunProgram(s.program)
+		} else {
+			_, err = s.debugger.Run(in, s.program)
+		}
+		code := exitOK
+		var parseErr *interpreter.ParseError
+		var runtimeErr *interpreter.RuntimeError
+		switch {
+		case err == nil, errors.Is(err, debugger.ErrQuit):
+		case errors.As(err, &parseErr):
+			for _, d := range parseErr.Diagnostics {
+				s.output("stderr", fmt.Sprintf("%s:%s\n", s.path, d))
+			}
+			code = exitParse
+		case errors.As(err, &runtimeErr):
+			path := s.path
+			if runtimeErr.Pos.Line > 0 {
+				path += ":" + runtimeErr.Pos.String()
+			}
+			s.output("stderr", fmt.Sprintf("%s: runtime error: %s\n", path, runtimeErr.Message))
+			code = exitRuntime
+		default:
+			s.output("stderr", fmt.Sprintf("%s: %s\n", s.path, err))
+			code = exitRuntime
+		}
+		s.output("console", fmt.Sprintf("gas used: %d, operations: %d\n", s.gas.Load(), s.ops.Load()))
+		s.event("exited", map[string]int{"exitCode": code})
+		s.event("terminated", nil)
+	}()
+}
+
+// stop is the debugger.StopFunc of the server: it tells the client the
+// program stopped and waits for it to say how to go on.
+func (s *Server) stop(reason debugger.Reason, frame *debugger.Frame) debugger.Action {
+	s.stoppedMu.Lock()
+	s.stopped = true
+	s.stoppedMu.Unlock()
+	s.event("stopped", map[string]interface{}{
+		"reason":            string(reason),
+		"threadId":          threadID,
+		"allThreadsStopped": true,
+	})
+	return <-s.resume
+}
+
+func (s *Server) isStopped() bool {
+	s.stoppedMu.Lock()
+	defer s.stoppedMu.Unlock()
+	return s.stopped
+}
+
+// resumeWith resumes the stopped program with action.
+func (s *Server) resumeWith(action debugger.Action) {
+	s.stoppedMu.Lock()
+	s.stopped = false
+	s.stoppedMu.Unlock()
+	s.refs = nil
+	s.resume <- action
+}
+
+// quit stops the program, if it was started, and waits for it to finish.
+func (s *Server) quit() {
+	if !s.started {
+		return
+	}
+	s.debugger.Quit()
+	for {
+		select {
+		case <-s.done:
+			return
+		case s.resume <- debugger.Quit:
+		}
+	}
+}
+
+func (s *Server) stackTrace() (interface{}, error) {
+	if !s.isStopped() {
+		return nil, errors.New("the program is not stopped")
+	}
+	src := source{Name: filepath.Base(s.path), Path: s.path}
+	frames := []stackFrame{}
+	for i, f := range s.debugger.Frames() {
+		frames = append(frames, stackFrame{
+			ID:     i + 1,
+			Name:   f.Name,
+			Source: src,
+			Line:   f.Pos.Line - 1 + s.lineBase,
+			Column: f.Pos.Column - 1 + s.columnBase,
+		})
+	}
+	return map[string]interface{}{"stackFrames": frames, "totalFrames": len(frames)}, nil
+}
+
+// frame returns the frame with the given id.
+func (s *Server) frame(id int) (*debugger.Frame, error) {
+	if !s.isStopped() {
+		return nil, errors.New("the program is not stopped")
+	}
+	frames := s.debugger.Frames()
+	if id < 1 || id > len(frames) {
+		return nil, fmt.Errorf("no frame %d", id)
+	}
+	return frames[id-1], nil
+}
+
+func (s *Server) scopes(req *request) (interface{}, error) {
+	var args struct {
+		FrameID int `json:"frameId"`
+	}
+	if err := s.arguments(req, &args); err != nil {
+		return nil, err
+	}
+	frame, err := s.frame(args.FrameID)
+	if err != nil {
+		return nil, err
+	}
+	scopes := []scope{}
+	for env := frame.Env; env != nil; env = env.Outer() {
+		name := "Closure"
+		switch {
+		case env.Outer() == nil:
+			name = "Globals"
+		case env == frame.Env:
+			name = "Locals"
+		}
+		scopes = append(scopes, scope{Name: name, VariablesReference: s.ref(env)})
+	}
+	scopes = append(scopes, scope{Name: "Meter", VariablesReference: s.ref(meter{})})
+	return map[string][]scope{"scopes": scopes}, nil
+}
+
+// ref returns a new reference to the variables of v, which is an
+// environment, a meter or a value, or 0 if v has none.
+func (s *Server) ref(v interface{}) int {
+	switch v := v.(type) {
+	case *object.Array:
+		if len(v.Elements) == 0 {
+			return 0
+		}
+	case *object.Hash:
+		if len(v.Pairs) == 0 {
+			return 0
+		}
+	case *object.Struct:
+		if len(v.Fields) == 0 {
+			return 0
+		}
+	case *object.Environment, meter:
+	default:
+		return 0
+	}
+	s.refs = append(s.refs, v)
+	return len(s.refs)
+}
+
+func (s *Server) variables(req *request) (interface{}, error) {
+	var args struct {
+		VariablesReference int `json:"variablesReference"`
+	}
+	if err := s.arguments(req, &args); err != nil {
+		return nil, err
+	}
+	if !s.isStopped() {
+		return nil, errors.New("the program is not stopped")
+	}
+	ref := args.VariablesReference
+	if ref < 1 || ref > len(s.refs) {
+		return nil, fmt.Errorf("no variables %d", ref)
+	}
+	vars := []variable{}
+	switch v := s.refs[ref-1].(type) {
+	case *object.Environment:
+		for _, name := range v.Names() {
+			value, _ := v.Get(name)
+			vars = append(vars, s.variable(name, value))
+		}
+	case *object.Array:
+		for i, elem := range v.Elements {
+			vars = append(vars, s.variable(strconv.Itoa(i), elem))
+		}
+	case *object.Hash:
+		for _, pair := range v.SortedPairs() {
+			name := pair.Key.Inspect()
+			if _, ok := pair.Key.(*object.String); ok {
+				name = strconv.Quote(name)
+			}
+			vars = append(vars, s.variable(name, pair.Value))
+		}
+	case *object.Struct:
+		for i, field := range v.Def.Fields {
+			vars = append(vars, s.variable(field, v.Fields[i]))
+		}
+	case meter:
+		vars = append(vars,
+			variable{Name: "gas", Value: strconv.FormatUint(s.gas.Load(), 10)},
+			variable{Name: "ops", Value: strconv.FormatUint(s.ops.Load(), 10)})
+		if s.limit != 0 {
+			vars = append(vars, variable{Name: "limit", Value: strconv.FormatUint(s.limit, 10)})
+		}
+	}
+	return map[string][]variable{"variables": vars}, nil
+}
+
+func (s *Server) variable(name string, v object.Object) variable {
+	return variable{Name: name, Value: debugger.Describe(v), Type: string(v.Type()), VariablesReference: s.ref(v)}
+}
+
+func (s *Server) evaluate(req *request) (interface{}, error) {
+	var args struct {
+		Expression string `json:"expression"`
+		FrameID    int    `json:"frameId"`
+	}
+	if err := s.arguments(req, &args); err != nil {
+		return nil, err
+	}
+	if s.isStopped() {
+		id := args.FrameID
+		if id == 0 {
+			id = 1 // the innermost frame
+		}
+		frame, err := s.frame(id)
+		if err != nil {
+			return nil, err
+		}
+		if v, ok := frame.Env.Get(args.Expression); ok {
+			result := s.variable(args.Expression, v)
+			return map[string]interface{}{
+				"result":             result.Value,
+				"type":               result.Type,
+				"variablesReference": result.VariablesReference,
+			}, nil
+		}
+	}
+	var n uint64
+	switch args.Expression {
+	case "gas":
+		n = s.gas.Load()
+	case "ops":
+		n = s.ops.Load()
+	default:
+		return nil, fmt.Errorf("undefined: %s", args.Expression)
+	}
+	return map[string]interface{}{"result": strconv.FormatUint(n, 10), "variablesReference": 0}, nil
+}
+
+func (s *Server) send(msg interface{}) {
+	s.outMu.Lock()
+	defer s.outMu.Unlock()
+	s.seq++
+	switch msg := msg.(type) {
+	case *response:
+		msg.Seq = s.seq
+	case *event:
+		msg.Seq = s.seq
+	}
+	// The client is gone if this fails, which Serve notices when reading.
+	wire.Write(s.w, msg)
+}
+
+func (s *Server) event(name string, body interface{}) {
+	s.send(&event{Type: "event", Event: name, Body: body})
+}
+
+func (s *Server) output(category, text string) {
+	s.event("output", map[string]string{"category": category, "output": text})
+}
+
+// output is an io.Writer sending what is written to it as output events.
+type output struct {
+	s        *Server
+	category string
+}
+
+func (o *output) Write(p []byte) (int, error) {
+	o.s.output(o.category, string(p))
+	return len(p), nil
+}
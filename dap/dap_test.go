@@ -0,0 +1,343 @@
+package dap
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/wire"
+)
+
+const program = `let add = fn(a, b) {
+	let sum = a + b;
+	sum
+};
+let xs = [1, 2];
+let y = add(xs[0], 3);
+print(y);`
+
+// message is a response or event, decoded loosely.
+type message struct {
+	Type       string          `json:"type"`
+	Event      string          `json:"event"`
+	Command    string          `json:"command"`
+	RequestSeq int             `json:"request_seq"`
+	Success    bool            `json:"success"`
+	Message    string          `json:"message"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// client talks to a server running in its own goroutine.
+type client struct {
+	t        *testing.T
+	w        io.WriteCloser
+	messages chan message
+	seq      int
+	done     chan error
+}
+
+func start(t *testing.T) *client {
+	t.Helper()
+	reqR, reqW := io.Pipe()
+	respR, respW := io.Pipe()
+	c := &client{t: t, w: reqW, messages: make(chan message, 100), done: make(chan error, 1)}
+	go func() {
+		c.done <- NewServer(reqR, respW).Serve()
+		respW.Close()
+	}()
+	go func() {
+		defer close(c.messages)
+		r := bufio.NewReader(respR)
+		for {
+			content, err := wire.Read(r)
+			if err != nil {
+				return
+			}
+			var m message
+			if err := json.Unmarshal(content, &m); err != nil {
+				t.Error(err)
+				return
+			}
+			c.messages <- m
+		}
+	}()
+	return c
+}
+
+func (c *client) send(command string, args interface{}) {
+	c.t.Helper()
+	c.seq++
+	req := map[string]interface{}{"seq": c.seq, "type": "request", "command": command, "arguments": args}
+	if err := wire.Write(c.w, req); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *client) next() message {
+	c.t.Helper()
+	m, ok := <-c.messages
+	if !ok {
+		c.t.Fatal("server closed the connection")
+	}
+	return m
+}
+
+// request sends a request and decodes the body of its response into body,
+// unless it is nil.
+func (c *client) request(command string, args, body interface{}) {
+	c.t.Helper()
+	c.send(command, args)
+	m := c.next()
+	if m.Type != "response" || m.Command != command || m.RequestSeq != c.seq {
+		c.t.Fatalf("%s: expected its response, got %+v", command, m)
+	}
+	if !m.Success {
+		c.t.Fatalf("%s failed: %s", command, m.Message)
+	}
+	if body != nil {
+		if err := json.Unmarshal(m.Body, body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+// expect reads an event called name and decodes its body into body, unless
+// it is nil.
+func (c *client) expect(name string, body interface{}) {
+	c.t.Helper()
+	m := c.next()
+	if m.Type != "event" || m.Event != name {
+		c.t.Fatalf("expected a %s event, got %+v", name, m)
+	}
+	if body != nil {
+		if err := json.Unmarshal(m.Body, body); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+}
+
+func (c *client) close() {
+	c.t.Helper()
+	c.request("disconnect", nil, nil)
+	if err := <-c.done; err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func writeProgram(t *testing.T, source string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "prog.mk")
+	if err := os.WriteFile(path, []byte(source), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestSession(t *testing.T) {
+	path := writeProgram(t, program)
+	c := start(t)
+	var capabilities map[string]bool
+	c.request("initialize", map[string]interface{}{"adapterID": "verigo"}, &capabilities)
+	if !capabilities["supportsConfigurationDoneRequest"] {
+		t.Errorf("configurationDone not supported. got=%v", capabilities)
+	}
+	c.request("launch", map[string]interface{}{"program": path}, nil)
+	c.expect("initialized", nil)
+
+	var bps struct{ Breakpoints []breakpoint }
+	c.request("setBreakpoints", map[string]interface{}{
+		"source":      map[string]string{"path": path},
+		"breakpoints": []map[string]int{{"line": 2}, {"line": 4}},
+	}, &bps)
+	expectedBps := []breakpoint{{Verified: true, Line: 2}, {Line: 4, Message: "no statement starts on this line"}}
+	if !reflect.DeepEqual(bps.Breakpoints, expectedBps) {
+		t.Errorf("wrong breakpoints. expected=%+v, got=%+v", expectedBps, bps.Breakpoints)
+	}
+	c.request("configurationDone", nil, nil)
+
+	var stopped struct{ Reason string }
+	c.expect("stopped", &stopped)
+	if stopped.Reason != "breakpoint" {
+		t.Errorf("wrong reason. expected=breakpoint, got=%q", stopped.Reason)
+	}
+	var threads struct{ Threads []thread }
+	c.request("threads", nil, &threads)
+	if len(threads.Threads) != 1 {
+		t.Errorf("wrong threads. got=%+v", threads.Threads)
+	}
+
+	var trace struct{ StackFrames []stackFrame }
+	c.request("stackTrace", map[string]int{"threadId": 1}, &trace)
+	src := source{Name: "prog.mk", Path: path}
+	expectedFrames := []stackFrame{
+		{ID: 1, Name: "add", Source: src, Line: 2, Column: 2},
+		{ID: 2, Name: "main", Source: src, Line: 6, Column: 1},
+	}
+	if !reflect.DeepEqual(trace.StackFrames, expectedFrames) {
+		t.Errorf("wrong stack. expected=%+v, got=%+v", expectedFrames, trace.StackFrames)
+	}
+
+	var scopes struct{ Scopes []scope }
+	c.request("scopes", map[string]int{"frameId": 1}, &scopes)
+	var names []string
+	for _, s := range scopes.Scopes {
+		names = append(names, s.Name)
+	}
+	if got := strings.Join(names, " "); got != "Locals Globals Meter" {
+		t.Fatalf("wrong scopes. expected=%q, got=%q", "Locals Globals Meter", got)
+	}
+	variables := func(ref int) map[string]variable {
+		t.Helper()
+		var body struct{ Variables []variable }
+		c.request("variables", map[string]int{"variablesReference": ref}, &body)
+		vars := map[string]variable{}
+		for _, v := range body.Variables {
+			vars[v.Name] = v
+		}
+		return vars
+	}
+	locals := variables(scopes.Scopes[0].VariablesReference)
+	if len(locals) != 2 || locals["a"].Value != "1" || locals["b"].Value != "3" || locals["a"].Type != "INTEGER" {
+		t.Errorf("wrong locals. got=%+v", locals)
+	}
+	globals := variables(scopes.Scopes[1].VariablesReference)
+	if globals["add"].Value != "fn(a, b)" || globals["print"].Value != "builtin" {
+		t.Errorf("wrong globals. got=%+v", globals)
+	}
+	xs := globals["xs"]
+	if xs.Value != "[1, 2]" || xs.VariablesReference == 0 {
+		t.Fatalf("wrong xs. got=%+v", xs)
+	}
+	if elems := variables(xs.VariablesReference); elems["0"].Value != "1" || elems["1"].Value != "2" {
+		t.Errorf("wrong elements. got=%+v", elems)
+	}
+	// Only the call to add has been charged.
+	if meter := variables(scopes.Scopes[2].VariablesReference); meter["gas"].Value != "1" || meter["ops"].Value != "1" {
+		t.Errorf("wrong meter. got=%+v", meter)
+	}
+
+	var result struct{ Result string }
+	c.request("evaluate", map[string]interface{}{"expression": "b", "frameId": 1}, &result)
+	if result.Result != "3" {
+		t.Errorf("wrong value of b. got=%q", result.Result)
+	}
+	c.send("evaluate", map[string]interface{}{"expression": "nope", "frameId": 1})
+	if m := c.next(); m.Success || m.Message != "undefined: nope" {
+		t.Errorf("wrong evaluate failure. got=%+v", m)
+	}
+
+	c.request("next", map[string]int{"threadId": 1}, nil)
+	c.expect("stopped", &stopped)
+	c.request("stackTrace", map[string]int{"threadId": 1}, &trace)
+	if stopped.Reason != "step" || trace.StackFrames[0].Line != 3 {
+		t.Errorf("next stopped wrongly. reason=%q, frames=%+v", stopped.Reason, trace.StackFrames)
+	}
+	// The addition has been charged.
+	c.request("evaluate", map[string]interface{}{"expression": "gas"}, &result)
+	if result.Result != "2" {
+		t.Errorf("wrong gas. expected=%q, got=%q", "2", result.Result)
+	}
+
+	c.request("continue", map[string]int{"threadId": 1}, nil)
+	var out struct{ Category, Output string }
+	c.expect("output", &out)
+	if out.Category != "stdout" || out.Output != "4\n" {
+		t.Errorf("wrong output. got=%+v", out)
+	}
+	c.expect("output", &out)
+	if out.Category != "console" || !strings.HasPrefix(out.Output, "gas used: ") {
+		t.Errorf("wrong meter output. got=%+v", out)
+	}
+	var exited struct{ ExitCode int }
+	c.expect("exited", &exited)
+	if exited.ExitCode != 0 {
+		t.Errorf("wrong exit code. got=%d", exited.ExitCode)
+	}
+	c.expect("terminated", nil)
+	c.close()
+}
+
+func TestStopOnEntryAndDisconnect(t *testing.T) {
+	path := writeProgram(t, program)
+	c := start(t)
+	c.request("initialize", nil, nil)
+	c.request("launch", map[string]interface{}{"program": path, "stopOnEntry": true, "mode": "middle"}, nil)
+	c.expect("initialized", nil)
+	c.request("configurationDone", nil, nil)
+	var stopped struct{ Reason string }
+	c.expect("stopped", &stopped)
+	if stopped.Reason != "entry" {
+		t.Errorf("wrong reason. expected=entry, got=%q", stopped.Reason)
+	}
+	line := func() int {
+		t.Helper()
+		var trace struct{ StackFrames []stackFrame }
+		c.request("stackTrace", map[string]int{"threadId": 1}, &trace)
+		return trace.StackFrames[0].Line
+	}
+	var lines []int
+	for _, command := range []string{"stepIn", "next", "stepIn", "stepOut"} {
+		c.request(command, map[string]int{"threadId": 1}, nil)
+		c.expect("stopped", &stopped)
+		lines = append(lines, line())
+	}
+	if expected := []int{5, 6, 2, 7}; !reflect.DeepEqual(lines, expected) {
+		t.Errorf("wrong steps. expected=%v, got=%v", expected, lines)
+	}
+	// Disconnecting while stopped stops the program.
+	c.close()
+}
+
+func TestRuntimeError(t *testing.T) {
+	path := writeProgram(t, "let x = 1;\nx / 0;")
+	c := start(t)
+	c.request("initialize", nil, nil)
+	c.request("launch", map[string]interface{}{"program": path}, nil)
+	c.expect("initialized", nil)
+	c.request("configurationDone", nil, nil)
+	var out struct{ Category, Output string }
+	c.expect("output", &out)
+	if out.Category != "stderr" || !strings.Contains(out.Output, "prog.mk:2:") || !strings.Contains(out.Output, "runtime error: ") {
+		t.Errorf("wrong error output. got=%+v", out)
+	}
+	c.expect("output", nil)
+	var exited struct{ ExitCode int }
+	c.expect("exited", &exited)
+	if exited.ExitCode != exitRuntime {
+		t.Errorf("wrong exit code. expected=%d, got=%d", exitRuntime, exited.ExitCode)
+	}
+	c.expect("terminated", nil)
+	c.close()
+}
+
+func TestLaunchErrors(t *testing.T) {
+	c := start(t)
+	c.request("initialize", nil, nil)
+	c.send("setBreakpoints", map[string]interface{}{"source": map[string]string{"path": "x.mk"}})
+	if m := c.next(); m.Success || m.Message != "no program launched" {
+		t.Errorf("wrong failure. got=%+v", m)
+	}
+	c.send("launch", map[string]interface{}{"program": writeProgram(t, "let = 1;")})
+	var out struct{ Category, Output string }
+	c.expect("output", &out)
+	if out.Category != "stderr" || !strings.Contains(out.Output, "prog.mk:1:") {
+		t.Errorf("wrong diagnostic. got=%+v", out)
+	}
+	if m := c.next(); m.Success || !strings.HasSuffix(m.Message, "does not parse") {
+		t.Errorf("wrong failure. got=%+v", m)
+	}
+	c.send("continue", nil)
+	if m := c.next(); m.Success || m.Message != "the program is not stopped" {
+		t.Errorf("wrong failure. got=%+v", m)
+	}
+	c.send("frobnicate", nil)
+	if m := c.next(); m.Success || m.Message != `unsupported request "frobnicate"` {
+		t.Errorf("wrong failure. got=%+v", m)
+	}
+	c.close()
+}
@@ -0,0 +1,313 @@
+// Package debugger steps through programs as they run. It is an object.Hook
+// that stops before statements, at breakpoints or after a step, and then
+// reads commands, one per line, until told to go on:
+//
+//	break LINE   (b)  stop before the statements starting on LINE
+//	clear LINE        remove the breakpoint on LINE
+//	continue     (c)  run until the next breakpoint
+//	step         (s)  stop before the next statement, entering calls
+//	next         (n)  stop before the next statement of this function
+//	out          (o)  stop once this function has returned
+//	print NAME   (p)  print the variable NAME
+//	locals            print the variables of this function
+//	env               print the chain of environments, innermost first
+//	stack        (bt) print the calls being evaluated, innermost first
+//	list         (l)  print the source around the current line
+//	gas               print the gas used so far
+//	quit         (q)  stop the program
+//
+// The debugger stops before the first statement, so that breakpoints can be
+// set, and quits at the end of its input. Front ends other than commands,
+// such as package dap, create it with NewFunc instead.
+package debugger
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/interpreter"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// ErrQuit is returned by Run when the program was stopped by quit.
+var ErrQuit = errors.New("debugger: quit")
+
+// Frame is a call being evaluated, or the program itself.
+type Frame struct {
+	Name     string           // the name the function is bound to
+	Function *object.Function // nil for the program
+	Env      *object.Environment
+	Pos      token.Position // of the statement being evaluated, if any yet
+}
+
+type mode int
+
+const (
+	running  mode = iota // stop at breakpoints only
+	stepping             // stop at the next statement
+	stepOver             // stop at the next statement no deeper than depth
+	stepOut              // stop at the next statement shallower than depth
+)
+
+// Debugger reads commands from one reader and writes to a writer. Create it
+// with New, pass it to interpreter.WithHook and run programs with Run.
+type Debugger struct {
+	// StopOnEntry stops programs before their first statement. New sets
+	// it.
+	StopOnEntry bool
+
+	path     string
+	lines    []string
+	commands *bufio.Scanner
+	out      io.Writer
+	stopFunc StopFunc // asked how to go on instead of reading commands
+	mode     mode
+	depth    int
+	entry    bool // the program has not stopped yet
+	frames   []*Frame
+	in       *interpreter.Interpreter
+
+	mu          sync.Mutex // guards the fields below
+	breakpoints map[int]bool
+	pauseNext   bool
+	cancel      context.CancelFunc
+	quit        bool
+}
+
+// New returns a debugger for the program in source, read from path, that
+// reads commands from commands and writes to out.
+func New(path, source string, commands io.Reader, out io.Writer) *Debugger {
+	return &Debugger{
+		StopOnEntry: true,
+		path:        path,
+		lines:       strings.Split(source, "\n"),
+		commands:    bufio.NewScanner(commands),
+		out:         out,
+		breakpoints: map[int]bool{},
+	}
+}
+
+// Break sets a breakpoint on line.
+func (d *Debugger) Break(line int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints[line] = true
+}
+
+// Run runs program with in, which must have been created with the debugger
+// as its hook, stopping before its first statement if StopOnEntry is set.
+func (d *Debugger) Run(in *interpreter.Interpreter, program *ast.Program) (object.Object, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	d.mu.Lock()
+	d.cancel, d.quit = cancel, false
+	d.mu.Unlock()
+	d.in, d.mode, d.entry = in, running, d.StopOnEntry
+	d.frames = []*Frame{{Name: "main", Env: in.Env()}}
+	result, err := in.RunProgramContext(ctx, program)
+	if d.quitting() {
+		return nil, ErrQuit
+	}
+	if d.stopFunc == nil {
+		fmt.Fprintln(d.out, "program finished")
+	}
+	return result, err
+}
+
+// Frames returns the calls being evaluated, innermost first. It may only be
+// called while the program is stopped.
+func (d *Debugger) Frames() []*Frame {
+	frames := make([]*Frame, len(d.frames))
+	for i, f := range d.frames {
+		frames[len(d.frames)-1-i] = f
+	}
+	return frames
+}
+
+func (d *Debugger) Statement(stmt ast.Statement, env *object.Environment) {
+	if d.quitting() {
+		return
+	}
+	frame := d.frames[len(d.frames)-1]
+	pos := ast.Pos(stmt)
+	// A breakpoint stops the program once as it arrives on a line, rather
+	// than before each statement on it.
+	d.mu.Lock()
+	breakpoint := d.breakpoints[pos.Line] && pos.Line != frame.Pos.Line
+	pause := d.pauseNext
+	d.pauseNext = false
+	d.mu.Unlock()
+	frame.Pos, frame.Env = pos, env
+	depth := len(d.frames)
+
+	reason := Step
+	switch {
+	case d.entry:
+		reason = Entry
+	case pause:
+		reason = Pause
+	case breakpoint:
+		reason = Breakpoint
+	case d.mode == stepping, d.mode == stepOver && depth <= d.depth, d.mode == stepOut && depth < d.depth:
+	default:
+		return
+	}
+	d.entry = false
+	if d.stopFunc != nil {
+		d.resume(d.stopFunc(reason, frame), depth)
+	} else {
+		d.pause(frame)
+	}
+}
+
+func (d *Debugger) Call(fn *object.Function, env *object.Environment) {
+	d.frames = append(d.frames, &Frame{Name: fn.Name(), Function: fn, Env: env})
+}
+
+func (d *Debugger) Return(fn *object.Function, result object.Object) {
+	if len(d.frames) > 1 {
+		d.frames = d.frames[:len(d.frames)-1]
+	}
+}
+
+// pause reads commands until one resumes the program.
+func (d *Debugger) pause(frame *Frame) {
+	fmt.Fprintf(d.out, "stopped at %s:%s in %s\n", d.path, frame.Pos, frame.Name)
+	d.printLine(frame.Pos.Line, "")
+	for {
+		fmt.Fprint(d.out, "(debug) ")
+		if !d.commands.Scan() {
+			fmt.Fprintln(d.out)
+			d.stop()
+			return
+		}
+		fields := strings.Fields(d.commands.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if d.command(frame, fields[0], fields[1:]) {
+			return
+		}
+	}
+}
+
+func (d *Debugger) stop() {
+	d.Quit()
+}
+
+// command runs a command and reports whether it resumes the program.
+func (d *Debugger) command(frame *Frame, name string, args []string) bool {
+	switch name {
+	case "break", "b", "clear":
+		line, err := d.lineArg(args)
+		if err != nil {
+			fmt.Fprintln(d.out, err)
+		} else if name != "clear" {
+			d.Break(line)
+			fmt.Fprintf(d.out, "breakpoint at line %d\n", line)
+		} else if d.Clear(line) {
+			fmt.Fprintf(d.out, "cleared line %d\n", line)
+		} else {
+			fmt.Fprintf(d.out, "no breakpoint at line %d\n", line)
+		}
+	case "continue", "c":
+		d.mode = running
+		return true
+	case "step", "s":
+		d.mode = stepping
+		return true
+	case "next", "n":
+		d.mode, d.depth = stepOver, len(d.frames)
+		return true
+	case "out", "o":
+		d.mode, d.depth = stepOut, len(d.frames)
+		return true
+	case "print", "p":
+		if len(args) != 1 {
+			fmt.Fprintln(d.out, "usage: print NAME")
+			break
+		}
+		if v, ok := frame.Env.Get(args[0]); ok {
+			fmt.Fprintf(d.out, "%s = %s\n", args[0], Describe(v))
+		} else {
+			fmt.Fprintf(d.out, "undefined: %s\n", args[0])
+		}
+	case "locals":
+		d.printEnv(frame.Env)
+	case "env":
+		for level, env := 0, frame.Env; env != nil; level, env = level+1, env.Outer() {
+			fmt.Fprintf(d.out, "#%d\n", level)
+			d.printEnv(env)
+		}
+	case "stack", "bt":
+		for i, f := range d.Frames() {
+			fmt.Fprintf(d.out, "#%d %s at %s\n", i, f.Name, f.Pos)
+		}
+	case "list", "l":
+		for line := frame.Pos.Line - 2; line <= frame.Pos.Line+2; line++ {
+			marker := "  "
+			if line == frame.Pos.Line {
+				marker = "=>"
+			}
+			d.printLine(line, marker)
+		}
+	case "gas":
+		fmt.Fprintf(d.out, "gas used: %d\n", d.in.GasUsed())
+	case "quit", "q":
+		d.stop()
+		return true
+	case "help", "h":
+		fmt.Fprintln(d.out, "commands: break LINE, clear LINE, continue, step, next, out, print NAME, locals, env, stack, list, gas, quit")
+	default:
+		fmt.Fprintf(d.out, "unknown command %q, try help\n", name)
+	}
+	return false
+}
+
+func (d *Debugger) lineArg(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, errors.New("usage: break LINE")
+	}
+	line, err := strconv.Atoi(args[0])
+	if err != nil || line < 1 || line > len(d.lines) {
+		return 0, fmt.Errorf("no line %s in %s", args[0], d.path)
+	}
+	return line, nil
+}
+
+func (d *Debugger) printLine(line int, marker string) {
+	if line < 1 || line > len(d.lines) {
+		return
+	}
+	if marker != "" {
+		marker += " "
+	}
+	fmt.Fprintf(d.out, "%s%4d | %s\n", marker, line, d.lines[line-1])
+}
+
+func (d *Debugger) printEnv(env *object.Environment) {
+	for _, name := range env.Names() {
+		v, _ := env.Get(name)
+		fmt.Fprintf(d.out, "  %s = %s\n", name, Describe(v))
+	}
+}
+
+// Describe returns the value of a variable as shown by debuggers, which
+// leave out the bodies of functions.
+func Describe(v object.Object) string {
+	switch v := v.(type) {
+	case *object.Function:
+		return "fn(" + ast.ParameterList(v.Parameters, v.Variadic) + ")"
+	case *object.Builtin:
+		return "builtin"
+	}
+	return v.Inspect()
+}
@@ -0,0 +1,159 @@
+package debugger
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/interpreter"
+)
+
+const program = `let fib = fn(n) {
+	if (n < 2) { return n; }
+	fib(n - 1) + fib(n - 2)
+};
+let x = fib(3);
+print(x);`
+
+// debug runs source under a debugger reading commands, and returns all it
+// and the program wrote.
+func debug(t *testing.T, source, commands string, mode interpreter.Mode) (string, error) {
+	t.Helper()
+	var out bytes.Buffer
+	d := New("prog.mk", source, strings.NewReader(commands), &out)
+	in := interpreter.New(interpreter.WithMode(mode), interpreter.WithStdout(&out), interpreter.WithHook(d))
+	parsed, err := interpreter.Parse(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = d.Run(in, parsed)
+	return out.String(), err
+}
+
+func TestSession(t *testing.T) {
+	commands := `b 2
+b 9
+foo
+c
+stack
+p n
+n
+s
+bt
+locals
+out
+out
+clear 2
+clear 2
+n
+env
+c
+`
+	expected := `stopped at prog.mk:1:1 in main
+   1 | let fib = fn(n) {
+(debug) breakpoint at line 2
+(debug) no line 9 in prog.mk
+(debug) unknown command "foo", try help
+(debug) stopped at prog.mk:2:2 in fib
+   2 | 	if (n < 2) { return n; }
+(debug) #0 fib at 2:2
+#1 main at 5:1
+(debug) n = 3
+(debug) stopped at prog.mk:3:2 in fib
+   3 | 	fib(n - 1) + fib(n - 2)
+(debug) stopped at prog.mk:2:2 in fib
+   2 | 	if (n < 2) { return n; }
+(debug) #0 fib at 2:2
+#1 fib at 3:2
+#2 main at 5:1
+(debug)   n = 2
+(debug) stopped at prog.mk:2:2 in fib
+   2 | 	if (n < 2) { return n; }
+(debug) stopped at prog.mk:2:2 in fib
+   2 | 	if (n < 2) { return n; }
+(debug) cleared line 2
+(debug) no breakpoint at line 2
+(debug) stopped at prog.mk:2:15 in fib
+   2 | 	if (n < 2) { return n; }
+(debug) #0
+  n = 0
+#1
+  fib = fn(n)
+  print = builtin
+  save = builtin
+(debug) 2
+program finished
+`
+	// The hook is called by every evaluator.
+	for _, mode := range []interpreter.Mode{interpreter.Full, interpreter.Middle, interpreter.Simple} {
+		out, err := debug(t, program, commands, mode)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		if out != expected {
+			t.Errorf("mode %d: wrong session.\nexpected=\n%s\ngot=\n%s", mode, expected, out)
+		}
+	}
+}
+
+func TestStepOverAndOut(t *testing.T) {
+	source := `let inc = fn(a) {
+	let b = a + 1;
+	b
+};
+let y = inc(1);
+let z = inc(y);
+print(z);`
+	out, err := debug(t, source, "n\nn\ns\nn\nlist\ngas\nout\np y\nc\n", interpreter.Full)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	expected := `stopped at prog.mk:1:1 in main
+   1 | let inc = fn(a) {
+(debug) stopped at prog.mk:5:1 in main
+   5 | let y = inc(1);
+(debug) stopped at prog.mk:6:1 in main
+   6 | let z = inc(y);
+(debug) stopped at prog.mk:2:2 in inc
+   2 | 	let b = a + 1;
+(debug) stopped at prog.mk:3:2 in inc
+   3 | 	b
+(debug)       1 | let inc = fn(a) {
+      2 | 	let b = a + 1;
+=>    3 | 	b
+      4 | };
+      5 | let y = inc(1);
+(debug) gas used: 4
+(debug) stopped at prog.mk:7:1 in main
+   7 | print(z);
+(debug) y = 2
+(debug) 3
+program finished
+`
+	if out != expected {
+		t.Errorf("wrong session.\nexpected=\n%s\ngot=\n%s", expected, out)
+	}
+}
+
+func TestQuit(t *testing.T) {
+	for _, commands := range []string{"n\nq\n", "n\n"} {
+		out, err := debug(t, `print(1); print(2); print(3);`, commands, interpreter.Full)
+		if !errors.Is(err, ErrQuit) {
+			t.Errorf("%q: expected ErrQuit, got=%v", commands, err)
+		}
+		if strings.Contains(out, "2\n") || strings.Contains(out, "finished") {
+			t.Errorf("%q: program went on after quitting. got=\n%s", commands, out)
+		}
+	}
+}
+
+func TestAnonymousFunctions(t *testing.T) {
+	out, err := debug(t, "let r = (fn(x) {\n\tx\n})(4);", "b 2\nc\nbt\nc\n", interpreter.Full)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !strings.Contains(out, "#0 fn@1:16 at 2:2\n") {
+		t.Errorf("anonymous function not named by position. got=\n%s", out)
+	}
+}
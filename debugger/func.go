@@ -0,0 +1,116 @@
+package debugger
+
+import (
+	"sort"
+
+	"github.com/SebastiaanWouters/verigo/interpreter"
+)
+
+// Action tells a stopped debugger how to go on.
+type Action int
+
+const (
+	Continue Action = iota // run until the next breakpoint
+	StepIn                 // stop before the next statement, entering calls
+	StepOver               // stop before the next statement of this function
+	StepOut                // stop once this function has returned
+	Quit                   // stop the program
+)
+
+// Reason tells why a program stopped.
+type Reason string
+
+const (
+	Entry      Reason = "entry"
+	Breakpoint Reason = "breakpoint"
+	Step       Reason = "step"
+	Pause      Reason = "pause"
+)
+
+// StopFunc is called by the goroutine running the program whenever it
+// stops, before the statement of frame is evaluated, and returns how to go
+// on. The program and its environments do not change until it returns.
+type StopFunc func(reason Reason, frame *Frame) Action
+
+// NewFunc returns a debugger that calls stop whenever it stops a program,
+// rather than reading commands. Break, Clear, SetBreakpoints, Breakpoints,
+// Pause and Quit may be called from any goroutine.
+func NewFunc(stop StopFunc) *Debugger {
+	return &Debugger{stopFunc: stop, breakpoints: map[int]bool{}}
+}
+
+// resume goes on as action tells, from a stop at depth.
+func (d *Debugger) resume(action Action, depth int) {
+	switch action {
+	case Continue:
+		d.mode = running
+	case StepIn:
+		d.mode = stepping
+	case StepOver:
+		d.mode, d.depth = stepOver, depth
+	case StepOut:
+		d.mode, d.depth = stepOut, depth
+	case Quit:
+		d.Quit()
+	}
+}
+
+// Clear removes the breakpoint on line, reporting whether there was one.
+func (d *Debugger) Clear(line int) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	ok := d.breakpoints[line]
+	delete(d.breakpoints, line)
+	return ok
+}
+
+// SetBreakpoints replaces the breakpoints by those on lines.
+func (d *Debugger) SetBreakpoints(lines []int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.breakpoints = map[int]bool{}
+	for _, line := range lines {
+		d.breakpoints[line] = true
+	}
+}
+
+// Breakpoints returns the lines with breakpoints, in order.
+func (d *Debugger) Breakpoints() []int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	lines := make([]int, 0, len(d.breakpoints))
+	for line := range d.breakpoints {
+		lines = append(lines, line)
+	}
+	sort.Ints(lines)
+	return lines
+}
+
+// Pause stops the running program before its next statement.
+func (d *Debugger) Pause() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.pauseNext = true
+}
+
+// Quit stops the program, whose Run then returns ErrQuit.
+func (d *Debugger) Quit() {
+	d.mu.Lock()
+	d.quit = true
+	cancel := d.cancel
+	d.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+}
+
+func (d *Debugger) quitting() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.quit
+}
+
+// Interpreter returns the interpreter of the program being run.
+func (d *Debugger) Interpreter() *interpreter.Interpreter {
+	return d.in
+}
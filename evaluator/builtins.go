@@ -44,6 +44,8 @@ var builtins = map[string]*object.Builtin{
 			switch arg := args[0].(type) {
 			case *object.String:
 				return &object.Integer{Value: int64(len(arg.Value))}
+			case *object.Array:
+				return &object.Integer{Value: int64(len(arg.Elements))}
 			default:
 				return newError("argument to `len` not supported, got %s",
 					args[0].Type())
@@ -179,6 +181,14 @@ var builtins = map[string]*object.Builtin{
 
 		},
 	},
+	"assert": &object.Builtin{
+		Name: "assert",
+		Fn:   object.Assert,
+	},
+	"assert_eq": &object.Builtin{
+		Name: "assert_eq",
+		Fn:   object.AssertEq,
+	},
 	"print": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			for _, arg := range args {
@@ -189,9 +199,31 @@ var builtins = map[string]*object.Builtin{
 	},
 }
 
+// Signatures declares the types of the builtins and utils, in the syntax of
+// type annotations, for the type checker.
+var Signatures = map[string]string{
+	"len":     "fn(any): int",
+	"pow":     "fn(int, int): int",
+	"sqrt":    "fn(int): int",
+	"sin":     "fn(int): int",
+	"tan":     "fn(int): int",
+	"rand":    "fn(int): int",
+	"fib":     "fn(int): int",
+	"isPrime": "fn(int): bool",
+	"print":   "fn(...any): null",
+	"save":    "fn(string, any): null",
+
+	"assert":       "fn(any, ...string): null",
+	"assert_eq":    "fn(any, any, ...string): null",
+	"assert_error": "fn(fn(): any, ...string): null",
+}
+
 var utils = map[string]*object.Save{
 	"save": &object.Save{
 		Fn: func(key object.Object, value object.Object, env *object.Environment, rChan chan object.Result) object.Object {
+			if err := object.CheckSave(value); err != nil {
+				return newError("%s", err)
+			}
 			if key.Type() == object.STRING_OBJ {
 				var res = object.Result{
 					Key:   key.Inspect(),
@@ -206,3 +238,35 @@ var utils = map[string]*object.Save{
 		},
 	},
 }
+
+// IsBuiltin reports whether name is provided, and metered, by the evaluator
+// itself.
+func IsBuiltin(name string) bool {
+	if _, ok := builtins[name]; ok {
+		return true
+	}
+	if _, ok := utils[name]; ok {
+		return true
+	}
+	if _, ok := callers[name]; ok {
+		return true
+	}
+	_, ok := builtinOpcodes[name]
+	return ok
+}
+
+// callers holds the builtins that call the functions they are passed.
+// They are not charged themselves, but the calls they make are.
+var callers = map[string]*object.Caller{
+	"assert_error": &object.Caller{
+		Name: "assert_error",
+		Fn:   object.AssertError,
+	},
+}
+
+// IsCaller reports whether name is a builtin that calls the functions it
+// is passed, whose cost depends on them.
+func IsCaller(name string) bool {
+	_, ok := callers[name]
+	return ok
+}
@@ -301,6 +301,50 @@ func TestFunctionApplication(t *testing.T) {
 	}
 }
 
+func TestFunctionArity(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let add = fn(x, y) { x + y; }; add(1);", "wrong number of arguments. got=1, want=2"},
+		{"let add = fn(x, y) { x + y; }; add(1, 2, 3);", "wrong number of arguments. got=3, want=2"},
+		{"let f = fn(x, y = 2) { x + y; }; f();", "wrong number of arguments. got=0, want at least 1"},
+		{"let f = fn(x, y = 2) { x + y; }; f(1, 2, 3);", "wrong number of arguments. got=3, want at most 2"},
+		{"let f = fn(x, ...ys) { x; }; f();", "wrong number of arguments. got=0, want at least 1"},
+		{"let f = fn(x = y) { x; }; f();", "identifier not found: y"},
+		{`save("a")`, "wrong number of arguments. got=1, want=2"},
+	}
+
+	for _, tt := range tests {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok {
+			t.Errorf("%q: no error object returned", tt.input)
+			continue
+		}
+		if errObj.Message != tt.expected {
+			t.Errorf("%q: wrong error message. expected=%q, got=%q", tt.input, tt.expected, errObj.Message)
+		}
+	}
+}
+
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let f = fn(x, y = 10) { x + y; }; f(1);", 11},
+		{"let f = fn(x, y = 10) { x + y; }; f(1, 2);", 3},
+		{"let f = fn(x, y = x * 2, z = y + 1) { x + y + z; }; f(1);", 6},
+		{"let f = fn(x, ...ys) { len(ys); }; f(1);", 0},
+		{"let f = fn(x, ...ys) { x + len(ys) * 10 + ys[2]; }; f(1, 2, 3, 4);", 35},
+		{"let f = fn(x = 1, ...ys) { x + len(ys); }; f() + f(5, 0, 0);", 8},
+	}
+
+	for _, tt := range tests {
+		testIntegerObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func TestEnclosingEnvironments(t *testing.T) {
 	input := `
 let first = 10;
@@ -417,3 +461,171 @@ func testNullObject(t *testing.T, obj object.Object) bool {
 	}
 	return true
 }
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	if len(result.Elements) != 3 {
+		t.Fatalf("array has wrong num of elements. got=%d",
+			len(result.Elements))
+	}
+
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][2]", 3},
+		{"let i = 0; [1][i];", 1},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"len([1, 2, 3])", 3},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", nil},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestHashLiterals(t *testing.T) {
+	input := `let two = "two";
+	{
+		"one": 10 - 9,
+		two: 1 + 1,
+		"thr" + "ee": 6 / 2,
+		4: 4,
+		true: 5,
+		false: 6
+	}`
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Hash)
+	if !ok {
+		t.Fatalf("Eval didn't return Hash. got=%T (%+v)", evaluated, evaluated)
+	}
+
+	expected := map[object.HashKey]int64{
+		(&object.String{Value: "one"}).HashKey():   1,
+		(&object.String{Value: "two"}).HashKey():   2,
+		(&object.String{Value: "three"}).HashKey(): 3,
+		(&object.Integer{Value: 4}).HashKey():      4,
+		evaluator.TRUE.HashKey():                   5,
+		evaluator.FALSE.HashKey():                  6,
+	}
+
+	if len(result.Pairs) != len(expected) {
+		t.Fatalf("Hash has wrong num of pairs. got=%d", len(result.Pairs))
+	}
+
+	for expectedKey, expectedValue := range expected {
+		pair, ok := result.Pairs[expectedKey]
+		if !ok {
+			t.Errorf("no pair for given key in Pairs")
+		}
+
+		testIntegerObject(t, pair.Value, expectedValue)
+	}
+}
+
+func TestHashIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`{"foo": 5}["foo"]`, 5},
+		{`{"foo": 5}["bar"]`, nil},
+		{`let key = "foo"; {"foo": 5}[key]`, 5},
+		{`{}["foo"]`, nil},
+		{`{5: 5}[5]`, 5},
+		{`{true: 5}[true]`, 5},
+		{`{false: 5}[false]`, 5},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestQuote(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(5)`, `5`},
+		{`quote(5 + 8)`, `(5 + 8)`},
+		{`quote(foobar)`, `foobar`},
+		{`quote(foobar + barfoo)`, `(foobar + barfoo)`},
+		{`quote(unquote(4))`, `4`},
+		{`quote(unquote(4 + 4))`, `8`},
+		{`quote(8 + unquote(4 + 4))`, `(8 + 8)`},
+		{`quote(unquote(4 + 4) + 8)`, `(8 + 8)`},
+		{`let foobar = 8; quote(foobar)`, `foobar`},
+		{`let foobar = 8; quote(unquote(foobar))`, `8`},
+		{`quote(unquote(true == false))`, `false`},
+		{`quote(unquote(quote(4 + 4)))`, `(4 + 4)`},
+		{`let q = quote(4 + 4); quote(unquote(4 + 4) + unquote(q))`, `(8 + (4 + 4))`},
+		{`quote(unquote("a" + "b") + unquote(null))`, `(ab + null)`},
+		{`let f = fn(n) { quote(unquote(n) * 2) }; [f(1), f(2)]`, `[QUOTE((1 * 2)), QUOTE((2 * 2))]`},
+	}
+
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		if arr, ok := evaluated.(*object.Array); ok {
+			if arr.Inspect() != tt.expected {
+				t.Errorf("%q: wrong quotes. expected=%q, got=%q", tt.input, tt.expected, arr.Inspect())
+			}
+			continue
+		}
+		quote, ok := evaluated.(*object.Quote)
+		if !ok {
+			t.Fatalf("%q: expected *object.Quote. got=%T (%+v)", tt.input, evaluated, evaluated)
+		}
+		if quote.Node == nil {
+			t.Fatalf("%q: quote.Node is nil", tt.input)
+		}
+		if quote.Node.String() != tt.expected {
+			t.Errorf("%q: not equal. got=%q, want=%q", tt.input, quote.Node.String(), tt.expected)
+		}
+	}
+
+	failures := []struct {
+		input    string
+		expected string
+	}{
+		{`quote(unquote([1]))`, "cannot unquote ARRAY"},
+		{`quote(1, 2)`, "wrong number of arguments. got=2, want=1"},
+		{`quote(unquote(1 / 0))`, "division by zero"},
+		{`let m = fn() { macro(x) { x } }; m()`, "macros can only be defined by a let at the top level"},
+	}
+	for _, tt := range failures {
+		errObj, ok := testEval(tt.input).(*object.Error)
+		if !ok || errObj.Message != tt.expected {
+			t.Errorf("%q: expected error %q, got=%v", tt.input, tt.expected, errObj)
+		}
+	}
+}
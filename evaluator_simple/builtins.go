@@ -44,6 +44,8 @@ var builtins = map[string]*object.Builtin{
 			switch arg := args[0].(type) {
 			case *object.String:
 				return &object.Integer{Value: int64(len(arg.Value))}
+			case *object.Array:
+				return &object.Integer{Value: int64(len(arg.Elements))}
 			default:
 				return newError("argument to `len` not supported, got %s",
 					args[0].Type())
@@ -179,6 +181,14 @@ var builtins = map[string]*object.Builtin{
 
 		},
 	},
+	"assert": &object.Builtin{
+		Name: "assert",
+		Fn:   object.Assert,
+	},
+	"assert_eq": &object.Builtin{
+		Name: "assert_eq",
+		Fn:   object.AssertEq,
+	},
 	"print": &object.Builtin{
 		Fn: func(args ...object.Object) object.Object {
 			for _, arg := range args {
@@ -188,3 +198,11 @@ var builtins = map[string]*object.Builtin{
 		},
 	},
 }
+
+// callers holds the builtins that call the functions they are passed.
+var callers = map[string]*object.Caller{
+	"assert_error": &object.Caller{
+		Name: "assert_error",
+		Fn:   object.AssertError,
+	},
+}
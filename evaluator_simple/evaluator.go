@@ -5,6 +5,7 @@ import (
 
 	"github.com/SebastiaanWouters/verigo/ast"
 	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/token"
 )
 
 var (
@@ -20,27 +21,46 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		return evalProgram(node, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
+	case *ast.BadStatement, *ast.BadExpression:
+		return newError("cannot evaluate %s: program has parse errors", node.String())
+	case *ast.FoldedExpression:
+		return Eval(node.Value, env)
 	// Expressions
 	case *ast.IntegerLiteral:
 		return &object.Integer{Value: node.Value}
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
+	case *ast.NullLiteral:
+		return NULL
+	case *ast.IsNullExpression:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return nativeBoolToBooleanObject(val.Type() == object.NULL_OBJ)
 	case *ast.PrefixExpression:
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return located(evalPrefixExpression(node.Operator, right), node.Token)
 	case *ast.InfixExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
 		}
+		if node.Operator == "??" {
+			// The default is only evaluated if needed.
+			if left.Type() != object.NULL_OBJ {
+				return left
+			}
+			return Eval(node.Right, env)
+		}
 		right := Eval(node.Right, env)
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return located(evalInfixExpression(node.Operator, left, right), node.Token)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node, env)
 	case *ast.IfExpression:
@@ -53,21 +73,64 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return object.Throw(val, node.Token.Pos)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
+	case *ast.MatchExpression:
+		return evalMatchExpression(node, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if b := node.Name.Binding; b != nil {
+			env.SetSlot(b.Slot, val)
+		} else {
+			env.Set(node.Name.Value, val)
+		}
+	case *ast.StructStatement:
+		def := &object.StructType{Name: node.Name.Value}
+		for _, field := range node.Fields {
+			def.Fields = append(def.Fields, field.Value)
+		}
+		if b := node.Name.Binding; b != nil {
+			env.SetSlot(b.Slot, def)
+		} else {
+			env.Set(node.Name.Value, def)
+		}
+	case *ast.StructLiteral:
+		return located(evalStructLiteral(node, env), node.Name.Token)
+	case *ast.FieldExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		if node.Optional && left.Type() == object.NULL_OBJ {
+			return NULL
+		}
+		return located(evalFieldExpression(left, node.Field.Value), node.Token)
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return located(evalIdentifier(node, env), node.Token)
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return &object.Function{Parameters: params, Variadic: node.Variadic, Env: env, Body: body, Scope: node.Scope}
 	case *ast.StringLiteral:
 		return &object.String{Value: node.Value}
+	case *ast.MacroLiteral:
+		// macro.Define removes the lets of macros at the top level before a
+		// program runs, as the interpreter and the REPL do, so any macro
+		// literal left is misplaced.
+		return located(newError("macros can only be defined by a let at the top level"), node.Token)
 	case *ast.CallExpression:
+		if _, ok := ast.IsCallTo(node, "quote"); ok {
+			return evalQuote(node, env)
+		}
 		function := Eval(node.Function, env)
 		if isError(function) {
 			return function
@@ -76,7 +139,33 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args, env)
+		if fn, ok := function.(*object.Function); ok && node.Tail {
+			// The calling function returns this call, so leave it to
+			// applyFunction to make once the caller's frame is gone.
+			return &object.TailCall{Fn: fn, Args: args}
+		}
+		return located(applyFunction(function, args, env), node.Token)
+	case *ast.ArrayLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return &object.Array{Elements: elements}
+	case *ast.IndexExpression:
+		left := Eval(node.Left, env)
+		if isError(left) {
+			return left
+		}
+		if node.Optional && left.Type() == object.NULL_OBJ {
+			return NULL
+		}
+		index := Eval(node.Index, env)
+		if isError(index) {
+			return index
+		}
+		return located(evalIndexExpression(left, index), node.Token)
+	case *ast.HashLiteral:
+		return evalHashLiteral(node, env)
 	}
 
 	return nil
@@ -85,6 +174,12 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 	var result object.Object
 	for _, statement := range program.Statements {
+		if hook := env.Hook(); hook != nil {
+			hook.Statement(statement, env)
+			if err := env.Meter().Canceled(); err != nil {
+				return object.MeterError(err)
+			}
+		}
 		result = Eval(statement, env)
 		switch result := result.(type) {
 		case *object.ReturnValue:
@@ -99,6 +194,12 @@ func evalProgram(program *ast.Program, env *object.Environment) object.Object {
 func evalBlockStatement(block *ast.BlockStatement, env *object.Environment) object.Object {
 	var result object.Object
 	for _, statement := range block.Statements {
+		if hook := env.Hook(); hook != nil {
+			hook.Statement(statement, env)
+			if err := env.Meter().Canceled(); err != nil {
+				return object.MeterError(err)
+			}
+		}
 		result = Eval(statement, env)
 		if result != nil {
 			rt := result.Type()
@@ -129,32 +230,157 @@ func evalIdentifier(
 	node *ast.Identifier,
 	env *object.Environment,
 ) object.Object {
+	if b := node.Binding; b != nil {
+		if val, ok := env.GetSlot(b.Depth, b.Slot); ok {
+			return val
+		}
+		// The variable is declared later in its function, so the name
+		// still refers to an outer binding, if any.
+	}
 	if val, ok := env.Get(node.Value); ok {
 		return val
 	}
 	if builtin, ok := builtins[node.Value]; ok {
 		return builtin
 	}
+	if caller, ok := callers[node.Value]; ok {
+		return caller
+	}
 	return newError("identifier not found: " + node.Value)
 }
 
 func evalForExpression(ie *ast.ForExpression, env *object.Environment) object.Object {
-	Eval(&ie.Variable, env)
+	if init := Eval(&ie.Variable, env); isError(init) {
+		return init
+	}
 	condition := Eval(ie.Condition, env)
 	if isError(condition) {
 		return condition
 	}
 	for isTruthy(condition) {
-		Eval(ie.Loop, env)
-		Eval(&ie.Update, env)
+		if err := env.Meter().Canceled(); err != nil {
+			return object.MeterError(err)
+		}
+		object.Branched(env, ie, 0)
+		if result := Eval(ie.Loop, env); isError(result) {
+			return result
+		}
+		if update := Eval(&ie.Update, env); isError(update) {
+			return update
+		}
 		condition = Eval(ie.Condition, env)
 		if isError(condition) {
 			return condition
 		}
 	}
+	object.Branched(env, ie, 1)
 	return NULL
 }
 
+// evalTryExpression runs the handler of te on the errors a try can catch,
+// and its finally block on everything else but the errors that end the
+// evaluation. A return or an error in the finally block replaces the
+// result.
+func evalTryExpression(te *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(te.Body, env)
+	if err, ok := result.(*object.Error); ok {
+		if !err.Catchable() {
+			return err
+		}
+		if te.Handler != nil {
+			if b := te.Catch.Binding; b != nil {
+				env.SetSlot(b.Slot, err.Value())
+			} else {
+				env.Set(te.Catch.Value, err.Value())
+			}
+			result = Eval(te.Handler, env)
+		}
+	}
+	if te.Finally != nil {
+		if err, ok := result.(*object.Error); ok && !err.Catchable() {
+			return err
+		}
+		done := Eval(te.Finally, env)
+		if done != nil && (done.Type() == object.RETURN_VALUE_OBJ || done.Type() == object.ERROR_OBJ) {
+			return done
+		}
+	}
+	return result
+}
+
+// evalMatchExpression evaluates the first arm of me whose pattern matches
+// the subject and whose guard holds. The names a pattern binds are bound
+// like a let, and only when it matches.
+func evalMatchExpression(me *ast.MatchExpression, env *object.Environment) object.Object {
+	subject := Eval(me.Subject, env)
+	if isError(subject) {
+		return subject
+	}
+	for i, arm := range me.Arms {
+		matched := object.Match(arm.Pattern, subject, func(name *ast.Identifier, v object.Object) {
+			if b := name.Binding; b != nil {
+				env.SetSlot(b.Slot, v)
+			} else {
+				env.Set(name.Value, v)
+			}
+		})
+		if !matched {
+			continue
+		}
+		if arm.Guard != nil {
+			guard := Eval(arm.Guard, env)
+			if isError(guard) {
+				return guard
+			}
+			if !isTruthy(guard) {
+				continue
+			}
+		}
+		object.Branched(env, me, i)
+		if arm.Body != nil {
+			return Eval(arm.Body, env)
+		}
+		return Eval(arm.Value, env)
+	}
+	return located(newError("no arm matches %s", subject.Inspect()), me.Token)
+}
+
+// evalQuote returns the argument of a call to quote, unevaluated but for
+// the arguments of the unquote calls in it, which are replaced by their
+// values. The quoted expression is copied, so that a macro can be called more
+// than once.
+func evalQuote(call *ast.CallExpression, env *object.Environment) object.Object {
+	if len(call.Arguments) != 1 {
+		return located(newError("wrong number of arguments. got=%d, want=1", len(call.Arguments)), call.Token)
+	}
+	var failed object.Object
+	quoted := ast.Modify(ast.Copy(call.Arguments[0]), func(node ast.Node) ast.Node {
+		unquote, ok := ast.IsCallTo(node, "unquote")
+		if !ok || failed != nil {
+			return node
+		}
+		if len(unquote.Arguments) != 1 {
+			failed = located(newError("wrong number of arguments. got=%d, want=1", len(unquote.Arguments)), unquote.Token)
+			return node
+		}
+		value := Eval(unquote.Arguments[0], env)
+		if isError(value) {
+			failed = value
+			return node
+		}
+		replacement, ok := object.ToNode(value, unquote.Token)
+		if !ok {
+			failed = located(newError("cannot unquote %s", value.Type()), unquote.Token)
+			return node
+		}
+		return replacement
+	})
+	if failed != nil {
+		return failed
+	}
+	return &object.Quote{Node: quoted}
+}
+
 func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Object {
 	condition := Eval(ie.Condition, env)
 	if isError(condition) {
@@ -162,22 +388,24 @@ func evalIfExpression(ie *ast.IfExpression, env *object.Environment) object.Obje
 	}
 
 	if isTruthy(condition) {
+		object.Branched(env, ie, 0)
 		return Eval(ie.Consequence, env)
-	} else if ie.Alternative != nil {
+	}
+	object.Branched(env, ie, 1)
+	if ie.Alternative != nil {
 		return Eval(ie.Alternative, env)
-	} else {
-		return NULL
 	}
+	return NULL
 }
 
+// isTruthy compares by value rather than identity so that booleans created
+// by embedding code behave like TRUE and FALSE.
 func isTruthy(obj object.Object) bool {
-	switch obj {
-	case NULL:
-		return false
-	case TRUE:
-		return true
-	case FALSE:
+	switch obj := obj.(type) {
+	case *object.Null:
 		return false
+	case *object.Boolean:
+		return obj.Value
 	default:
 		return true
 	}
@@ -189,6 +417,15 @@ func evalInfixExpression(operator string, left object.Object, right object.Objec
 		return evalStringInfixExpression(operator, left, right)
 	case left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ:
 		return evalIntegerInfixExpression(operator, left, right)
+	case left.Type() == object.BOOLEAN_OBJ && right.Type() == object.BOOLEAN_OBJ:
+		return evalBooleanInfixExpression(operator, left, right)
+	case left.Type() == object.NULL_OBJ || right.Type() == object.NULL_OBJ:
+		// Nulls made by embedding code equal NULL.
+		if operator == "==" || operator == "!=" {
+			return nativeBoolToBooleanObject((left.Type() == right.Type()) == (operator == "=="))
+		}
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
 	case operator == "==":
 		return nativeBoolToBooleanObject(left == right)
 	case operator == "!=":
@@ -231,6 +468,9 @@ func evalIntegerInfixExpression(
 		return &object.Integer{Value: leftVal * rightVal}
 	case "/":
 
+		if rightVal == 0 {
+			return newError("division by zero")
+		}
 		return &object.Integer{Value: leftVal / rightVal}
 	case "<":
 
@@ -250,6 +490,23 @@ func evalIntegerInfixExpression(
 	}
 }
 
+func evalBooleanInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal := left.(*object.Boolean).Value
+	rightVal := right.(*object.Boolean).Value
+	switch operator {
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator: %s %s %s",
+			left.Type(), operator, right.Type())
+	}
+}
+
 func evalPrefixExpression(operator string, right object.Object) object.Object {
 	switch operator {
 	case "!":
@@ -262,16 +519,7 @@ func evalPrefixExpression(operator string, right object.Object) object.Object {
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
-	switch right {
-	case TRUE:
-		return FALSE
-	case FALSE:
-		return TRUE
-	case NULL:
-		return TRUE
-	default:
-		return FALSE
-	}
+	return nativeBoolToBooleanObject(!isTruthy(right))
 }
 
 func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
@@ -284,6 +532,122 @@ func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
 	return &object.Integer{Value: -value}
 }
 
+func evalIndexExpression(left, index object.Object) object.Object {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		return evalArrayIndexExpression(left, index)
+	case left.Type() == object.HASH_OBJ:
+		return evalHashIndexExpression(left, index)
+	default:
+		return newError("index operator not supported: %s", left.Type())
+	}
+}
+
+func evalArrayIndexExpression(array, index object.Object) object.Object {
+	elements := array.(*object.Array).Elements
+	idx := index.(*object.Integer).Value
+	if idx < 0 || idx > int64(len(elements)-1) {
+		return NULL
+	}
+	return elements[idx]
+}
+
+func evalHashIndexExpression(hash, index object.Object) object.Object {
+	key, ok := index.(object.Hashable)
+	if !ok {
+		return newError("unusable as hash key: %s", index.Type())
+	}
+	pair, ok := hash.(*object.Hash).Pairs[key.HashKey()]
+	if !ok {
+		return NULL
+	}
+	return pair.Value
+}
+
+// evalStructLiteral builds a struct of the type node names. Fields not
+// given are copied from the base, if any, or null.
+func evalStructLiteral(node *ast.StructLiteral, env *object.Environment) object.Object {
+	def := Eval(node.Name, env)
+	if isError(def) {
+		return def
+	}
+	structType, ok := def.(*object.StructType)
+	if !ok {
+		return newError("not a struct: %s", node.Name.Value)
+	}
+
+	fields := make([]object.Object, len(structType.Fields))
+	if node.Base != nil {
+		base := Eval(node.Base, env)
+		if isError(base) {
+			return base
+		}
+		s, ok := base.(*object.Struct)
+		if !ok || s.Def != structType {
+			return newError("cannot copy the fields of %s into %s", typeName(base), structType.Name)
+		}
+		copy(fields, s.Fields)
+	} else {
+		for i := range fields {
+			fields[i] = NULL
+		}
+	}
+	for i, field := range node.Fields {
+		idx, ok := structType.Field(field.Value)
+		if !ok {
+			return newError("unknown field %s of struct %s", field.Value, structType.Name)
+		}
+		value := Eval(node.Values[i], env)
+		if isError(value) {
+			return value
+		}
+		fields[idx] = value
+	}
+	return &object.Struct{Def: structType, Fields: fields}
+}
+
+func evalFieldExpression(left object.Object, name string) object.Object {
+	switch left := left.(type) {
+	case *object.Struct:
+		if value, ok := left.Get(name); ok {
+			return value
+		}
+		return newError("unknown field %s of struct %s", name, left.Def.Name)
+	case *object.Hash:
+		return evalHashIndexExpression(left, &object.String{Value: name})
+	default:
+		return newError("field access not supported: %s", left.Type())
+	}
+}
+
+// typeName returns the name of the struct type of obj, or else its type.
+func typeName(obj object.Object) string {
+	if s, ok := obj.(*object.Struct); ok {
+		return s.Def.Name
+	}
+	return string(obj.Type())
+}
+
+func evalHashLiteral(node *ast.HashLiteral, env *object.Environment) object.Object {
+	pairs := make(map[object.HashKey]object.HashPair)
+	for _, keyNode := range node.Keys {
+		key := Eval(keyNode, env)
+		if isError(key) {
+			return key
+		}
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", key.Type())
+		}
+		value := Eval(node.Pairs[keyNode], env)
+		if isError(value) {
+			return value
+		}
+		pairs[hashKey.HashKey()] = object.HashPair{Key: key, Value: value}
+	}
+	return &object.Hash{Pairs: pairs}
+}
+
 func nativeBoolToBooleanObject(boolean bool) *object.Boolean {
 	if boolean {
 		return TRUE
@@ -294,36 +658,106 @@ func nativeBoolToBooleanObject(boolean bool) *object.Boolean {
 func applyFunction(fn object.Object, args []object.Object, env *object.Environment) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
-		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
-		return unwrapReturnValue(evaluated)
+		for {
+			if err := env.Meter().Canceled(); err != nil {
+				return object.MeterError(err)
+			}
+			extendedEnv, err := extendFunctionEnv(fn, args)
+			if err != nil {
+				return err
+			}
+			hook := env.Hook()
+			if hook != nil {
+				hook.Call(fn, extendedEnv)
+			}
+			evaluated := unwrapReturnValue(Eval(fn.Body, extendedEnv))
+			if hook != nil {
+				hook.Return(fn, evaluated)
+			}
+			tail, ok := evaluated.(*object.TailCall)
+			if !ok {
+				return evaluated
+			}
+			fn, args = tail.Fn, tail.Args
+		}
 	case *object.Builtin:
 		return fn.Fn(args...)
+	case *object.Caller:
+		return fn.Fn(func(f object.Object, args ...object.Object) object.Object {
+			return applyFunction(f, args, env)
+		}, args...)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// extendFunctionEnv returns the environment fn runs in when called with
+// args. Omitted parameters are bound to their default values, evaluated in
+// that environment after the parameters before them, and a rest parameter
+// to an array of the remaining arguments.
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
-) *object.Environment {
-	env := object.NewEnclosedEnvironment(fn.Env)
+) (*object.Environment, object.Object) {
+	if err := fn.CheckArity(len(args)); err != nil {
+		return nil, err
+	}
+	var env *object.Environment
+	if fn.Scope != nil {
+		env = object.NewFunctionEnvironment(fn.Env, fn.Scope.Names)
+	} else {
+		env = object.NewEnclosedEnvironment(fn.Env)
+	}
 	for paramIdx, param := range fn.Parameters {
-		env.Set(param.Value, args[paramIdx])
+		var arg object.Object
+		switch {
+		case fn.Variadic && paramIdx == len(fn.Parameters)-1:
+			rest := []object.Object{}
+			if paramIdx < len(args) {
+				rest = append(rest, args[paramIdx:]...)
+			}
+			arg = &object.Array{Elements: rest}
+		case paramIdx < len(args):
+			arg = args[paramIdx]
+		default:
+			arg = Eval(param.Default, env)
+			if isError(arg) {
+				return nil, arg
+			}
+		}
+		if fn.Scope != nil {
+			env.SetSlot(param.Binding.Slot, arg)
+		} else {
+			env.Set(param.Value, arg)
+		}
 	}
-	return env
+	return env, nil
 }
 
+// unwrapReturnValue returns the result of a function whose body evaluated
+// to obj. A body that produces no value, such as one ending in a let,
+// returns null.
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
 	}
+	if obj == nil {
+		return NULL
+	}
 	return obj
 }
 
 func newError(format string, a ...interface{}) *object.Error {
-	return &object.Error{Message: fmt.Sprintf(format, a...)}
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: object.RuntimeError}
+}
+
+// located sets the position of obj to that of tok if it is an error raised
+// without one.
+func located(obj object.Object, tok token.Token) object.Object {
+	if err, ok := obj.(*object.Error); ok {
+		return err.At(tok.Pos)
+	}
+	return obj
 }
 
 func isError(obj object.Object) bool {
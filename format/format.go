@@ -0,0 +1,457 @@
+// Package format prints Monkey programs in their canonical form.
+//
+// Unlike the String methods of the ast package, which exist for debugging,
+// the output of this package is valid source: parsing it yields a tree equal
+// to the one that was printed, up to token literals.
+package format
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/parser"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// The precedences are those of the parser. atom is how tightly expressions
+// that are not operators bind.
+const (
+	lowest = parser.LOWEST
+	equals = parser.EQUALS
+	prefix = parser.PREFIX
+	call   = parser.CALL
+	atom   = parser.INDEX + 1
+)
+
+// infix returns how tightly the infix operator op binds. The token types of
+// operators are their literals.
+func infix(op string) int {
+	return parser.Precedence(token.TokenType(op))
+}
+
+// Source parses src and returns it in canonical form.
+func Source(src []byte) ([]byte, error) {
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "\n"))
+	}
+	return []byte(Node(program)), nil
+}
+
+// Node returns the canonical source of node. Programs and statements are
+// terminated by a newline.
+func Node(node ast.Node) string {
+	pr := &printer{}
+	switch node := node.(type) {
+	case *ast.Program:
+		pr.statements(node.Statements)
+	case *ast.BlockStatement:
+		pr.block(node)
+	case ast.Statement:
+		pr.statements([]ast.Statement{node})
+	case ast.Expression:
+		pr.expression(node, lowest)
+	default:
+		panic(fmt.Sprintf("format: unexpected node %T", node))
+	}
+	return pr.buf.String()
+}
+
+// Hash returns the SHA-256 of the canonical source of program, so that
+// programs differing only in layout share an identity.
+func Hash(program *ast.Program) [sha256.Size]byte {
+	return sha256.Sum256([]byte(Node(program)))
+}
+
+type printer struct {
+	buf    bytes.Buffer
+	indent int
+}
+
+func (pr *printer) write(s string) {
+	pr.buf.WriteString(s)
+}
+
+func (pr *printer) newline() {
+	pr.buf.WriteByte('\n')
+	for i := 0; i < pr.indent; i++ {
+		pr.buf.WriteByte('\t')
+	}
+}
+
+func (pr *printer) statements(stmts []ast.Statement) {
+	for i, stmt := range stmts {
+		if i > 0 {
+			pr.newline()
+		}
+		pr.statement(stmt)
+		if needsSemicolon(stmt, stmts[i+1:]) {
+			pr.write(";")
+		}
+	}
+	if pr.indent == 0 && len(stmts) > 0 {
+		pr.write("\n")
+	}
+}
+
+// needsSemicolon reports whether stmt must be terminated. Statements that
+// end in a block only are when the next statement would otherwise continue
+// their expression, as in `if (x) { a }; -b`.
+func needsSemicolon(stmt ast.Statement, rest []ast.Statement) bool {
+	if _, ok := stmt.(*ast.StructStatement); ok {
+		return false
+	}
+	es, ok := stmt.(*ast.ExpressionStatement)
+	if !ok || !endsWithBlock(es.Expression) {
+		return true
+	}
+	if len(rest) == 0 {
+		return false
+	}
+	next, ok := rest[0].(*ast.ExpressionStatement)
+	return ok && continuesExpression(next.Expression)
+}
+
+func endsWithBlock(exp ast.Expression) bool {
+	switch exp.(type) {
+	case *ast.IfExpression, *ast.ForExpression, *ast.FunctionLiteral, *ast.TryExpression, *ast.MatchExpression, *ast.MacroLiteral:
+		return true
+	}
+	return false
+}
+
+// continuesExpression reports whether the source of exp starts with a token
+// that the parser would take as continuing the preceding expression.
+func continuesExpression(exp ast.Expression) bool {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return precedenceOf(exp.Left) < infix(exp.Operator) ||
+			continuesExpression(exp.Left)
+	case *ast.CallExpression:
+		return precedenceOf(exp.Function) < call || continuesExpression(exp.Function)
+	case *ast.IndexExpression:
+		return precedenceOf(exp.Left) < call || continuesExpression(exp.Left)
+	case *ast.FieldExpression:
+		return precedenceOf(exp.Left) < call || continuesExpression(exp.Left)
+	case *ast.PrefixExpression:
+		return exp.Operator == "-"
+	case *ast.ArrayLiteral:
+		return true
+	default:
+		return false
+	}
+}
+
+func (pr *printer) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		pr.let(stmt)
+	case *ast.ReturnStatement:
+		pr.write("return ")
+		pr.expression(stmt.ReturnValue, lowest)
+	case *ast.ThrowStatement:
+		pr.write("throw ")
+		pr.expression(stmt.Value, lowest)
+	case *ast.StructStatement:
+		pr.write("struct " + stmt.Name.Value + " {")
+		for i, field := range stmt.Fields {
+			if i > 0 {
+				pr.write(",")
+			}
+			pr.write(" ")
+			pr.declaration(field)
+		}
+		if len(stmt.Fields) > 0 {
+			pr.write(" ")
+		}
+		pr.write("}")
+	case *ast.ExpressionStatement:
+		pr.expression(stmt.Expression, lowest)
+	default:
+		panic(fmt.Sprintf("format: unexpected statement %T", stmt))
+	}
+}
+
+func (pr *printer) let(stmt *ast.LetStatement) {
+	pr.write("let ")
+	pr.declaration(stmt.Name)
+	pr.write(" = ")
+	pr.expression(stmt.Value, lowest)
+}
+
+// declaration prints a declared name with its annotation, if any. The
+// String methods of type annotations already give their canonical form.
+func (pr *printer) declaration(ident *ast.Identifier) {
+	pr.write(ident.Value)
+	if ident.Type != nil {
+		pr.write(": " + ident.Type.String())
+	}
+}
+
+func (pr *printer) block(block *ast.BlockStatement) {
+	if len(block.Statements) == 0 {
+		pr.write("{}")
+		return
+	}
+	pr.write("{")
+	pr.indent++
+	pr.newline()
+	pr.statements(block.Statements)
+	pr.indent--
+	pr.newline()
+	pr.write("}")
+}
+
+// expression prints exp, parenthesised if it binds less tightly than the
+// context it appears in.
+func (pr *printer) expression(exp ast.Expression, context int) {
+	if precedenceOf(exp) < context {
+		pr.write("(")
+		pr.expression(exp, lowest)
+		pr.write(")")
+		return
+	}
+
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		pr.write(exp.Value)
+	case *ast.IntegerLiteral:
+		pr.write(strconv.FormatInt(exp.Value, 10))
+	case *ast.StringLiteral:
+		pr.write(`"` + exp.Value + `"`)
+	case *ast.Boolean:
+		pr.write(strconv.FormatBool(exp.Value))
+	case *ast.NullLiteral:
+		pr.write("null")
+	case *ast.IsNullExpression:
+		pr.expression(exp.Value, equals)
+		pr.write(" is null")
+	case *ast.FoldedExpression:
+		pr.expression(exp.Value, context)
+	case *ast.PrefixExpression:
+		pr.write(exp.Operator)
+		pr.expression(exp.Right, prefix)
+	case *ast.InfixExpression:
+		prec := infix(exp.Operator)
+		pr.expression(exp.Left, prec)
+		pr.write(" " + exp.Operator + " ")
+		pr.expression(exp.Right, prec+1)
+	case *ast.CallExpression:
+		pr.expression(exp.Function, call)
+		pr.write("(")
+		pr.list(exp.Arguments)
+		pr.write(")")
+	case *ast.IndexExpression:
+		pr.expression(exp.Left, call)
+		if exp.Optional {
+			pr.write("?")
+		}
+		pr.write("[")
+		pr.expression(exp.Index, lowest)
+		pr.write("]")
+	case *ast.FieldExpression:
+		pr.expression(exp.Left, call)
+		if exp.Optional {
+			pr.write("?")
+		}
+		pr.write("." + exp.Field.Value)
+	case *ast.ArrayLiteral:
+		pr.write("[")
+		pr.list(exp.Elements)
+		pr.write("]")
+	case *ast.StructLiteral:
+		pr.write(exp.Name.Value + "{")
+		if exp.Base != nil {
+			pr.write("...")
+			pr.expression(exp.Base, lowest)
+		}
+		for i, field := range exp.Fields {
+			if i > 0 || exp.Base != nil {
+				pr.write(", ")
+			}
+			pr.write(field.Value + ": ")
+			pr.expression(exp.Values[i], lowest)
+		}
+		pr.write("}")
+	case *ast.HashLiteral:
+		pr.write("{")
+		for i, key := range exp.Keys {
+			if i > 0 {
+				pr.write(", ")
+			}
+			pr.expression(key, lowest)
+			pr.write(": ")
+			pr.expression(exp.Pairs[key], lowest)
+		}
+		pr.write("}")
+	case *ast.FunctionLiteral:
+		pr.write("fn(")
+		for i, param := range exp.Parameters {
+			if i > 0 {
+				pr.write(", ")
+			}
+			if exp.Variadic && i == len(exp.Parameters)-1 {
+				pr.write("...")
+			}
+			pr.declaration(param)
+			if param.Default != nil {
+				pr.write(" = ")
+				pr.expression(param.Default, lowest)
+			}
+		}
+		pr.write(")")
+		if exp.ReturnType != nil {
+			pr.write(": " + exp.ReturnType.String())
+		}
+		pr.write(" ")
+		pr.block(exp.Body)
+	case *ast.MacroLiteral:
+		pr.write("macro(")
+		for i, param := range exp.Parameters {
+			if i > 0 {
+				pr.write(", ")
+			}
+			pr.write(param.Value)
+		}
+		pr.write(") ")
+		pr.block(exp.Body)
+	case *ast.IfExpression:
+		pr.write("if (")
+		pr.expression(exp.Condition, lowest)
+		pr.write(") ")
+		pr.block(exp.Consequence)
+		if exp.Alternative != nil {
+			pr.write(" else ")
+			pr.block(exp.Alternative)
+		}
+	case *ast.ForExpression:
+		pr.write("for (")
+		pr.let(&exp.Variable)
+		pr.write("; ")
+		pr.expression(exp.Condition, lowest)
+		pr.write("; ")
+		pr.let(&exp.Update)
+		pr.write(") ")
+		pr.block(exp.Loop)
+	case *ast.TryExpression:
+		pr.write("try ")
+		pr.block(exp.Body)
+		if exp.Handler != nil {
+			pr.write(" catch (" + exp.Catch.Value + ") ")
+			pr.block(exp.Handler)
+		}
+		if exp.Finally != nil {
+			pr.write(" finally ")
+			pr.block(exp.Finally)
+		}
+	case *ast.MatchExpression:
+		pr.write("match (")
+		pr.expression(exp.Subject, lowest)
+		pr.write(") {")
+		if len(exp.Arms) == 0 {
+			pr.write("}")
+			return
+		}
+		pr.indent++
+		for _, arm := range exp.Arms {
+			pr.newline()
+			pr.pattern(arm.Pattern)
+			if arm.Guard != nil {
+				pr.write(" if ")
+				pr.expression(arm.Guard, lowest)
+			}
+			pr.write(" => ")
+			if _, ok := arm.Value.(*ast.HashLiteral); ok {
+				// A brace after => starts a block.
+				pr.write("(")
+				pr.expression(arm.Value, lowest)
+				pr.write(")")
+			} else if arm.Body != nil {
+				pr.block(arm.Body)
+			} else {
+				pr.expression(arm.Value, lowest)
+			}
+			pr.write(",")
+		}
+		pr.indent--
+		pr.newline()
+		pr.write("}")
+	default:
+		panic(fmt.Sprintf("format: unexpected expression %T", exp))
+	}
+}
+
+func (pr *printer) pattern(p ast.Pattern) {
+	switch p := p.(type) {
+	case *ast.LiteralPattern:
+		pr.expression(p.Value, lowest)
+	case *ast.WildcardPattern:
+		pr.write("_")
+	case *ast.BindingPattern:
+		pr.write(p.Name.Value)
+	case *ast.ArrayPattern:
+		pr.write("[")
+		for i, el := range p.Elements {
+			if i > 0 {
+				pr.write(", ")
+			}
+			pr.pattern(el)
+		}
+		if p.Rest != nil {
+			if len(p.Elements) > 0 {
+				pr.write(", ")
+			}
+			pr.write("..." + p.Rest.Value)
+		}
+		pr.write("]")
+	case *ast.HashPattern:
+		pr.write("{")
+		for i, key := range p.Keys {
+			if i > 0 {
+				pr.write(", ")
+			}
+			pr.expression(key, lowest)
+			pr.write(": ")
+			pr.pattern(p.Values[i])
+		}
+		pr.write("}")
+	}
+}
+
+func (pr *printer) list(exps []ast.Expression) {
+	for i, exp := range exps {
+		if i > 0 {
+			pr.write(", ")
+		}
+		pr.expression(exp, lowest)
+	}
+}
+
+// precedenceOf returns how tightly exp binds. Everything that is not an
+// operator expression is atomic.
+func precedenceOf(exp ast.Expression) int {
+	switch exp := exp.(type) {
+	case *ast.InfixExpression:
+		return infix(exp.Operator)
+	case *ast.PrefixExpression:
+		return prefix
+	case *ast.IsNullExpression:
+		return equals
+	case *ast.IntegerLiteral:
+		if exp.Value < 0 {
+			return prefix
+		}
+		return atom
+	case *ast.FoldedExpression:
+		return precedenceOf(exp.Value)
+	default:
+		return atom
+	}
+}
@@ -0,0 +1,186 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/parser"
+)
+
+func TestSource(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x=5", "let x = 5;\n"},
+		{"a+b*c", "a + b * c;\n"},
+		{"(a+b)*c", "(a + b) * c;\n"},
+		{"a-(b-c)", "a - (b - c);\n"},
+		{"(a-b)-c", "a - b - c;\n"},
+		{"-(a+b)", "-(a + b);\n"},
+		{"!-a", "!-a;\n"},
+		{"0007", "7;\n"},
+		{"add(1,2*3)[0]", "add(1, 2 * 3)[0];\n"},
+		{`{"a":1,2:[true,"b"]}`, `{"a": 1, 2: [true, "b"]};` + "\n"},
+		{"fn(x,y){x+y}", "fn(x, y) {\n\tx + y;\n}\n"},
+		{"fn(){}", "fn() {}\n"},
+		{"match(x){0=>1,[a,...r] if a>0=>{r},{\"k\":v}=>({\"v\":v}),_=>2};-1", "match (x) {\n\t0 => 1,\n\t[a, ...r] if a > 0 => {\n\t\tr;\n\t},\n\t{\"k\": v} => ({\"v\": v}),\n\t_ => 2,\n};\n-1;\n"},
+		{"let y = match (x) {}", "let y = match (x) {};\n"},
+		{"let m = macro(a,b){quote(unquote(a)+unquote(b))}", "let m = macro(a, b) {\n\tquote(unquote(a) + unquote(b));\n};\n"},
+		{"struct P{x,y:int};struct E{}\nP{...p,x:1}.y", "struct P { x, y: int }\nstruct E {}\nP{...p, x: 1}.y;\n"},
+		{"(-1).x; (a + b).c; f(x).y", "(-1).x;\n(a + b).c;\nf(x).y;\n"},
+		{"fn(a,b=1+2,...c:int){c}", "fn(a, b = 1 + 2, ...c: int) {\n\tc;\n}\n"},
+		{"let x:int=1", "let x: int = 1;\n"},
+		{
+			"fn(a:[int],f:fn(int,...string):bool):{string:int}{a}",
+			"fn(a: [int], f: fn(int, ...string): bool): {string: int} {\n\ta;\n}\n",
+		},
+		{
+			"if(a<b){return a}else{let c=b;c}",
+			"if (a < b) {\n\treturn a;\n} else {\n\tlet c = b;\n\tc;\n}\n",
+		},
+		{
+			"for(let i=0;i<10;let i=i+1){print(i)}",
+			"for (let i = 0; i < 10; let i = i + 1) {\n\tprint(i);\n}\n",
+		},
+		{
+			"let f = fn(n) { if (n < 2) { n } else { f(n - 1) } }; f(3)",
+			"let f = fn(n) {\n\tif (n < 2) {\n\t\tn;\n\t} else {\n\t\tf(n - 1);\n\t}\n};\nf(3);\n",
+		},
+		{"if (a) { 1 }; -2", "if (a) {\n\t1;\n};\n-2;\n"},
+		{"if (a) { 1 }; (b)", "if (a) {\n\t1;\n}\nb;\n"},
+		{"if (a) { 1 } b", "if (a) {\n\t1;\n}\nb;\n"},
+		{"a??b??c", "a ?? b ?? c;\n"},
+		{"(a??b)+c", "(a ?? b) + c;\n"},
+		{"h?[k]is null==(x is null)", "h?[k] is null == (x is null);\n"},
+		{"p?.x?.y??p.z", "p?.x?.y ?? p.z;\n"},
+		{"let x=null", "let x = null;\n"},
+		{
+			"try{f()}catch(e){throw e}finally{g()}",
+			"try {\n\tf();\n} catch (e) {\n\tthrow e;\n} finally {\n\tg();\n}\n",
+		},
+	}
+
+	for _, tt := range tests {
+		out, err := Source([]byte(tt.input))
+		if err != nil {
+			t.Errorf("Source(%q) returned error: %s", tt.input, err)
+			continue
+		}
+		if string(out) != tt.expected {
+			t.Errorf("Source(%q) wrong.\nexpected=%q\ngot=     %q", tt.input, tt.expected, out)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	inputs := []string{
+		"a + b * c - d / e; -a * b; !(a == b) != c",
+		"(a + b)(c)[d]; (-a)(b); -a(b); a[b][c](d)",
+		"fn(x) { x }(5); if (x) { f } else { g }(1)",
+		"if (x) { 1 }; [1, 2][0]; if (y) { 2 }; -3",
+		"let h = {\"k\": fn(a) { a }, 1: [if (a) { b }]}; h[\"k\"](2)",
+		`
+let map = fn(arr, f) {
+  let out = [];
+  for (let i = 0; i < len(arr); let i = i + 1) {
+    let out = out + [f(arr[i])];
+  }
+  return out;
+};
+map([1, 2, 3], fn(x) { x * 2 });
+`,
+	}
+
+	for _, input := range inputs {
+		original := parse(t, input)
+		formatted := Node(original)
+		reparsed := parse(t, formatted)
+
+		if !equal(reflect.ValueOf(original), reflect.ValueOf(reparsed)) {
+			t.Errorf("parse(format(src)) differs from parse(src) for %q:\n%s", input, formatted)
+		}
+		if again := Node(reparsed); again != formatted {
+			t.Errorf("format is not idempotent.\nfirst= %q\nsecond=%q", formatted, again)
+		}
+	}
+}
+
+func TestHash(t *testing.T) {
+	a := parse(t, "let x = 1 + 2;\nx")
+	b := parse(t, "let   x=1+2 ; x;")
+	c := parse(t, "let x = 1 + 3; x")
+
+	if Hash(a) != Hash(b) {
+		t.Errorf("programs differing in layout have different hashes")
+	}
+	if Hash(a) == Hash(c) {
+		t.Errorf("different programs have the same hash")
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
+
+var hashLiteralType = reflect.TypeOf(ast.HashLiteral{})
+
+// equal compares two trees, ignoring tokens.
+func equal(a, b reflect.Value) bool {
+	if a.Kind() != b.Kind() {
+		return false
+	}
+	switch a.Kind() {
+	case reflect.Interface, reflect.Pointer:
+		if a.IsNil() || b.IsNil() {
+			return a.IsNil() == b.IsNil()
+		}
+		return equal(a.Elem(), b.Elem())
+	case reflect.Slice:
+		if a.Len() != b.Len() {
+			return false
+		}
+		for i := 0; i < a.Len(); i++ {
+			if !equal(a.Index(i), b.Index(i)) {
+				return false
+			}
+		}
+		return true
+	case reflect.Struct:
+		if a.Type() != b.Type() {
+			return false
+		}
+		if a.Type() == hashLiteralType {
+			ha, hb := a.Addr().Interface().(*ast.HashLiteral), b.Addr().Interface().(*ast.HashLiteral)
+			if len(ha.Keys) != len(hb.Keys) {
+				return false
+			}
+			for i := range ha.Keys {
+				if !equal(reflect.ValueOf(ha.Keys[i]), reflect.ValueOf(hb.Keys[i])) ||
+					!equal(reflect.ValueOf(ha.Pairs[ha.Keys[i]]), reflect.ValueOf(hb.Pairs[hb.Keys[i]])) {
+					return false
+				}
+			}
+			return true
+		}
+		for i := 0; i < a.NumField(); i++ {
+			if a.Type().Field(i).Name == "Token" {
+				continue
+			}
+			if !equal(a.Field(i), b.Field(i)) {
+				return false
+			}
+		}
+		return true
+	default:
+		return a.Interface() == b.Interface()
+	}
+}
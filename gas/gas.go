@@ -0,0 +1,154 @@
+package gas
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Opcodes charged by the metered evaluators. The numeric values are the ones
+// sent over the evaluator op channel and must not be renumbered.
+const (
+	OpNone = -1
+
+	OpAdd = iota - 1
+	OpSub
+	OpMul
+	OpDiv
+	OpLt
+	OpGt
+	OpEq
+	OpNotEq
+	OpIsPrime
+	OpSin
+	OpTan
+	OpRand
+	OpPow
+	OpSqrt
+	OpLen
+	OpFib
+	OpConcat
+	// OpCall is charged each time a script function is entered, tail
+	// calls included, so that recursion without other operations still
+	// runs out of gas.
+	OpCall
+
+	// OpCustom is the first opcode free for builtins registered by
+	// embedding code.
+	OpCustom
+)
+
+var opNames = map[int]string{
+	OpAdd:     "add",
+	OpSub:     "sub",
+	OpMul:     "mul",
+	OpDiv:     "div",
+	OpLt:      "lt",
+	OpGt:      "gt",
+	OpEq:      "eq",
+	OpNotEq:   "noteq",
+	OpIsPrime: "isprime",
+	OpSin:     "sin",
+	OpTan:     "tan",
+	OpRand:    "rand",
+	OpPow:     "pow",
+	OpSqrt:    "sqrt",
+	OpLen:     "len",
+	OpFib:     "fib",
+	OpConcat:  "concat",
+	OpCall:    "call",
+}
+
+func Name(op int) string {
+	if name, ok := opNames[op]; ok {
+		return name
+	}
+	return fmt.Sprintf("op%d", op)
+}
+
+// Schedule prices opcodes. Opcodes without an explicit weight cost Default.
+type Schedule struct {
+	Version string
+	Default uint64
+	Weights map[int]uint64
+}
+
+// DefaultSchedule charges one unit per operation, which matches the count
+// kept by evaluator_middle.
+var DefaultSchedule = &Schedule{Version: "1", Default: 1}
+
+func (s *Schedule) Cost(op int) uint64 {
+	if op == OpNone {
+		return 0
+	}
+	if w, ok := s.Weights[op]; ok {
+		return w
+	}
+	return s.Default
+}
+
+var (
+	ErrOutOfGas = errors.New("out of gas")
+	ErrCanceled = errors.New("evaluation canceled")
+)
+
+// Meter accumulates the cost of a single evaluation and fails once the
+// limit is exceeded, or Done is closed. A Limit of 0 means unlimited.
+type Meter struct {
+	Limit    uint64
+	Schedule *Schedule
+	// Observer, when set, is called for every charged opcode.
+	Observer func(op int)
+	// Done, when set, cancels the evaluation once closed, as the channel
+	// of a context.Context does.
+	Done <-chan struct{}
+
+	used uint64
+}
+
+func NewMeter(limit uint64) *Meter {
+	return &Meter{Limit: limit, Schedule: DefaultSchedule}
+}
+
+func (m *Meter) Charge(op int) error {
+	if m == nil || op == OpNone {
+		return m.Canceled()
+	}
+	schedule := m.Schedule
+	if schedule == nil {
+		schedule = DefaultSchedule
+	}
+	m.used += schedule.Cost(op)
+	if m.Observer != nil {
+		m.Observer(op)
+	}
+	if m.Limit != 0 && m.used > m.Limit {
+		return ErrOutOfGas
+	}
+	return m.Canceled()
+}
+
+// Canceled returns ErrCanceled once Done is closed. The evaluators check it
+// on every function call and loop iteration, which may charge nothing.
+func (m *Meter) Canceled() error {
+	if m == nil || m.Done == nil {
+		return nil
+	}
+	select {
+	case <-m.Done:
+		return ErrCanceled
+	default:
+		return nil
+	}
+}
+
+// Reset clears the gas used so the meter can be reused for another run.
+func (m *Meter) Reset() {
+	m.used = 0
+}
+
+func (m *Meter) Used() uint64 {
+	if m == nil {
+		return 0
+	}
+	return m.used
+}
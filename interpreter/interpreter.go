@@ -0,0 +1,429 @@
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/cost"
+	"github.com/SebastiaanWouters/verigo/evaluator"
+	"github.com/SebastiaanWouters/verigo/evaluator_middle"
+	"github.com/SebastiaanWouters/verigo/evaluator_simple"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/macro"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/optimize"
+	"github.com/SebastiaanWouters/verigo/parser"
+	"github.com/SebastiaanWouters/verigo/resolver"
+	"github.com/SebastiaanWouters/verigo/token"
+	"github.com/SebastiaanWouters/verigo/types"
+)
+
+// builtinTypes holds the parsed evaluator.Signatures.
+var builtinTypes = func() map[string]types.Type {
+	m := make(map[string]types.Type, len(evaluator.Signatures))
+	for name, sig := range evaluator.Signatures {
+		m[name] = types.MustParse(sig)
+	}
+	return m
+}()
+
+// Mode selects which evaluator runs the program.
+type Mode int
+
+const (
+	// Full uses evaluator, which reports every opcode.
+	Full Mode = iota
+	// Middle uses evaluator_middle, which counts operations.
+	Middle
+	// Simple uses evaluator_simple, which does not meter at all.
+	Simple
+)
+
+// Variadic is the arity of builtins that accept any number of arguments.
+const Variadic = -1
+
+type Option func(*Interpreter)
+
+func WithMode(mode Mode) Option {
+	return func(in *Interpreter) { in.mode = mode }
+}
+
+// WithGas aborts a run once it has been charged more than limit. A limit of
+// 0 means unlimited.
+func WithGas(limit uint64) Option {
+	return func(in *Interpreter) { in.meter.Limit = limit }
+}
+
+func WithSchedule(schedule *gas.Schedule) Option {
+	return func(in *Interpreter) { in.meter.Schedule = schedule }
+}
+
+// WithResultSink receives every value passed to save().
+func WithResultSink(sink func(object.Result)) Option {
+	return func(in *Interpreter) { in.resultSink = sink }
+}
+
+// WithOpSink receives every opcode charged while running.
+func WithOpSink(sink func(op int)) Option {
+	return func(in *Interpreter) { in.meter.Observer = sink }
+}
+
+// WithOptimizer optimizes programs with package optimize before running
+// them.
+func WithOptimizer(mode optimize.Mode) Option {
+	return func(in *Interpreter) { in.optimize = &mode }
+}
+
+// WithStdout redirects the output of print().
+func WithStdout(w io.Writer) Option {
+	return func(in *Interpreter) { in.stdout = w }
+}
+
+// WithHook makes hook observe every run, as described by object.Hook.
+func WithHook(hook object.Hook) Option {
+	return func(in *Interpreter) { in.hook = hook }
+}
+
+// Interpreter runs Monkey programs in a persistent environment. Bindings made
+// by one run are visible to the next.
+type Interpreter struct {
+	mode       Mode
+	meter      *gas.Meter
+	env        *object.Environment
+	stdout     io.Writer
+	resultSink func(object.Result)
+	opCount    int
+	// opcodes holds the opcodes charged by host builtins.
+	opcodes  map[string]int
+	optimize *optimize.Mode
+	// macros holds the macros defined by earlier runs.
+	macros *object.Environment
+	hook   object.Hook
+}
+
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{
+		mode:   Full,
+		meter:  gas.NewMeter(0),
+		stdout: os.Stdout,
+		macros: object.NewEnvironment(),
+		opcodes: map[string]int{
+			"print": gas.OpNone,
+			"save":  gas.OpNone,
+		},
+	}
+	for _, opt := range opts {
+		opt(in)
+	}
+	in.env = object.NewMeteredEnvironment(in.meter)
+	in.env.SetHook(in.hook)
+	in.env.Set("print", &object.Builtin{Fn: in.print})
+	in.env.Set("save", &object.Builtin{Fn: in.save})
+	return in
+}
+
+// RegisterBuiltin exposes fn to scripts as name. Calls are checked against
+// arity, unless it is Variadic, and charged as costOpcode, unless it is
+// gas.OpNone or the interpreter runs in Simple mode. A nil result of fn is
+// null to scripts.
+func (in *Interpreter) RegisterBuiltin(name string, arity int, costOpcode int, fn object.BuiltinFunction) error {
+	if evaluator.IsBuiltin(name) {
+		return fmt.Errorf("cannot redefine builtin %q", name)
+	}
+	in.opcodes[name] = costOpcode
+	in.env.Set(name, &object.Builtin{
+		Name: name,
+		Fn: func(args ...object.Object) object.Object {
+			if arity != Variadic && len(args) != arity {
+				return newError("wrong number of arguments. got=%d, want=%d",
+					len(args), arity)
+			}
+			if in.mode != Simple {
+				if err := in.meter.Charge(costOpcode); err != nil {
+					return object.MeterError(err)
+				}
+			}
+			if result := fn(args...); result != nil {
+				return result
+			}
+			return in.null()
+		},
+	})
+	return nil
+}
+
+// RegisterFunc exposes a Go function to scripts as name, converting its
+// arguments and results as described by object.WrapFunc.
+func (in *Interpreter) RegisterFunc(name string, costOpcode int, fn interface{}) error {
+	builtin, err := object.WrapFunc(name, fn)
+	if err != nil {
+		return err
+	}
+	return in.RegisterBuiltin(name, Variadic, costOpcode, builtin.Fn)
+}
+
+// Set binds name to the Go value v, converted with object.FromGo.
+func (in *Interpreter) Set(name string, v interface{}) error {
+	obj, err := object.FromGo(v)
+	if err != nil {
+		return err
+	}
+	in.env.Set(name, obj)
+	return nil
+}
+
+// Env returns the environment shared by every run.
+func (in *Interpreter) Env() *object.Environment {
+	return in.env
+}
+
+// GasUsed returns the gas charged by the last run.
+func (in *Interpreter) GasUsed() uint64 {
+	return in.meter.Used()
+}
+
+// OpCount returns the operations counted by the last run in Middle mode.
+func (in *Interpreter) OpCount() int {
+	return in.opCount
+}
+
+// Run parses and runs input, and returns the value of its last statement,
+// which is null if it has none.
+func (in *Interpreter) Run(input string) (object.Object, error) {
+	return in.RunContext(context.Background(), input)
+}
+
+// RunContext is like Run, but stops the program with a RuntimeError of kind
+// object.CanceledError once ctx is done.
+func (in *Interpreter) RunContext(ctx context.Context, input string) (object.Object, error) {
+	program, err := Parse(input)
+	if err != nil {
+		return nil, err
+	}
+	return in.RunProgramContext(ctx, program)
+}
+
+// RunProgram expands the macros of, checks, optimizes if enabled, and runs
+// program, returning the value of its last statement, or null. It returns a
+// *ParseError, without running anything, if Expand or Check reports errors.
+func (in *Interpreter) RunProgram(program *ast.Program) (object.Object, error) {
+	return in.RunProgramContext(context.Background(), program)
+}
+
+// RunProgramContext is like RunProgram, but stops the program once ctx is
+// done.
+func (in *Interpreter) RunProgramContext(ctx context.Context, program *ast.Program) (object.Object, error) {
+	if err := diagnosticsError(in.Expand(program)); err != nil {
+		return nil, err
+	}
+	if err := diagnosticsError(in.Check(program)); err != nil {
+		return nil, err
+	}
+	if in.optimize != nil {
+		optimize.Program(program, *in.optimize)
+	}
+
+	in.meter.Reset()
+	in.meter.Done = ctx.Done()
+	defer func() { in.meter.Done = nil }()
+	in.opCount = 0
+
+	if in.mode != Full && in.mode != Middle && in.mode != Simple {
+		return nil, fmt.Errorf("unknown mode %d", in.mode)
+	}
+	result := in.eval(program)
+	if errObj, ok := result.(*object.Error); ok {
+		kind := errObj.Kind
+		if kind == "" {
+			kind = object.RuntimeError
+		}
+		return nil, &RuntimeError{Message: errObj.Message, Kind: kind, Pos: errObj.Pos}
+	}
+	if result == nil {
+		return in.null(), nil
+	}
+	return result, nil
+}
+
+// eval runs program with the evaluator of the mode. A panic in the host
+// code it calls, such as a builtin, a hook or a sink, becomes the error of
+// the run rather than crashing the host.
+func (in *Interpreter) eval(program *ast.Program) (result object.Object) {
+	defer func() {
+		if r := recover(); r != nil {
+			result = newError("panic in host code: %v", r)
+		}
+	}()
+	switch in.mode {
+	case Middle:
+		return evaluator_middle.Eval(program, in.env, &in.opCount)
+	case Simple:
+		return evaluator_simple.Eval(program, in.env)
+	default:
+		return evaluator.Eval(program, in.env, nil, nil)
+	}
+}
+
+// Expand defines the macros of program and expands the calls to them, as
+// described in package macro. Macros stay defined for later runs.
+func (in *Interpreter) Expand(program *ast.Program) []parser.Diagnostic {
+	macro.Define(program, in.macros)
+	return macro.Expand(program, in.macros)
+}
+
+// Resolve binds the identifiers of program, as described in package
+// resolver, taking the names already defined in the interpreter's
+// environment into account.
+func (in *Interpreter) Resolve(program *ast.Program) []parser.Diagnostic {
+	return resolver.Resolve(program, in.defined)
+}
+
+// Check resolves program and infers its types, returning the diagnostics of
+// both in source order. Names defined by the host have type any, builtins
+// the types declared by evaluator.Signatures.
+func (in *Interpreter) Check(program *ast.Program) []parser.Diagnostic {
+	diagnostics := append(in.Resolve(program), types.Check(program, builtinTypes)...)
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Pos.Offset < diagnostics[j].Pos.Offset
+	})
+	return diagnostics
+}
+
+// Estimate returns the gas that running program would be charged, as
+// estimated by package cost under the interpreter's schedule. Symbols for
+// integers and arrays already bound in the environment are replaced by
+// their values.
+func (in *Interpreter) Estimate(program *ast.Program) cost.Estimate {
+	est := cost.Analyze(program, in.meter.Schedule, in.opcodes)
+	if est.Unbounded {
+		return est
+	}
+	values := map[string]cost.Poly{}
+	for _, sym := range est.Gas.Symbols() {
+		name := strings.TrimSuffix(strings.TrimPrefix(sym, "len("), ")")
+		obj, ok := in.env.Get(name)
+		if !ok {
+			continue
+		}
+		switch obj := obj.(type) {
+		case *object.Integer:
+			if name == sym {
+				values[sym] = cost.Const(obj.Value)
+			}
+		case *object.Array:
+			if name != sym {
+				values[sym] = cost.Const(int64(len(obj.Elements)))
+			}
+		case *object.String:
+			if name != sym {
+				values[sym] = cost.Const(int64(len(obj.Value)))
+			}
+		}
+	}
+	if len(values) == 0 {
+		return est
+	}
+	bound, err := est.Gas.Subst(values)
+	if err != nil {
+		return est
+	}
+	est.Gas = bound
+	return est
+}
+
+func (in *Interpreter) defined(name string) bool {
+	_, ok := in.env.Get(name)
+	return ok || evaluator.IsBuiltin(name)
+}
+
+// Parse parses input, returning a *ParseError if it is not a valid program.
+func Parse(input string) (*ast.Program, error) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, &ParseError{Errors: p.Errors(), Diagnostics: p.Diagnostics()}
+	}
+	return program, nil
+}
+
+func (in *Interpreter) print(args ...object.Object) object.Object {
+	for _, arg := range args {
+		fmt.Fprintln(in.stdout, arg.Inspect())
+	}
+	return in.null()
+}
+
+func (in *Interpreter) save(args ...object.Object) object.Object {
+	if len(args) != 2 {
+		return newError("wrong number of arguments. got=%d, want=2",
+			len(args))
+	}
+	if args[0].Type() != object.STRING_OBJ {
+		return newError("arguments to `save` not supported, got %s",
+			args[0].Type())
+	}
+	if err := object.CheckSave(args[1]); err != nil {
+		return newError("%s", err)
+	}
+	if in.resultSink != nil {
+		in.resultSink(object.Result{Key: args[0].Inspect(), Value: args[1]})
+	}
+	return in.null()
+}
+
+// null returns the NULL of the selected evaluator.
+func (in *Interpreter) null() object.Object {
+	switch in.mode {
+	case Middle:
+		return evaluator_middle.NULL
+	case Simple:
+		return evaluator_simple.NULL
+	default:
+		return evaluator.NULL
+	}
+}
+
+// diagnosticsError returns a *ParseError if diagnostics include errors.
+func diagnosticsError(diagnostics []parser.Diagnostic) error {
+	var errs []string
+	for _, d := range diagnostics {
+		if d.Severity == parser.Error {
+			errs = append(errs, d.Message)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return &ParseError{Errors: errs, Diagnostics: diagnostics}
+}
+
+// ParseError reports a program that does not parse or resolve.
+type ParseError struct {
+	Errors      []string
+	Diagnostics []parser.Diagnostic
+}
+
+func (e *ParseError) Error() string {
+	return "parse errors:\n\t" + strings.Join(e.Errors, "\n\t")
+}
+
+// RuntimeError reports an error a program did not catch.
+type RuntimeError struct {
+	Message string
+	Kind    string         // one of the object Error kinds
+	Pos     token.Position // zero if unknown
+}
+
+func (e *RuntimeError) Error() string {
+	return e.Message
+}
+
+func newError(format string, a ...interface{}) *object.Error {
+	return &object.Error{Message: fmt.Sprintf(format, a...), Kind: object.RuntimeError}
+}
@@ -0,0 +1,706 @@
+package interpreter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/optimize"
+)
+
+func TestRunInEveryMode(t *testing.T) {
+	input := `
+let add = fn(x, y) { x + y; };
+add(2, 3) * 2;`
+
+	for _, mode := range []Mode{Full, Middle, Simple} {
+		in := New(WithMode(mode))
+		result, err := in.Run(input)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		testInteger(t, result, 10)
+	}
+}
+
+func TestEnvironmentPersistsAcrossRuns(t *testing.T) {
+	in := New()
+	if _, err := in.Run("let x = 5;"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	result, err := in.Run("x * 2")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testInteger(t, result, 10)
+}
+
+func TestRegisterBuiltin(t *testing.T) {
+	var ops []int
+	in := New(WithOpSink(func(op int) { ops = append(ops, op) }))
+	err := in.RegisterBuiltin("double", 1, gas.OpCustom, func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	result, err := in.Run("double(21)")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testInteger(t, result, 42)
+	if len(ops) != 1 || ops[0] != gas.OpCustom {
+		t.Errorf("wrong ops charged. got=%v", ops)
+	}
+
+	_, err = in.Run("double(1, 2)")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected RuntimeError. got=%v", err)
+	}
+	if runtimeErr.Message != "wrong number of arguments. got=2, want=1" {
+		t.Errorf("wrong error message. got=%q", runtimeErr.Message)
+	}
+
+	// Running out of gas in a builtin cannot be caught.
+	for _, mode := range []Mode{Full, Middle} {
+		in := New(WithMode(mode), WithGas(3))
+		if err := in.RegisterBuiltin("burn", 0, gas.OpCustom, func(args ...object.Object) object.Object {
+			return &object.Integer{Value: 1}
+		}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := in.Run(`let f = fn() { burn() + burn() + burn() }; try { f() } catch (e) { e["kind"] }`)
+		if !errors.As(err, &runtimeErr) || runtimeErr.Kind != object.OutOfGasError {
+			t.Errorf("mode %d: expected an uncaught out of gas error. got=%v", mode, err)
+		}
+	}
+
+	// A nil result is null, and a panic is an error of the run.
+	for _, mode := range []Mode{Full, Middle, Simple} {
+		in := New(WithMode(mode), WithStdout(io.Discard))
+		if err := in.RegisterBuiltin("nothing", 0, gas.OpNone, func(args ...object.Object) object.Object {
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if err := in.RegisterBuiltin("boom", 0, gas.OpNone, func(args ...object.Object) object.Object {
+			panic("boom")
+		}); err != nil {
+			t.Fatal(err)
+		}
+		result, err := in.Run(`print(nothing()); nothing() is null`)
+		if err != nil || result.Inspect() != "true" {
+			t.Errorf("mode %d: wrong result of a nil builtin. got=%v, %v", mode, result, err)
+		}
+		if _, err := in.Run(`nothing() + 1`); err == nil || err.Error() != "unknown operator: NULL + INTEGER" {
+			t.Errorf("mode %d: expected an operator error. got=%v", mode, err)
+		}
+		if _, err := in.Run(`boom()`); !errors.As(err, &runtimeErr) || runtimeErr.Message != "panic in host code: boom" {
+			t.Errorf("mode %d: expected the panic as an error. got=%v", mode, err)
+		}
+	}
+
+	if err := in.RegisterBuiltin("len", 1, gas.OpNone, nil); err == nil {
+		t.Errorf("expected error when redefining len")
+	}
+}
+
+func TestRegisterFuncAndSet(t *testing.T) {
+	type account struct {
+		Owner   string `monkey:"owner"`
+		Balance int64  `monkey:"balance"`
+	}
+
+	in := New()
+	in.RegisterFunc("total", gas.OpNone, func(accounts []account) int64 {
+		var sum int64
+		for _, a := range accounts {
+			sum += a.Balance
+		}
+		return sum
+	})
+	in.Set("accounts", []account{{"ann", 10}, {"bob", 32}})
+
+	result, err := in.Run(`total(accounts) + len(accounts[1]["owner"])`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testInteger(t, result, 45)
+
+	result, err = in.Run(`accounts[0]`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var a account
+	if err := object.Decode(result, &a); err != nil || a.Owner != "ann" {
+		t.Errorf("wrong account decoded. got=%+v, err=%v", a, err)
+	}
+}
+
+func TestRegisteredBooleansAreTruthyByValue(t *testing.T) {
+	in := New()
+	in.RegisterBuiltin("no", 0, gas.OpNone, func(args ...object.Object) object.Object {
+		return &object.Boolean{Value: false}
+	})
+
+	result, err := in.Run("if (no()) { 1 } else { 2 }")
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	testInteger(t, result, 2)
+}
+
+func TestGasLimit(t *testing.T) {
+	input := `
+let sum = 0;
+for (let i = 0; i < 100; let i = i + 1) {
+  let sum = sum + i;
+}
+sum;`
+
+	for _, mode := range []Mode{Full, Middle} {
+		in := New(WithMode(mode), WithGas(50))
+		_, err := in.Run(input)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) || runtimeErr.Message != "out of gas" {
+			t.Errorf("mode %d: expected out of gas. got=%v", mode, err)
+		}
+		if in.GasUsed() != 51 {
+			t.Errorf("mode %d: wrong gas used. got=%d", mode, in.GasUsed())
+		}
+
+		in = New(WithMode(mode), WithGas(1000))
+		result, err := in.Run(input)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		testInteger(t, result, 4950)
+	}
+
+	// Simple mode does not meter, not even registered builtins.
+	in := New(WithMode(Simple), WithGas(50))
+	if err := in.RegisterBuiltin("burn", 0, gas.OpCustom, func(args ...object.Object) object.Object {
+		return &object.Integer{Value: 0}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := in.Run(input + "burn();"); err != nil {
+		t.Fatalf("simple mode: unexpected error: %s", err)
+	}
+	if in.GasUsed() != 0 {
+		t.Errorf("simple mode: wrong gas used. got=%d", in.GasUsed())
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	input := `
+let sum = fn(n, acc) {
+  if (n == 0) { return acc; }
+  sum(n - 1, acc + n)
+};
+let even = fn(n) { if (n == 0) { true } else { odd(n - 1) } };
+let odd = fn(n) { if (n == 0) { false } else { even(n - 1) } };
+if (even(100001)) { 0 } else { sum(1000000, 0) }`
+
+	for _, mode := range []Mode{Full, Middle, Simple} {
+		in := New(WithMode(mode))
+		result, err := in.Run(input)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		testInteger(t, result, 500000500000)
+		if mode != Simple && in.GasUsed() != 4300007 {
+			t.Errorf("mode %d: wrong gas used. got=%d", mode, in.GasUsed())
+		}
+	}
+
+	for _, mode := range []Mode{Full, Middle} {
+		for _, input := range []string{
+			`let loop = fn(n) { loop(n + 1) }; loop(0)`,
+			// Calls are charged even when nothing else is.
+			`let f = fn() { f() }; f()`,
+		} {
+			in := New(WithMode(mode), WithGas(100))
+			_, err := in.Run(input)
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) || runtimeErr.Message != "out of gas" {
+				t.Errorf("mode %d: %q expected out of gas. got=%v", mode, input, err)
+			}
+		}
+	}
+}
+
+func TestTryCatch(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{`try { len(1) } catch (e) { e["message"] }`, "argument to `len` not supported, got INTEGER"},
+		{`try { throw "bad"; 1 } catch (e) { e["kind"] + ": " + e["message"] }`, "thrown: bad"},
+		{`try { 1 / 0 } catch (e) { e["kind"] }`, "runtime"},
+		{"let f = fn() {\n  throw \"x\";\n};\ntry { f() } catch (e) { [e[\"line\"], e[\"column\"]] }", "[2, 3]"},
+		{`let f = fn(x) { x + 1 }; try { f(true) } catch (e) { e["column"] }`, int64(19)},
+		{`let f = fn() { try { return 1; } finally { 2 } }; f()`, int64(1)},
+		{`let f = fn() { try { 1 } finally { return 2; } }; f()`, int64(2)},
+		{`let n = 0; try { try { throw "a"; } finally { let n = 1; } } catch (e) { n }`, int64(1)},
+		{`try { try { throw "a"; } catch (e) { throw e; } } catch (e) { e["message"] }`, "a"},
+		{`try { throw {"message": "m", "kind": "mine"}; } catch (e) { e["kind"] }`, "mine"},
+		{`try { throw {"kind": "out of gas"}; } catch (e) { e["message"] }`, "cannot throw an error of kind out of gas"},
+		{`let sum = 0; for (let i = 0; i < 4; let i = i + 1) { let sum = try { sum + 10 / (i - 2) } catch (e) { sum + 100 } }; sum`, int64(95)},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []Mode{Full, Middle, Simple} {
+			in := New(WithMode(mode))
+			result, err := in.Run(tt.input)
+			if err != nil {
+				t.Errorf("%q (mode %d): unexpected error: %s", tt.input, mode, err)
+				continue
+			}
+			switch expected := tt.expected.(type) {
+			case int64:
+				testInteger(t, result, expected)
+			case string:
+				if result.Inspect() != expected {
+					t.Errorf("%q (mode %d): expected=%q, got=%q", tt.input, mode, expected, result.Inspect())
+				}
+			}
+		}
+	}
+}
+
+func TestUncaughtErrors(t *testing.T) {
+	_, err := New().Run("let x = 1;\nthrow \"oops\";")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) {
+		t.Fatalf("expected a runtime error. got=%v", err)
+	}
+	if runtimeErr.Message != "oops" || runtimeErr.Kind != object.ThrownError || runtimeErr.Pos.String() != "2:1" {
+		t.Errorf("wrong error. got=%+v", runtimeErr)
+	}
+
+	for _, mode := range []Mode{Full, Middle} {
+		in := New(WithMode(mode), WithGas(20))
+		_, err := in.Run(`let loop = fn(n) { loop(n + 1) }; try { loop(0) } catch (e) { 1 } finally { 2 }`)
+		if !errors.As(err, &runtimeErr) || runtimeErr.Kind != object.OutOfGasError {
+			t.Errorf("mode %d: expected out of gas. got=%v", mode, err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, mode := range []Mode{Full, Middle, Simple} {
+		in := New(WithMode(mode))
+		_, err := in.RunContext(ctx, `let loop = fn() { loop() }; try { loop() } catch (e) { 1 }`)
+		if !errors.As(err, &runtimeErr) || runtimeErr.Kind != object.CanceledError {
+			t.Errorf("mode %d: expected cancellation. got=%v", mode, err)
+		}
+	}
+}
+
+func TestNull(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`null`, "null"},
+		{`[null == null, null != null, null == false, null != 0, missing == null, if (false) { 1 } == missing]`,
+			"[true, false, false, true, true, true]"},
+		{`[missing is null, 0 is null, "" is null, null is null]`, "[true, false, false, true]"},
+		{`[missing ?? 5, 0 ?? 5, false ?? 5, null ?? null ?? "x"]`, "[5, 0, false, x]"},
+		{`let h = {"a": 1}; [h?["a"], h?["b"], missing?[fail()], missing?[0]?[1] ?? 2]`, "[1, null, null, 2]"},
+		{`struct P { x }; let p = P { x: {"y": 1} }; [p?.x?.y, p.x?.z, missing?.x, missing?.x?.y ?? 2]`, "[1, null, null, 2]"},
+		{`let f = fn(x) { if (x is null) { return "none"; } x }; [f(missing), f(1)]`, "[none, 1]"},
+		// A function that produces no value returns null.
+		{`let f = fn() { let y = 1; }; [f() is null, f() ?? 7, f() == null, f()]`, "[true, 7, true, null]"},
+		{`let f = fn() { }; [f() is null, f() ?? 7, f() == null]`, "[true, 7, true]"},
+		// So does a program that produces no value.
+		{`let x = 1;`, "null"},
+		{``, "null"},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []Mode{Full, Middle, Simple} {
+			in := New(WithMode(mode))
+			if err := in.Set("missing", nil); err != nil {
+				t.Fatal(err)
+			}
+			if err := in.RegisterFunc("fail", gas.OpNone, func() error { return errors.New("evaluated") }); err != nil {
+				t.Fatal(err)
+			}
+			result, err := in.Run(tt.input)
+			if err != nil {
+				t.Errorf("%q (mode %d): unexpected error: %s", tt.input, mode, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (mode %d): expected=%q, got=%q", tt.input, mode, tt.expected, result.Inspect())
+			}
+		}
+	}
+
+	if _, err := New().Run(`let f = fn(x) { x + 1 }; f(null)`); err == nil || err.Error() != "unknown operator: NULL + INTEGER" {
+		t.Errorf("expected an operator error. got=%v", err)
+	}
+}
+
+func TestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let f = fn(a, b = 2) { [a, b] }; [f(1), f(1, 3)]`, "[[1, 2], [1, 3]]"},
+		{`let f = fn(a, b = a + 1, c = b * 2) { [a, b, c] }; f(1)`, "[1, 2, 4]"},
+		{`let f = fn(first, ...rest) { [first, rest] }; [f(1), f(1, 2, 3)]`, "[[1, []], [1, [2, 3]]]"},
+		{`let f = fn(a = 0, ...rest: int) { a + len(rest) }; f() + f(1, 2, 3)`, "3"},
+		{`let x = 10; let f = fn(a = x) { let x = 1; a }; f()`, "10"},
+		{`let mk = fn(n) { fn(a = n) { a } }; mk(7)()`, "7"},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []Mode{Full, Middle, Simple} {
+			result, err := New(WithMode(mode)).Run(tt.input)
+			if err != nil {
+				t.Errorf("%q (mode %d): unexpected error: %s", tt.input, mode, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (mode %d): expected=%q, got=%q", tt.input, mode, tt.expected, result.Inspect())
+			}
+		}
+	}
+
+	failures := []struct {
+		input    string
+		expected string
+	}{
+		{`let call = fn(f) { f(1) }; call(fn(a, b) { a })`, "wrong number of arguments. got=1, want=2"},
+		{`let call = fn(f) { f(1, 2, 3) }; call(fn(a, b = 1) { a })`, "wrong number of arguments. got=3, want at most 2"},
+		{`let call = fn(f) { f() }; call(fn(a, ...b) { a })`, "wrong number of arguments. got=0, want at least 1"},
+	}
+	for _, tt := range failures {
+		for _, mode := range []Mode{Full, Middle, Simple} {
+			_, err := New(WithMode(mode)).Run(tt.input)
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) || runtimeErr.Message != tt.expected {
+				t.Errorf("%q (mode %d): expected %q, got=%v", tt.input, mode, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestStructs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`struct Point { x, y }; let p = Point { x: 1, y: 2 }; p`, "Point{x: 1, y: 2}"},
+		{`struct Point { x, y }; Point { y: 2 }`, "Point{x: null, y: 2}"},
+		{`struct Point { x, y }; let p = Point { x: 1, y: 2 }; p.x * 10 + p.y`, "12"},
+		{`struct Point { x, y }; let p = Point { x: 1, y: 2 }; let q = Point { ...p, x: 5 }; [p, q]`,
+			"[Point{x: 1, y: 2}, Point{x: 5, y: 2}]"},
+		{`struct Point { x, y }; let f = fn(p) { Point { ...p, y: p.y + 1 } }; f(f(Point { x: 0, y: 0 })).y`, "2"},
+		{`struct Line { from, to }; struct P { x, y }; let l = Line { from: P { x: 1, y: 2 }, to: P { x: 3, y: 4 } }; l.to.x - l.from.y`, "1"},
+		{`let mk = fn(a) { struct Box { v }; Box { v: a } }; mk(3).v`, "3"},
+		{`let h = {"a": 1}; [h.a, h.b]`, "[1, null]"},
+		{`try { throw "bad"; } catch (e) { e.message }`, "bad"},
+		{`struct Point { x, y }; Point`, "struct Point { x, y }"},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []Mode{Full, Middle, Simple} {
+			result, err := New(WithMode(mode)).Run(tt.input)
+			if err != nil {
+				t.Errorf("%q (mode %d): unexpected error: %s", tt.input, mode, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (mode %d): expected=%q, got=%q", tt.input, mode, tt.expected, result.Inspect())
+			}
+		}
+	}
+
+	failures := []struct {
+		input    string
+		expected string
+	}{
+		{`struct P { x }; let get = fn(p) { p.y }; get(P { x: 1 })`, "unknown field y of struct P"},
+		{`let get = fn(p) { p.y }; get(1)`, "field access not supported: INTEGER"},
+		{`let mk = fn(t) { t { x: 1 } }; mk(1)`, "not a struct: t"},
+		{`struct P { x }; struct Q { x }; let copy = fn(q) { P { ...q } }; copy(Q { x: 1 })`, "cannot copy the fields of Q into P"},
+		{`struct P { f }; save("p", P { f: fn() { 1 } })`, "cannot save P: cannot encode FUNCTION as JSON"},
+	}
+	for _, tt := range failures {
+		for _, mode := range []Mode{Full, Middle, Simple} {
+			_, err := New(WithMode(mode)).Run(tt.input)
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) || runtimeErr.Message != tt.expected {
+				t.Errorf("%q (mode %d): expected %q, got=%v", tt.input, mode, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestMatch(t *testing.T) {
+	classify := `let classify = fn(v) {
+  match (v) {
+    0 => "zero",
+    -1 => "minus one",
+    "a" => "letter",
+    null => "nothing",
+    [] => "empty",
+    [x, y] => x + y,
+    [first, ...rest] if len(rest) > 1 => rest,
+    {"kind": "point", "at": [x, _]} => x,
+    n if n == 10 => { let m = n * 2; m },
+    _ => "other",
+  }
+};
+`
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`classify(0)`, "zero"},
+		{`classify(-1)`, "minus one"},
+		{`classify("a")`, "letter"},
+		{`classify(null)`, "nothing"},
+		{`classify([])`, "empty"},
+		{`classify([1, 2])`, "3"},
+		{`classify([1, 2, 3])`, "[2, 3]"},
+		{`classify([1])`, "other"},
+		{`classify({"kind": "point", "at": [4, 5], "extra": 1})`, "4"},
+		{`classify({"kind": "line"})`, "other"},
+		{`classify(10)`, "20"},
+		{`classify(false)`, "other"},
+		{`let x = 1; match ([5]) { [x] => x }; x`, "5"},
+		{`let x = 1; match ([5]) { [x] if x > 9 => x, _ => 0 }`, "0"},
+		{`let x = 1; match ([2, 3]) { [x] => x, _ => 0 }; x`, "1"},
+		{`let count = fn(xs, n) { match (xs) { [] => n, [_, ...rest] => count(rest, n + 1) } }; count([1, 2, 3, 4], 0)`, "4"},
+		{`match (true) { true => 1, false => 2 }`, "1"},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []Mode{Full, Middle, Simple} {
+			result, err := New(WithMode(mode)).Run(classify + tt.input)
+			if err != nil {
+				t.Errorf("%q (mode %d): unexpected error: %s", tt.input, mode, err)
+				continue
+			}
+			if result.Inspect() != tt.expected {
+				t.Errorf("%q (mode %d): expected=%q, got=%q", tt.input, mode, tt.expected, result.Inspect())
+			}
+		}
+	}
+
+	failures := []struct {
+		input    string
+		expected string
+	}{
+		{`match ([1, 2]) { [x] => x }`, "no arm matches [1, 2]"},
+		{`match (1) { x if x / 0 => x }`, "division by zero"},
+	}
+	for _, tt := range failures {
+		for _, mode := range []Mode{Full, Middle, Simple} {
+			_, err := New(WithMode(mode)).Run(tt.input)
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) || runtimeErr.Message != tt.expected {
+				t.Errorf("%q (mode %d): expected %q, got=%v", tt.input, mode, tt.expected, err)
+			}
+		}
+	}
+}
+
+func TestMatchTailCalls(t *testing.T) {
+	input := `let down = fn(n) { match (n) { 0 => "done", _ => down(n - 1) } }; down(200000)`
+	for _, mode := range []Mode{Full, Middle, Simple} {
+		result, err := New(WithMode(mode)).Run(input)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		if result.Inspect() != "done" {
+			t.Errorf("mode %d: expected done, got=%s", mode, result.Inspect())
+		}
+	}
+}
+
+func TestMacros(t *testing.T) {
+	for _, mode := range []Mode{Full, Middle, Simple} {
+		in := New(WithMode(mode))
+		_, err := in.Run(`let unless = macro(cond, cons, alt) {
+  quote(if (!(unquote(cond))) { unquote(cons) } else { unquote(alt) })
+};`)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		// Macros stay defined, and are expanded before the program is
+		// checked, so that the branch not taken is never evaluated.
+		result, err := in.Run(`let n = 3; unless(n > 10, n * 2, n / 0)`)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		testInteger(t, result, 6)
+		if _, ok := in.Env().Get("unless"); ok {
+			t.Errorf("mode %d: macro bound in the environment", mode)
+		}
+	}
+
+	_, err := New().Run(`let m = macro() { 1 }; m()`)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) || parseErr.Errors[0] != "macro m must return a quote, got INTEGER" {
+		t.Errorf("expected a ParseError, got=%v", err)
+	}
+}
+
+func TestRunDecodedProgram(t *testing.T) {
+	input := `
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+let point = {"x": fib(10), "y": [1, 2, 3][1]};
+match (point) { {"x": x} if x > 50 => x, _ => 0 }`
+
+	for _, mode := range []Mode{Full, Middle, Simple} {
+		program, err := Parse(input)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		data, err := ast.Encode(program)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		decoded, err := ast.DecodeProgram(data)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		original, in := New(WithMode(mode)), New(WithMode(mode))
+		if _, err := original.RunProgram(program); err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		result, err := in.RunProgram(decoded)
+		if err != nil {
+			t.Fatalf("mode %d: unexpected error: %s", mode, err)
+		}
+		testInteger(t, result, 55)
+		if in.OpCount() != original.OpCount() {
+			t.Errorf("mode %d: decoded program charged %d ops, the original %d", mode, in.OpCount(), original.OpCount())
+		}
+	}
+}
+
+func TestSinks(t *testing.T) {
+	var out bytes.Buffer
+	var results []object.Result
+	in := New(
+		WithStdout(&out),
+		WithResultSink(func(r object.Result) { results = append(results, r) }),
+	)
+
+	_, err := in.Run(`print("hello"); save("answer", 42);`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if out.String() != "hello\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+	if len(results) != 1 || results[0].Key != "answer" {
+		t.Fatalf("wrong results. got=%+v", results)
+	}
+	testInteger(t, results[0].Value, 42)
+}
+
+func TestParseError(t *testing.T) {
+	_, err := New().Run("let = 5;")
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected ParseError. got=%v", err)
+	}
+}
+
+func TestCheckRejectsBeforeRunning(t *testing.T) {
+	var results []object.Result
+	in := New(WithResultSink(func(r object.Result) { results = append(results, r) }))
+
+	_, err := in.Run(`save("a", 1); let x = 2 * 3; x + "b";`)
+	var parseErr *ParseError
+	if !errors.As(err, &parseErr) {
+		t.Fatalf("expected ParseError. got=%v", err)
+	}
+	if len(parseErr.Errors) != 1 || parseErr.Errors[0] != "unknown operator: INTEGER + STRING" {
+		t.Errorf("wrong errors. got=%q", parseErr.Errors)
+	}
+	if len(results) != 0 || in.GasUsed() != 0 {
+		t.Errorf("program ran. results=%v, gas=%d", results, in.GasUsed())
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	schedule := &gas.Schedule{Version: "test", Default: 1, Weights: map[int]uint64{gas.OpCustom: 10}}
+	in := New(WithSchedule(schedule), WithStdout(&bytes.Buffer{}))
+	err := in.RegisterBuiltin("double", 1, gas.OpCustom, func(args ...object.Object) object.Object {
+		return &object.Integer{Value: args[0].(*object.Integer).Value * 2}
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := in.Set("n", 4); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	program, err := Parse(`for (let i = 0; i < n; let i = i + 1) { print(double(i)); }`)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	est := in.Estimate(program)
+	if est.String() != "at most 49" {
+		t.Errorf("wrong estimate. got=%s", est)
+	}
+	if _, err := in.RunProgram(program); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if in.GasUsed() != 49 {
+		t.Errorf("wrong gas used. got=%d", in.GasUsed())
+	}
+}
+
+func TestOptimizer(t *testing.T) {
+	input := `let f = fn(x) { x * (2 + 3) }; f(1 + 1)`
+	tests := []struct {
+		opts []Option
+		gas  uint64
+	}{
+		{nil, 4},
+		{[]Option{WithOptimizer(optimize.PreserveCost)}, 4},
+		{[]Option{WithOptimizer(optimize.ReduceCost)}, 2},
+	}
+
+	for _, tt := range tests {
+		in := New(tt.opts...)
+		result, err := in.Run(input)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		testInteger(t, result, 10)
+		if in.GasUsed() != tt.gas {
+			t.Errorf("wrong gas used. expected=%d, got=%d", tt.gas, in.GasUsed())
+		}
+	}
+}
+
+func testInteger(t *testing.T, obj object.Object, expected int64) {
+	t.Helper()
+	result, ok := obj.(*object.Integer)
+	if !ok {
+		t.Fatalf("object is not Integer. got=%T (%+v)", obj, obj)
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%d, want=%d",
+			result.Value, expected)
+	}
+}
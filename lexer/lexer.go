@@ -9,15 +9,25 @@ type Lexer struct {
 	position     int
 	readPosition int
 	char         byte
+
+	// line and column locate char.
+	line   int
+	column int
 }
 
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
 func (l *Lexer) readChar() {
+	if l.char == '\n' {
+		l.line++
+		l.column = 1
+	} else {
+		l.column++
+	}
 	if l.readPosition >= len(l.input) {
 		l.char = 0
 	} else {
@@ -36,16 +46,31 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
-
 	l.skipWhitespace()
 
+	pos := l.pos()
+	tok := l.nextToken()
+	tok.Pos = pos
+	tok.End = l.pos()
+	return tok
+}
+
+func (l *Lexer) pos() token.Position {
+	return token.Position{Offset: l.position, Line: l.line, Column: l.column}
+}
+
+func (l *Lexer) nextToken() token.Token {
+	var tok token.Token
+
 	switch l.char {
 	case '=':
 		if l.peekChar() == '=' {
 			ch := l.char
 			l.readChar()
 			tok = token.Token{Type: token.EQ, Literal: string(ch) + string(l.char)}
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
 		} else {
 			tok = newToken(token.ASSIGN, l.char)
 		}
@@ -75,18 +100,47 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.RPAREN, l.char)
 	case ',':
 		tok = newToken(token.COMMA, l.char)
+	case ':':
+		tok = newToken(token.COLON, l.char)
+	case '.':
+		if l.peekChar() == '.' && l.readPosition+1 < len(l.input) && l.input[l.readPosition+1] == '.' {
+			l.readChar()
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = newToken(token.DOT, l.char)
+		}
+	case '?':
+		switch l.peekChar() {
+		case '?':
+			l.readChar()
+			tok = token.Token{Type: token.COALESCE, Literal: "??"}
+		case '[':
+			l.readChar()
+			tok = token.Token{Type: token.OPTIONAL_INDEX, Literal: "?["}
+		case '.':
+			l.readChar()
+			tok = token.Token{Type: token.OPTIONAL_FIELD, Literal: "?."}
+		default:
+			tok = newToken(token.ILLEGAL, l.char)
+		}
 	case '+':
 		tok = newToken(token.PLUS, l.char)
 	case '{':
 		tok = newToken(token.LBRACE, l.char)
 	case '}':
 		tok = newToken(token.RBRACE, l.char)
+	case '[':
+		tok = newToken(token.LBRACKET, l.char)
+	case ']':
+		tok = newToken(token.RBRACKET, l.char)
 	case '"':
 		tok.Type = token.STRING
 		tok.Literal = l.readString()
 	case 0:
 		tok.Literal = ""
 		tok.Type = token.EOF
+		return tok
 	default:
 		if isLetter(l.char) {
 			tok.Literal = l.readIdentifier()
@@ -0,0 +1,150 @@
+package lsp
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/parser"
+	"github.com/SebastiaanWouters/verigo/resolver"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// document is an open file, parsed as it is. The parser leaves out what it
+// cannot parse, so the tree of a document being edited is still useful.
+type document struct {
+	uri         string
+	version     int
+	text        string
+	lines       []int // the offsets the lines start at
+	program     *ast.Program
+	diagnostics []parser.Diagnostic
+	// defs maps identifiers to the declarations of their variables.
+	defs map[*ast.Identifier]*ast.Identifier
+	// closes maps the offsets of opening braces to the offsets of the
+	// closing braces matching them.
+	closes map[int]int
+}
+
+func newDocument(uri string, version int, text string) *document {
+	d := &document{uri: uri, version: version, text: text, lines: []int{0}, closes: map[int]int{}}
+	for i := 0; i < len(text); i++ {
+		if text[i] == '\n' {
+			d.lines = append(d.lines, i+1)
+		}
+	}
+	p := parser.New(lexer.New(text))
+	d.program = p.ParseProgram()
+	d.diagnostics = p.Diagnostics()
+	d.defs = resolver.Definitions(d.program)
+
+	var opens []int
+	l := lexer.New(text)
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LBRACE:
+			opens = append(opens, tok.Pos.Offset)
+		case token.RBRACE:
+			if len(opens) > 0 {
+				d.closes[opens[len(opens)-1]] = tok.Pos.Offset
+				opens = opens[:len(opens)-1]
+			}
+		}
+	}
+	return d
+}
+
+// position returns the position of the byte at offset.
+func (d *document) position(offset int) position {
+	if offset > len(d.text) {
+		offset = len(d.text)
+	}
+	line := sort.Search(len(d.lines), func(i int) bool { return d.lines[i] > offset }) - 1
+	character := 0
+	for _, r := range d.text[d.lines[line]:offset] {
+		character += utf16Len(r)
+	}
+	return position{Line: line, Character: character}
+}
+
+// offset returns the offset of the byte at p, or of the end of its line if
+// p is past it.
+func (d *document) offset(p position) int {
+	if p.Line < 0 {
+		return 0
+	}
+	if p.Line >= len(d.lines) {
+		return len(d.text)
+	}
+	offset, character := d.lines[p.Line], 0
+	for offset < len(d.text) && d.text[offset] != '\n' && character < p.Character {
+		r, size := utf8.DecodeRuneInString(d.text[offset:])
+		offset += size
+		character += utf16Len(r)
+	}
+	return offset
+}
+
+func (d *document) span(pos, end token.Position) textRange {
+	if end.Offset < pos.Offset {
+		end = pos
+	}
+	return textRange{Start: d.position(pos.Offset), End: d.position(end.Offset)}
+}
+
+func utf16Len(r rune) int {
+	if r >= 0x10000 {
+		return 2
+	}
+	return 1
+}
+
+// identifierAt returns the identifier naming a variable that offset is in
+// or just after, or nil if there is none.
+func (d *document) identifierAt(offset int) *ast.Identifier {
+	var found *ast.Identifier
+	fields := map[*ast.Identifier]bool{}
+	ast.Inspect(d.program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.StructStatement:
+			for _, field := range node.Fields {
+				fields[field] = true
+			}
+		case *ast.StructLiteral:
+			for _, field := range node.Fields {
+				fields[field] = true
+			}
+		case *ast.FieldExpression:
+			fields[node.Field] = true
+		case *ast.Identifier:
+			if !fields[node] && node.Token.Pos.Offset <= offset && offset <= node.Token.End.Offset {
+				found = node
+			}
+		}
+		return found == nil
+	})
+	return found
+}
+
+// scopes returns the functions whose parameters and bodies contain offset,
+// innermost first.
+func (d *document) scopes(offset int) []*ast.FunctionLiteral {
+	var fns []*ast.FunctionLiteral
+	ast.Inspect(d.program, func(node ast.Node) bool {
+		fn, ok := node.(*ast.FunctionLiteral)
+		if !ok || fn.Body == nil || fn.Body.Token.Type != token.LBRACE {
+			return true
+		}
+		open := fn.Body.Token.Pos.Offset
+		end, ok := d.closes[open]
+		if !ok {
+			end = len(d.text) // the body is not closed yet
+		}
+		if fn.Token.Pos.Offset < offset && offset <= end {
+			fns = append([]*ast.FunctionLiteral{fn}, fns...)
+		}
+		return true
+	})
+	return fns
+}
@@ -0,0 +1,331 @@
+// Package lsp serves the Language Server Protocol, so that editors can
+// check programs as they are written. For each open document, the server
+// publishes the parse errors with their ranges, shows the signatures of
+// builtins on hover, goes to the declarations of variables bound by let,
+// parameters and patterns, completes the names in scope and formats the
+// document.
+//
+// Documents are synchronized in full on every change.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/evaluator"
+	"github.com/SebastiaanWouters/verigo/format"
+	"github.com/SebastiaanWouters/verigo/parser"
+	"github.com/SebastiaanWouters/verigo/resolver"
+	"github.com/SebastiaanWouters/verigo/wire"
+)
+
+// ErrNoShutdown is returned by Serve when the client asks it to exit
+// without shutting it down first.
+var ErrNoShutdown = errors.New("lsp: exit without shutdown")
+
+// Server is a language server talking to one client.
+type Server struct {
+	r           *bufio.Reader
+	w           io.Writer
+	initialized bool
+	shutdown    bool
+	documents   map[string]*document
+}
+
+// NewServer returns a server reading messages from r and writing to w.
+func NewServer(r io.Reader, w io.Writer) *Server {
+	return &Server{r: bufio.NewReader(r), w: w, documents: map[string]*document{}}
+}
+
+// Serve answers messages until the client asks it to exit or closes r.
+func (s *Server) Serve() error {
+	for {
+		content, err := wire.Read(s.r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var msg message
+		if err := json.Unmarshal(content, &msg); err != nil {
+			return fmt.Errorf("lsp: %w", err)
+		}
+		if msg.Method == "exit" {
+			if !s.shutdown {
+				return ErrNoShutdown
+			}
+			return nil
+		}
+		if len(msg.ID) == 0 {
+			s.notified(&msg)
+			continue
+		}
+		result, err := s.handle(&msg)
+		if err != nil {
+			var rerr *responseError
+			if !errors.As(err, &rerr) {
+				rerr = &responseError{Code: codeRequestFailed, Message: err.Error()}
+			}
+			s.send(&errorResponse{JSONRPC: "2.0", ID: msg.ID, Error: rerr})
+			continue
+		}
+		s.send(&response{JSONRPC: "2.0", ID: msg.ID, Result: result})
+	}
+}
+
+// handle answers a request, returning its result.
+func (s *Server) handle(msg *message) (interface{}, error) {
+	switch {
+	case msg.Method == "initialize":
+		s.initialized = true
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":           1, // full
+				"hoverProvider":              true,
+				"definitionProvider":         true,
+				"completionProvider":         map[string]interface{}{},
+				"documentFormattingProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "verigo"},
+		}, nil
+	case !s.initialized:
+		return nil, &responseError{Code: codeServerNotInitialized, Message: "the server is not initialized"}
+	case s.shutdown:
+		return nil, &responseError{Code: codeInvalidRequest, Message: "the server is shut down"}
+	}
+
+	switch msg.Method {
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+	case "textDocument/hover":
+		return s.hover(msg)
+	case "textDocument/definition":
+		return s.definition(msg)
+	case "textDocument/completion":
+		return s.completion(msg)
+	case "textDocument/formatting":
+		return s.formatting(msg)
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: fmt.Sprintf("unsupported method %q", msg.Method)}
+}
+
+// notified acts on a notification. Unknown notifications are ignored, as
+// are those the server cannot make sense of, since it cannot answer them.
+func (s *Server) notified(msg *message) {
+	switch msg.Method {
+	case "textDocument/didOpen":
+		var params struct {
+			TextDocument struct {
+				URI     string `json:"uri"`
+				Version int    `json:"version"`
+				Text    string `json:"text"`
+			} `json:"textDocument"`
+		}
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return
+		}
+		doc := params.TextDocument
+		s.open(newDocument(doc.URI, doc.Version, doc.Text))
+	case "textDocument/didChange":
+		var params struct {
+			TextDocument struct {
+				URI     string `json:"uri"`
+				Version int    `json:"version"`
+			} `json:"textDocument"`
+			ContentChanges []struct {
+				Text string `json:"text"`
+			} `json:"contentChanges"`
+		}
+		if json.Unmarshal(msg.Params, &params) != nil || len(params.ContentChanges) == 0 {
+			return
+		}
+		doc := params.TextDocument
+		s.open(newDocument(doc.URI, doc.Version, params.ContentChanges[len(params.ContentChanges)-1].Text))
+	case "textDocument/didClose":
+		var params struct {
+			TextDocument textDocumentIdentifier `json:"textDocument"`
+		}
+		if json.Unmarshal(msg.Params, &params) != nil {
+			return
+		}
+		if doc, ok := s.documents[params.TextDocument.URI]; ok {
+			delete(s.documents, doc.uri)
+			s.publish(doc.uri, doc.version, []diagnostic{})
+		}
+	}
+}
+
+func (s *Server) open(doc *document) {
+	s.documents[doc.uri] = doc
+	diagnostics := []diagnostic{}
+	for _, d := range doc.diagnostics {
+		severity := severityError
+		if d.Severity == parser.Warning {
+			severity = severityWarning
+		}
+		message := d.Message
+		if d.Hint != "" {
+			message += " (hint: " + d.Hint + ")"
+		}
+		diagnostics = append(diagnostics, diagnostic{
+			Range:    doc.span(d.Pos, d.End),
+			Severity: severity,
+			Code:     d.Code,
+			Source:   "verigo",
+			Message:  message,
+		})
+	}
+	s.publish(doc.uri, doc.version, diagnostics)
+}
+
+func (s *Server) publish(uri string, version int, diagnostics []diagnostic) {
+	s.send(&notification{
+		JSONRPC: "2.0",
+		Method:  "textDocument/publishDiagnostics",
+		Params:  publishDiagnosticsParams{URI: uri, Version: version, Diagnostics: diagnostics},
+	})
+}
+
+// at returns the document and offset a request is about.
+func (s *Server) at(msg *message) (*document, int, error) {
+	var params textDocumentPositionParams
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, 0, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, 0, err
+	}
+	return doc, doc.offset(params.Position), nil
+}
+
+func (s *Server) document(uri string) (*document, error) {
+	doc, ok := s.documents[uri]
+	if !ok {
+		return nil, &responseError{Code: codeInvalidParams, Message: fmt.Sprintf("%s is not open", uri)}
+	}
+	return doc, nil
+}
+
+// hover shows the signature of the builtin under the cursor, unless the
+// program declares a variable of the same name.
+func (s *Server) hover(msg *message) (interface{}, error) {
+	doc, offset, err := s.at(msg)
+	if err != nil {
+		return nil, err
+	}
+	ident := doc.identifierAt(offset)
+	if ident == nil || doc.defs[ident] != nil {
+		return nil, nil
+	}
+	sig, ok := evaluator.Signatures[ident.Value]
+	if !ok {
+		return nil, nil
+	}
+	return hover{
+		Contents: markupContent{Kind: "markdown", Value: "```monkey\n" + ident.Value + ": " + sig + "\n```\nbuiltin"},
+		Range:    doc.span(ident.Token.Pos, ident.Token.End),
+	}, nil
+}
+
+func (s *Server) definition(msg *message) (interface{}, error) {
+	doc, offset, err := s.at(msg)
+	if err != nil {
+		return nil, err
+	}
+	ident := doc.identifierAt(offset)
+	if ident == nil || doc.defs[ident] == nil {
+		return nil, nil
+	}
+	decl := doc.defs[ident]
+	return location{URI: doc.uri, Range: doc.span(decl.Token.Pos, decl.Token.End)}, nil
+}
+
+// completion lists the names in scope, innermost first, then the builtins.
+func (s *Server) completion(msg *message) (interface{}, error) {
+	doc, offset, err := s.at(msg)
+	if err != nil {
+		return nil, err
+	}
+	kinds := map[*ast.Identifier]int{}
+	ast.Inspect(doc.program, func(node ast.Node) bool {
+		switch node := node.(type) {
+		case *ast.LetStatement:
+			if _, ok := node.Value.(*ast.FunctionLiteral); ok {
+				kinds[node.Name] = kindFunction
+			}
+		case *ast.StructStatement:
+			kinds[node.Name] = kindStruct
+		}
+		return true
+	})
+
+	items := []completionItem{}
+	seen := map[string]bool{}
+	add := func(decls []*ast.Identifier) {
+		for _, decl := range decls {
+			if seen[decl.Value] {
+				continue
+			}
+			seen[decl.Value] = true
+			kind := kinds[decl]
+			if kind == 0 {
+				kind = kindVariable
+			}
+			items = append(items, completionItem{Label: decl.Value, Kind: kind})
+		}
+	}
+	for _, fn := range doc.scopes(offset) {
+		add(resolver.Declarations(fn))
+	}
+	add(resolver.Globals(doc.program))
+
+	builtins := make([]string, 0, len(evaluator.Signatures))
+	for name := range evaluator.Signatures {
+		if !seen[name] {
+			builtins = append(builtins, name)
+		}
+	}
+	sort.Strings(builtins)
+	for _, name := range builtins {
+		items = append(items, completionItem{Label: name, Kind: kindFunction, Detail: evaluator.Signatures[name]})
+	}
+	return items, nil
+}
+
+// formatting replaces the document by its canonical form, if it parses.
+func (s *Server) formatting(msg *message) (interface{}, error) {
+	var params struct {
+		TextDocument textDocumentIdentifier `json:"textDocument"`
+	}
+	if err := json.Unmarshal(msg.Params, &params); err != nil {
+		return nil, &responseError{Code: codeInvalidParams, Message: err.Error()}
+	}
+	doc, err := s.document(params.TextDocument.URI)
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range doc.diagnostics {
+		if d.Severity == parser.Error {
+			return nil, errors.New("cannot format a document with parse errors")
+		}
+	}
+	formatted := format.Node(doc.program)
+	if formatted == doc.text {
+		return []textEdit{}, nil
+	}
+	whole := textRange{End: doc.position(len(doc.text))}
+	return []textEdit{{Range: whole, NewText: formatted}}, nil
+}
+
+func (s *Server) send(msg interface{}) {
+	// The client is gone if this fails, which Serve notices when reading.
+	wire.Write(s.w, msg)
+}
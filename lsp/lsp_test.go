@@ -0,0 +1,287 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/wire"
+)
+
+const uri = "file:///prog.mk"
+
+// reply is a response or notification, decoded loosely.
+type reply struct {
+	ID     *int            `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *responseError  `json:"error"`
+	Params json.RawMessage `json:"params"`
+}
+
+// session sends messages to a server, each a request if it has an id, and
+// returns the responses by id and the notifications in order. The session
+// is opened by initialize and closed by shutdown and exit.
+func session(t *testing.T, messages ...map[string]interface{}) (map[int]reply, []reply) {
+	t.Helper()
+	var in bytes.Buffer
+	messages = append([]map[string]interface{}{{"id": 0, "method": "initialize", "params": map[string]interface{}{}}}, messages...)
+	messages = append(messages, map[string]interface{}{"id": 1000, "method": "shutdown"}, map[string]interface{}{"method": "exit"})
+	for _, msg := range messages {
+		msg["jsonrpc"] = "2.0"
+		if err := wire.Write(&in, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var out bytes.Buffer
+	if err := NewServer(&in, &out).Serve(); err != nil {
+		t.Fatal(err)
+	}
+	responses := map[int]reply{}
+	var notifications []reply
+	r := bufio.NewReader(&out)
+	for {
+		content, err := wire.Read(r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		var rep reply
+		if err := json.Unmarshal(content, &rep); err != nil {
+			t.Fatal(err)
+		}
+		if rep.ID != nil {
+			responses[*rep.ID] = rep
+		} else {
+			notifications = append(notifications, rep)
+		}
+	}
+	return responses, notifications
+}
+
+func open(text string) map[string]interface{} {
+	return map[string]interface{}{"method": "textDocument/didOpen", "params": map[string]interface{}{
+		"textDocument": map[string]interface{}{"uri": uri, "version": 1, "languageId": "monkey", "text": text},
+	}}
+}
+
+func at(id int, method string, line, character int) map[string]interface{} {
+	return map[string]interface{}{"id": id, "method": method, "params": map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+		"position":     position{Line: line, Character: character},
+	}}
+}
+
+func result(t *testing.T, responses map[int]reply, id int, v interface{}) {
+	t.Helper()
+	rep, ok := responses[id]
+	if !ok {
+		t.Fatalf("no response to %d", id)
+	}
+	if rep.Error != nil {
+		t.Fatalf("%d failed: %s", id, rep.Error.Message)
+	}
+	if err := json.Unmarshal(rep.Result, v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	change := map[string]interface{}{"method": "textDocument/didChange", "params": map[string]interface{}{
+		"textDocument":   map[string]interface{}{"uri": uri, "version": 2},
+		"contentChanges": []map[string]string{{"text": "let x = 1;"}},
+	}}
+	closing := map[string]interface{}{"method": "textDocument/didClose", "params": map[string]interface{}{
+		"textDocument": map[string]string{"uri": uri},
+	}}
+	_, notifications := session(t, open("let s = \"é\"; let x = );"), change, closing)
+	if len(notifications) != 3 {
+		t.Fatalf("wrong number of notifications. expected=3, got=%d", len(notifications))
+	}
+	var published []publishDiagnosticsParams
+	for _, n := range notifications {
+		var params publishDiagnosticsParams
+		if n.Method != "textDocument/publishDiagnostics" {
+			t.Fatalf("wrong notification. got=%q", n.Method)
+		}
+		if err := json.Unmarshal(n.Params, &params); err != nil {
+			t.Fatal(err)
+		}
+		published = append(published, params)
+	}
+	expected := []diagnostic{{
+		Range:    textRange{Start: position{Line: 0, Character: 21}, End: position{Line: 0, Character: 22}},
+		Severity: severityError,
+		Code:     "P002",
+		Source:   "verigo",
+		Message:  "no prefix parse function for ) found (hint: expected an expression)",
+	}}
+	if !reflect.DeepEqual(published[0].Diagnostics, expected) {
+		t.Errorf("wrong diagnostics.\nexpected=%+v\ngot=     %+v", expected, published[0].Diagnostics)
+	}
+	if published[1].Version != 2 || len(published[1].Diagnostics) != 0 {
+		t.Errorf("diagnostics not cleared on change. got=%+v", published[1])
+	}
+	if len(published[2].Diagnostics) != 0 {
+		t.Errorf("diagnostics not cleared on close. got=%+v", published[2])
+	}
+}
+
+const program = `let total = fn(xs, scale) {
+	let sum = 0;
+	for (let i = 0; i < len(xs); let i = i + 1) {
+		let sum = sum + xs[i] * scale;
+	}
+	sum
+};
+total([1, 2], 3);`
+
+func TestHover(t *testing.T) {
+	responses, _ := session(t, open(program+"\nlet f = fn(len) { len };"),
+		at(1, "textDocument/hover", 2, 22), // len
+		at(2, "textDocument/hover", 0, 5),  // total
+		at(3, "textDocument/hover", 8, 19), // a parameter named len
+	)
+	var h hover
+	result(t, responses, 1, &h)
+	expected := hover{
+		Contents: markupContent{Kind: "markdown", Value: "```monkey\nlen: fn(any): int\n```\nbuiltin"},
+		Range:    textRange{Start: position{Line: 2, Character: 21}, End: position{Line: 2, Character: 24}},
+	}
+	if h != expected {
+		t.Errorf("wrong hover.\nexpected=%+v\ngot=     %+v", expected, h)
+	}
+	for _, id := range []int{2, 3} {
+		if got := string(responses[id].Result); got != "null" {
+			t.Errorf("%d: expected no hover. got=%s", id, got)
+		}
+	}
+}
+
+func TestDefinition(t *testing.T) {
+	tests := []struct {
+		line, character int
+		expected        *textRange
+	}{
+		{3, 13, &textRange{Start: position{Line: 1, Character: 5}, End: position{Line: 1, Character: 8}}},   // sum, let
+		{3, 6, &textRange{Start: position{Line: 1, Character: 5}, End: position{Line: 1, Character: 8}}},    // sum, let again
+		{3, 19, &textRange{Start: position{Line: 0, Character: 15}, End: position{Line: 0, Character: 17}}}, // xs, parameter
+		{3, 22, &textRange{Start: position{Line: 2, Character: 10}, End: position{Line: 2, Character: 11}}}, // i, loop variable
+		{7, 2, &textRange{Start: position{Line: 0, Character: 4}, End: position{Line: 0, Character: 9}}},    // total, global
+		{2, 22, nil}, // len, builtin
+		{5, 0, nil},  // whitespace
+	}
+	var messages []map[string]interface{}
+	for i, tt := range tests {
+		messages = append(messages, at(i+1, "textDocument/definition", tt.line, tt.character))
+	}
+	responses, _ := session(t, append([]map[string]interface{}{open(program)}, messages...)...)
+	for i, tt := range tests {
+		var loc *location
+		result(t, responses, i+1, &loc)
+		if tt.expected == nil {
+			if loc != nil {
+				t.Errorf("%d:%d: expected no definition. got=%+v", tt.line, tt.character, loc)
+			}
+			continue
+		}
+		if loc == nil || loc.URI != uri || loc.Range != *tt.expected {
+			t.Errorf("%d:%d: wrong definition. expected=%+v, got=%+v", tt.line, tt.character, tt.expected, loc)
+		}
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	text := "struct P { x }\nlet f = fn(a) {\n\tlet g = fn(b, len) {  };\n\tlet c = 1;\n\t\n};\nlet d = 2;"
+	responses, _ := session(t, open(text),
+		at(1, "textDocument/completion", 2, 22), // in g
+		at(2, "textDocument/completion", 4, 1),  // in f
+		at(3, "textDocument/completion", 6, 9),  // at the top level
+	)
+	labels := func(id int) (string, []completionItem) {
+		var items []completionItem
+		result(t, responses, id, &items)
+		var names []string
+		for _, item := range items {
+			names = append(names, item.Label)
+		}
+		return strings.Join(names, " "), items
+	}
+	tests := []struct {
+		id       int
+		expected string
+	}{
+		{1, "b len a g c P f d assert assert_eq assert_error fib isPrime pow print rand save sin sqrt tan"},
+		{2, "a g c P f d assert assert_eq assert_error fib isPrime len pow print rand save sin sqrt tan"},
+		{3, "P f d assert assert_eq assert_error fib isPrime len pow print rand save sin sqrt tan"},
+	}
+	for _, tt := range tests {
+		if got, _ := labels(tt.id); got != tt.expected {
+			t.Errorf("%d: wrong completions.\nexpected=%q\ngot=     %q", tt.id, tt.expected, got)
+		}
+	}
+	_, items := labels(3)
+	expected := []completionItem{{Label: "P", Kind: kindStruct}, {Label: "f", Kind: kindFunction}, {Label: "d", Kind: kindVariable},
+		{Label: "assert", Kind: kindFunction, Detail: "fn(any, ...string): null"}}
+	if !reflect.DeepEqual(items[:4], expected) {
+		t.Errorf("wrong items.\nexpected=%+v\ngot=     %+v", expected, items[:4])
+	}
+}
+
+func TestFormatting(t *testing.T) {
+	formatting := func(id int) map[string]interface{} {
+		return map[string]interface{}{"id": id, "method": "textDocument/formatting", "params": map[string]interface{}{
+			"textDocument": map[string]string{"uri": uri},
+			"options":      map[string]interface{}{"tabSize": 4, "insertSpaces": false},
+		}}
+	}
+	responses, _ := session(t, open("let x=1;\nif(x<2){print(x)}"), formatting(1))
+	var edits []textEdit
+	result(t, responses, 1, &edits)
+	expected := []textEdit{{
+		Range:   textRange{End: position{Line: 1, Character: 17}},
+		NewText: "let x = 1;\nif (x < 2) {\n\tprint(x);\n}\n",
+	}}
+	if !reflect.DeepEqual(edits, expected) {
+		t.Errorf("wrong edits.\nexpected=%+v\ngot=     %+v", expected, edits)
+	}
+
+	responses, _ = session(t, open("let x = 1;\n"), formatting(1))
+	result(t, responses, 1, &edits)
+	if len(edits) != 0 {
+		t.Errorf("formatted text edited. got=%+v", edits)
+	}
+	responses, _ = session(t, open("let x = ;"), formatting(1))
+	if rep := responses[1]; rep.Error == nil || rep.Error.Code != codeRequestFailed {
+		t.Errorf("formatted a document with errors. got=%+v", rep)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var in, out bytes.Buffer
+	wire.Write(&in, map[string]interface{}{"jsonrpc": "2.0", "id": 1, "method": "textDocument/hover"})
+	wire.Write(&in, map[string]interface{}{"jsonrpc": "2.0", "method": "exit"})
+	if err := NewServer(&in, &out).Serve(); err != ErrNoShutdown {
+		t.Errorf("wrong error. expected=%v, got=%v", ErrNoShutdown, err)
+	}
+	if !strings.Contains(out.String(), `"code":-32002`) {
+		t.Errorf("request served before initialize. got=%s", out.String())
+	}
+
+	responses, _ := session(t,
+		at(1, "textDocument/hover", 0, 0),
+		map[string]interface{}{"id": 2, "method": "workspace/symbol", "params": map[string]interface{}{}},
+	)
+	if rep := responses[1]; rep.Error == nil || rep.Error.Code != codeInvalidParams {
+		t.Errorf("hovered a document not open. got=%+v", rep)
+	}
+	if rep := responses[2]; rep.Error == nil || rep.Error.Code != codeMethodNotFound {
+		t.Errorf("unknown method served. got=%+v", rep)
+	}
+}
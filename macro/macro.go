@@ -0,0 +1,111 @@
+// Package macro expands the macros of a program before it runs.
+//
+// A macro is a macro literal bound by a let at the top level of a program,
+// as in let unless = macro(cond, body) { quote(if (!unquote(cond)) { unquote(body) }) }.
+// Define removes such lets from the program and binds the macros, and
+// Expand replaces every call to one by the quote the macro returns when
+// called with its arguments quoted. Macros run in evaluator_simple, unmetered:
+// expanding a program charges no gas.
+package macro
+
+import (
+	"fmt"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/evaluator_simple"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/parser"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// Diagnostic codes reported while expanding macros.
+const (
+	CodeArity  = "M001"
+	CodeFailed = "M002"
+)
+
+// Define removes the lets binding macro literals at the top level of
+// program and binds the macros in env instead.
+func Define(program *ast.Program, env *object.Environment) {
+	stmts := []ast.Statement{}
+	for _, stmt := range program.Statements {
+		if let, ok := stmt.(*ast.LetStatement); ok {
+			if lit, ok := let.Value.(*ast.MacroLiteral); ok {
+				env.Set(let.Name.Value, &object.Macro{Parameters: lit.Parameters, Body: lit.Body, Env: env})
+				continue
+			}
+		}
+		stmts = append(stmts, stmt)
+	}
+	program.Statements = stmts
+}
+
+// Expand replaces the calls in program to the macros bound in env by the
+// expressions they return, and reports the calls that fail. Calls in the
+// arguments of a call are expanded first; the expressions a macro returns
+// are not expanded again.
+func Expand(program *ast.Program, env *object.Environment) []parser.Diagnostic {
+	var diagnostics []parser.Diagnostic
+	ast.Modify(program, func(node ast.Node) ast.Node {
+		call, ok := node.(*ast.CallExpression)
+		if !ok {
+			return node
+		}
+		ident, ok := call.Function.(*ast.Identifier)
+		if !ok {
+			return node
+		}
+		obj, ok := env.Get(ident.Value)
+		m, isMacro := obj.(*object.Macro)
+		if !ok || !isMacro {
+			return node
+		}
+		expanded, d := expand(m, ident, call.Arguments)
+		if d != nil {
+			diagnostics = append(diagnostics, *d)
+			return node
+		}
+		return expanded
+	})
+	return diagnostics
+}
+
+// expand calls m, named by ident, with args quoted.
+func expand(m *object.Macro, ident *ast.Identifier, args []ast.Expression) (ast.Node, *parser.Diagnostic) {
+	if len(args) != len(m.Parameters) {
+		return nil, report(ident.Token, CodeArity, "wrong number of arguments to macro %s. got=%d, want=%d",
+			ident.Value, len(args), len(m.Parameters))
+	}
+	env := object.NewEnclosedEnvironment(m.Env)
+	for i, param := range m.Parameters {
+		env.Set(param.Value, &object.Quote{Node: args[i]})
+	}
+	result := evaluator_simple.Eval(m.Body, env)
+	if rv, ok := result.(*object.ReturnValue); ok {
+		result = rv.Value
+	}
+	switch result := result.(type) {
+	case *object.Quote:
+		return result.Node, nil
+	case *object.Error:
+		tok := ident.Token
+		if result.Pos.Line > 0 {
+			tok = token.Token{Pos: result.Pos, End: result.Pos}
+		}
+		return nil, report(tok, CodeFailed, "macro %s failed: %s", ident.Value, result.Message)
+	case nil:
+		return nil, report(ident.Token, CodeFailed, "macro %s must return a quote, got nothing", ident.Value)
+	default:
+		return nil, report(ident.Token, CodeFailed, "macro %s must return a quote, got %s", ident.Value, result.Type())
+	}
+}
+
+func report(tok token.Token, code, format string, a ...interface{}) *parser.Diagnostic {
+	return &parser.Diagnostic{
+		Code:     code,
+		Severity: parser.Error,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      tok.Pos,
+		End:      tok.End,
+	}
+}
@@ -0,0 +1,126 @@
+package macro
+
+import (
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	return program
+}
+
+func TestDefine(t *testing.T) {
+	program := parse(t, `
+let number = 1;
+let function = fn(x, y) { x + y };
+let mymacro = macro(x, y) { x + y; };
+let f = fn() { let inner = macro() { 1 }; };`)
+	env := object.NewEnvironment()
+	Define(program, env)
+
+	if len(program.Statements) != 3 {
+		t.Fatalf("wrong number of statements. got=%d", len(program.Statements))
+	}
+	for _, name := range []string{"number", "function", "inner"} {
+		if _, ok := env.Get(name); ok {
+			t.Errorf("%s should not be defined", name)
+		}
+	}
+	obj, ok := env.Get("mymacro")
+	if !ok {
+		t.Fatalf("macro not in environment")
+	}
+	m, ok := obj.(*object.Macro)
+	if !ok {
+		t.Fatalf("object is not Macro. got=%T (%+v)", obj, obj)
+	}
+	if len(m.Parameters) != 2 || m.Parameters[0].String() != "x" || m.Parameters[1].String() != "y" {
+		t.Errorf("wrong parameters. got=%v", m.Parameters)
+	}
+	if m.Body.String() != "(x + y)" {
+		t.Errorf("wrong body. got=%q", m.Body.String())
+	}
+}
+
+func TestExpand(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`let infixExpression = macro() { quote(1 + 2); }; infixExpression();`,
+			`(1 + 2)`,
+		},
+		{
+			`let reverse = macro(a, b) { quote(unquote(b) - unquote(a)); }; reverse(2 + 2, 10 - 5);`,
+			`(10 - 5) - (2 + 2)`,
+		},
+		{
+			`let unless = macro(cond, cons, alt) {
+				quote(if (!(unquote(cond))) { unquote(cons); } else { unquote(alt); });
+			};
+			unless(10 > 5, print("not greater"), print("greater"));`,
+			`if (!(10 > 5)) { print("not greater") } else { print("greater") }`,
+		},
+		{
+			`let twice = macro(x) { quote(unquote(x) + unquote(x)) }; let f = fn() { [twice(1), twice(twice(2))] };`,
+			`let f = fn() { [1 + 1, (2 + 2) + (2 + 2)] };`,
+		},
+		{
+			`let const = macro() { let n = 6 * 7; quote(unquote(n)) }; for (let i = 0; i < const(); let i = i + 1) { const() }`,
+			`for (let i = 0; i < 42; let i = i + 1) { 42 }`,
+		},
+	}
+
+	for _, tt := range tests {
+		expected := parse(t, tt.expected)
+		program := parse(t, tt.input)
+		env := object.NewEnvironment()
+		Define(program, env)
+		if diagnostics := Expand(program, env); len(diagnostics) != 0 {
+			t.Errorf("%q: unexpected diagnostics: %v", tt.input, diagnostics)
+			continue
+		}
+		if program.String() != expected.String() {
+			t.Errorf("%q: not equal. want=%q, got=%q", tt.input, expected.String(), program.String())
+		}
+	}
+}
+
+func TestExpandFailures(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`let m = macro(x) { quote(x) }; m(1, 2)`,
+			"1:32: error[M001]: wrong number of arguments to macro m. got=2, want=1"},
+		{`let m = macro(x) { 1 }; m(1)`,
+			"1:25: error[M002]: macro m must return a quote, got INTEGER"},
+		{`let m = macro() { }; m()`,
+			"1:22: error[M002]: macro m must return a quote, got nothing"},
+		{`let m = macro() { 1 / 0 }; m()`,
+			"1:21: error[M002]: macro m failed: division by zero"},
+		{`let m = macro(x) { quote(unquote([x])) }; m(1)`,
+			"1:33: error[M002]: macro m failed: cannot unquote ARRAY"},
+	}
+
+	for _, tt := range tests {
+		program := parse(t, tt.input)
+		env := object.NewEnvironment()
+		Define(program, env)
+		diagnostics := Expand(program, env)
+		if len(diagnostics) != 1 || diagnostics[0].String() != tt.expected {
+			t.Errorf("%q: expected %q, got=%v", tt.input, tt.expected, diagnostics)
+		}
+	}
+}
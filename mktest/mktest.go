@@ -0,0 +1,200 @@
+// Package mktest runs the tests written in Monkey. A test is a function
+// bound by a let at the top level of a file named *_test.mk, to a name
+// starting with test_, that takes no arguments:
+//
+//	let test_add = fn() {
+//		assert_eq(add(1, 2), 3);
+//		assert_error(fn() { add(1, true) }, "INTEGER");
+//	};
+//
+// A test passes if calling it raises no error; the assert, assert_eq and
+// assert_error builtins raise errors of kind "assertion", which a try cannot
+// catch, so that a failed assertion always fails its test. Every test runs
+// in an interpreter of its own, which runs the top level of its file before
+// calling it, so that tests cannot see what other tests did.
+package mktest
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/interpreter"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// Suffix ends the names of the files tests are found in.
+const Suffix = "_test.mk"
+
+// Prefix starts the names of tests.
+const Prefix = "test_"
+
+// Test is a test of a file.
+type Test struct {
+	Name string
+	Pos  token.Position // of the name it is bound to
+}
+
+// Find returns the tests of program, in the order they are defined.
+func Find(program *ast.Program) []Test {
+	var tests []Test
+	for _, stmt := range program.Statements {
+		let, ok := stmt.(*ast.LetStatement)
+		if !ok || !strings.HasPrefix(let.Name.Value, Prefix) {
+			continue
+		}
+		if _, ok := let.Value.(*ast.FunctionLiteral); ok {
+			tests = append(tests, Test{Name: let.Name.Value, Pos: let.Name.Token.Pos})
+		}
+	}
+	return tests
+}
+
+// Result is the outcome of a test.
+type Result struct {
+	Test
+	Err error // why it failed, nil if it passed
+}
+
+// ErrPos returns where r failed: where its error was raised, if that is
+// known, and otherwise where the test is defined.
+func (r *Result) ErrPos() token.Position {
+	var runtimeErr *interpreter.RuntimeError
+	if errors.As(r.Err, &runtimeErr) && runtimeErr.Pos.Line > 0 {
+		return runtimeErr.Pos
+	}
+	return r.Test.Pos
+}
+
+// Run runs the tests of source, which is a file's, for which match returns
+// true, or all of them if match is nil. Every test gets an interpreter
+// created with opts. Run returns a *interpreter.ParseError if source does
+// not parse.
+func Run(source string, match func(name string) bool, opts ...interpreter.Option) ([]Result, error) {
+	program, err := interpreter.Parse(source)
+	if err != nil {
+		return nil, err
+	}
+	var results []Result
+	for _, test := range Find(program) {
+		if match != nil && !match(test.Name) {
+			continue
+		}
+		results = append(results, Result{Test: test, Err: run(source, test, opts)})
+	}
+	return results, nil
+}
+
+// run runs test in an interpreter of its own, after the top level of its
+// file, which is parsed again since running a program changes it.
+func run(source string, test Test, opts []interpreter.Option) error {
+	program, err := interpreter.Parse(source)
+	if err != nil {
+		return err
+	}
+	in := interpreter.New(opts...)
+	if _, err := in.RunProgram(program); err != nil {
+		return err
+	}
+	_, err = in.RunProgram(call(test))
+	return err
+}
+
+// call returns the program calling test without arguments, written where
+// test is defined, so that the errors the call raises point there.
+func call(test Test) *ast.Program {
+	name := &ast.Identifier{Token: token.Token{Type: token.IDENT, Literal: test.Name, Pos: test.Pos}, Value: test.Name}
+	paren := token.Token{Type: token.LPAREN, Literal: "(", Pos: test.Pos}
+	exp := &ast.CallExpression{Token: paren, Function: name}
+	return &ast.Program{Statements: []ast.Statement{&ast.ExpressionStatement{Token: name.Token, Expression: exp}}}
+}
+
+// Files returns the test files among paths, and in the directories among
+// them and below, in order. Files named explicitly are kept whatever their
+// names.
+func Files(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && p != path && strings.HasPrefix(d.Name(), ".") {
+				return filepath.SkipDir
+			}
+			if !d.IsDir() && strings.HasSuffix(d.Name(), Suffix) {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Report writes the results of the tests of the file at path, and returns
+// the number of tests that failed. Tests that passed are only listed if
+// verbose is set.
+func Report(w io.Writer, path string, results []Result, verbose bool) (failed int, err error) {
+	for _, r := range results {
+		if r.Err == nil {
+			if verbose {
+				if _, err := fmt.Fprintf(w, "--- PASS: %s (%s:%s)\n", r.Name, path, r.Test.Pos); err != nil {
+					return failed, err
+				}
+			}
+			continue
+		}
+		failed++
+		if _, err := fmt.Fprintf(w, "--- FAIL: %s (%s:%s)\n", r.Name, path, r.Test.Pos); err != nil {
+			return failed, err
+		}
+		for _, line := range r.lines() {
+			if _, err := fmt.Fprintf(w, "\t%s:%s\n", path, line); err != nil {
+				return failed, err
+			}
+		}
+	}
+	status := "ok  "
+	if failed > 0 {
+		status = "FAIL"
+	}
+	_, err = fmt.Fprintf(w, "%s\t%s\t%d passed, %d failed\n", status, path, len(results)-failed, failed)
+	return failed, err
+}
+
+// lines returns what went wrong in r, a line for each diagnostic if the
+// file did not check, each starting with its position.
+func (r *Result) lines() []string {
+	var parseErr *interpreter.ParseError
+	if errors.As(r.Err, &parseErr) && len(parseErr.Diagnostics) > 0 {
+		lines := make([]string, len(parseErr.Diagnostics))
+		for i, d := range parseErr.Diagnostics {
+			lines[i] = d.String()
+		}
+		return lines
+	}
+	message := r.Err.Error()
+	var runtimeErr *interpreter.RuntimeError
+	if errors.As(r.Err, &runtimeErr) {
+		message = runtimeErr.Message
+	}
+	return []string{r.ErrPos().String() + ": " + message}
+}
@@ -0,0 +1,128 @@
+package mktest
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/interpreter"
+)
+
+const source = `let add = fn(a, b) { a + b };
+let test_add = fn() {
+	assert_eq(add(1, 2), 3);
+};
+let test_broken = fn() {
+	assert(add(2, 2) == 5, "two and two");
+};
+let test_errors = fn() {
+	assert_error(fn() { add(1, true) }, "INTEGER");
+	assert_error(fn() { add(1, 2) });
+};
+let test_alias = test_add;
+let helper = fn() { assert(false) };`
+
+func TestRun(t *testing.T) {
+	for _, mode := range []interpreter.Mode{interpreter.Full, interpreter.Middle, interpreter.Simple} {
+		results, err := Run(source, nil, interpreter.WithMode(mode), interpreter.WithStdout(io.Discard))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		failed, err := Report(&out, "math_test.mk", results, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `--- PASS: test_add (math_test.mk:2:5)
+--- FAIL: test_broken (math_test.mk:5:5)
+	math_test.mk:6:8: assertion failed: two and two
+--- FAIL: test_errors (math_test.mk:8:5)
+	math_test.mk:10:14: assertion failed: expected an error, got 3
+FAIL	math_test.mk	1 passed, 2 failed
+`
+		if failed != 2 || out.String() != expected {
+			t.Errorf("mode %d: wrong report (%d failed).\nexpected=%q\ngot=     %q", mode, failed, expected, out.String())
+		}
+	}
+
+	results, err := Run(source, func(name string) bool { return strings.HasSuffix(name, "add") })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Name != "test_add" || results[0].Err != nil {
+		t.Errorf("wrong results of matching tests %+v", results)
+	}
+
+	// A failing top level fails every test, where it fails.
+	results, err = Run("let test_a = fn() {};\nlet x = assert(false);", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err == nil || results[0].ErrPos().String() != "2:15" {
+		t.Errorf("wrong results of a failing top level %+v", results)
+	}
+	var out bytes.Buffer
+	results, err = Run("let test_a = fn() { 1 + true };", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Report(&out, "a_test.mk", results, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "\ta_test.mk:1:23: error[") {
+		t.Errorf("type errors not reported with their positions.\n%s", out.String())
+	}
+	// A try cannot swallow a failed assertion, nor can assert_error.
+	results, err = Run(`let test_a = fn() { try { assert_eq(1, 2) } catch (e) { } };
+let test_b = fn() { try { 1 } finally { assert(false) } };
+let test_c = fn() { assert_error(fn() { assert(false) }) };`, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range results {
+		if r.Err == nil {
+			t.Errorf("%s passed despite a failed assertion", r.Name)
+		}
+	}
+
+	// Calling a test that takes arguments fails where it is defined.
+	results, err = Run("let x = 1;\nlet test_a = fn(a) { a };", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0].Err == nil || results[0].ErrPos().String() != "2:5" {
+		t.Errorf("wrong results of a test taking arguments %+v", results)
+	}
+	if _, err := Run("let test_a = ;", nil); err == nil {
+		t.Error("expected a parse error")
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b_test.mk", "a.mk", "sub/c_test.mk", ".hidden/d_test.mk", "e_test.mk.bak"} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	files, err := Files([]string{dir, filepath.Join(dir, "a.mk")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range files {
+		files[i], _ = filepath.Rel(dir, f)
+	}
+	if got := strings.Join(files, " "); got != "a.mk b_test.mk sub/c_test.mk" {
+		t.Errorf("wrong files %q", got)
+	}
+	if _, err := Files([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
@@ -0,0 +1,171 @@
+package object
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ApplyFunc applies a function, builtin or not, to args in the evaluation
+// of the builtin it is passed to.
+type ApplyFunc func(fn Object, args ...Object) Object
+
+// Caller is a builtin that calls the functions it is passed, such as
+// assert_error. Evaluators pass Fn the ApplyFunc of the call.
+type Caller struct {
+	Name string
+	Fn   func(apply ApplyFunc, args ...Object) Object
+}
+
+func (c *Caller) Type() ObjectType { return BUILTIN_OBJ }
+func (c *Caller) Inspect() string  { return "builtin function" }
+
+// Assert implements assert(condition, message?): it fails unless
+// condition is neither false nor null.
+func Assert(args ...Object) Object {
+	if err := checkAssertArgs(args, 1); err != nil {
+		return err
+	}
+	switch v := args[0].(type) {
+	case *Null:
+	case *Boolean:
+		if v.Value {
+			return &Null{}
+		}
+	default:
+		return &Null{}
+	}
+	return assertionFailed(args[1:], "")
+}
+
+// AssertEq implements assert_eq(actual, expected, message?): it fails
+// unless actual and expected are Equal.
+func AssertEq(args ...Object) Object {
+	if err := checkAssertArgs(args, 2); err != nil {
+		return err
+	}
+	if Equal(args[0], args[1]) {
+		return &Null{}
+	}
+	return assertionFailed(args[2:], fmt.Sprintf("expected %s, got %s", show(args[1]), show(args[0])))
+}
+
+// AssertError implements assert_error(fn, part?): it calls fn without
+// arguments and fails unless fn raises an error that a try could catch,
+// with part in its message if it is given. The errors a try cannot catch
+// are returned as they are.
+func AssertError(apply ApplyFunc, args ...Object) Object {
+	if err := checkAssertArgs(args, 1); err != nil {
+		return err
+	}
+	var part *String
+	if len(args) == 2 {
+		s, ok := args[1].(*String)
+		if !ok {
+			return &Error{Message: fmt.Sprintf("argument to `assert_error` must be a string, got %s", args[1].Type()), Kind: RuntimeError}
+		}
+		part = s
+	}
+	result := apply(args[0])
+	err, ok := result.(*Error)
+	switch {
+	case !ok:
+		return assertionFailed(nil, "expected an error, got "+show(result))
+	case !err.Catchable():
+		return err
+	case part != nil && !strings.Contains(err.Message, part.Value):
+		return assertionFailed(nil, fmt.Sprintf("expected an error containing %q, got %q", part.Value, err.Message))
+	}
+	return &Null{}
+}
+
+// checkAssertArgs checks that an assertion taking n values was given them,
+// and optionally a message.
+func checkAssertArgs(args []Object, n int) *Error {
+	if len(args) != n && len(args) != n+1 {
+		return &Error{Message: fmt.Sprintf("wrong number of arguments. got=%d, want=%d or %d", len(args), n, n+1), Kind: RuntimeError}
+	}
+	return nil
+}
+
+// assertionFailed returns the error of a failed assertion, with the
+// message given to it, if any, before what went wrong.
+func assertionFailed(message []Object, what string) *Error {
+	parts := []string{"assertion failed"}
+	if len(message) == 1 {
+		if s, ok := message[0].(*String); ok {
+			parts = append(parts, s.Value)
+		} else {
+			parts = append(parts, message[0].Inspect())
+		}
+	}
+	if what != "" {
+		parts = append(parts, what)
+	}
+	return &Error{Message: strings.Join(parts, ": "), Kind: AssertionError}
+}
+
+// show returns v as written in programs, quoting strings.
+func show(v Object) string {
+	if s, ok := v.(*String); ok {
+		return fmt.Sprintf("%q", s.Value)
+	}
+	if v == nil {
+		return "nothing"
+	}
+	return v.Inspect()
+}
+
+// Equal reports whether a and b are the same value: equal integers,
+// booleans or strings, null, or arrays, hashes and structs of the same
+// type whose elements are Equal. Functions are only equal to themselves.
+func Equal(a, b Object) bool {
+	switch a := a.(type) {
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Null:
+		_, ok := b.(*Null)
+		return ok
+	case *Array:
+		b, ok := b.(*Array)
+		if !ok || len(a.Elements) != len(b.Elements) {
+			return false
+		}
+		for i, e := range a.Elements {
+			if !Equal(e, b.Elements[i]) {
+				return false
+			}
+		}
+		return true
+	case *Hash:
+		b, ok := b.(*Hash)
+		if !ok || len(a.Pairs) != len(b.Pairs) {
+			return false
+		}
+		for key, pair := range a.Pairs {
+			other, ok := b.Pairs[key]
+			if !ok || !Equal(pair.Value, other.Value) {
+				return false
+			}
+		}
+		return true
+	case *Struct:
+		b, ok := b.(*Struct)
+		if !ok || a.Def != b.Def {
+			return false
+		}
+		for i, f := range a.Fields {
+			if !Equal(f, b.Fields[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
@@ -0,0 +1,65 @@
+package object
+
+import "testing"
+
+func TestAssertions(t *testing.T) {
+	one, two := &Integer{Value: 1}, &Integer{Value: 2}
+	apply := func(fn Object, args ...Object) Object { return fn }
+	tests := []struct {
+		result   Object
+		expected string // the message of the error, or "" if it passed
+	}{
+		{Assert(&Boolean{Value: true}), ""},
+		{Assert(one), ""},
+		{Assert(&Null{}), "assertion failed"},
+		{Assert(&Boolean{Value: false}, &String{Value: "why"}), "assertion failed: why"},
+		{Assert(), "wrong number of arguments. got=0, want=1 or 2"},
+		{AssertEq(&Array{Elements: []Object{one, two}}, &Array{Elements: []Object{one, two}}), ""},
+		{AssertEq(&String{Value: "a"}, &String{Value: "b"}, &String{Value: "strings"}), `assertion failed: strings: expected "b", got "a"`},
+		{AssertEq(one, &String{Value: "1"}), `assertion failed: expected "1", got 1`},
+		{AssertError(apply, &Error{Message: "boom", Kind: ThrownError}), ""},
+		{AssertError(apply, &Error{Message: "boom"}, &String{Value: "bang"}), `assertion failed: expected an error containing "bang", got "boom"`},
+		{AssertError(apply, one), "assertion failed: expected an error, got 1"},
+		{AssertError(apply, &Error{Message: "out of gas", Kind: OutOfGasError}), "out of gas"},
+		{AssertError(apply, &Error{Message: "assertion failed", Kind: AssertionError}), "assertion failed"},
+	}
+	for i, tt := range tests {
+		err, _ := tt.result.(*Error)
+		switch {
+		case tt.expected == "" && err != nil:
+			t.Errorf("%d: unexpected error %q", i, err.Message)
+		case tt.expected != "" && (err == nil || err.Message != tt.expected):
+			t.Errorf("%d: expected error %q, got %s", i, tt.expected, tt.result.Inspect())
+		case err != nil && err.Message != "out of gas" && err.Kind != AssertionError && err.Kind != RuntimeError:
+			t.Errorf("%d: wrong kind %q", i, err.Kind)
+		}
+	}
+}
+
+func TestEqual(t *testing.T) {
+	def := &StructType{Name: "P", Fields: []string{"x"}}
+	hash := func(v Object) *Hash {
+		key := &String{Value: "k"}
+		return &Hash{Pairs: map[HashKey]HashPair{key.HashKey(): {Key: key, Value: v}}}
+	}
+	fn := &Function{}
+	tests := []struct {
+		a, b     Object
+		expected bool
+	}{
+		{&Integer{Value: 1}, &Integer{Value: 1}, true},
+		{&Integer{Value: 1}, &Boolean{Value: true}, false},
+		{&Null{}, &Null{}, true},
+		{hash(&String{Value: "v"}), hash(&String{Value: "v"}), true},
+		{hash(&String{Value: "v"}), hash(&String{Value: "w"}), false},
+		{&Struct{Def: def, Fields: []Object{&Integer{Value: 1}}}, &Struct{Def: def, Fields: []Object{&Integer{Value: 1}}}, true},
+		{&Struct{Def: def, Fields: []Object{&Integer{Value: 1}}}, &Struct{Def: &StructType{Name: "P"}, Fields: []Object{&Integer{Value: 1}}}, false},
+		{fn, fn, true},
+		{fn, &Function{}, false},
+	}
+	for i, tt := range tests {
+		if got := Equal(tt.a, tt.b); got != tt.expected {
+			t.Errorf("%d: Equal(%s, %s) = %t", i, tt.a.Inspect(), tt.b.Inspect(), got)
+		}
+	}
+}
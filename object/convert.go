@@ -0,0 +1,516 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// tagName is the struct tag that renames or skips fields when converting
+// between structs and hashes, e.g. `monkey:"name"` or `monkey:"-"`.
+const tagName = "monkey"
+
+var (
+	objectType = reflect.TypeOf((*Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// FromGo converts a Go value to a Monkey object. Integers become INTEGER,
+// slices and arrays become ARRAY, maps and structs become HASH and functions
+// are wrapped with WrapFunc. Values that already are objects are returned
+// as is.
+func FromGo(v interface{}) (Object, error) {
+	if v == nil {
+		return &Null{}, nil
+	}
+	if obj, ok := v.(Object); ok {
+		return obj, nil
+	}
+	return fromValue(reflect.ValueOf(v))
+}
+
+// ref identifies a pointer, map or slice that is being converted. Slices
+// are told apart by length as well, since a slice and its prefix share a
+// data pointer.
+type ref struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// converter tracks the references on the path from the value passed to
+// FromGo, so that cyclic values are reported instead of recursing forever.
+type converter struct {
+	path map[ref]bool
+}
+
+func fromValue(v reflect.Value) (Object, error) {
+	c := &converter{path: map[ref]bool{}}
+	return c.value(v)
+}
+
+// enter marks v as being converted and reports whether it already was.
+func (c *converter) enter(v reflect.Value) (ref, bool) {
+	r := ref{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		r.len = v.Len()
+	}
+	if c.path[r] {
+		return r, false
+	}
+	c.path[r] = true
+	return r, true
+}
+
+func (c *converter) value(v reflect.Value) (Object, error) {
+	if v.IsValid() && v.Type().Implements(objectType) {
+		if v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return &Null{}, nil
+			}
+		}
+		return v.Interface().(Object), nil
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		return &Null{}, nil
+	case reflect.Bool:
+		return &Boolean{Value: v.Bool()}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%d overflows INTEGER", v.Uint())
+		}
+		return &Integer{Value: int64(v.Uint())}, nil
+	case reflect.String:
+		return &String{Value: v.String()}, nil
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return &Null{}, nil
+		}
+		if v.Kind() == reflect.Interface {
+			return c.value(v.Elem())
+		}
+		r, ok := c.enter(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert cyclic %s", v.Type())
+		}
+		defer delete(c.path, r)
+		return c.value(v.Elem())
+	case reflect.Slice:
+		if v.IsNil() {
+			return &Null{}, nil
+		}
+		r, ok := c.enter(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert cyclic %s", v.Type())
+		}
+		defer delete(c.path, r)
+		return c.list(v)
+	case reflect.Array:
+		return c.list(v)
+	case reflect.Map:
+		if v.IsNil() {
+			return &Null{}, nil
+		}
+		r, ok := c.enter(v)
+		if !ok {
+			return nil, fmt.Errorf("cannot convert cyclic %s", v.Type())
+		}
+		defer delete(c.path, r)
+		return c.hash(v)
+	case reflect.Struct:
+		return c.structure(v)
+	case reflect.Func:
+		if v.IsNil() {
+			return &Null{}, nil
+		}
+		return WrapFunc("", v.Interface())
+	default:
+		return nil, fmt.Errorf("cannot convert %s to an object", v.Type())
+	}
+}
+
+func (c *converter) list(v reflect.Value) (Object, error) {
+	elements := make([]Object, v.Len())
+	for i := range elements {
+		el, err := c.value(v.Index(i))
+		if err != nil {
+			return nil, fmt.Errorf("index %d: %w", i, err)
+		}
+		elements[i] = el
+	}
+	return &Array{Elements: elements}, nil
+}
+
+func (c *converter) hash(v reflect.Value) (Object, error) {
+	hash := &Hash{Pairs: make(map[HashKey]HashPair, v.Len())}
+	iter := v.MapRange()
+	for iter.Next() {
+		key, err := c.value(iter.Key())
+		if err != nil {
+			return nil, err
+		}
+		hashable, ok := key.(Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", key.Type())
+		}
+		value, err := c.value(iter.Value())
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %w", key.Inspect(), err)
+		}
+		hash.Pairs[hashable.HashKey()] = HashPair{Key: key, Value: value}
+	}
+	return hash, nil
+}
+
+func (c *converter) structure(v reflect.Value) (Object, error) {
+	hash := &Hash{Pairs: make(map[HashKey]HashPair)}
+	for _, field := range structFields(v.Type()) {
+		value, err := c.value(v.FieldByIndex(field.index))
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.name, err)
+		}
+		key := &String{Value: field.name}
+		hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: value}
+	}
+	return hash, nil
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields lists the exported fields of t under the name given by their
+// tag, if any.
+func structFields(t reflect.Type) []structField {
+	fields := []structField{}
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() || f.Anonymous {
+			continue
+		}
+		name := f.Name
+		if tag, ok := f.Tag.Lookup(tagName); ok {
+			tag, _, _ = strings.Cut(tag, ",")
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, index: f.Index})
+	}
+	return fields
+}
+
+// ToGo converts an object to its natural Go representation: int64, bool,
+// string, nil, []interface{} or map[interface{}]interface{}. Structs become
+// a map[string]interface{} of their fields. Other objects, such as
+// functions, are returned unchanged.
+func ToGo(obj Object) interface{} {
+	switch obj := obj.(type) {
+	case nil, *Null:
+		return nil
+	case *Integer:
+		return obj.Value
+	case *Boolean:
+		return obj.Value
+	case *String:
+		return obj.Value
+	case *Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, el := range obj.Elements {
+			elements[i] = ToGo(el)
+		}
+		return elements
+	case *Hash:
+		m := make(map[interface{}]interface{}, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			m[ToGo(pair.Key)] = ToGo(pair.Value)
+		}
+		return m
+	case *Struct:
+		m := make(map[string]interface{}, len(obj.Fields))
+		for i, value := range obj.Fields {
+			m[obj.Def.Fields[i]] = ToGo(value)
+		}
+		return m
+	default:
+		return obj
+	}
+}
+
+// Decode stores obj in the value pointed to by target, converting it to the
+// target type. Hashes and structs decode into maps and into Go structs,
+// whose fields are matched by tag or name.
+func Decode(obj Object, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Pointer || v.IsNil() {
+		return errors.New("decode target must be a non-nil pointer")
+	}
+	return decodeValue(obj, v.Elem())
+}
+
+func decodeValue(obj Object, v reflect.Value) error {
+	if v.Type() == objectType {
+		if obj == nil {
+			v.Set(reflect.Zero(objectType))
+		} else {
+			v.Set(reflect.ValueOf(obj))
+		}
+		return nil
+	}
+
+	if _, ok := obj.(*Null); ok || obj == nil {
+		switch v.Kind() {
+		case reflect.Pointer, reflect.Interface, reflect.Slice, reflect.Map, reflect.Func:
+			v.Set(reflect.Zero(v.Type()))
+			return nil
+		}
+		return mismatch(obj, v.Type())
+	}
+
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.Type().NumMethod() != 0 {
+			if reflect.TypeOf(obj).Implements(v.Type()) {
+				v.Set(reflect.ValueOf(obj))
+				return nil
+			}
+			return mismatch(obj, v.Type())
+		}
+		if goValue := ToGo(obj); goValue != nil {
+			v.Set(reflect.ValueOf(goValue))
+		}
+		return nil
+	case reflect.Pointer:
+		if reflect.TypeOf(obj) == v.Type() {
+			v.Set(reflect.ValueOf(obj))
+			return nil
+		}
+		elem := reflect.New(v.Type().Elem())
+		if err := decodeValue(obj, elem.Elem()); err != nil {
+			return err
+		}
+		v.Set(elem)
+		return nil
+	case reflect.Bool:
+		b, ok := obj.(*Boolean)
+		if !ok {
+			return mismatch(obj, v.Type())
+		}
+		v.SetBool(b.Value)
+		return nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		i, ok := obj.(*Integer)
+		if !ok {
+			return mismatch(obj, v.Type())
+		}
+		if v.OverflowInt(i.Value) {
+			return fmt.Errorf("%d overflows %s", i.Value, v.Type())
+		}
+		v.SetInt(i.Value)
+		return nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		i, ok := obj.(*Integer)
+		if !ok {
+			return mismatch(obj, v.Type())
+		}
+		if i.Value < 0 || v.OverflowUint(uint64(i.Value)) {
+			return fmt.Errorf("%d overflows %s", i.Value, v.Type())
+		}
+		v.SetUint(uint64(i.Value))
+		return nil
+	case reflect.String:
+		s, ok := obj.(*String)
+		if !ok {
+			return mismatch(obj, v.Type())
+		}
+		v.SetString(s.Value)
+		return nil
+	case reflect.Slice:
+		array, ok := obj.(*Array)
+		if !ok {
+			return mismatch(obj, v.Type())
+		}
+		slice := reflect.MakeSlice(v.Type(), len(array.Elements), len(array.Elements))
+		for i, el := range array.Elements {
+			if err := decodeValue(el, slice.Index(i)); err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+		v.Set(slice)
+		return nil
+	case reflect.Array:
+		array, ok := obj.(*Array)
+		if !ok {
+			return mismatch(obj, v.Type())
+		}
+		if len(array.Elements) != v.Len() {
+			return fmt.Errorf("cannot decode ARRAY of length %d into %s",
+				len(array.Elements), v.Type())
+		}
+		for i, el := range array.Elements {
+			if err := decodeValue(el, v.Index(i)); err != nil {
+				return fmt.Errorf("index %d: %w", i, err)
+			}
+		}
+		return nil
+	case reflect.Map:
+		hash, ok := hashOf(obj)
+		if !ok {
+			return mismatch(obj, v.Type())
+		}
+		m := reflect.MakeMapWithSize(v.Type(), len(hash.Pairs))
+		for _, pair := range hash.Pairs {
+			key := reflect.New(v.Type().Key()).Elem()
+			if err := decodeValue(pair.Key, key); err != nil {
+				return err
+			}
+			value := reflect.New(v.Type().Elem()).Elem()
+			if err := decodeValue(pair.Value, value); err != nil {
+				return fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+			}
+			m.SetMapIndex(key, value)
+		}
+		v.Set(m)
+		return nil
+	case reflect.Struct:
+		hash, ok := hashOf(obj)
+		if !ok {
+			return mismatch(obj, v.Type())
+		}
+		for _, field := range structFields(v.Type()) {
+			key := &String{Value: field.name}
+			pair, ok := hash.Pairs[key.HashKey()]
+			if !ok {
+				continue
+			}
+			if err := decodeValue(pair.Value, v.FieldByIndex(field.index)); err != nil {
+				return fmt.Errorf("field %s: %w", field.name, err)
+			}
+		}
+		return nil
+	default:
+		return mismatch(obj, v.Type())
+	}
+}
+
+// hashOf returns obj as a hash, with the fields of a struct under their
+// names.
+func hashOf(obj Object) (*Hash, bool) {
+	switch obj := obj.(type) {
+	case *Hash:
+		return obj, true
+	case *Struct:
+		hash := &Hash{Pairs: make(map[HashKey]HashPair, len(obj.Fields))}
+		for i, value := range obj.Fields {
+			key := &String{Value: obj.Def.Fields[i]}
+			hash.Pairs[key.HashKey()] = HashPair{Key: key, Value: value}
+		}
+		return hash, true
+	}
+	return nil, false
+}
+
+func mismatch(obj Object, t reflect.Type) error {
+	if obj == nil {
+		return fmt.Errorf("cannot decode nil into %s", t)
+	}
+	return fmt.Errorf("cannot decode %s into %s", obj.Type(), t)
+}
+
+// WrapFunc turns a Go function into a builtin. Arguments are decoded into
+// the parameter types of fn and checked before it is called. fn may return
+// nothing, a value, an error, or a value and an error; a non-nil error is
+// reported to the script as an error object.
+func WrapFunc(name string, fn interface{}) (*Builtin, error) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		return nil, fmt.Errorf("cannot wrap %T as a builtin", fn)
+	}
+	t := v.Type()
+
+	switch t.NumOut() {
+	case 0, 1:
+	case 2:
+		if t.Out(1) != errorType {
+			return nil, fmt.Errorf("second result of %s must be an error", t)
+		}
+	default:
+		return nil, fmt.Errorf("%s returns too many results", t)
+	}
+
+	label := name
+	if label == "" {
+		label = "builtin"
+	}
+
+	return &Builtin{
+		Name: name,
+		Fn: func(args ...Object) Object {
+			in, errObj := decodeArgs(label, t, args)
+			if errObj != nil {
+				return errObj
+			}
+			return convertResults(t, v.Call(in))
+		},
+	}, nil
+}
+
+func decodeArgs(name string, t reflect.Type, args []Object) ([]reflect.Value, *Error) {
+	fixed := t.NumIn()
+	if t.IsVariadic() {
+		fixed--
+		if len(args) < fixed {
+			return nil, &Error{Message: fmt.Sprintf(
+				"wrong number of arguments. got=%d, want at least %d", len(args), fixed)}
+		}
+	} else if len(args) != fixed {
+		return nil, &Error{Message: fmt.Sprintf(
+			"wrong number of arguments. got=%d, want=%d", len(args), fixed)}
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var paramType reflect.Type
+		if i < fixed {
+			paramType = t.In(i)
+		} else {
+			paramType = t.In(fixed).Elem()
+		}
+		value := reflect.New(paramType).Elem()
+		if err := decodeValue(arg, value); err != nil {
+			return nil, &Error{Message: fmt.Sprintf(
+				"argument %d to `%s` not supported: %s", i+1, name, err)}
+		}
+		in[i] = value
+	}
+	return in, nil
+}
+
+func convertResults(t reflect.Type, out []reflect.Value) Object {
+	if len(out) == 0 {
+		return &Null{}
+	}
+	last := out[len(out)-1]
+	if t.Out(len(out)-1) == errorType {
+		if !last.IsNil() {
+			return &Error{Message: last.Interface().(error).Error()}
+		}
+		if len(out) == 1 {
+			return &Null{}
+		}
+	}
+	obj, err := fromValue(out[0])
+	if err != nil {
+		return &Error{Message: err.Error()}
+	}
+	return obj
+}
@@ -0,0 +1,201 @@
+package object
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X      int64  `monkey:"x"`
+	Y      int64  `monkey:"y"`
+	Label  string `monkey:"label"`
+	Hidden string `monkey:"-"`
+}
+
+func TestFromGo(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{nil, "null"},
+		{5, "5"},
+		{uint8(7), "7"},
+		{true, "true"},
+		{"hello", "hello"},
+		{[]int{1, 2, 3}, "[1, 2, 3]"},
+		{[2]string{"a", "b"}, "[a, b]"},
+		{map[string]int{"b": 2, "a": 1}, "{a: 1, b: 2}"},
+		{map[int]bool{2: false, 1: true}, "{1: true, 2: false}"},
+		{point{X: 1, Y: 2, Label: "p", Hidden: "h"}, "{label: p, x: 1, y: 2}"},
+		{&point{X: 3}, "{label: , x: 3, y: 0}"},
+		{(*point)(nil), "null"},
+		{&Integer{Value: 9}, "9"},
+	}
+
+	for _, tt := range tests {
+		obj, err := FromGo(tt.input)
+		if err != nil {
+			t.Errorf("FromGo(%#v) returned error: %s", tt.input, err)
+			continue
+		}
+		if obj.Inspect() != tt.expected {
+			t.Errorf("FromGo(%#v) wrong. expected=%q, got=%q",
+				tt.input, tt.expected, obj.Inspect())
+		}
+	}
+
+	if _, err := FromGo(1.5); err == nil {
+		t.Errorf("expected error converting float64")
+	}
+	if _, err := FromGo(uint64(1 << 63)); err == nil {
+		t.Errorf("expected overflow error")
+	}
+}
+
+type node struct {
+	Name string `monkey:"name"`
+	Next *node  `monkey:"next"`
+}
+
+func TestFromGoCycles(t *testing.T) {
+	loop := &node{Name: "a"}
+	loop.Next = loop
+
+	m := map[string]interface{}{}
+	m["self"] = m
+
+	s := []interface{}{nil}
+	s[0] = s
+
+	for _, input := range []interface{}{loop, m, s} {
+		if _, err := FromGo(input); err == nil || !strings.Contains(err.Error(), "cyclic") {
+			t.Errorf("FromGo(%T) expected cycle error, got=%v", input, err)
+		}
+	}
+
+	shared := &node{Name: "b"}
+	obj, err := FromGo([]*node{shared, shared})
+	if err != nil {
+		t.Fatalf("FromGo of shared pointers returned error: %s", err)
+	}
+	if obj.Inspect() != "[{name: b, next: null}, {name: b, next: null}]" {
+		t.Errorf("shared pointers converted wrong. got=%q", obj.Inspect())
+	}
+}
+
+func TestToGo(t *testing.T) {
+	obj, _ := FromGo(map[string]interface{}{
+		"n":    5,
+		"list": []interface{}{"a", true, nil},
+	})
+
+	expected := map[interface{}]interface{}{
+		"n":    int64(5),
+		"list": []interface{}{"a", true, nil},
+	}
+	if got := ToGo(obj); !reflect.DeepEqual(got, expected) {
+		t.Errorf("ToGo wrong. expected=%#v, got=%#v", expected, got)
+	}
+}
+
+func TestDecode(t *testing.T) {
+	obj, _ := FromGo(map[string]interface{}{"x": 1, "y": 2, "label": "origin"})
+
+	var p point
+	if err := Decode(obj, &p); err != nil {
+		t.Fatalf("Decode returned error: %s", err)
+	}
+	if p != (point{X: 1, Y: 2, Label: "origin"}) {
+		t.Errorf("Decode wrong. got=%+v", p)
+	}
+
+	var m map[string]int
+	if err := Decode(obj, &m); err == nil {
+		t.Errorf("expected error decoding a STRING into int")
+	}
+
+	list, _ := FromGo([]int{1, 2})
+	var ints []int8
+	if err := Decode(list, &ints); err != nil || !reflect.DeepEqual(ints, []int8{1, 2}) {
+		t.Errorf("Decode wrong. got=%v, err=%v", ints, err)
+	}
+
+	if err := Decode(&Integer{Value: 300}, new(int8)); err == nil {
+		t.Errorf("expected overflow error")
+	}
+	if err := Decode(list, p); err == nil {
+		t.Errorf("expected error decoding into a non-pointer")
+	}
+}
+
+func TestStructsToGo(t *testing.T) {
+	def := &StructType{Name: "Point", Fields: []string{"x", "y", "label"}}
+	newPoint := func(x, y int64, label string) *Struct {
+		return &Struct{Def: def, Fields: []Object{
+			&Integer{Value: x}, &Integer{Value: y}, &String{Value: label}}}
+	}
+
+	expected := map[string]interface{}{"x": int64(1), "y": int64(2), "label": "a"}
+	if got := ToGo(newPoint(1, 2, "a")); !reflect.DeepEqual(got, expected) {
+		t.Errorf("ToGo wrong. expected=%#v, got=%#v", expected, got)
+	}
+
+	var p point
+	if err := Decode(newPoint(1, 2, "a"), &p); err != nil || p != (point{X: 1, Y: 2, Label: "a"}) {
+		t.Errorf("Decode wrong. got=%+v, err=%v", p, err)
+	}
+
+	list := &Array{Elements: []Object{newPoint(1, 2, "a"), newPoint(3, 4, "b")}}
+	var points []point
+	if err := Decode(list, &points); err != nil {
+		t.Fatalf("Decode returned error: %s", err)
+	}
+	if !reflect.DeepEqual(points, []point{{X: 1, Y: 2, Label: "a"}, {X: 3, Y: 4, Label: "b"}}) {
+		t.Errorf("Decode wrong. got=%+v", points)
+	}
+
+	var m map[string]interface{}
+	if err := Decode(newPoint(1, 2, "a"), &m); err != nil || !reflect.DeepEqual(m, expected) {
+		t.Errorf("Decode wrong. got=%#v, err=%v", m, err)
+	}
+}
+
+func TestWrapFunc(t *testing.T) {
+	add, err := WrapFunc("add", func(a, b int) int { return a + b })
+	if err != nil {
+		t.Fatalf("WrapFunc returned error: %s", err)
+	}
+	testObject(t, add.Fn(&Integer{Value: 2}, &Integer{Value: 3}), "5")
+	testObject(t, add.Fn(&Integer{Value: 2}), "ERROR: wrong number of arguments. got=1, want=2")
+	testObject(t, add.Fn(&Integer{Value: 2}, &String{Value: "x"}),
+		"ERROR: argument 2 to `add` not supported: cannot decode STRING into int")
+
+	join, _ := WrapFunc("join", func(sep string, parts ...string) string {
+		out := ""
+		for i, p := range parts {
+			if i > 0 {
+				out += sep
+			}
+			out += p
+		}
+		return out
+	})
+	testObject(t, join.Fn(&String{Value: "-"}, &String{Value: "a"}, &String{Value: "b"}), "a-b")
+	testObject(t, join.Fn(), "ERROR: wrong number of arguments. got=0, want at least 1")
+
+	fail, _ := WrapFunc("fail", func() (int, error) { return 0, errors.New("boom") })
+	testObject(t, fail.Fn(), "ERROR: boom")
+
+	if _, err := WrapFunc("bad", func() (int, int) { return 0, 0 }); err == nil {
+		t.Errorf("expected error wrapping func with two non-error results")
+	}
+}
+
+func testObject(t *testing.T, obj Object, expected string) {
+	t.Helper()
+	if obj.Inspect() != expected {
+		t.Errorf("wrong object. expected=%q, got=%q", expected, obj.Inspect())
+	}
+}
@@ -1,8 +1,16 @@
 package object
 
+import (
+	"sort"
+
+	"github.com/SebastiaanWouters/verigo/gas"
+)
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
+	env.meter = outer.meter
+	env.hook = outer.hook
 	return env
 }
 func NewEnvironment() *Environment {
@@ -10,19 +18,117 @@ func NewEnvironment() *Environment {
 	return &Environment{store: s, outer: nil}
 }
 
+// NewMeteredEnvironment returns a root environment whose evaluation, and that
+// of every environment enclosed by it, is charged against m.
+func NewMeteredEnvironment(m *gas.Meter) *Environment {
+	env := NewEnvironment()
+	env.meter = m
+	return env
+}
+
+// NewFunctionEnvironment returns the environment of a call to a resolved
+// function, which keeps the variables named by names in slots.
+func NewFunctionEnvironment(outer *Environment, names []string) *Environment {
+	return &Environment{
+		outer: outer,
+		meter: outer.meter,
+		hook:  outer.hook,
+		slots: make([]Object, len(names)),
+		names: names,
+	}
+}
+
 type Environment struct {
 	store map[string]Object
 	outer *Environment
+	meter *gas.Meter
+	hook  Hook
+	// slots hold the variables of a resolved function, named by names. A
+	// nil slot has not been assigned yet.
+	slots []Object
+	names []string
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
 	obj, ok := e.store[name]
+	if !ok {
+		obj, ok = e.getSlotByName(name)
+	}
 	if !ok && e.outer != nil {
 		obj, ok = e.outer.Get(name)
 	}
 	return obj, ok
 }
 func (e *Environment) Set(name string, val Object) Object {
+	for i, n := range e.names {
+		if n == name {
+			e.slots[i] = val
+			return val
+		}
+	}
+	if e.store == nil {
+		e.store = make(map[string]Object)
+	}
 	e.store[name] = val
 	return val
 }
+
+// GetSlot returns the variable in slot of the function environment depth
+// levels out from e.
+func (e *Environment) GetSlot(depth, slot int) (Object, bool) {
+	for ; depth > 0 && e != nil; depth-- {
+		e = e.outer
+	}
+	if e == nil || slot >= len(e.slots) || e.slots[slot] == nil {
+		return nil, false
+	}
+	return e.slots[slot], true
+}
+
+func (e *Environment) SetSlot(slot int, val Object) Object {
+	e.slots[slot] = val
+	return val
+}
+
+func (e *Environment) getSlotByName(name string) (Object, bool) {
+	for i, n := range e.names {
+		if n == name && e.slots[i] != nil {
+			return e.slots[i], true
+		}
+	}
+	return nil, false
+}
+
+func (e *Environment) Meter() *gas.Meter {
+	return e.meter
+}
+
+// SetHook makes h observe evaluations in e and in the environments enclosed
+// by it from now on.
+func (e *Environment) SetHook(h Hook) {
+	e.hook = h
+}
+
+func (e *Environment) Hook() Hook {
+	return e.hook
+}
+
+// Outer returns the environment enclosing e, or nil if e is a root.
+func (e *Environment) Outer() *Environment {
+	return e.outer
+}
+
+// Names returns the sorted names of the variables defined in e itself.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	for i, name := range e.names {
+		if _, ok := e.store[name]; !ok && e.slots[i] != nil {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,125 @@
+package object
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// Error kinds. Scripts can catch errors of every kind but AssertionError,
+// OutOfGasError and CanceledError, which always end the evaluation: a try
+// around a failed assertion cannot make the test it is in pass.
+const (
+	RuntimeError   = "runtime"
+	ThrownError    = "thrown"
+	AssertionError = "assertion" // raised by a failed assert, assert_eq or assert_error
+	OutOfGasError  = "out of gas"
+	CanceledError  = "canceled"
+)
+
+// Catchable reports whether a try can catch e.
+func (e *Error) Catchable() bool {
+	return e.Kind != AssertionError && e.Kind != OutOfGasError && e.Kind != CanceledError
+}
+
+// At sets the position of e to pos unless it is already known, and returns
+// e.
+func (e *Error) At(pos token.Position) *Error {
+	if e.Pos.Line == 0 {
+		e.Pos = pos
+	}
+	return e
+}
+
+// Value returns the hash a catch block binds e to, with the keys message,
+// kind, line and column.
+func (e *Error) Value() *Hash {
+	kind := e.Kind
+	if kind == "" {
+		kind = RuntimeError
+	}
+	fields := []struct {
+		key   string
+		value Object
+	}{
+		{"message", &String{Value: e.Message}},
+		{"kind", &String{Value: kind}},
+		{"line", &Integer{Value: int64(e.Pos.Line)}},
+		{"column", &Integer{Value: int64(e.Pos.Column)}},
+	}
+	pairs := make(map[HashKey]HashPair, len(fields))
+	for _, f := range fields {
+		key := &String{Value: f.key}
+		pairs[key.HashKey()] = HashPair{Key: key, Value: f.value}
+	}
+	return &Hash{Pairs: pairs}
+}
+
+// Throw returns the error raised by throwing v at pos. A string becomes the
+// message of a ThrownError. A hash, such as one a catch block was bound to,
+// gives the message and optionally the kind and position; the kinds that
+// cannot be caught cannot be thrown either. Any other value is thrown with
+// its Inspect as the message.
+func Throw(v Object, pos token.Position) *Error {
+	e := &Error{Message: v.Inspect(), Kind: ThrownError, Pos: pos}
+	switch v := v.(type) {
+	case *String:
+		e.Message = v.Value
+	case *Hash:
+		if s, ok := hashField(v, "message").(*String); ok {
+			e.Message = s.Value
+		}
+		if s, ok := hashField(v, "kind").(*String); ok {
+			e.Kind = s.Value
+			if !e.Catchable() {
+				return &Error{Message: "cannot throw an error of kind " + s.Value, Kind: RuntimeError, Pos: pos}
+			}
+		}
+		line, lok := hashField(v, "line").(*Integer)
+		column, cok := hashField(v, "column").(*Integer)
+		if lok && cok && line.Value > 0 {
+			e.Pos = token.Position{Line: int(line.Value), Column: int(column.Value)}
+		}
+	}
+	return e
+}
+
+func hashField(h *Hash, key string) Object {
+	return h.Pairs[(&String{Value: key}).HashKey()].Value
+}
+
+// MeterError converts an error returned by the meter of an environment.
+func MeterError(err error) *Error {
+	kind := RuntimeError
+	switch {
+	case errors.Is(err, gas.ErrOutOfGas):
+		kind = OutOfGasError
+	case errors.Is(err, gas.ErrCanceled):
+		kind = CanceledError
+	}
+	return &Error{Message: err.Error(), Kind: kind}
+}
+
+// CheckArity returns the error of calling f with n arguments, or nil if f
+// accepts that many.
+func (f *Function) CheckArity(n int) *Error {
+	min, max := ast.Arity(f.Parameters, f.Variadic)
+	var want string
+	switch {
+	case n >= min && (max < 0 || n <= max):
+		return nil
+	case min == max:
+		want = fmt.Sprintf("want=%d", min)
+	case n < min:
+		want = fmt.Sprintf("want at least %d", min)
+	default:
+		want = fmt.Sprintf("want at most %d", max)
+	}
+	return &Error{
+		Message: fmt.Sprintf("wrong number of arguments. got=%d, %s", n, want),
+		Kind:    RuntimeError,
+	}
+}
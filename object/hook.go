@@ -0,0 +1,38 @@
+package object
+
+import "github.com/SebastiaanWouters/verigo/ast"
+
+// Hook observes an evaluation, for debuggers, profilers and coverage tools.
+// It is set on a root environment and, like its meter, inherited by every
+// environment enclosed by it. Every evaluator calls it.
+type Hook interface {
+	// Statement is called before stmt is evaluated in env. The hook may
+	// stop the evaluation there by canceling the context it runs with.
+	Statement(stmt ast.Statement, env *Environment)
+	// Call is called before the body of fn is evaluated in env, the
+	// environment of the call.
+	Call(fn *Function, env *Environment)
+	// Return is called once the body of fn has been evaluated to result,
+	// which is a *TailCall if fn ends by calling a function in its place.
+	Return(fn *Function, result Object)
+}
+
+// BranchHook is a Hook that is also told which way the evaluation branches,
+// for coverage tools.
+type BranchHook interface {
+	Hook
+	// Branch is called once node has chosen the branch it takes. For an if
+	// expression branch is 0 for the consequence and 1 for the alternative,
+	// even if there is none; for a for expression it is 0 whenever the loop
+	// is entered and 1 once it is left; for a match expression it is the
+	// index of the arm taken.
+	Branch(node ast.Expression, branch int)
+}
+
+// Branched tells the hook of env that node took branch, if it is a
+// BranchHook.
+func Branched(env *Environment, node ast.Expression, branch int) {
+	if hook, ok := env.Hook().(BranchHook); ok {
+		hook.Branch(node, branch)
+	}
+}
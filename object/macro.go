@@ -0,0 +1,55 @@
+package object
+
+import (
+	"strconv"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// Quote is the value of quote(x): the expression x itself, with the
+// unquote calls in it replaced by their values.
+type Quote struct {
+	Node ast.Node
+}
+
+func (q *Quote) Type() ObjectType { return QUOTE_OBJ }
+func (q *Quote) Inspect() string  { return "QUOTE(" + q.Node.String() + ")" }
+
+// Macro is a macro literal bound while expanding a program.
+type Macro struct {
+	Parameters []*ast.Identifier
+	Body       *ast.BlockStatement
+	Env        *Environment
+}
+
+func (m *Macro) Type() ObjectType { return MACRO_OBJ }
+func (m *Macro) Inspect() string {
+	return (&ast.MacroLiteral{Token: token.Token{Literal: "macro"}, Parameters: m.Parameters, Body: m.Body}).String()
+}
+
+// ToNode returns the expression that evaluates to obj, which replaces an
+// unquote call at tok. Only integers, strings, booleans, null and quotes
+// can be unquoted.
+func ToNode(obj Object, tok token.Token) (ast.Node, bool) {
+	switch obj := obj.(type) {
+	case *Integer:
+		tok.Type, tok.Literal = token.INT, strconv.FormatInt(obj.Value, 10)
+		return &ast.IntegerLiteral{Token: tok, Value: obj.Value}, true
+	case *String:
+		tok.Type, tok.Literal = token.STRING, obj.Value
+		return &ast.StringLiteral{Token: tok, Value: obj.Value}, true
+	case *Boolean:
+		tok.Type, tok.Literal = token.FALSE, "false"
+		if obj.Value {
+			tok.Type, tok.Literal = token.TRUE, "true"
+		}
+		return &ast.Boolean{Token: tok, Value: obj.Value}, true
+	case *Null:
+		tok.Type, tok.Literal = token.NULL, "null"
+		return &ast.NullLiteral{Token: tok}, true
+	case *Quote:
+		return obj.Node, true
+	}
+	return nil, false
+}
@@ -0,0 +1,95 @@
+package object
+
+import "github.com/SebastiaanWouters/verigo/ast"
+
+// binding is a name a pattern binds and the value it binds it to.
+type binding struct {
+	name  *ast.Identifier
+	value Object
+}
+
+// Match reports whether v matches p. Only if it does, bind is called for
+// each name p binds, in the order of ast.PatternBindings. Matching is shared
+// by the evaluators and charges no gas.
+func Match(p ast.Pattern, v Object, bind func(*ast.Identifier, Object)) bool {
+	var bindings []binding
+	if !match(p, v, &bindings) {
+		return false
+	}
+	for _, b := range bindings {
+		bind(b.name, b.value)
+	}
+	return true
+}
+
+func match(p ast.Pattern, v Object, bindings *[]binding) bool {
+	switch p := p.(type) {
+	case *ast.WildcardPattern:
+		return true
+	case *ast.BindingPattern:
+		*bindings = append(*bindings, binding{p.Name, v})
+		return true
+	case *ast.LiteralPattern:
+		return matchLiteral(p.Value, v)
+	case *ast.ArrayPattern:
+		arr, ok := v.(*Array)
+		if !ok || len(arr.Elements) < len(p.Elements) ||
+			(p.Rest == nil && len(arr.Elements) != len(p.Elements)) {
+			return false
+		}
+		for i, el := range p.Elements {
+			if !match(el, arr.Elements[i], bindings) {
+				return false
+			}
+		}
+		if p.Rest != nil && p.Rest.Value != "_" {
+			rest := append([]Object{}, arr.Elements[len(p.Elements):]...)
+			*bindings = append(*bindings, binding{p.Rest, &Array{Elements: rest}})
+		}
+		return true
+	case *ast.HashPattern:
+		hash, ok := v.(*Hash)
+		if !ok {
+			return false
+		}
+		for i, key := range p.Keys {
+			pair, ok := hash.Pairs[literalKey(key)]
+			if !ok || !match(p.Values[i], pair.Value, bindings) {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// matchLiteral reports whether v has the type and value of lit.
+func matchLiteral(lit ast.Expression, v Object) bool {
+	switch lit := lit.(type) {
+	case *ast.IntegerLiteral:
+		i, ok := v.(*Integer)
+		return ok && i.Value == lit.Value
+	case *ast.StringLiteral:
+		s, ok := v.(*String)
+		return ok && s.Value == lit.Value
+	case *ast.Boolean:
+		b, ok := v.(*Boolean)
+		return ok && b.Value == lit.Value
+	case *ast.NullLiteral:
+		return v.Type() == NULL_OBJ
+	}
+	return false
+}
+
+// literalKey returns the hash key of the literal key of a hash pattern.
+func literalKey(key ast.Expression) HashKey {
+	switch key := key.(type) {
+	case *ast.IntegerLiteral:
+		return (&Integer{Value: key.Value}).HashKey()
+	case *ast.StringLiteral:
+		return (&String{Value: key.Value}).HashKey()
+	case *ast.Boolean:
+		return (&Boolean{Value: key.Value}).HashKey()
+	}
+	return HashKey{}
+}
@@ -3,9 +3,12 @@ package object
 import (
 	"bytes"
 	"fmt"
+	"hash/fnv"
+	"sort"
 	"strings"
 
 	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/token"
 )
 
 type ObjectType string
@@ -19,6 +22,13 @@ const (
 	FUNCTION_OBJ     = "FUNCTION"
 	STRING_OBJ       = "STRING"
 	BUILTIN_OBJ      = "BUILTIN"
+	ARRAY_OBJ        = "ARRAY"
+	HASH_OBJ         = "HASH"
+	TAIL_CALL_OBJ    = "TAIL_CALL"
+	STRUCT_OBJ       = "STRUCT"
+	STRUCT_TYPE_OBJ  = "STRUCT_TYPE"
+	QUOTE_OBJ        = "QUOTE"
+	MACRO_OBJ        = "MACRO"
 )
 
 type Object interface {
@@ -59,6 +69,11 @@ func (b *Builtin) Inspect() string  { return "builtin function" }
 
 type Error struct {
 	Message string
+	// Kind classifies the error, as one of the Error kinds. Errors made by
+	// host builtins may leave it empty, which counts as RuntimeError.
+	Kind string
+	// Pos is where the error was raised; it is zero if unknown.
+	Pos token.Position
 }
 
 type String struct {
@@ -75,32 +90,55 @@ func (s *String) Inspect() string  { return s.Value }
 
 type Function struct {
 	Parameters []*ast.Identifier
+	Variadic   bool
 	Body       *ast.BlockStatement
 	Env        *Environment
+	Scope      *ast.Scope
 }
 
 func (f *Function) Type() ObjectType { return FUNCTION_OBJ }
 func (f *Function) Inspect() string {
 	var out bytes.Buffer
-	params := []string{}
-	for _, p := range f.Parameters {
-		params = append(params, p.String())
-	}
 	out.WriteString("fn")
 	out.WriteString("(")
-	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(ast.ParameterList(f.Parameters, f.Variadic))
 	out.WriteString(") {\n")
 	out.WriteString(f.Body.String())
 	out.WriteString("\n}")
 	return out.String()
 }
 
+// Name returns the name f is bound to in the environment it was defined in,
+// or an enclosing one, or fn@LINE:COL, locating its body, if it is bound in
+// none of them. Tools use it to refer to functions.
+func (f *Function) Name() string {
+	for env := f.Env; env != nil; env = env.Outer() {
+		for _, name := range env.Names() {
+			if v, _ := env.Get(name); v == f {
+				return name
+			}
+		}
+	}
+	return "fn@" + f.Body.Token.Pos.String()
+}
+
 func (e *Error) Type() ObjectType { return ERROR_OBJ }
 func (e *Error) Inspect() string  { return "ERROR: " + e.Message }
 
 func (rv *ReturnValue) Type() ObjectType { return RETURN_VALUE_OBJ }
 func (rv *ReturnValue) Inspect() string  { return rv.Value.Inspect() }
 
+// TailCall is the value of a call in tail position, which the evaluators
+// make once the calling function has returned it, so that tail recursion
+// does not grow the stack.
+type TailCall struct {
+	Fn   *Function
+	Args []Object
+}
+
+func (tc *TailCall) Type() ObjectType { return TAIL_CALL_OBJ }
+func (tc *TailCall) Inspect() string  { return "tail call" }
+
 type Null struct{}
 
 func (b *Boolean) Type() ObjectType { return BOOLEAN_OBJ }
@@ -111,3 +149,91 @@ func (i *Integer) Type() ObjectType { return INTEGER_OBJ }
 
 func (n *Null) Type() ObjectType { return NULL_OBJ }
 func (n *Null) Inspect() string  { return "null" }
+
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType { return ARRAY_OBJ }
+func (a *Array) Inspect() string {
+	var out bytes.Buffer
+	elements := []string{}
+	for _, e := range a.Elements {
+		elements = append(elements, e.Inspect())
+	}
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+	return out.String()
+}
+
+type HashKey struct {
+	Type  ObjectType
+	Value uint64
+}
+
+// Hashable is implemented by the objects that can be used as hash keys.
+type Hashable interface {
+	HashKey() HashKey
+}
+
+func (b *Boolean) HashKey() HashKey {
+	var value uint64
+	if b.Value {
+		value = 1
+	}
+	return HashKey{Type: b.Type(), Value: value}
+}
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
+}
+
+func (s *String) HashKey() HashKey {
+	h := fnv.New64a()
+	h.Write([]byte(s.Value))
+	return HashKey{Type: s.Type(), Value: h.Sum64()}
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+type Hash struct {
+	Pairs map[HashKey]HashPair
+}
+
+func (h *Hash) Type() ObjectType { return HASH_OBJ }
+
+// Inspect lists the pairs sorted by key so that the output is stable.
+func (h *Hash) Inspect() string {
+	var out bytes.Buffer
+	pairs := []string{}
+	for _, pair := range h.SortedPairs() {
+		pairs = append(pairs, pair.Key.Inspect()+": "+pair.Value.Inspect())
+	}
+	out.WriteString("{")
+	out.WriteString(strings.Join(pairs, ", "))
+	out.WriteString("}")
+	return out.String()
+}
+
+// SortedPairs returns the pairs of h ordered by key type, then key.
+func (h *Hash) SortedPairs() []HashPair {
+	pairs := make([]HashPair, 0, len(h.Pairs))
+	for _, pair := range h.Pairs {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i].Key, pairs[j].Key
+		if a.Type() != b.Type() {
+			return a.Type() < b.Type()
+		}
+		if a, ok := a.(*Integer); ok {
+			return a.Value < b.(*Integer).Value
+		}
+		return a.Inspect() < b.Inspect()
+	})
+	return pairs
+}
@@ -0,0 +1,148 @@
+package object
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// StructType is the value a struct declaration binds its name to.
+type StructType struct {
+	Name   string
+	Fields []string
+}
+
+func (st *StructType) Type() ObjectType { return STRUCT_TYPE_OBJ }
+func (st *StructType) Inspect() string {
+	return "struct " + st.Name + " { " + strings.Join(st.Fields, ", ") + " }"
+}
+
+// Field returns the index of the field called name.
+func (st *StructType) Field(name string) (int, bool) {
+	for i, f := range st.Fields {
+		if f == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Struct is a value of a struct type, with a value for each of its fields in
+// order. Structs are values: a literal such as Point { ...p, x: 1 } updates
+// a copy of p, which is left unchanged, as are any variables bound to it.
+type Struct struct {
+	Def    *StructType
+	Fields []Object
+}
+
+func (s *Struct) Type() ObjectType { return STRUCT_OBJ }
+func (s *Struct) Inspect() string {
+	fields := make([]string, len(s.Fields))
+	for i, v := range s.Fields {
+		fields[i] = s.Def.Fields[i] + ": " + v.Inspect()
+	}
+	return s.Def.Name + "{" + strings.Join(fields, ", ") + "}"
+}
+
+// Get returns the value of the field called name.
+func (s *Struct) Get(name string) (Object, bool) {
+	i, ok := s.Def.Field(name)
+	if !ok {
+		return nil, false
+	}
+	return s.Fields[i], true
+}
+
+// MarshalJSON encodes s as an object with its fields in order, so that saved
+// structs are readable by other programs.
+func (s *Struct) MarshalJSON() ([]byte, error) {
+	return jsonValue(s)
+}
+
+// The other values that can be saved are encoded in the same plain form as
+// the fields of structs.
+
+func (i *Integer) MarshalJSON() ([]byte, error) { return jsonValue(i) }
+func (s *String) MarshalJSON() ([]byte, error)  { return jsonValue(s) }
+func (b *Boolean) MarshalJSON() ([]byte, error) { return jsonValue(b) }
+func (n *Null) MarshalJSON() ([]byte, error)    { return jsonValue(n) }
+func (a *Array) MarshalJSON() ([]byte, error)   { return jsonValue(a) }
+func (h *Hash) MarshalJSON() ([]byte, error)    { return jsonValue(h) }
+
+// CheckSave returns why obj cannot be saved, if it cannot be encoded as
+// JSON, naming structs by their type.
+func CheckSave(obj Object) error {
+	if _, err := jsonValue(obj); err != nil {
+		name := string(obj.Type())
+		if s, ok := obj.(*Struct); ok {
+			name = s.Def.Name
+		}
+		return fmt.Errorf("cannot save %s: %s", name, err)
+	}
+	return nil
+}
+
+// jsonValue encodes the plain JSON form of obj: integers, strings and
+// booleans as themselves, null as null, arrays as arrays and structs and
+// hashes as objects. Hash keys are written as strings, in sorted order.
+func jsonValue(obj Object) (json.RawMessage, error) {
+	switch obj := obj.(type) {
+	case *Integer:
+		return json.Marshal(obj.Value)
+	case *String:
+		return json.Marshal(obj.Value)
+	case *Boolean:
+		return json.Marshal(obj.Value)
+	case *Null:
+		return json.RawMessage("null"), nil
+	case *Array:
+		elements := make([]json.RawMessage, len(obj.Elements))
+		for i, el := range obj.Elements {
+			v, err := jsonValue(el)
+			if err != nil {
+				return nil, err
+			}
+			elements[i] = v
+		}
+		return json.Marshal(elements)
+	case *Hash:
+		keys := make([]string, 0, len(obj.Pairs))
+		values := make(map[string]Object, len(obj.Pairs))
+		for _, pair := range obj.Pairs {
+			key := pair.Key.Inspect()
+			keys = append(keys, key)
+			values[key] = pair.Value
+		}
+		sort.Strings(keys)
+		return jsonObject(keys, func(i int) Object { return values[keys[i]] })
+	case *Struct:
+		return jsonObject(obj.Def.Fields, func(i int) Object { return obj.Fields[i] })
+	default:
+		return nil, fmt.Errorf("cannot encode %s as JSON", obj.Type())
+	}
+}
+
+func jsonObject(keys []string, value func(i int) Object) (json.RawMessage, error) {
+	var out bytes.Buffer
+	out.WriteByte('{')
+	for i, key := range keys {
+		if i > 0 {
+			out.WriteByte(',')
+		}
+		k, err := json.Marshal(key)
+		if err != nil {
+			return nil, err
+		}
+		v, err := jsonValue(value(i))
+		if err != nil {
+			return nil, err
+		}
+		out.Write(k)
+		out.WriteByte(':')
+		out.Write(v)
+	}
+	out.WriteByte('}')
+	return out.Bytes(), nil
+}
@@ -0,0 +1,470 @@
+// Package optimize simplifies programs before they run.
+//
+// It folds operators applied to literals into literals, prunes the branches
+// of ifs and the bodies of loops whose conditions are constant, drops
+// statements after a return and replaces variables bound once to a literal
+// by that literal.
+//
+// Folding removes operations that would be charged gas. In PreserveCost
+// mode, the folded literals are wrapped in an ast.FoldedExpression that
+// charges the removed opcodes when evaluated, so that an optimized program
+// is charged exactly as the original, and runs out of gas at the same
+// point. In ReduceCost mode, folded operations are free.
+package optimize
+
+import (
+	"strconv"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/evaluator"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+type Mode int
+
+const (
+	// PreserveCost keeps the gas charged by the program unchanged.
+	PreserveCost Mode = iota
+	// ReduceCost charges nothing for operations done before running.
+	ReduceCost
+)
+
+func (m Mode) String() string {
+	if m == ReduceCost {
+		return "reduce"
+	}
+	return "preserve"
+}
+
+// Program optimizes program in place and returns it. The program should
+// have been checked: operations that fail when run, such as dividing by
+// zero, are left in place so that they still fail.
+//
+// Globals are only replaced by their values outside functions, as a later
+// program run in the same environment may bind them again before calling
+// the functions.
+func Program(program *ast.Program, mode Mode) *ast.Program {
+	o := &optimizer{mode: mode, trivial: map[*ast.LetStatement]bool{}}
+	o.declare(program.Statements, nil)
+	program.Statements = o.statements(program.Statements, &scope{global: true, constants: map[string]constant{}})
+	return program
+}
+
+// constant is a variable bound once to a literal.
+type constant struct {
+	value  ast.Expression
+	global bool
+}
+
+// scope holds the constants known in a function, or in the program if
+// global is set.
+type scope struct {
+	global    bool
+	constants map[string]constant
+}
+
+type optimizer struct {
+	mode Mode
+	// trivial holds the lets that bind variables to their only value.
+	trivial map[*ast.LetStatement]bool
+}
+
+// declare records the trivial lets of a function with the given parameters
+// and body, or of the program: those that bind a variable declared only once
+// and are not nested in a block.
+func (o *optimizer) declare(body []ast.Statement, params []*ast.Identifier) {
+	counts := map[string]int{}
+	for _, p := range params {
+		counts[p.Value]++
+	}
+	ast.Lets(body, func(let *ast.LetStatement) {
+		counts[let.Name.Value]++
+	})
+	for _, stmt := range body {
+		if let, ok := stmt.(*ast.LetStatement); ok && counts[let.Name.Value] == 1 {
+			o.trivial[let] = true
+		}
+	}
+}
+
+// statements optimizes stmts, in which the constants of env are known.
+// Constants bound by stmts are added to env.
+func (o *optimizer) statements(stmts []ast.Statement, env *scope) []ast.Statement {
+	out := make([]ast.Statement, 0, len(stmts))
+	for i, stmt := range stmts {
+		last := i == len(stmts)-1
+		stmt = o.statement(stmt, env)
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			if lit, ok := literal(stmt.Value); ok && o.trivial[stmt] {
+				env.constants[stmt.Name.Value] = constant{value: lit, global: env.global}
+			}
+		case *ast.ReturnStatement, *ast.ThrowStatement:
+			// What follows is unreachable.
+			return append(out, stmt)
+		case *ast.ExpressionStatement:
+			if pruned, ok := o.prune(stmt, last); ok {
+				out = append(out, pruned...)
+				if n := len(pruned); n > 0 {
+					switch pruned[n-1].(type) {
+					case *ast.ReturnStatement, *ast.ThrowStatement:
+						return out
+					}
+				}
+				continue
+			}
+		}
+		out = append(out, stmt)
+	}
+	return out
+}
+
+// prune replaces an if or a loop with a constant condition by the
+// statements that run. The statement must be kept if it is the last of its
+// block, whose value is that of the branch taken, and no branch runs.
+func (o *optimizer) prune(stmt *ast.ExpressionStatement, last bool) ([]ast.Statement, bool) {
+	switch exp := stmt.Expression.(type) {
+	case *ast.IfExpression:
+		lit, ok := literal(exp.Condition)
+		if !ok {
+			return nil, false
+		}
+		var taken []ast.Statement
+		if truthy(lit) {
+			taken = exp.Consequence.Statements
+		} else if exp.Alternative != nil {
+			taken = exp.Alternative.Statements
+		}
+		if len(taken) == 0 && last {
+			return nil, false
+		}
+		return append(o.charges(exp.Condition), taken...), true
+	case *ast.ForExpression:
+		lit, ok := literal(exp.Condition)
+		if !ok || truthy(lit) || last {
+			return nil, false
+		}
+		return append([]ast.Statement{&exp.Variable}, o.charges(exp.Condition)...), true
+	}
+	return nil, false
+}
+
+// charges returns a statement charging the operations folded into exp, if
+// they are to be charged.
+func (o *optimizer) charges(exp ast.Expression) []ast.Statement {
+	folded, ok := exp.(*ast.FoldedExpression)
+	if !ok {
+		return nil
+	}
+	return []ast.Statement{&ast.ExpressionStatement{Token: folded.Token, Expression: folded}}
+}
+
+func (o *optimizer) statement(stmt ast.Statement, env *scope) ast.Statement {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		stmt.Value = o.expression(stmt.Value, env)
+	case *ast.ReturnStatement:
+		stmt.ReturnValue = o.expression(stmt.ReturnValue, env)
+	case *ast.ThrowStatement:
+		stmt.Value = o.expression(stmt.Value, env)
+	case *ast.ExpressionStatement:
+		stmt.Expression = o.expression(stmt.Expression, env)
+	}
+	return stmt
+}
+
+func (o *optimizer) block(block *ast.BlockStatement, env *scope) {
+	block.Statements = o.statements(block.Statements, env)
+}
+
+func (o *optimizer) expression(exp ast.Expression, env *scope) ast.Expression {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		if c, ok := env.constants[exp.Value]; ok {
+			return relocate(c.value, exp.Token)
+		}
+	case *ast.PrefixExpression:
+		exp.Right = o.expression(exp.Right, env)
+		return o.prefix(exp)
+	case *ast.IsNullExpression:
+		exp.Value = o.expression(exp.Value, env)
+	case *ast.InfixExpression:
+		exp.Left = o.expression(exp.Left, env)
+		exp.Right = o.expression(exp.Right, env)
+		return o.infix(exp)
+	case *ast.IfExpression:
+		exp.Condition = o.expression(exp.Condition, env)
+		o.block(exp.Consequence, env)
+		if exp.Alternative != nil {
+			o.block(exp.Alternative, env)
+		}
+		if lit, ok := literal(exp.Condition); ok {
+			if truthy(lit) {
+				exp.Alternative = nil
+			} else {
+				exp.Consequence.Statements = nil
+			}
+		}
+	case *ast.ForExpression:
+		// The loop variable and the variables assigned in the loop are
+		// never constant.
+		o.statement(&exp.Variable, env)
+		exp.Condition = o.expression(exp.Condition, env)
+		o.block(exp.Loop, env)
+		o.statement(&exp.Update, env)
+	case *ast.TryExpression:
+		o.block(exp.Body, env)
+		if exp.Handler != nil {
+			o.block(exp.Handler, env)
+		}
+		if exp.Finally != nil {
+			o.block(exp.Finally, env)
+		}
+	case *ast.FunctionLiteral:
+		o.function(exp, env)
+	case *ast.CallExpression:
+		if _, ok := ast.IsCallTo(exp, "quote"); ok {
+			// Quoted expressions are values, which must be kept as written.
+			break
+		}
+		exp.Function = o.expression(exp.Function, env)
+		for i, arg := range exp.Arguments {
+			exp.Arguments[i] = o.expression(arg, env)
+		}
+	case *ast.ArrayLiteral:
+		for i, el := range exp.Elements {
+			exp.Elements[i] = o.expression(el, env)
+		}
+	case *ast.IndexExpression:
+		exp.Left = o.expression(exp.Left, env)
+		exp.Index = o.expression(exp.Index, env)
+	case *ast.StructLiteral:
+		if exp.Base != nil {
+			exp.Base = o.expression(exp.Base, env)
+		}
+		for i, value := range exp.Values {
+			exp.Values[i] = o.expression(value, env)
+		}
+	case *ast.FieldExpression:
+		exp.Left = o.expression(exp.Left, env)
+	case *ast.MatchExpression:
+		exp.Subject = o.expression(exp.Subject, env)
+		for _, arm := range exp.Arms {
+			if arm.Guard != nil {
+				arm.Guard = o.expression(arm.Guard, env)
+			}
+			if arm.Body != nil {
+				o.block(arm.Body, env)
+			} else {
+				arm.Value = o.expression(arm.Value, env)
+			}
+		}
+	case *ast.HashLiteral:
+		pairs := make(map[ast.Expression]ast.Expression, len(exp.Pairs))
+		for i, key := range exp.Keys {
+			value := exp.Pairs[key]
+			key = o.expression(key, env)
+			exp.Keys[i] = key
+			pairs[key] = o.expression(value, env)
+		}
+		exp.Pairs = pairs
+	}
+	return exp
+}
+
+func (o *optimizer) function(fn *ast.FunctionLiteral, outer *scope) {
+	o.declare(fn.Body.Statements, fn.Parameters)
+	declared := map[string]bool{}
+	for _, p := range fn.Parameters {
+		declared[p.Value] = true
+	}
+	ast.Lets(fn.Body.Statements, func(let *ast.LetStatement) {
+		declared[let.Name.Value] = true
+	})
+
+	env := &scope{constants: map[string]constant{}}
+	for name, c := range outer.constants {
+		if !c.global && !declared[name] {
+			env.constants[name] = c
+		}
+	}
+	for _, p := range fn.Parameters {
+		if p.Default != nil {
+			p.Default = o.expression(p.Default, env)
+		}
+	}
+	o.block(fn.Body, env)
+}
+
+func (o *optimizer) prefix(exp *ast.PrefixExpression) ast.Expression {
+	right, ok := literal(exp.Right)
+	if !ok {
+		return exp
+	}
+	var value ast.Expression
+	switch exp.Operator {
+	case "!":
+		value = boolean(!truthy(right), exp.Token)
+	case "-":
+		i, ok := right.(*ast.IntegerLiteral)
+		if !ok {
+			return exp
+		}
+		value = integer(-i.Value, exp.Token)
+	default:
+		return exp
+	}
+	return o.fold(exp.Token, value, ops(exp.Right))
+}
+
+func (o *optimizer) infix(exp *ast.InfixExpression) ast.Expression {
+	left, lok := literal(exp.Left)
+	if lok && exp.Operator == "??" {
+		// Literals are never null, so the default is never evaluated.
+		return exp.Left
+	}
+	right, rok := literal(exp.Right)
+	if !lok || !rok {
+		return exp
+	}
+	tok := firstToken(exp)
+	charged := append(ops(exp.Left), ops(exp.Right)...)
+
+	switch left := left.(type) {
+	case *ast.IntegerLiteral:
+		right, ok := right.(*ast.IntegerLiteral)
+		if !ok {
+			return exp
+		}
+		op, ok := evaluator.IntegerOpcode(exp.Operator)
+		if !ok || (exp.Operator == "/" && right.Value == 0) {
+			return exp
+		}
+		l, r := left.Value, right.Value
+		var value ast.Expression
+		switch exp.Operator {
+		case "+":
+			value = integer(l+r, tok)
+		case "-":
+			value = integer(l-r, tok)
+		case "*":
+			value = integer(l*r, tok)
+		case "/":
+			value = integer(l/r, tok)
+		case "<":
+			value = boolean(l < r, tok)
+		case ">":
+			value = boolean(l > r, tok)
+		case "==":
+			value = boolean(l == r, tok)
+		case "!=":
+			value = boolean(l != r, tok)
+		}
+		return o.fold(tok, value, append(charged, op))
+	case *ast.StringLiteral:
+		right, ok := right.(*ast.StringLiteral)
+		if !ok || exp.Operator != "+" {
+			return exp
+		}
+		value := &ast.StringLiteral{
+			Token: token.Token{Type: token.STRING, Literal: left.Value + right.Value, Pos: tok.Pos, End: tok.End},
+			Value: left.Value + right.Value,
+		}
+		return o.fold(tok, value, append(charged, gas.OpConcat))
+	case *ast.Boolean:
+		right, ok := right.(*ast.Boolean)
+		if !ok {
+			return exp
+		}
+		switch exp.Operator {
+		case "==":
+			return o.fold(tok, boolean(left.Value == right.Value, tok), charged)
+		case "!=":
+			return o.fold(tok, boolean(left.Value != right.Value, tok), charged)
+		}
+	}
+	return exp
+}
+
+// fold returns value, charging ops if the mode preserves cost.
+func (o *optimizer) fold(tok token.Token, value ast.Expression, charged []int) ast.Expression {
+	if o.mode == ReduceCost || len(charged) == 0 {
+		return value
+	}
+	return &ast.FoldedExpression{Token: tok, Value: value, Ops: charged}
+}
+
+// literal returns the literal exp evaluates to, if it is known.
+func literal(exp ast.Expression) (ast.Expression, bool) {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral, *ast.StringLiteral, *ast.Boolean:
+		return exp, true
+	case *ast.FoldedExpression:
+		return exp.Value, true
+	}
+	return nil, false
+}
+
+// ops returns the opcodes charged by a folded expression.
+func ops(exp ast.Expression) []int {
+	if folded, ok := exp.(*ast.FoldedExpression); ok {
+		return folded.Ops
+	}
+	return nil
+}
+
+// truthy reports whether a literal counts as true in conditions.
+func truthy(lit ast.Expression) bool {
+	if b, ok := lit.(*ast.Boolean); ok {
+		return b.Value
+	}
+	return true
+}
+
+func integer(v int64, at token.Token) *ast.IntegerLiteral {
+	lit := strconv.FormatInt(v, 10)
+	return &ast.IntegerLiteral{Token: token.Token{Type: token.INT, Literal: lit, Pos: at.Pos, End: at.End}, Value: v}
+}
+
+func boolean(v bool, at token.Token) *ast.Boolean {
+	tok := token.Token{Type: token.FALSE, Literal: "false", Pos: at.Pos, End: at.End}
+	if v {
+		tok.Type, tok.Literal = token.TRUE, "true"
+	}
+	return &ast.Boolean{Token: tok, Value: v}
+}
+
+// relocate returns a copy of lit at the position of tok.
+func relocate(lit ast.Expression, at token.Token) ast.Expression {
+	switch lit := lit.(type) {
+	case *ast.IntegerLiteral:
+		return integer(lit.Value, at)
+	case *ast.Boolean:
+		return boolean(lit.Value, at)
+	case *ast.StringLiteral:
+		tok := lit.Token
+		tok.Pos, tok.End = at.Pos, at.End
+		return &ast.StringLiteral{Token: tok, Value: lit.Value}
+	}
+	return lit
+}
+
+// firstToken returns the token an infix expression starts at.
+func firstToken(exp *ast.InfixExpression) token.Token {
+	switch left := exp.Left.(type) {
+	case *ast.InfixExpression:
+		return firstToken(left)
+	case *ast.IntegerLiteral:
+		return left.Token
+	case *ast.StringLiteral:
+		return left.Token
+	case *ast.Boolean:
+		return left.Token
+	case *ast.FoldedExpression:
+		return left.Token
+	case *ast.PrefixExpression:
+		return left.Token
+	}
+	return exp.Token
+}
@@ -0,0 +1,146 @@
+package optimize
+
+import (
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/evaluator"
+	"github.com/SebastiaanWouters/verigo/evaluator_middle"
+	"github.com/SebastiaanWouters/verigo/evaluator_simple"
+	"github.com/SebastiaanWouters/verigo/format"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/parser"
+	"github.com/SebastiaanWouters/verigo/resolver"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	return program
+}
+
+func TestProgram(t *testing.T) {
+	tests := []struct {
+		input    string
+		preserve string
+		reduce   string
+	}{
+		{`1 + 2 * 3`, "7;\n", ""},
+		{`-(2 - 5) * 2; !true; !0`, "6;\nfalse;\nfalse;\n", ""},
+		{`"a" + "b" + "c"`, "\"abc\";\n", ""},
+		{`true == false; 1 < 2 == true`, "false;\ntrue;\n", ""},
+		{`1 / 0; 1 + true`, "1 / 0;\n1 + true;\n", ""},
+		{`let x = 2; let y = x * 3; y + x`, "let x = 2;\nlet y = 6;\n8;\n", ""},
+		{`if (true) { 1 } else { 2 }`, "1;\n", ""},
+		{`if (1 > 2) { 1 } else { 2 }; 3`, "false;\n2;\n3;\n", "2;\n3;\n"},
+		{`if (1 > 2) { 1 }; 3`, "false;\n3;\n", "3;\n"},
+		{`if (false) { 1 }`, "if (false) {}\n", ""},
+		{`f(if (1 < 2) { 1 } else { 2 })`, "f(if (true) {\n\t1;\n});\n", ""},
+		{`let stop = 0; for (let i = 0; stop > 1; let i = i + 1) { i }; 3`,
+			"let stop = 0;\nlet i = 0;\nfalse;\n3;\n", "let stop = 0;\nlet i = 0;\n3;\n"},
+		{`let f = fn() { return 1; 2 + 3 };`, "let f = fn() {\n\treturn 1;\n};\n", ""},
+		{`let f = fn() { if (true) { return 1; } 2 };`, "let f = fn() {\n\treturn 1;\n};\n", ""},
+		{`let x = 1; let f = fn() { x };`, "let x = 1;\nlet f = fn() {\n\tx;\n};\n", ""},
+		{`let f = fn(a) { let b = 2; a * b + b * b };`,
+			"let f = fn(a) {\n\tlet b = 2;\n\ta * 2 + 4;\n};\n", ""},
+		{`let f = fn(a) { let b = 2; let g = fn(b) { b }; let h = fn() { b }; g(a) + h() };`,
+			"let f = fn(a) {\n\tlet b = 2;\n\tlet g = fn(b) {\n\t\tb;\n\t};\n\tlet h = fn() {\n\t\t2;\n\t};\n\tg(a) + h();\n};\n", ""},
+		{`let x = 1; let x = x + 1; x`, "let x = 1;\nlet x = x + 1;\nx;\n", ""},
+		{`x; let x = 1; x`, "x;\nlet x = 1;\n1;\n", ""},
+		{`let n = 3; for (let i = 0; i < n; let i = i + 1) { i }`,
+			"let n = 3;\nfor (let i = 0; i < 3; let i = i + 1) {\n\ti;\n}\n", ""},
+		{`let h = {1 + 1: 2 * 2}; h[2]`, "let h = {2: 4};\nh[2];\n", ""},
+		{`(1 + 2) ?? f(); x ?? 1 + 2`, "3;\nx ?? 3;\n", ""},
+	}
+
+	for _, tt := range tests {
+		for _, mode := range []Mode{PreserveCost, ReduceCost} {
+			expected := tt.preserve
+			if mode == ReduceCost && tt.reduce != "" {
+				expected = tt.reduce
+			}
+			got := format.Node(Program(parse(t, tt.input), mode))
+			if got != expected {
+				t.Errorf("%q (%s): expected=%q, got=%q", tt.input, mode, expected, got)
+			}
+		}
+	}
+}
+
+// TestSemantics checks that optimized programs evaluate to the same values,
+// charging the same gas when preserving cost and no more otherwise.
+func TestSemantics(t *testing.T) {
+	tests := []string{
+		`1 + 2 * 3 - 4 / 2`,
+		`let a = 5; let b = a * a; let s = "x" + "y"; len(s) + b`,
+		`if (2 * 3 > 5) { 10 + 1 } else { 20 + 2 }`,
+		`let f = fn(n) { if (1 < 2) { return n * (2 + 3); } n }; f(4) + f(2 * 2)`,
+		`let s = 0; for (let i = 0; i < 2 + 3; let i = i + 1) { let s = s + i * (1 + 1); }; s`,
+		`let fact = fn(n) { if (n < 1 + 1) { return 1; } n * fact(n - 1) }; fact(2 * 3)`,
+		`let k = 3; let add = fn(x) { let two = 2; x + k + two }; add(1 + k)`,
+		`let stop = 0; if (!(1 == 1)) { 1 }; for (let i = 0; stop > 1 + 1; let i = i + 1) { i }; pow(2, 1 + 2)`,
+		`let h = {"a" + "b": 1 + 1}; h["ab"] * 3`,
+		`[1 + 1, 2 * 2][0 + 1]`,
+		`let h = {"a": 1}; let n = null; (h?["a"] ?? 0) + (2 * 2 ?? 3) + (n?[1 + 1] ?? 5)`,
+		`let x = 2 * 3; let f = fn(v) { match (v) { [a] if a > 1 + 1 => a * x, _ => x + 1 } }; f([4]) + f(1) + x`,
+		`let P = 1; struct P { x }; let p = P { x: 2 * 3 }; P { ...p, x: p.x + 1 }.x`,
+	}
+
+	for _, input := range tests {
+		want, wantGas := run(t, parse(t, input))
+		for _, mode := range []Mode{PreserveCost, ReduceCost} {
+			got, gotGas := run(t, Program(parse(t, input), mode))
+			if got != want {
+				t.Errorf("%q (%s): wrong value. expected=%s, got=%s", input, mode, want, got)
+			}
+			switch {
+			case mode == PreserveCost && gotGas != wantGas:
+				t.Errorf("%q (%s): wrong gas. expected=%d, got=%d", input, mode, wantGas, gotGas)
+			case mode == ReduceCost && gotGas > wantGas:
+				t.Errorf("%q (%s): more gas. original=%d, got=%d", input, mode, wantGas, gotGas)
+			}
+		}
+	}
+}
+
+// run evaluates program, resolved, with each evaluator and returns the
+// value and the gas charged, which must agree.
+func run(t *testing.T, program *ast.Program) (string, uint64) {
+	t.Helper()
+	resolver.Resolve(program, evaluator.IsBuiltin)
+
+	meter := gas.NewMeter(0)
+	result := evaluator.Eval(program, object.NewMeteredEnvironment(meter), nil, nil)
+
+	middleMeter := gas.NewMeter(0)
+	var count int
+	middle := evaluator_middle.Eval(program, object.NewMeteredEnvironment(middleMeter), &count)
+
+	simple := evaluator_simple.Eval(program, object.NewEnvironment())
+
+	if middle.Inspect() != result.Inspect() || simple.Inspect() != result.Inspect() {
+		t.Errorf("evaluators disagree: %s, %s, %s", result.Inspect(), middle.Inspect(), simple.Inspect())
+	}
+	if middleMeter.Used() != meter.Used() {
+		t.Errorf("evaluators charge differently: %d, %d", meter.Used(), middleMeter.Used())
+	}
+	return result.Inspect(), meter.Used()
+}
+
+func TestPreservedGasLimit(t *testing.T) {
+	input := `let x = 1 + 2 + 3 + 4; x`
+	for limit := uint64(1); limit <= 3; limit++ {
+		program := Program(parse(t, input), PreserveCost)
+		result := evaluator.Eval(program, object.NewMeteredEnvironment(gas.NewMeter(limit)), nil, nil)
+		_, failed := result.(*object.Error)
+		if failed != (limit < 3) {
+			t.Errorf("limit %d: wrong result %s", limit, result.Inspect())
+		}
+	}
+}
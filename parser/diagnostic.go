@@ -0,0 +1,63 @@
+package parser
+
+import (
+	"fmt"
+
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+type Severity int
+
+const (
+	Error Severity = iota
+	Warning
+)
+
+func (s Severity) String() string {
+	if s == Warning {
+		return "warning"
+	}
+	return "error"
+}
+
+// Diagnostic codes reported by the parser.
+const (
+	CodeUnexpectedToken = "P001"
+	CodeExpectedExpr    = "P002"
+	CodeIllegalChar     = "P003"
+	CodeBadInteger      = "P004"
+	CodeExpectedType    = "P005"
+	CodeExpectedPattern = "P006"
+	CodeNonExhaustive   = "P007"
+)
+
+// Diagnostic describes a problem found in the source, located by the span
+// [Pos, End).
+type Diagnostic struct {
+	Code     string
+	Severity Severity
+	Message  string
+	Pos      token.Position
+	End      token.Position
+	Hint     string
+}
+
+func (d Diagnostic) String() string {
+	s := fmt.Sprintf("%s: %s[%s]: %s", d.Pos, d.Severity, d.Code, d.Message)
+	if d.Hint != "" {
+		s += " (hint: " + d.Hint + ")"
+	}
+	return s
+}
+
+// expectHints explain the most common reasons a token is missing.
+var expectHints = map[token.TokenType]string{
+	token.RPAREN:    "check for a missing closing parenthesis",
+	token.RBRACE:    "check for a missing closing brace",
+	token.RBRACKET:  "check for a missing closing bracket",
+	token.LBRACE:    "blocks must be enclosed in braces",
+	token.ASSIGN:    "bindings are written `let name = value;`",
+	token.IDENT:     "expected a name",
+	token.SEMICOLON: "for loops are written `for (let i = 0; i < n; let i = i + 1) { ... }`",
+	token.COLON:     "hash pairs are written `key: value`",
+}
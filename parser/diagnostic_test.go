@@ -0,0 +1,205 @@
+package parser
+
+import (
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/lexer"
+)
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{
+			"let = 5; let y = 2; y",
+			[]string{"1:5: error[P001]: expected next token to be IDENT, got = instead (hint: expected a name)"},
+		},
+		{
+			"let x = [1, 2; let y = {1: 2, 3}; 7",
+			[]string{
+				"1:14: error[P001]: expected next token to be ], got ; instead (hint: check for a missing closing bracket)",
+				"1:32: error[P001]: expected next token to be :, got } instead (hint: hash pairs are written `key: value`)",
+			},
+		},
+		{
+			"if (x) {\n  1 +\n} else {\n  @\n}",
+			[]string{
+				"3:1: error[P002]: no prefix parse function for } found (hint: expected an expression)",
+				"4:3: error[P003]: illegal character \"@\"",
+			},
+		},
+		{
+			"let x = 99999999999999999999;",
+			[]string{"1:9: error[P004]: could not parse \"99999999999999999999\" as integer (hint: integers must fit in 64 bits)"},
+		},
+		{
+			"fn(x) { x",
+			[]string{"1:10: error[P001]: expected next token to be }, got EOF instead (hint: check for a missing closing brace)"},
+		},
+		{
+			"fn(a = 1, b) { b }",
+			[]string{"1:11: error[P001]: parameter b without a default value follows one with a default (hint: give b a default value or move it first)"},
+		},
+		{
+			"fn(...a, b) { b }",
+			[]string{"1:8: error[P001]: only the last parameter can be a rest parameter"},
+		},
+		{
+			"struct P { x, x }",
+			[]string{"1:15: error[P001]: duplicate field x in struct P"},
+		},
+		{
+			"p.1",
+			[]string{"1:3: error[P001]: expected next token to be IDENT, got INT instead (hint: expected a name)"},
+		},
+		{
+			"fn(...a = []) { a }",
+			[]string{"1:9: error[P001]: rest parameter a cannot have a default value"},
+		},
+		{
+			"match (x) { 1 + 2 => 3 }",
+			[]string{"1:15: error[P001]: expected next token to be =>, got + instead"},
+		},
+		{
+			"match (x) { a.b => 1 }",
+			[]string{"1:14: error[P001]: expected next token to be =>, got . instead"},
+		},
+		{
+			"match (x) { fn => 1 }",
+			[]string{"1:13: error[P006]: expected a pattern, got FUNCTION (hint: patterns are literals, names, _, [a, ...rest] or {\"key\": pattern})"},
+		},
+		{
+			"match (x) { [a, {\"k\": a}] => a }",
+			[]string{"1:23: error[P006]: a is bound more than once in the pattern"},
+		},
+		{
+			"macro(a, b = 1) { a }",
+			[]string{"1:10: error[P001]: macro parameter b must be a plain name (hint: macros take their arguments unevaluated, as quotes)"},
+		},
+		{
+			"match (x) { [...a, b] => a }",
+			[]string{"1:18: error[P006]: the rest of an array pattern must come last"},
+		},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("%q: wrong number of diagnostics. got=%v", tt.input, diagnostics)
+			continue
+		}
+		for i, d := range diagnostics {
+			if d.String() != tt.expected[i] {
+				t.Errorf("%q: wrong diagnostic. expected=%q, got=%q",
+					tt.input, tt.expected[i], d.String())
+			}
+		}
+		if len(p.Errors()) != len(tt.expected) {
+			t.Errorf("%q: Errors and Diagnostics disagree. got=%v", tt.input, p.Errors())
+		}
+	}
+}
+
+func TestMatchWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"match (x) { true => 1 }", []string{
+			"1:1: warning[P007]: match on a boolean does not cover false (hint: add an arm for false or _)",
+		}},
+		{"match (x) { true if y => 1, false => 2 }", []string{
+			"1:1: warning[P007]: match on a boolean does not cover true (hint: add an arm for true or _)",
+		}},
+		{"match (x) { true => 1, false => 2 }", nil},
+		{"match (x) { true => 1, b => 2 }", nil},
+		{"match (x) { 1 => 1 }", nil},
+		{"match (x) { true => 1, b if b => 2 }", []string{
+			"1:1: warning[P007]: match on a boolean does not cover false (hint: add an arm for false or _)",
+		}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Errorf("%q: unexpected errors: %v", tt.input, p.Errors())
+			continue
+		}
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("%q: wrong number of diagnostics. got=%v", tt.input, diagnostics)
+			continue
+		}
+		for i, d := range diagnostics {
+			if d.String() != tt.expected[i] {
+				t.Errorf("%q: wrong diagnostic. expected=%q, got=%q", tt.input, tt.expected[i], d.String())
+			}
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let = 5; let y = 2; y", "<bad statement>let y = 2;y"},
+		{"let f = fn(x { x }; let z = 1;", "let f = <bad expression>;let z = 1;"},
+		{"let a = (1 + ; let b = 2", "let a = <bad expression>;let b = 2;"},
+		{"let f = fn(x) { let y = x +; return y; }; f(1)",
+			"let f = fn(x) let y = (x + <bad expression>);return y;;f(1)"},
+		{"for (let i = 0; 1 < 2; let i = i + 1) { i }; 8", "<bad expression>8"},
+		{"fn() { f(1, 2; 3 }; 4", "fn() f(1, 2)34"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) == 0 {
+			t.Errorf("%q: expected errors", tt.input)
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: wrong program. expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// Every prefix of a valid program is parsed without panicking into a tree
+// whose String method, which dereferences every child, does not panic.
+func TestNoNilNodes(t *testing.T) {
+	input := `let f = fn(a, b) { if (a < b) { return [a, b][0]; } else { {"k": b}["k"] } };
+for (let i = 0; i < 10; let i = i + 1) { f(i, 2 * -i); }
+f(1, 2)`
+
+	for i := 0; i <= len(input); i++ {
+		src := input[:i]
+		func() {
+			defer func() {
+				if r := recover(); r != nil {
+					t.Errorf("%q: panic: %v", src, r)
+				}
+			}()
+			program := New(lexer.New(src)).ParseProgram()
+			for _, stmt := range program.Statements {
+				switch stmt := stmt.(type) {
+				case nil:
+					t.Errorf("%q: nil statement", src)
+				case *ast.LetStatement:
+					if stmt.Value == nil {
+						t.Errorf("%q: let without value", src)
+					}
+				case *ast.ExpressionStatement:
+					if stmt.Expression == nil {
+						t.Errorf("%q: statement without expression", src)
+					}
+				}
+			}
+			_ = program.String()
+		}()
+	}
+}
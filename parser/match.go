@@ -0,0 +1,215 @@
+package parser
+
+import (
+	"strconv"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+const patternHint = `patterns are literals, names, _, [a, ...rest] or {"key": pattern}`
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expression := &ast.MatchExpression{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return p.badExpression(expression.Token)
+	}
+	p.nextToken()
+	expression.Subject = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+		return p.badExpression(expression.Token)
+	}
+
+	for !p.peekTokenIs(token.RBRACE) && !p.panicking {
+		p.nextToken()
+		arm := &ast.MatchArm{Pattern: p.parsePattern(map[string]bool{})}
+		if p.panicking {
+			break
+		}
+		if p.peekTokenIs(token.IF) {
+			p.nextToken()
+			p.nextToken()
+			arm.Guard = p.parseExpression(LOWEST)
+		}
+		if !p.expectPeek(token.ARROW) {
+			break
+		}
+		if p.peekTokenIs(token.LBRACE) {
+			p.nextToken()
+			arm.Body = p.parseBlockStatement()
+		} else {
+			p.nextToken()
+			arm.Value = p.parseExpression(LOWEST)
+		}
+		expression.Arms = append(expression.Arms, arm)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			break
+		}
+	}
+	if p.panicking || !p.expectPeek(token.RBRACE) {
+		return p.badExpression(expression.Token)
+	}
+	p.checkExhaustive(expression)
+	return expression
+}
+
+// parsePattern parses the pattern starting at the current token. bound
+// holds the names bound so far by the enclosing pattern, which may only be
+// bound once.
+func (p *Parser) parsePattern(bound map[string]bool) ast.Pattern {
+	switch p.curToken.Type {
+	case token.INT, token.MINUS:
+		return p.parseIntegerPattern()
+	case token.STRING:
+		return &ast.LiteralPattern{Value: &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}}
+	case token.TRUE, token.FALSE:
+		return &ast.LiteralPattern{Value: &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}}
+	case token.NULL:
+		return &ast.LiteralPattern{Value: &ast.NullLiteral{Token: p.curToken}}
+	case token.IDENT:
+		if p.curToken.Literal == "_" {
+			return &ast.WildcardPattern{Token: p.curToken}
+		}
+		return &ast.BindingPattern{Name: p.bindPattern(bound)}
+	case token.LBRACKET:
+		return p.parseArrayPattern(bound)
+	case token.LBRACE:
+		return p.parseHashPattern(bound)
+	}
+	p.errorAt(p.curToken, CodeExpectedPattern, patternHint, "expected a pattern, got %s", p.curToken.Type)
+	return &ast.WildcardPattern{Token: p.curToken}
+}
+
+// bindPattern returns the name at the current token, which a pattern binds.
+func (p *Parser) bindPattern(bound map[string]bool) *ast.Identifier {
+	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if bound[ident.Value] && ident.Value != "_" {
+		p.errorAt(ident.Token, CodeExpectedPattern, "", "%s is bound more than once in the pattern", ident.Value)
+	}
+	bound[ident.Value] = true
+	return ident
+}
+
+func (p *Parser) parseIntegerPattern() ast.Pattern {
+	tok := p.curToken
+	if p.curTokenIs(token.MINUS) && !p.expectPeek(token.INT) {
+		return &ast.WildcardPattern{Token: tok}
+	}
+	literal := p.curToken.Literal
+	if tok.Type == token.MINUS {
+		literal = "-" + literal
+	}
+	value, err := strconv.ParseInt(literal, 0, 64)
+	if err != nil {
+		p.errorAt(p.curToken, CodeBadInteger, "integers must fit in 64 bits",
+			"could not parse %q as integer", literal)
+		return &ast.WildcardPattern{Token: tok}
+	}
+	tok.Type, tok.Literal, tok.End = token.INT, literal, p.curToken.End
+	return &ast.LiteralPattern{Value: &ast.IntegerLiteral{Token: tok, Value: value}}
+}
+
+func (p *Parser) parseArrayPattern(bound map[string]bool) ast.Pattern {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+	for !p.peekTokenIs(token.RBRACKET) && !p.panicking {
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				break
+			}
+			pattern.Rest = p.bindPattern(bound)
+			if !p.peekTokenIs(token.RBRACKET) {
+				p.errorAt(p.peekToken, CodeExpectedPattern, "", "the rest of an array pattern must come last")
+			}
+			break
+		}
+		pattern.Elements = append(pattern.Elements, p.parsePattern(bound))
+		if !p.peekTokenIs(token.RBRACKET) && !p.expectPeek(token.COMMA) {
+			break
+		}
+	}
+	if !p.panicking {
+		p.expectPeek(token.RBRACKET)
+	}
+	return pattern
+}
+
+func (p *Parser) parseHashPattern(bound map[string]bool) ast.Pattern {
+	pattern := &ast.HashPattern{Token: p.curToken}
+	for !p.peekTokenIs(token.RBRACE) && !p.panicking {
+		p.nextToken()
+		var key ast.Expression
+		switch p.curToken.Type {
+		case token.STRING:
+			key = &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
+		case token.TRUE, token.FALSE:
+			key = &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
+		case token.INT, token.MINUS:
+			lit, ok := p.parseIntegerPattern().(*ast.LiteralPattern)
+			if !ok {
+				return pattern
+			}
+			key = lit.Value
+		default:
+			p.errorAt(p.curToken, CodeExpectedPattern, "hash pattern keys are strings, integers or booleans",
+				"expected a hash key, got %s", p.curToken.Type)
+			return pattern
+		}
+		if !p.expectPeek(token.COLON) {
+			break
+		}
+		p.nextToken()
+		pattern.Keys = append(pattern.Keys, key)
+		pattern.Values = append(pattern.Values, p.parsePattern(bound))
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			break
+		}
+	}
+	if !p.panicking {
+		p.expectPeek(token.RBRACE)
+	}
+	return pattern
+}
+
+// checkExhaustive warns about a match on a boolean, one whose patterns are
+// boolean literals, that does not cover both values with arms that have no
+// guard. Other matches may well be exhaustive, but that cannot be told
+// without knowing the type of the subject.
+func (p *Parser) checkExhaustive(match *ast.MatchExpression) {
+	covered := map[bool]bool{}
+	boolean := false
+	for _, arm := range match.Arms {
+		switch pattern := arm.Pattern.(type) {
+		case *ast.WildcardPattern, *ast.BindingPattern:
+			if arm.Guard == nil {
+				return
+			}
+		case *ast.LiteralPattern:
+			b, ok := pattern.Value.(*ast.Boolean)
+			if !ok {
+				return
+			}
+			boolean = true
+			if arm.Guard == nil {
+				covered[b.Value] = true
+			}
+		default:
+			return
+		}
+	}
+	if !boolean {
+		return
+	}
+	for _, value := range []bool{true, false} {
+		if !covered[value] {
+			p.report(Diagnostic{
+				Code:     CodeNonExhaustive,
+				Severity: Warning,
+				Message:  "match on a boolean does not cover " + strconv.FormatBool(value),
+				Pos:      match.Token.Pos,
+				End:      match.Token.End,
+				Hint:     "add an arm for " + strconv.FormatBool(value) + " or _",
+			})
+		}
+	}
+}
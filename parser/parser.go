@@ -14,6 +14,7 @@ import (
 const (
 	_ int = iota
 	LOWEST
+	COALESCE // ??
 	EQUALS
 	// ==
 	LESSGREATER // > or <
@@ -21,27 +22,49 @@ const (
 	PRODUCT     // *
 	PREFIX      // -X or !X
 	CALL        // myFunction(X)
+	INDEX       // array[index]
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
+	token.EQ:             EQUALS,
+	token.NOT_EQ:         EQUALS,
+	token.LT:             LESSGREATER,
+	token.GT:             LESSGREATER,
+	token.PLUS:           SUM,
+	token.MINUS:          SUM,
+	token.SLASH:          PRODUCT,
+	token.ASTERISK:       PRODUCT,
+	token.LPAREN:         CALL,
+	token.LBRACKET:       INDEX,
+	token.COALESCE:       COALESCE,
+	token.IS:             EQUALS,
+	token.OPTIONAL_INDEX: INDEX,
+	token.DOT:            CALL,
+	token.OPTIONAL_FIELD: CALL,
 }
 
 type Parser struct {
 	l *lexer.Lexer
 
-	errors []string
+	errors      []string
+	diagnostics []Diagnostic
 
+	prevToken token.Token
 	curToken  token.Token
 	peekToken token.Token
+	// pushedBack holds the token that followed peekToken after a backup.
+	pushedBack *token.Token
+
+	// panicking is set from the first error in a statement until the parser
+	// has synchronised on the next statement boundary. Errors reported in
+	// the meantime are dropped, as they are usually caused by the first.
+	panicking bool
+	// depth counts the brackets, braces and parentheses opened up to and
+	// including curToken that are still open, and open holds their types,
+	// with the parentheses of for loop headers recorded as FOR.
+	depth  int
+	open   []token.TokenType
+	popped token.TokenType
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -72,13 +95,25 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.NULL, p.parseNull)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
 	p.registerPrefix(token.FOR, p.parseForExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.MACRO, p.parseMacroLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
+	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.OPTIONAL_INDEX, p.parseIndexExpression)
+	p.registerInfix(token.COALESCE, p.parseInfixExpression)
+	p.registerInfix(token.IS, p.parseIsNullExpression)
+	p.registerInfix(token.DOT, p.parseFieldExpression)
+	p.registerInfix(token.OPTIONAL_FIELD, p.parseFieldExpression)
 	p.registerInfix(token.PLUS, p.parseInfixExpression)
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
@@ -98,15 +133,53 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Diagnostics returns the problems found while parsing, in source order.
+func (p *Parser) Diagnostics() []Diagnostic {
+	return p.diagnostics
+}
+
+func (p *Parser) report(d Diagnostic) {
+	if p.panicking {
+		return
+	}
+	if d.Severity == Error {
+		p.panicking = true
+		p.errors = append(p.errors, d.Message)
+	}
+	p.diagnostics = append(p.diagnostics, d)
+}
+
+func (p *Parser) errorAt(tok token.Token, code string, hint string, format string, a ...interface{}) {
+	p.report(Diagnostic{
+		Code:     code,
+		Severity: Error,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      tok.Pos,
+		End:      tok.End,
+		Hint:     hint,
+	})
+}
+
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead",
-		t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	if p.peekTokenIs(token.ILLEGAL) {
+		p.illegalError(p.peekToken)
+		return
+	}
+	p.errorAt(p.peekToken, CodeUnexpectedToken, expectHints[t],
+		"expected next token to be %s, got %s instead", t, p.peekToken.Type)
 }
 
 func (p *Parser) noPrefixParseFnError(t token.TokenType) {
-	msg := fmt.Sprintf("no prefix parse function for %s found", t)
-	p.errors = append(p.errors, msg)
+	if t == token.ILLEGAL {
+		p.illegalError(p.curToken)
+		return
+	}
+	p.errorAt(p.curToken, CodeExpectedExpr, "expected an expression",
+		"no prefix parse function for %s found", t)
+}
+
+func (p *Parser) illegalError(tok token.Token) {
+	p.errorAt(tok, CodeIllegalChar, "", "illegal character %q", tok.Literal)
 }
 
 func (p *Parser) expectPeek(t token.TokenType) bool {
@@ -119,49 +192,170 @@ func (p *Parser) expectPeek(t token.TokenType) bool {
 	}
 }
 
-func (p *Parser) peekPrecedence() int {
-	if p, ok := precedences[p.peekToken.Type]; ok {
+// Precedence returns how tightly the infix operator t binds, or LOWEST if t
+// is not one. Package format uses it to place parentheses where the parser
+// needs them.
+func Precedence(t token.TokenType) int {
+	if p, ok := precedences[t]; ok {
 		return p
 	}
 
 	return LOWEST
 }
 
-func (p *Parser) curPrecedence() int {
-	if p, ok := precedences[p.curToken.Type]; ok {
-		return p
-	}
+func (p *Parser) peekPrecedence() int {
+	return Precedence(p.peekToken.Type)
+}
 
-	return LOWEST
+func (p *Parser) curPrecedence() int {
+	return Precedence(p.curToken.Type)
 }
 
 func (p *Parser) nextToken() {
+	p.prevToken = p.curToken
 	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	if p.pushedBack != nil {
+		p.peekToken = *p.pushedBack
+		p.pushedBack = nil
+	} else {
+		p.peekToken = p.l.NextToken()
+	}
+	switch nesting(p.curToken.Type) {
+	case 1:
+		opened := p.curToken.Type
+		if opened == token.LPAREN && p.prevToken.Type == token.FOR {
+			opened = token.FOR
+		}
+		if opened == token.OPTIONAL_INDEX {
+			opened = token.LBRACKET
+		}
+		p.open = append(p.open, opened)
+		p.depth++
+	case -1:
+		if n := len(p.open); n > 0 {
+			p.popped = p.open[n-1]
+			p.open = p.open[:n-1]
+		}
+		p.depth--
+	}
+}
+
+// backup undoes the last nextToken. It can only be called once in a row.
+func (p *Parser) backup() {
+	switch nesting(p.curToken.Type) {
+	case 1:
+		p.open = p.open[:len(p.open)-1]
+		p.depth--
+	case -1:
+		p.open = append(p.open, p.popped)
+		p.depth++
+	}
+	pushed := p.peekToken
+	p.pushedBack = &pushed
+	p.peekToken = p.curToken
+	p.curToken = p.prevToken
+}
+
+// unclosed reports whether the innermost open delimiter is a bracket or
+// parenthesis that cannot contain a semicolon.
+func (p *Parser) unclosed() bool {
+	if len(p.open) == 0 {
+		return false
+	}
+	top := p.open[len(p.open)-1]
+	return top == token.LPAREN || top == token.LBRACKET
+}
+
+func nesting(t token.TokenType) int {
+	switch t {
+	case token.LPAREN, token.LBRACE, token.LBRACKET, token.OPTIONAL_INDEX:
+		return 1
+	case token.RPAREN, token.RBRACE, token.RBRACKET:
+		return -1
+	}
+	return 0
+}
+
+// synchronize skips the rest of a statement that failed to parse, given the
+// nesting depth it started at. It stops on the statement's terminating
+// semicolon, or before the closing brace of the enclosing block or the start
+// of the next let or return statement, ignoring any of these that appear
+// inside brackets opened by the statement itself.
+func (p *Parser) synchronize(start int) {
+	p.panicking = false
+
+	for !p.curTokenIs(token.EOF) {
+		if p.depth < start {
+			if p.curTokenIs(token.RBRACE) && start > 0 {
+				// Leave the brace closing the block to the block.
+				p.backup()
+				return
+			}
+			// A stray closing delimiter.
+			p.depth = start
+		}
+		if p.curTokenIs(token.SEMICOLON) && p.depth > start && p.unclosed() {
+			// Only for loop headers hold semicolons in parentheses, so
+			// the statement left its brackets unclosed.
+			p.open = p.open[:len(p.open)-(p.depth-start)]
+			p.depth = start
+			return
+		}
+		if p.depth == start {
+			if p.curTokenIs(token.SEMICOLON) {
+				return
+			}
+			switch p.peekToken.Type {
+			case token.LET, token.RETURN, token.THROW, token.STRUCT:
+				return
+			case token.RBRACE:
+				if start > 0 {
+					return
+				}
+			}
+		}
+		p.nextToken()
+	}
 }
 
 func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 	for p.curToken.Type != token.EOF {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			program.Statements = append(program.Statements, stmt)
-		}
+		program.Statements = append(program.Statements, p.parseStatement())
 		p.nextToken()
 	}
 	return program
 }
 
+// parseStatement never returns nil: statements that cannot be parsed at all
+// are replaced by an *ast.BadStatement.
 func (p *Parser) parseStatement() ast.Statement {
+	start, depth := p.curToken, p.depth-nesting(p.curToken.Type)
+	var stmt ast.Statement
 	switch p.curToken.Type {
 	case token.LET:
-		return p.parseLetStatement()
+		if let := p.parseLetStatement(); let != nil {
+			stmt = let
+		}
 	case token.RETURN:
-		return p.parseReturnStatement()
+		stmt = p.parseReturnStatement()
+	case token.THROW:
+		stmt = p.parseThrowStatement()
+	case token.STRUCT:
+		if decl := p.parseStructStatement(); decl != nil {
+			stmt = decl
+		}
 	default:
-		return p.parseExpressionStatement()
+		stmt = p.parseExpressionStatement()
+	}
+	if p.panicking {
+		p.synchronize(depth)
+	}
+	if stmt == nil {
+		return &ast.BadStatement{Token: start}
 	}
+	return stmt
 }
 
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
@@ -177,12 +371,60 @@ func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() *ast.ThrowStatement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+
+	p.nextToken()
+
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseStructStatement() *ast.StructStatement {
+	stmt := &ast.StructStatement{Token: p.curToken}
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+	seen := map[string]bool{}
+	for !p.peekTokenIs(token.RBRACE) {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		field := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		field.Type = p.parseAnnotation()
+		if seen[field.Value] {
+			p.errorAt(field.Token, CodeUnexpectedToken, "", "duplicate field %s in struct %s", field.Value, stmt.Name.Value)
+			return nil
+		}
+		seen[field.Value] = true
+		stmt.Fields = append(stmt.Fields, field)
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return nil
+		}
+	}
+	p.nextToken()
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseLetStatement() *ast.LetStatement {
 	stmt := &ast.LetStatement{Token: p.curToken}
 	if !p.expectPeek(token.IDENT) {
 		return nil
 	}
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	stmt.Name.Type = p.parseAnnotation()
 	if !p.expectPeek(token.ASSIGN) {
 		return nil
 	}
@@ -213,11 +455,11 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
 		p.noPrefixParseFnError(p.curToken.Type)
-		return nil
+		return p.badExpression(p.curToken)
 	}
 	leftExp := prefix()
 
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+	for !p.panicking && !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
 		infix := p.infixParseFns[p.peekToken.Type]
 		if infix == nil {
 			return leftExp
@@ -236,31 +478,35 @@ func (p *Parser) parseGroupedExpression() ast.Expression {
 	exp := p.parseExpression(LOWEST)
 
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(p.peekToken)
 	}
 
 	return exp
 }
 
+func (p *Parser) badExpression(tok token.Token) ast.Expression {
+	return &ast.BadExpression{Token: tok}
+}
+
 func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 	expression.Consequence = p.parseBlockStatement()
 
 	if p.peekTokenIs(token.ELSE) {
 		p.nextToken()
 		if !p.expectPeek(token.LBRACE) {
-			return nil
+			return p.badExpression(expression.Token)
 		}
 		expression.Alternative = p.parseBlockStatement()
 	}
@@ -268,45 +514,77 @@ func (p *Parser) parseIfExpression() ast.Expression {
 	return expression
 }
 
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
+	if !p.expectPeek(token.LBRACE) {
+		return p.badExpression(expression.Token)
+	}
+	expression.Body = p.parseBlockStatement()
+
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+			return p.badExpression(expression.Token)
+		}
+		expression.Catch = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		if !p.expectPeek(token.RPAREN) || !p.expectPeek(token.LBRACE) {
+			return p.badExpression(expression.Token)
+		}
+		expression.Handler = p.parseBlockStatement()
+	}
+	if p.peekTokenIs(token.FINALLY) {
+		p.nextToken()
+		if !p.expectPeek(token.LBRACE) {
+			return p.badExpression(expression.Token)
+		}
+		expression.Finally = p.parseBlockStatement()
+	}
+	if expression.Handler == nil && expression.Finally == nil {
+		p.errorAt(p.peekToken, CodeUnexpectedToken, "a try needs a catch or a finally block",
+			"expected next token to be %s, got %s instead", token.CATCH, p.peekToken.Type)
+		return p.badExpression(expression.Token)
+	}
+
+	return expression
+}
+
 func (p *Parser) parseForExpression() ast.Expression {
 	expression := &ast.ForExpression{Token: p.curToken}
 	if !p.expectPeek(token.LPAREN) {
-		msg := fmt.Sprintf("1. could not parse %q as LPAREN", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		return p.badExpression(expression.Token)
 	}
 	if !p.expectPeek(token.LET) {
-		msg := fmt.Sprintf("2. could not parse %q as LET", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		return p.badExpression(expression.Token)
 	}
-	expression.Variable = *p.parseLetStatement()
-	if !p.expectPeek(token.IDENT) {
-		msg := fmt.Sprintf("3. could not parse %q as IDENT", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+	variable := p.parseLetStatement()
+	if variable == nil {
+		return p.badExpression(expression.Token)
 	}
+	expression.Variable = *variable
+	if !p.peekTokenIs(token.IDENT) {
+		p.errorAt(p.peekToken, CodeUnexpectedToken,
+			"the loop condition must start with the loop variable",
+			"expected next token to be %s, got %s instead", token.IDENT, p.peekToken.Type)
+		return p.badExpression(expression.Token)
+	}
+	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
 	if !p.expectPeek(token.SEMICOLON) {
-		msg := fmt.Sprintf("4. could not parse %q as SEMICOLON", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		return p.badExpression(expression.Token)
 	}
 	if !p.expectPeek(token.LET) {
-		msg := fmt.Sprintf("4. could not parse %q as IDENT", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		return p.badExpression(expression.Token)
+	}
+	update := p.parseLetStatement()
+	if update == nil {
+		return p.badExpression(expression.Token)
 	}
-	expression.Update = *p.parseLetStatement()
+	expression.Update = *update
 	if !p.expectPeek(token.RPAREN) {
-		msg := fmt.Sprintf("5. could not parse %q as RPAREN", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		return p.badExpression(expression.Token)
 	}
 	if !p.expectPeek(token.LBRACE) {
-		msg := fmt.Sprintf("6. could not parse %q as LBRACE", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		return p.badExpression(expression.Token)
 	}
 	expression.Loop = p.parseBlockStatement()
 
@@ -318,23 +596,46 @@ func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block.Statements = []ast.Statement{}
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
-		stmt := p.parseStatement()
-		if stmt != nil {
-			block.Statements = append(block.Statements, stmt)
-		}
+		block.Statements = append(block.Statements, p.parseStatement())
 		p.nextToken()
 	}
+	if p.curTokenIs(token.EOF) {
+		p.errorAt(p.curToken, CodeUnexpectedToken, expectHints[token.RBRACE],
+			"expected next token to be %s, got %s instead", token.RBRACE, token.EOF)
+	}
 	return block
 }
 
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(lit.Token)
 	}
-	lit.Parameters = p.parseFunctionParameters()
-	if !p.expectPeek(token.LBRACE) {
-		return nil
+	lit.Parameters, lit.Variadic = p.parseFunctionParameters()
+	lit.ReturnType = p.parseAnnotation()
+	if p.panicking || !p.expectPeek(token.LBRACE) {
+		return p.badExpression(lit.Token)
+	}
+	lit.Body = p.parseBlockStatement()
+	ast.MarkTailCalls(lit)
+	return lit
+}
+
+func (p *Parser) parseMacroLiteral() ast.Expression {
+	lit := &ast.MacroLiteral{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return p.badExpression(lit.Token)
+	}
+	params, variadic := p.parseFunctionParameters()
+	for i, param := range params {
+		if param.Default != nil || param.Type != nil || (variadic && i == len(params)-1) {
+			p.errorAt(param.Token, CodeUnexpectedToken, "macros take their arguments unevaluated, as quotes",
+				"macro parameter %s must be a plain name", param.Value)
+		}
+	}
+	lit.Parameters = params
+	if p.panicking || !p.expectPeek(token.LBRACE) {
+		return p.badExpression(lit.Token)
 	}
 	lit.Body = p.parseBlockStatement()
 	return lit
@@ -344,25 +645,57 @@ func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
-func (p *Parser) parseFunctionParameters() []*ast.Identifier {
+// parseFunctionParameters parses the parameters of a function literal and
+// reports whether the last one is a rest parameter. Parameters with default
+// values must come after those without, and a rest parameter comes last.
+func (p *Parser) parseFunctionParameters() ([]*ast.Identifier, bool) {
 	identifiers := []*ast.Identifier{}
 	if p.peekTokenIs(token.RPAREN) {
 		p.nextToken()
-		return identifiers
+		return identifiers, false
 	}
-	p.nextToken()
-	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
-	identifiers = append(identifiers, ident)
-	for p.peekTokenIs(token.COMMA) {
-		p.nextToken()
-		p.nextToken()
+	variadic, defaults := false, false
+	for !p.panicking {
+		if len(identifiers) > 0 && !p.expectPeek(token.COMMA) {
+			break
+		}
+		if variadic {
+			p.errorAt(p.curToken, CodeUnexpectedToken, "",
+				"only the last parameter can be a rest parameter")
+			break
+		}
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			variadic = true
+		}
+		if !p.expectPeek(token.IDENT) {
+			break
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		ident.Type = p.parseAnnotation()
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			if variadic {
+				p.errorAt(p.curToken, CodeUnexpectedToken, "",
+					"rest parameter %s cannot have a default value", ident.Value)
+				break
+			}
+			p.nextToken()
+			ident.Default = p.parseExpression(LOWEST)
+			defaults = true
+		} else if defaults && !variadic {
+			p.errorAt(ident.Token, CodeUnexpectedToken, "give "+ident.Value+" a default value or move it first",
+				"parameter %s without a default value follows one with a default", ident.Value)
+		}
 		identifiers = append(identifiers, ident)
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
 	}
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+	if !p.panicking {
+		p.expectPeek(token.RPAREN)
 	}
-	return identifiers
+	return identifiers, variadic
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
@@ -372,22 +705,65 @@ func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 }
 
 func (p *Parser) parseCallArguments() []ast.Expression {
-	args := []ast.Expression{}
-	if p.peekTokenIs(token.RPAREN) {
+	return p.parseExpressionList(token.RPAREN)
+}
+
+func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+	list := []ast.Expression{}
+	if p.peekTokenIs(end) {
 		p.nextToken()
-		return args
+		return list
 	}
 	p.nextToken()
-	args = append(args, p.parseExpression(LOWEST))
-	for p.peekTokenIs(token.COMMA) {
+	list = append(list, p.parseExpression(LOWEST))
+	for !p.panicking && p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
+		list = append(list, p.parseExpression(LOWEST))
 	}
-	if !p.expectPeek(token.RPAREN) {
-		return nil
+	if !p.panicking {
+		p.expectPeek(end)
+	}
+	return list
+}
+
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{Token: p.curToken}
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+	return array
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	exp := &ast.IndexExpression{Token: p.curToken, Left: left, Optional: p.curTokenIs(token.OPTIONAL_INDEX)}
+	p.nextToken()
+	exp.Index = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RBRACKET) {
+		return p.badExpression(exp.Token)
+	}
+	return exp
+}
+
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{Token: p.curToken}
+	hash.Pairs = make(map[ast.Expression]ast.Expression)
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+		if !p.expectPeek(token.COLON) {
+			return p.badExpression(hash.Token)
+		}
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+		hash.Keys = append(hash.Keys, key)
+		hash.Pairs[key] = value
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return p.badExpression(hash.Token)
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return p.badExpression(hash.Token)
 	}
-	return args
+	return hash
 }
 
 func (p *Parser) parseIntegerLiteral() ast.Expression {
@@ -396,21 +772,77 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		p.errorAt(p.curToken, CodeBadInteger, "integers must fit in 64 bits",
+			"could not parse %q as integer", p.curToken.Literal)
+		return p.badExpression(p.curToken)
 	}
 
 	lit.Value = value
 	return lit
 }
 
+func (p *Parser) parseNull() ast.Expression {
+	return &ast.NullLiteral{Token: p.curToken}
+}
+
+func (p *Parser) parseIsNullExpression(left ast.Expression) ast.Expression {
+	expression := &ast.IsNullExpression{Token: p.curToken, Value: left}
+	if !p.expectPeek(token.NULL) {
+		return p.badExpression(expression.Token)
+	}
+	return expression
+}
+
 func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
 
 func (p *Parser) parseIdentifier() ast.Expression {
-	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if p.peekTokenIs(token.LBRACE) {
+		// No other expression is followed by a brace, so this is a struct
+		// literal.
+		p.nextToken()
+		return p.parseStructLiteral(ident)
+	}
+	return ident
+}
+
+func (p *Parser) parseStructLiteral(name *ast.Identifier) ast.Expression {
+	lit := &ast.StructLiteral{Token: p.curToken, Name: name}
+	for !p.peekTokenIs(token.RBRACE) {
+		if p.peekTokenIs(token.ELLIPSIS) && lit.Base == nil && len(lit.Fields) == 0 {
+			p.nextToken()
+			p.nextToken()
+			lit.Base = p.parseExpression(LOWEST)
+		} else {
+			if !p.expectPeek(token.IDENT) {
+				return p.badExpression(lit.Token)
+			}
+			lit.Fields = append(lit.Fields, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+			if !p.expectPeek(token.COLON) {
+				return p.badExpression(lit.Token)
+			}
+			p.nextToken()
+			lit.Values = append(lit.Values, p.parseExpression(LOWEST))
+		}
+		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
+			return p.badExpression(lit.Token)
+		}
+	}
+	if !p.expectPeek(token.RBRACE) {
+		return p.badExpression(lit.Token)
+	}
+	return lit
+}
+
+func (p *Parser) parseFieldExpression(left ast.Expression) ast.Expression {
+	exp := &ast.FieldExpression{Token: p.curToken, Left: left, Optional: p.curTokenIs(token.OPTIONAL_FIELD)}
+	if !p.expectPeek(token.IDENT) {
+		return p.badExpression(exp.Token)
+	}
+	exp.Field = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	return exp
 }
 
 func (p *Parser) parsePrefixExpression() ast.Expression {
@@ -447,3 +879,83 @@ func (p *Parser) curTokenIs(t token.TokenType) bool {
 func (p *Parser) peekTokenIs(t token.TokenType) bool {
 	return p.peekToken.Type == t
 }
+
+// ParseType parses input consisting of a single type annotation, as used in
+// declarations of builtin signatures.
+func (p *Parser) ParseType() ast.TypeExpr {
+	t := p.parseType()
+	if !p.panicking {
+		p.expectPeek(token.EOF)
+	}
+	return t
+}
+
+// parseAnnotation parses the optional `: type` following a declared name or
+// parameter list.
+func (p *Parser) parseAnnotation() ast.TypeExpr {
+	if p.panicking || !p.peekTokenIs(token.COLON) {
+		return nil
+	}
+	p.nextToken()
+	p.nextToken()
+	return p.parseType()
+}
+
+func (p *Parser) parseType() ast.TypeExpr {
+	switch p.curToken.Type {
+	case token.IDENT, token.NULL:
+		return &ast.NamedType{Token: p.curToken, Name: p.curToken.Literal}
+	case token.LBRACKET:
+		t := &ast.ArrayType{Token: p.curToken}
+		p.nextToken()
+		t.Elem = p.parseType()
+		p.expectPeek(token.RBRACKET)
+		return t
+	case token.LBRACE:
+		t := &ast.HashType{Token: p.curToken}
+		p.nextToken()
+		t.Key = p.parseType()
+		if p.expectPeek(token.COLON) {
+			p.nextToken()
+			t.Value = p.parseType()
+			p.expectPeek(token.RBRACE)
+		} else {
+			t.Value = t.Key
+		}
+		return t
+	case token.FUNCTION:
+		return p.parseFunctionType()
+	default:
+		p.errorAt(p.curToken, CodeExpectedType, "types are written int, [int], {string: int} or fn(int): int",
+			"expected a type, got %s", p.curToken.Type)
+		return &ast.NamedType{Token: p.curToken, Name: "any"}
+	}
+}
+
+func (p *Parser) parseFunctionType() ast.TypeExpr {
+	t := &ast.FunctionType{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return t
+	}
+	for !p.peekTokenIs(token.RPAREN) && !p.panicking {
+		if len(t.Parameters) > 0 && !p.expectPeek(token.COMMA) {
+			return t
+		}
+		if t.Variadic {
+			p.errorAt(p.peekToken, CodeUnexpectedToken, "",
+				"only the last parameter can be variadic")
+			return t
+		}
+		p.nextToken()
+		if p.curTokenIs(token.ELLIPSIS) {
+			t.Variadic = true
+			p.nextToken()
+		}
+		t.Parameters = append(t.Parameters, p.parseType())
+	}
+	if !p.panicking {
+		p.nextToken()
+	}
+	t.Result = p.parseAnnotation()
+	return t
+}
@@ -2,6 +2,7 @@ package parser
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"github.com/SebastiaanWouters/verigo/ast"
@@ -246,6 +247,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a ?? b + c == d",
+			"(a ?? ((b + c) == d))",
+		},
+		{
+			"a?[0]?[1] ?? b[2] is null",
+			"(((a?[0])?[1]) ?? ((b[2]) is null))",
+		},
+		{
+			"p?.q.x?.y ?? f(a?.b)",
+			"((((p?.q).x)?.y) ?? f((a?.b)))",
+		},
+		{
+			"x is null == !y",
+			"((x is null) == (!y))",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -587,6 +604,33 @@ func TestFunctionParameterParsing(t *testing.T) {
 	}
 }
 
+func TestDefaultAndRestParameters(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+		variadic bool
+	}{
+		{"fn(a, b = 2) {}", "fn(a, b = 2) ", false},
+		{"fn(a, b: int = 1 + 2, c = b) {}", "fn(a, b: int = (1 + 2), c = b) ", false},
+		{"fn(...rest) {}", "fn(...rest) ", true},
+		{"fn(a = 1, ...rest: int) {}", "fn(a = 1, ...rest: int) ", true},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		function := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+		if function.String() != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, function.String())
+		}
+		if function.Variadic != tt.variadic {
+			t.Errorf("%q: wrong Variadic. expected=%t, got=%t", tt.input, tt.variadic, function.Variadic)
+		}
+	}
+}
+
 func TestCallExpressionParsing(t *testing.T) {
 	input := "add(1, 2 * 3, 4 + 5);"
 
@@ -698,7 +742,7 @@ func TestStringLiteralExpression(t *testing.T) {
 	}
 }
 
-/*func TestParsingEmptyArrayLiterals(t *testing.T) {
+func TestParsingEmptyArrayLiterals(t *testing.T) {
 	input := "[]"
 
 	l := lexer.New(input)
@@ -935,7 +979,30 @@ func TestParsingHashLiteralsWithExpressions(t *testing.T) {
 
 		testFunc(value)
 	}
-}*/
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let x: int = 1;", "let x: int = 1;"},
+		{"let xs: [string] = [];", "let xs: [string] = [];"},
+		{"let h: {string: [int]} = {};", "let h: {string: [int]} = {};"},
+		{"fn(a: int, b): bool { a }", "fn(a: int, b): bool a"},
+		{"fn(f: fn(int, ...any): int) { f }", "fn(f: fn(int, ...any): int) f"},
+		{"let f: fn() = fn() {};", "let f: fn() = fn() ;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+}
 
 func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 	if s.TokenLiteral() != "let" {
@@ -1081,3 +1148,184 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+func TestTryExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f(x) } catch (e) { g(e) }", "try f(x)catch(e) g(e)"},
+		{"try { f(x) } finally { g() }", "try f(x)finally g()"},
+		{"let y = try { 1 } catch (e) { 2 } finally { 3 };", "let y = try 1catch(e) 2finally 3;"},
+		{"throw \"bad\";", "throw bad;"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, got)
+		}
+	}
+
+	p := New(lexer.New("try { 1 } 2"))
+	p.ParseProgram()
+	if len(p.Errors()) != 1 || p.Errors()[0] != "expected next token to be CATCH, got INT instead" {
+		t.Errorf("wrong errors for a try without catch. got=%v", p.Errors())
+	}
+}
+
+func TestStructs(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"struct Point { x, y }", "struct Point { x, y }"},
+		{"struct Empty {}; Empty {}", "struct Empty {}Empty{}"},
+		{"struct P { x: int, y: [string] };", "struct P { x: int, y: [string] }"},
+		{"let p = Point { x: 1 + 2, y: f(3) };", "let p = Point{x: (1 + 2), y: f(3)};"},
+		{"Point { ...p, x: 0, }", "Point{...p, x: 0}"},
+		{"p.x", "(p.x)"},
+		{"-p.x * q.y", "((-(p.x)) * (q.y))"},
+		{"a.b[0].c", "(((a.b)[0]).c)"},
+		{"f(x).y(z)", "(f(x).y)(z)"},
+		{"Point { x: 1 }.x", "(Point{x: 1}.x)"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"fn(n) { f(n) }", []string{"f(n)"}},
+		{"fn(n) { return f(n); g(n) }", []string{"f(n)", "g(n)"}},
+		{"fn(n) { f(n); g(n) }", []string{"g(n)"}},
+		{"fn(n) { f(n) + 1 }", nil},
+		{"fn(n) { let x = f(n); x }", nil},
+		{"fn(n) { f(g(n)) }", []string{"f(g(n))"}},
+		{"fn(n) { if (n) { f(n) } else { g(n) } }", []string{"f(n)", "g(n)"}},
+		{"fn(n) { if (n) { f(n) }; g(n) }", []string{"g(n)"}},
+		{"fn(n) { if (n) { return f(n); }; 1 }", []string{"f(n)"}},
+		{"fn(n) { return if (n) { f(n) } else { 1 }; }", []string{"f(n)"}},
+		{"fn(n) { for (let i = 0; i < n; let i = i + 1) { return f(i); } }", nil},
+		{"fn(n) { try { return f(n); } catch (e) { g(e) } }", nil},
+		{"fn(n) { fn(m) { f(m) }; g(n) + 1 }", []string{"f(m)"}},
+		{"f(n)", nil},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		var got []string
+		collectTailCalls(program.Statements, &got)
+		if strings.Join(got, ", ") != strings.Join(tt.expected, ", ") {
+			t.Errorf("%q: wrong tail calls. expected=%v, got=%v", tt.input, tt.expected, got)
+		}
+	}
+}
+
+// collectTailCalls appends the calls marked as tail calls in stmts.
+func collectTailCalls(stmts []ast.Statement, calls *[]string) {
+	var expr func(ast.Expression)
+	block := func(b *ast.BlockStatement) {
+		if b != nil {
+			collectTailCalls(b.Statements, calls)
+		}
+	}
+	expr = func(e ast.Expression) {
+		switch e := e.(type) {
+		case *ast.CallExpression:
+			if e.Tail {
+				*calls = append(*calls, e.String())
+			}
+			expr(e.Function)
+			for _, a := range e.Arguments {
+				expr(a)
+			}
+		case *ast.InfixExpression:
+			expr(e.Left)
+			expr(e.Right)
+		case *ast.IfExpression:
+			expr(e.Condition)
+			block(e.Consequence)
+			block(e.Alternative)
+		case *ast.ForExpression:
+			block(e.Loop)
+		case *ast.FunctionLiteral:
+			block(e.Body)
+		}
+	}
+	for _, s := range stmts {
+		switch s := s.(type) {
+		case *ast.LetStatement:
+			expr(s.Value)
+		case *ast.ReturnStatement:
+			expr(s.ReturnValue)
+		case *ast.ExpressionStatement:
+			expr(s.Expression)
+		}
+	}
+}
+
+func TestMatchExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`match (x) { 0 => "zero", -1 => "minus", _ => x }`, `match (x) { 0 => zero, -1 => minus, _ => x }`},
+		{`match (f(x)) { [a, b] => a + b, [h, ...t] => t, }`, "match (f(x)) { [a, b] => (a + b), [h, ...t] => t }"},
+		{`match (h) { {"k": [v], 1: true} => v, null => 0 }`, `match (h) { {"k": [v], 1: true} => v, null => 0 }`},
+		{`match (n) { n if n > 1 => { let m = n; m }, false => 2 }`, "match (n) { n if (n > 1) => { let m = n;m }, false => 2 }"},
+		{`let y = match (x) { _ => 1 } + 1;`, "let y = (match (x) { _ => 1 } + 1);"},
+		{`match (x) {}`, "match (x) { }"},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestMacroLiteral(t *testing.T) {
+	input := `macro(x, y) { x + y; }`
+
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("program.Statements does not contain 1 statement. got=%d", len(program.Statements))
+	}
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("statement is not ast.ExpressionStatement. got=%T", program.Statements[0])
+	}
+	macro, ok := stmt.Expression.(*ast.MacroLiteral)
+	if !ok {
+		t.Fatalf("stmt.Expression is not ast.MacroLiteral. got=%T", stmt.Expression)
+	}
+	if len(macro.Parameters) != 2 {
+		t.Fatalf("macro literal parameters wrong. want 2, got=%d", len(macro.Parameters))
+	}
+	testLiteralExpression(t, macro.Parameters[0], "x")
+	testLiteralExpression(t, macro.Parameters[1], "y")
+	if macro.String() != "macro(x, y) (x + y)" {
+		t.Errorf("wrong String. got=%q", macro.String())
+	}
+}
@@ -0,0 +1,125 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// WritePprof writes the profile gzipped in the protocol buffer format of
+// pprof, with ops, gas and wall time as the values of its samples. Every
+// call is a location at the line it was on, in the function it was
+// evaluating, so that pprof attributes them to Monkey functions and lines.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	e := &pprofEncoder{strings: map[string]uint64{"": 0}, table: []string{""}}
+	var profile protobuf
+	for _, t := range [][2]string{{"ops", "count"}, {"gas", "units"}, {"wall", "nanoseconds"}} {
+		profile.message(1, e.valueType(t[0], t[1]))
+	}
+	functions := map[*Function]uint64{}
+	locations := map[Frame]uint64{}
+	var defs protobuf // functions and locations, written after the samples
+	for _, s := range p.order {
+		var sample, ids protobuf
+		for i := len(s.Stack) - 1; i >= 0; i-- {
+			frame := s.Stack[i]
+			id, ok := locations[frame]
+			if !ok {
+				fid, ok := functions[frame.Function]
+				if !ok {
+					fid = uint64(len(functions) + 1)
+					functions[frame.Function] = fid
+					var function protobuf
+					function.uint(1, fid)
+					function.uint(2, e.string(frame.Function.Name))
+					function.uint(4, e.string(p.path))
+					function.uint(5, uint64(frame.Function.Line))
+					defs.message(5, function)
+				}
+				id = uint64(len(locations) + 1)
+				locations[frame] = id
+				var line, location protobuf
+				line.uint(1, fid)
+				line.uint(2, uint64(frame.Line))
+				location.uint(1, id)
+				location.message(4, line)
+				defs.message(4, location)
+			}
+			ids.varint(id)
+		}
+		var values protobuf
+		values.varint(s.Ops)
+		values.varint(s.Gas)
+		values.varint(uint64(s.Time))
+		sample.message(1, ids)
+		sample.message(2, values)
+		profile.message(2, sample)
+	}
+	profile = append(profile, defs...)
+	period := e.valueType("gas", "units")
+	def := e.string("gas")
+	for _, s := range e.table {
+		profile.bytes(6, []byte(s))
+	}
+	profile.message(11, period)
+	profile.uint(14, def) // the default sample type
+
+	z := gzip.NewWriter(w)
+	if _, err := z.Write(profile); err != nil {
+		return err
+	}
+	return z.Close()
+}
+
+// pprofEncoder keeps the string table of a profile.
+type pprofEncoder struct {
+	strings map[string]uint64
+	table   []string
+}
+
+func (e *pprofEncoder) string(s string) uint64 {
+	i, ok := e.strings[s]
+	if !ok {
+		i = uint64(len(e.table))
+		e.strings[s] = i
+		e.table = append(e.table, s)
+	}
+	return i
+}
+
+func (e *pprofEncoder) valueType(typ, unit string) protobuf {
+	var v protobuf
+	v.uint(1, e.string(typ))
+	v.uint(2, e.string(unit))
+	return v
+}
+
+// protobuf is an encoded protocol buffer message.
+type protobuf []byte
+
+func (b *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		*b = append(*b, byte(x)|0x80)
+		x >>= 7
+	}
+	*b = append(*b, byte(x))
+}
+
+// uint encodes an integer field, leaving it out if it is zero.
+func (b *protobuf) uint(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(field) << 3)
+	b.varint(x)
+}
+
+// bytes encodes a length-delimited field.
+func (b *protobuf) bytes(field int, data []byte) {
+	b.varint(uint64(field)<<3 | 2)
+	b.varint(uint64(len(data)))
+	*b = append(*b, data...)
+}
+
+func (b *protobuf) message(field int, m protobuf) {
+	b.bytes(field, m)
+}
@@ -0,0 +1,182 @@
+// Package profile finds out where programs spend their gas and time. A
+// Profiler is an object.Hook that follows the calls of a program and the
+// line each is on, and attributes every charged opcode, and the time
+// between statements, to the calls being evaluated.
+//
+// Functions are told apart by where they are defined, so that the closures
+// made by one function literal are one function. Profiles are reported
+// flat, by function or by line, as a call tree, or in the format of pprof:
+//
+//	verigo profile -o prog.pb.gz prog.mk
+//	go tool pprof -top prog.pb.gz
+package profile
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/gas"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// Function is a function of the profiled program, or the program itself.
+type Function struct {
+	Name string
+	Line int // of the body, where the function is defined
+	Col  int
+
+	id int // tells functions apart in the keys of samples
+}
+
+// String returns the name of f and where it is defined.
+func (f *Function) String() string {
+	if f.Line == 0 {
+		return f.Name
+	}
+	return f.Name + " " + strconv.Itoa(f.Line) + ":" + strconv.Itoa(f.Col)
+}
+
+// Frame is a call on a stack, and the line it is on.
+type Frame struct {
+	Function *Function
+	Line     int
+}
+
+// Sample is what was charged, and how long it took, while the stack was
+// Stack.
+type Sample struct {
+	Stack []Frame // innermost last
+	Ops   uint64  // charged opcodes
+	Gas   uint64
+	Time  time.Duration
+}
+
+// Profiler profiles the programs run with it as their hook and its Op
+// method as their op sink:
+//
+//	p := profile.New("prog.mk", nil)
+//	in := interpreter.New(interpreter.WithHook(p), interpreter.WithOpSink(p.Op))
+//	in.Run(src)
+//	p.Stop()
+//
+// Running several programs with one profiler adds up their profiles.
+type Profiler struct {
+	path      string
+	schedule  *gas.Schedule
+	now       func() time.Time
+	main      *Function
+	functions map[token.Position]*Function // by the position of their bodies
+	stack     []Frame
+	samples   map[string]*Sample
+	order     []*Sample // samples in the order they were first taken
+	current   *Sample
+	last      time.Time
+}
+
+// New returns a profiler for programs read from path, charging opcodes as
+// schedule does, or gas.DefaultSchedule if it is nil.
+func New(path string, schedule *gas.Schedule) *Profiler {
+	if schedule == nil {
+		schedule = gas.DefaultSchedule
+	}
+	main := &Function{Name: "main"}
+	return &Profiler{
+		path:      path,
+		schedule:  schedule,
+		now:       time.Now,
+		main:      main,
+		functions: map[token.Position]*Function{},
+		stack:     []Frame{{Function: main}},
+		samples:   map[string]*Sample{},
+	}
+}
+
+// Path returns the path the profiled programs were read from.
+func (p *Profiler) Path() string {
+	return p.path
+}
+
+// Op charges op to the calls being evaluated.
+func (p *Profiler) Op(op int) {
+	p.sample()
+	s := p.at()
+	s.Ops++
+	s.Gas += p.schedule.Cost(op)
+}
+
+func (p *Profiler) Statement(stmt ast.Statement, env *object.Environment) {
+	p.sample()
+	p.stack[len(p.stack)-1].Line = ast.Pos(stmt).Line
+	p.current = nil
+}
+
+func (p *Profiler) Call(fn *object.Function, env *object.Environment) {
+	p.sample()
+	pos := fn.Body.Token.Pos
+	f, ok := p.functions[pos]
+	if !ok {
+		f = &Function{Name: fn.Name(), Line: pos.Line, Col: pos.Column, id: len(p.functions) + 1}
+		p.functions[pos] = f
+	}
+	p.stack = append(p.stack, Frame{Function: f, Line: f.Line})
+	p.current = nil
+}
+
+func (p *Profiler) Return(fn *object.Function, result object.Object) {
+	p.sample()
+	if len(p.stack) > 1 {
+		p.stack = p.stack[:len(p.stack)-1]
+	}
+	p.current = nil
+}
+
+// Stop charges the time since the last statement, call or return to the
+// calls being evaluated then. Call it once a program has finished, whether
+// or not it failed in a call.
+func (p *Profiler) Stop() {
+	p.sample()
+	p.stack = []Frame{{Function: p.main}}
+	p.current = nil
+	p.last = time.Time{}
+}
+
+// sample charges the time since the last event to the calls being
+// evaluated.
+func (p *Profiler) sample() {
+	now := p.now()
+	if !p.last.IsZero() {
+		p.at().Time += now.Sub(p.last)
+	}
+	p.last = now
+}
+
+// at returns the sample of the stack, finding it first if the stack has
+// changed since the last event.
+func (p *Profiler) at() *Sample {
+	if p.current != nil {
+		return p.current
+	}
+	var key strings.Builder
+	for _, frame := range p.stack {
+		key.WriteString(strconv.Itoa(frame.Function.id))
+		key.WriteByte(':')
+		key.WriteString(strconv.Itoa(frame.Line))
+		key.WriteByte(' ')
+	}
+	s, ok := p.samples[key.String()]
+	if !ok {
+		s = &Sample{Stack: append([]Frame(nil), p.stack...)}
+		p.samples[key.String()] = s
+		p.order = append(p.order, s)
+	}
+	p.current = s
+	return s
+}
+
+// Samples returns the samples taken, in the order they were first taken.
+func (p *Profiler) Samples() []*Sample {
+	return p.order
+}
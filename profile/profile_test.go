@@ -0,0 +1,175 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SebastiaanWouters/verigo/interpreter"
+)
+
+const program = `let sq = fn(x) { x * x };
+let a = sq(2) + sq(3);
+print(a);`
+
+// profile runs source with p under mode, with a clock that ticks a
+// millisecond whenever it is read.
+func profile(t *testing.T, p *Profiler, source string, mode interpreter.Mode) {
+	t.Helper()
+	var clock time.Time
+	p.now = func() time.Time {
+		clock = clock.Add(time.Millisecond)
+		return clock
+	}
+	in := interpreter.New(interpreter.WithMode(mode), interpreter.WithStdout(io.Discard),
+		interpreter.WithHook(p), interpreter.WithOpSink(p.Op))
+	if _, err := in.Run(source); err != nil {
+		t.Fatal(err)
+	}
+	p.Stop()
+}
+
+func TestFunctionsAndLines(t *testing.T) {
+	for _, mode := range []interpreter.Mode{interpreter.Full, interpreter.Middle} {
+		p := New("prog.mk", nil)
+		profile(t, p, program, mode)
+
+		var functions []string
+		for _, e := range p.Functions() {
+			functions = append(functions, e.Function.String())
+		}
+		if got := strings.Join(functions, ", "); got != "main, sq 1:16" {
+			t.Fatalf("mode %d: wrong functions. got=%q", mode, got)
+		}
+		main, sq := p.Functions()[0], p.Functions()[1]
+		if main.Flat.Ops != 1 || main.Cum.Ops != 5 || sq.Flat.Gas != 4 || sq.Cum.Gas != 4 {
+			t.Errorf("mode %d: wrong costs. main=%+v, sq=%+v", mode, *main, *sq)
+		}
+		if total := p.Total(); total.Gas != 5 || total.Time != main.Cum.Time {
+			t.Errorf("mode %d: wrong total %+v", mode, total)
+		}
+
+		line := p.Lines()[0]
+		if line.Function != main.Function || line.Line != 2 || line.Flat.Ops != 1 || line.Cum.Ops != 5 {
+			t.Errorf("mode %d: wrong first line %+v", mode, *line)
+		}
+	}
+}
+
+func TestRecursionAndRuns(t *testing.T) {
+	source := `let f = fn(n) { if (n > 0) { 1 + f(n - 1) } else { 0 } }; f(3);`
+	p := New("prog.mk", nil)
+	profile(t, p, source, interpreter.Full)
+	profile(t, p, source, interpreter.Full)
+
+	// Recursive calls are charged once to f, and the runs add up.
+	f := p.Functions()[1]
+	if f.Function.Name != "f" || f.Cum.Ops != 28 || f.Cum.Ops != p.Total().Ops {
+		t.Errorf("wrong profile of f %+v, total %+v", *f, p.Total())
+	}
+
+	var tree bytes.Buffer
+	if err := p.WriteTree(&tree); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(tree.String(), "\n"), "\n")
+	if len(lines) != 6 || !strings.HasSuffix(lines[5], "        f 1:15") {
+		t.Errorf("wrong tree.\n%s", tree.String())
+	}
+}
+
+func TestPprof(t *testing.T) {
+	p := New("prog.mk", nil)
+	profile(t, p, program, interpreter.Full)
+
+	var buf bytes.Buffer
+	if err := p.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	z, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(z)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fields := decode(t, data)
+	var table []string
+	for _, s := range fields[6] {
+		table = append(table, string(s.([]byte)))
+	}
+	if got := strings.Join(table, " "); got != " ops count gas units wall nanoseconds main prog.mk sq" {
+		t.Errorf("wrong string table %q", got)
+	}
+	if len(fields[1]) != 3 || len(fields[5]) != 2 || len(fields[4]) != 4 {
+		t.Errorf("wrong number of sample types, functions or locations: %d, %d, %d",
+			len(fields[1]), len(fields[5]), len(fields[4]))
+	}
+
+	// The values of the samples add up to the total.
+	var ops, gas, wall uint64
+	for _, s := range fields[2] {
+		sample := decode(t, s.([]byte))
+		values := packed(t, sample[2][0].([]byte))
+		ops, gas, wall = ops+values[0], gas+values[1], wall+values[2]
+	}
+	if total := p.Total(); ops != total.Ops || gas != total.Gas || wall != uint64(total.Time) {
+		t.Errorf("wrong sum of values %d, %d, %d. expected %+v", ops, gas, wall, total)
+	}
+	if def := fields[14]; len(def) != 1 || table[def[0].(uint64)] != "gas" {
+		t.Errorf("wrong default sample type %v", def)
+	}
+}
+
+// decode decodes the varint and length-delimited fields of a protocol
+// buffer message, by field number.
+func decode(t *testing.T, data []byte) map[int][]interface{} {
+	t.Helper()
+	fields := map[int][]interface{}{}
+	for len(data) > 0 {
+		key := varint(t, &data)
+		field := int(key >> 3)
+		switch key & 7 {
+		case 0:
+			fields[field] = append(fields[field], varint(t, &data))
+		case 2:
+			n := varint(t, &data)
+			if uint64(len(data)) < n {
+				t.Fatalf("field %d: truncated", field)
+			}
+			fields[field] = append(fields[field], data[:n])
+			data = data[n:]
+		default:
+			t.Fatalf("field %d: unexpected wire type %d", field, key&7)
+		}
+	}
+	return fields
+}
+
+func packed(t *testing.T, data []byte) []uint64 {
+	var xs []uint64
+	for len(data) > 0 {
+		xs = append(xs, varint(t, &data))
+	}
+	return xs
+}
+
+func varint(t *testing.T, data *[]byte) uint64 {
+	var x uint64
+	for shift := 0; ; shift += 7 {
+		if len(*data) == 0 {
+			t.Fatal("truncated varint")
+		}
+		b := (*data)[0]
+		*data = (*data)[1:]
+		x |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return x
+		}
+	}
+}
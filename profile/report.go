@@ -0,0 +1,176 @@
+package profile
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cost is what was charged, and how long it took.
+type Cost struct {
+	Ops  uint64
+	Gas  uint64
+	Time time.Duration
+}
+
+func (c *Cost) add(s *Sample) {
+	c.Ops += s.Ops
+	c.Gas += s.Gas
+	c.Time += s.Time
+}
+
+// Entry is a function or a line of a report. Flat is what was charged while
+// it was being evaluated itself, Cum also what was charged in the calls it
+// made.
+type Entry struct {
+	Function *Function
+	Line     int // 0 in reports by function
+	Flat     Cost
+	Cum      Cost
+}
+
+// Functions returns the functions of the profile, by the gas they and the
+// calls they made were charged.
+func (p *Profiler) Functions() []*Entry {
+	return p.entries(func(f Frame) Frame { return Frame{Function: f.Function} })
+}
+
+// Lines returns the lines of the profile, by the gas they and the calls
+// made on them were charged.
+func (p *Profiler) Lines() []*Entry {
+	return p.entries(func(f Frame) Frame { return f })
+}
+
+// entries adds up the samples by the frames key makes of their calls.
+func (p *Profiler) entries(key func(Frame) Frame) []*Entry {
+	byKey := map[Frame]*Entry{}
+	var entries []*Entry
+	for _, s := range p.order {
+		seen := map[Frame]bool{}
+		for i, frame := range s.Stack {
+			k := key(frame)
+			e, ok := byKey[k]
+			if !ok {
+				e = &Entry{Function: k.Function, Line: k.Line}
+				byKey[k] = e
+				entries = append(entries, e)
+			}
+			if i == len(s.Stack)-1 {
+				e.Flat.add(s)
+			}
+			// Recursive calls are only charged once to their function.
+			if !seen[k] {
+				seen[k] = true
+				e.Cum.add(s)
+			}
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i], entries[j]
+		if a.Cum.Gas != b.Cum.Gas {
+			return a.Cum.Gas > b.Cum.Gas
+		}
+		return a.Cum.Time > b.Cum.Time
+	})
+	return entries
+}
+
+// Total returns what the profiled programs were charged.
+func (p *Profiler) Total() Cost {
+	var total Cost
+	for _, s := range p.order {
+		total.add(s)
+	}
+	return total
+}
+
+const header = "   flat ops     cum ops    flat gas     cum gas   flat time    cum time  "
+
+func writeRow(w io.Writer, flat, cum Cost, name string) error {
+	_, err := fmt.Fprintf(w, "%11d %11d %11d %11d %11s %11s  %s\n",
+		flat.Ops, cum.Ops, flat.Gas, cum.Gas, flat.Time, cum.Time, name)
+	return err
+}
+
+// WriteFunctions writes a report of the functions of the profile.
+func (p *Profiler) WriteFunctions(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, header+"function"); err != nil {
+		return err
+	}
+	for _, e := range p.Functions() {
+		if err := writeRow(w, e.Flat, e.Cum, e.Function.String()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteLines writes a report of the lines of the profile.
+func (p *Profiler) WriteLines(w io.Writer) error {
+	if _, err := fmt.Fprintln(w, header+"line"); err != nil {
+		return err
+	}
+	for _, e := range p.Lines() {
+		name := fmt.Sprintf("%s:%d in %s", p.path, e.Line, e.Function.Name)
+		if err := writeRow(w, e.Flat, e.Cum, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// node is a call of the call tree, or the program at its root.
+type node struct {
+	function *Function
+	flat     Cost
+	cum      Cost
+	children []*node
+}
+
+func (n *node) child(f *Function) *node {
+	for _, c := range n.children {
+		if c.function == f {
+			return c
+		}
+	}
+	c := &node{function: f}
+	n.children = append(n.children, c)
+	return c
+}
+
+// WriteTree writes the call tree of the profile, in which every call is
+// below the function that made it.
+func (p *Profiler) WriteTree(w io.Writer) error {
+	root := &node{function: p.main}
+	for _, s := range p.order {
+		n := root
+		n.cum.add(s)
+		for _, frame := range s.Stack[1:] {
+			n = n.child(frame.Function)
+			n.cum.add(s)
+		}
+		n.flat.add(s)
+	}
+	if _, err := fmt.Fprintln(w, header+"call"); err != nil {
+		return err
+	}
+	return writeNode(w, root, 0)
+}
+
+func writeNode(w io.Writer, n *node, depth int) error {
+	if err := writeRow(w, n.flat, n.cum, strings.Repeat("  ", depth)+n.function.String()); err != nil {
+		return err
+	}
+	children := append([]*node(nil), n.children...)
+	sort.SliceStable(children, func(i, j int) bool {
+		return children[i].cum.Gas > children[j].cum.Gas
+	})
+	for _, c := range children {
+		if err := writeNode(w, c, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/SebastiaanWouters/verigo/evaluator"
 	"github.com/SebastiaanWouters/verigo/evaluator_simple"
 	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/macro"
 	"github.com/SebastiaanWouters/verigo/object"
 )
 
@@ -26,42 +27,51 @@ func opChanMonitor(c chan int) {
 		<-c
 	}
 }
-func rChanMonitor(c chan object.Result) {
-	for {
-		writeToDisk(<-c)
+
+// collectResults gathers the results saved to c until it is closed, and
+// then sends them on done.
+func collectResults(c chan object.Result, done chan []object.Result) {
+	results := []object.Result{}
+	for res := range c {
+		results = append(results, res)
 	}
+	done <- results
 }
 
-func writeToDisk(res object.Result) {
-	filename := "results.json"
-
-	err := checkFile(filename)
+// writeToDisk appends res to the JSON array in filename.
+func writeToDisk(filename string, res object.Result) error {
+	// Encode the new result before touching the file, so a value that cannot
+	// be saved leaves the existing results intact.
+	entry, err := json.Marshal(res)
 	if err != nil {
-		fmt.Println(err)
+		return err
+	}
+
+	if err := checkFile(filename); err != nil {
+		return err
 	}
 
 	file, err := ioutil.ReadFile(filename)
 	if err != nil {
-		fmt.Println(err)
+		return err
 	}
 
-	data := []object.Result{}
-
-	json.Unmarshal(file, &data)
+	data := []json.RawMessage{}
+	if len(file) > 0 {
+		if err := json.Unmarshal(file, &data); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+	}
 
-	data = append(data, res)
+	data = append(data, entry)
 
 	// Preparing the data to be marshalled and written.
 	dataBytes, err := json.Marshal(data)
 	if err != nil {
-		fmt.Println(err)
-	}
-
-	err = ioutil.WriteFile(filename, dataBytes, 0644)
-	if err != nil {
-		fmt.Println(err)
+		return err
 	}
 
+	return ioutil.WriteFile(filename, dataBytes, 0644)
 }
 
 func checkFile(filename string) error {
@@ -75,16 +85,23 @@ func checkFile(filename string) error {
 	return nil
 }
 
+// Start runs an interactive session, printing the value of every line and
+// the results it saves. Use StartWithResults to keep the saved results.
 func Start(in io.Reader, out io.Writer) {
+	StartWithResults(in, out, "")
+}
+
+// StartWithResults runs an interactive session like Start, and also
+// appends the results saved to the JSON file at path, unless it is empty.
+func StartWithResults(in io.Reader, out io.Writer, path string) {
 	scanner := bufio.NewScanner(in)
 	env := object.NewEnvironment()
+	macros := object.NewEnvironment()
 	opChan := make(chan int)
-	rChan := make(chan object.Result)
 	go opChanMonitor(opChan)
-	go rChanMonitor(rChan)
 
 	for {
-		fmt.Printf(PROMPT)
+		fmt.Fprint(out, PROMPT)
 		scanned := scanner.Scan()
 		if !scanned {
 			return
@@ -97,9 +114,44 @@ func Start(in io.Reader, out io.Writer) {
 			printParserErrors(out, p.Errors())
 			continue
 		}
+		if errors := expandMacros(program, macros); len(errors) != 0 {
+			printParserErrors(out, errors)
+			continue
+		}
+
+		// Each line saves to its own channel, so that all its results are
+		// in once the channel is closed after it runs.
+		rChan := make(chan object.Result)
+		done := make(chan []object.Result)
+		go collectResults(rChan, done)
+		evaluated := evaluator.Eval(program, env, rChan, opChan)
+		close(rChan)
+
+		for _, res := range <-done {
+			fmt.Fprintf(out, "saved %s = %s\n", res.Key, res.Value.Inspect())
+			if path == "" {
+				continue
+			}
+			if err := writeToDisk(path, res); err != nil {
+				fmt.Fprintf(out, "\t%s\n", err)
+			}
+		}
+		if evaluated != nil {
+			fmt.Fprintln(out, evaluated.Inspect())
+		}
+	}
+}
 
-		evaluator.Eval(program, env, rChan, opChan)
+// expandMacros defines the macros of program in macros and expands the
+// calls to them, as the interpreter does before running a program. It
+// returns the calls that failed to expand.
+func expandMacros(program *ast.Program, macros *object.Environment) []string {
+	macro.Define(program, macros)
+	errors := []string{}
+	for _, d := range macro.Expand(program, macros) {
+		errors = append(errors, d.String())
 	}
+	return errors
 }
 
 func Eval(input string, rChan chan object.Result, opChan chan int) {
@@ -108,12 +160,14 @@ func Eval(input string, rChan chan object.Result, opChan chan int) {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
+	expandMacros(program, object.NewEnvironment())
 
 	evaluator.Eval(program, env, rChan, opChan)
 
 }
 
 func EvalParsed(program *ast.Program, env *object.Environment, rChan chan object.Result, opChan chan int) {
+	expandMacros(program, object.NewEnvironment())
 	evaluator.Eval(program, env, rChan, opChan)
 }
 
@@ -123,12 +177,14 @@ func Eval_Simple(input string) {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
+	expandMacros(program, object.NewEnvironment())
 
 	evaluator_simple.Eval(program, env)
 
 }
 
 func EvalParsed_Simple(program *ast.Program, env *object.Environment) {
+	expandMacros(program, object.NewEnvironment())
 	evaluator_simple.Eval(program, env)
 }
 
@@ -138,12 +194,14 @@ func Eval_Middle(input string, opCount *int) {
 	l := lexer.New(input)
 	p := parser.New(l)
 	program := p.ParseProgram()
+	expandMacros(program, object.NewEnvironment())
 
 	evaluator_middle.Eval(program, env, opCount)
 
 }
 
 func EvalParsed_Middle(program *ast.Program, env *object.Environment, opCount *int) {
+	expandMacros(program, object.NewEnvironment())
 	evaluator_middle.Eval(program, env, opCount)
 }
 
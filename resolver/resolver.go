@@ -0,0 +1,312 @@
+// Package resolver binds the identifiers of a program to their declarations
+// before it runs.
+//
+// Only functions create scopes in Monkey: a let inside a block or loop
+// binds in the enclosing function, or globally at the top level. The
+// resolver gives every variable of a function a slot, recorded in the
+// function literal's Scope, and annotates identifiers referring to them with
+// an ast.Binding so that the evaluators can find them without looking up
+// names. Globals keep being looked up by name, as they may be defined by the
+// host or by earlier programs.
+//
+// As in the evaluators, a let binds its name from the start of the
+// function: until it has run, the name still refers to any outer binding.
+package resolver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/parser"
+)
+
+// Diagnostic codes reported by the resolver.
+const (
+	CodeUndefined = "R001"
+	CodeUnused    = "R002"
+)
+
+// Resolve annotates program and reports identifiers that are not defined
+// anywhere as errors, and local variables that are never used as warnings.
+// Names that are neither declared by the program nor accepted by defined,
+// which may be nil, are undefined. Resolving a program again replaces its
+// annotations.
+func Resolve(program *ast.Program, defined func(name string) bool) []parser.Diagnostic {
+	r := &resolver{defined: defined}
+	r.program(program)
+	sort.SliceStable(r.diagnostics, func(i, j int) bool {
+		return r.diagnostics[i].Pos.Offset < r.diagnostics[j].Pos.Offset
+	})
+	return r.diagnostics
+}
+
+// Definitions resolves program as Resolve does, and returns the declaration
+// of each identifier of program that declares or uses a variable declared by
+// program: the first identifier binding the variable in its scope, by let,
+// parameter, catch, pattern or struct declaration.
+func Definitions(program *ast.Program) map[*ast.Identifier]*ast.Identifier {
+	r := &resolver{defs: map[*ast.Identifier]*ast.Identifier{}}
+	r.program(program)
+	return r.defs
+}
+
+// Globals returns the identifiers declaring the globals of program, in
+// order, each variable once.
+func Globals(program *ast.Program) []*ast.Identifier {
+	return declarations(nil, program.Statements)
+}
+
+// Declarations returns the identifiers declaring the variables of fn in
+// slot order: its parameters, then the names bound in its body, each once.
+func Declarations(fn *ast.FunctionLiteral) []*ast.Identifier {
+	return declarations(fn.Parameters, fn.Body.Statements)
+}
+
+func declarations(params []*ast.Identifier, stmts []ast.Statement) []*ast.Identifier {
+	s := &scope{slots: map[string]int{}}
+	for _, param := range params {
+		s.declare(param)
+	}
+	ast.Lets(stmts, func(let *ast.LetStatement) {
+		s.declare(let.Name)
+	})
+	return s.decls
+}
+
+type resolver struct {
+	defined     func(name string) bool
+	globals     map[string]*ast.Identifier
+	scopes      []*scope
+	diagnostics []parser.Diagnostic
+	// defs records declarations for Definitions, when it is not nil.
+	defs map[*ast.Identifier]*ast.Identifier
+}
+
+type scope struct {
+	slots map[string]int
+	names []string
+	used  []bool
+	// decls holds the first declaration of each variable.
+	decls []*ast.Identifier
+	// lets holds the first declaration of each variable bound by let.
+	lets []*ast.Identifier
+}
+
+func (s *scope) declare(ident *ast.Identifier) int {
+	if slot, ok := s.slots[ident.Value]; ok {
+		return slot
+	}
+	slot := len(s.names)
+	s.slots[ident.Value] = slot
+	s.names = append(s.names, ident.Value)
+	s.used = append(s.used, false)
+	s.decls = append(s.decls, ident)
+	return slot
+}
+
+func (r *resolver) program(program *ast.Program) {
+	r.globals = map[string]*ast.Identifier{}
+	for _, ident := range Globals(program) {
+		r.globals[ident.Value] = ident
+	}
+	for _, stmt := range program.Statements {
+		r.statement(stmt)
+	}
+}
+
+// define records that ident refers to the variable declared by decl.
+func (r *resolver) define(ident, decl *ast.Identifier) {
+	if r.defs != nil && decl != nil {
+		r.defs[ident] = decl
+	}
+}
+
+func (r *resolver) statement(stmt ast.Statement) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		r.expression(stmt.Value)
+		r.bind(stmt.Name)
+	case *ast.ReturnStatement:
+		r.expression(stmt.ReturnValue)
+	case *ast.ThrowStatement:
+		r.expression(stmt.Value)
+	case *ast.StructStatement:
+		r.bind(stmt.Name)
+	case *ast.ExpressionStatement:
+		r.expression(stmt.Expression)
+	}
+}
+
+func (r *resolver) block(block *ast.BlockStatement) {
+	for _, stmt := range block.Statements {
+		r.statement(stmt)
+	}
+}
+
+func (r *resolver) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.Identifier:
+		r.use(exp)
+	case *ast.PrefixExpression:
+		r.expression(exp.Right)
+	case *ast.IsNullExpression:
+		r.expression(exp.Value)
+	case *ast.InfixExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Right)
+	case *ast.IfExpression:
+		r.expression(exp.Condition)
+		r.block(exp.Consequence)
+		if exp.Alternative != nil {
+			r.block(exp.Alternative)
+		}
+	case *ast.ForExpression:
+		r.statement(&exp.Variable)
+		r.expression(exp.Condition)
+		r.block(exp.Loop)
+		r.statement(&exp.Update)
+	case *ast.TryExpression:
+		r.block(exp.Body)
+		if exp.Handler != nil {
+			r.bind(exp.Catch)
+			r.block(exp.Handler)
+		}
+		if exp.Finally != nil {
+			r.block(exp.Finally)
+		}
+	case *ast.FunctionLiteral:
+		r.function(exp)
+	case *ast.CallExpression:
+		if _, ok := ast.IsCallTo(exp, "quote"); ok {
+			// Only the unquoted parts of a quote are evaluated.
+			for _, arg := range exp.Arguments {
+				for _, unquoted := range ast.Unquotes(arg) {
+					r.expression(unquoted)
+				}
+			}
+			return
+		}
+		r.expression(exp.Function)
+		for _, arg := range exp.Arguments {
+			r.expression(arg)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range exp.Elements {
+			r.expression(el)
+		}
+	case *ast.IndexExpression:
+		r.expression(exp.Left)
+		r.expression(exp.Index)
+	case *ast.HashLiteral:
+		for _, key := range exp.Keys {
+			r.expression(key)
+			r.expression(exp.Pairs[key])
+		}
+	case *ast.StructLiteral:
+		r.use(exp.Name)
+		if exp.Base != nil {
+			r.expression(exp.Base)
+		}
+		for _, value := range exp.Values {
+			r.expression(value)
+		}
+	case *ast.FieldExpression:
+		r.expression(exp.Left)
+	case *ast.MatchExpression:
+		r.expression(exp.Subject)
+		for _, arm := range exp.Arms {
+			for _, name := range ast.PatternBindings(arm.Pattern) {
+				r.bind(name)
+			}
+			if arm.Guard != nil {
+				r.expression(arm.Guard)
+			}
+			if arm.Body != nil {
+				r.block(arm.Body)
+			} else {
+				r.expression(arm.Value)
+			}
+		}
+	}
+}
+
+func (r *resolver) function(fn *ast.FunctionLiteral) {
+	s := &scope{slots: map[string]int{}}
+	for _, param := range fn.Parameters {
+		s.declare(param)
+	}
+	ast.Lets(fn.Body.Statements, func(let *ast.LetStatement) {
+		if _, ok := s.slots[let.Name.Value]; !ok {
+			s.lets = append(s.lets, let.Name)
+		}
+		s.declare(let.Name)
+	})
+
+	r.scopes = append(r.scopes, s)
+	for _, param := range fn.Parameters {
+		if param.Default != nil {
+			r.expression(param.Default)
+		}
+		r.bind(param)
+	}
+	r.block(fn.Body)
+	r.scopes = r.scopes[:len(r.scopes)-1]
+
+	fn.Scope = &ast.Scope{Names: s.names}
+	for _, ident := range s.lets {
+		if !s.used[s.slots[ident.Value]] && !strings.HasPrefix(ident.Value, "_") {
+			r.report(ident, parser.Warning, CodeUnused,
+				"rename it to _"+ident.Value+" if it is needed for its side effects",
+				"%s declared and not used", ident.Value)
+		}
+	}
+}
+
+// bind annotates an identifier being declared in the innermost scope.
+func (r *resolver) bind(ident *ast.Identifier) {
+	ident.Binding = nil
+	if len(r.scopes) == 0 {
+		r.define(ident, r.globals[ident.Value])
+		return
+	}
+	s := r.scopes[len(r.scopes)-1]
+	slot := s.slots[ident.Value]
+	ident.Binding = &ast.Binding{Depth: 0, Slot: slot}
+	r.define(ident, s.decls[slot])
+}
+
+// use annotates an identifier being read.
+func (r *resolver) use(ident *ast.Identifier) {
+	ident.Binding = nil
+	for i := len(r.scopes) - 1; i >= 0; i-- {
+		s := r.scopes[i]
+		if slot, ok := s.slots[ident.Value]; ok {
+			s.used[slot] = true
+			ident.Binding = &ast.Binding{Depth: len(r.scopes) - 1 - i, Slot: slot}
+			r.define(ident, s.decls[slot])
+			return
+		}
+	}
+	if decl := r.globals[ident.Value]; decl != nil {
+		r.define(ident, decl)
+		return
+	}
+	if r.defined != nil && r.defined(ident.Value) {
+		return
+	}
+	r.report(ident, parser.Error, CodeUndefined, "",
+		"identifier not found: %s", ident.Value)
+}
+
+func (r *resolver) report(ident *ast.Identifier, severity parser.Severity, code, hint, format string, a ...interface{}) {
+	r.diagnostics = append(r.diagnostics, parser.Diagnostic{
+		Code:     code,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      ident.Token.Pos,
+		End:      ident.Token.End,
+		Hint:     hint,
+	})
+}
@@ -0,0 +1,213 @@
+package resolver
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/evaluator"
+	"github.com/SebastiaanWouters/verigo/evaluator_middle"
+	"github.com/SebastiaanWouters/verigo/evaluator_simple"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/object"
+	"github.com/SebastiaanWouters/verigo/parser"
+)
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors: %v", p.Errors())
+	}
+	return program
+}
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"let x = 1; x + len(\"a\")", nil},
+		{"let f = fn() { g() }; let g = fn() { 1 }; f()", nil},
+		{"let f = fn(a) { b };",
+			[]string{"1:17: error[R001]: identifier not found: b"}},
+		{"let f = fn() {\n  let x = 1;\n  let _y = 2;\n  3\n};",
+			[]string{"2:7: warning[R002]: x declared and not used (hint: rename it to _x if it is needed for its side effects)"}},
+		{"let f = fn(n) { let s = 0; for (let i = 0; i < n; let i = i + 1) { let s = s + i; } s };", nil},
+		{"let f = fn() { try { 1 } catch (e) { e } };", nil},
+		{"let f = fn(a, b = a + 1, ...c) { [b, c] };", nil},
+		{"let f = fn(a) { struct P { x }; P { x: a }.x };", nil},
+		{"let f = fn(v) { match (v) { [x, ...rest] if x > 0 => rest, {\"k\": k} => k, _ => 0 } };", nil},
+		{"let f = fn(v) { match (v) { [x, y] => x } };",
+			[]string{"1:33: warning[R002]: y declared and not used (hint: rename it to _y if it is needed for its side effects)"}},
+		{"let f = fn(a) { quote(b + unquote(a * 2)) };", nil},
+		{"quote(unquote(c))", []string{"1:15: error[R001]: identifier not found: c"}},
+		{"match (1) { x => y }", []string{"1:18: error[R001]: identifier not found: y"}},
+		{"let f = fn() { struct P { x }; 1 };",
+			[]string{"1:23: warning[R002]: P declared and not used (hint: rename it to _P if it is needed for its side effects)"}},
+		{"Q { x: 1 }.y;", []string{"1:1: error[R001]: identifier not found: Q"}},
+		{"let f = fn(a = zz) { a };",
+			[]string{"1:16: error[R001]: identifier not found: zz"}},
+		{"let f = fn() { try { 1 } catch (e) { 2 } };",
+			[]string{"1:33: warning[R002]: e declared and not used (hint: rename it to _e if it is needed for its side effects)"}},
+		{"zz; let f = fn() { let unused = yy; 1 };",
+			[]string{
+				"1:1: error[R001]: identifier not found: zz",
+				"1:24: warning[R002]: unused declared and not used (hint: rename it to _unused if it is needed for its side effects)",
+				"1:33: error[R001]: identifier not found: yy",
+			}},
+	}
+
+	for _, tt := range tests {
+		diagnostics := Resolve(parse(t, tt.input), evaluator.IsBuiltin)
+		if len(diagnostics) != len(tt.expected) {
+			t.Errorf("%q: wrong diagnostics. got=%v", tt.input, diagnostics)
+			continue
+		}
+		for i, d := range diagnostics {
+			if d.String() != tt.expected[i] {
+				t.Errorf("%q: wrong diagnostic. expected=%q, got=%q",
+					tt.input, tt.expected[i], d.String())
+			}
+		}
+	}
+}
+
+func TestDefined(t *testing.T) {
+	program := parse(t, "host + 1")
+	if diagnostics := Resolve(program, nil); len(diagnostics) != 1 {
+		t.Errorf("expected host to be undefined. got=%v", diagnostics)
+	}
+	defined := func(name string) bool { return name == "host" }
+	if diagnostics := Resolve(program, defined); len(diagnostics) != 0 {
+		t.Errorf("expected host to be defined. got=%v", diagnostics)
+	}
+}
+
+func TestBindings(t *testing.T) {
+	program := parse(t, `let g = 1; let f = fn(a, b) { let c = a; fn(d) { a + c + d + g } };`)
+	Resolve(program, nil)
+
+	outer := program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if got := outer.Scope.Names; len(got) != 3 || got[0] != "a" || got[1] != "b" || got[2] != "c" {
+		t.Fatalf("wrong outer scope. got=%v", got)
+	}
+	inner := outer.Body.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+	body := inner.Body.Statements[0].(*ast.ExpressionStatement).Expression
+
+	expected := []*ast.Binding{{Depth: 1, Slot: 0}, {Depth: 1, Slot: 2}, {Depth: 0, Slot: 0}, nil}
+	var idents []*ast.Identifier
+	for exp := body; ; {
+		infix, ok := exp.(*ast.InfixExpression)
+		if !ok {
+			idents = append([]*ast.Identifier{exp.(*ast.Identifier)}, idents...)
+			break
+		}
+		idents = append([]*ast.Identifier{infix.Right.(*ast.Identifier)}, idents...)
+		exp = infix.Left
+	}
+	for i, ident := range idents {
+		want, got := expected[i], ident.Binding
+		if (want == nil) != (got == nil) || (want != nil && *want != *got) {
+			t.Errorf("%s: wrong binding. expected=%v, got=%v", ident.Value, want, got)
+		}
+	}
+}
+
+func TestDefinitions(t *testing.T) {
+	input := `let g = 1;
+let f = fn(a, b) {
+	let c = a;
+	let c = c + g;
+	match (b) { [x] => x + c, _ => len(h) }
+};
+let h = f(g, [2]);`
+	program := parse(t, input)
+	defs := Definitions(program)
+	// Each identifier is written as name@line:col, and so is its definition.
+	var got []string
+	ast.Inspect(program, func(node ast.Node) bool {
+		if ident, ok := node.(*ast.Identifier); ok {
+			s := ident.Value + "@" + ident.Token.Pos.String()
+			if def, ok := defs[ident]; ok {
+				s += " -> " + def.Token.Pos.String()
+			}
+			got = append(got, s)
+		}
+		return true
+	})
+	expected := []string{
+		"g@1:5 -> 1:5", "f@2:5 -> 2:5", "a@2:12 -> 2:12", "b@2:15 -> 2:15",
+		"c@3:6 -> 3:6", "a@3:10 -> 2:12",
+		"c@4:6 -> 3:6", "c@4:10 -> 3:6", "g@4:14 -> 1:5",
+		"b@5:9 -> 2:15", "x@5:15 -> 5:15", "x@5:21 -> 5:15", "c@5:25 -> 3:6",
+		"len@5:33", "h@5:37 -> 7:5",
+		"h@7:5 -> 7:5", "f@7:9 -> 2:5", "g@7:11 -> 1:5",
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("wrong definitions.\nexpected=%q\ngot=     %q", expected, got)
+	}
+}
+
+func TestDeclarations(t *testing.T) {
+	program := parse(t, `let a = 1; let f = fn(x, y) { let z = 1; let x = 2; try { z } catch (e) { e } }; let a = 2;`)
+	names := func(idents []*ast.Identifier) string {
+		var s []string
+		for _, ident := range idents {
+			s = append(s, ident.Value+"@"+ident.Token.Pos.String())
+		}
+		return strings.Join(s, " ")
+	}
+	if got := names(Globals(program)); got != "a@1:5 f@1:16" {
+		t.Errorf("wrong globals. got=%q", got)
+	}
+	fn := program.Statements[1].(*ast.LetStatement).Value.(*ast.FunctionLiteral)
+	if got := names(Declarations(fn)); got != "x@1:23 y@1:26 z@1:35 e@1:70" {
+		t.Errorf("wrong declarations. got=%q", got)
+	}
+}
+
+// Resolved programs evaluate as they do without annotations, in every
+// evaluator.
+func TestResolvedEvaluation(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected int64
+	}{
+		{"let add = fn(a) { fn(b) { a + b } }; add(2)(3)", 5},
+		{"let fact = fn(n) { if (n < 2) { 1 } else { n * fact(n - 1) } }; fact(5)", 120},
+		{"let f = fn(n) { let s = 0; for (let i = 0; i < n; let i = i + 1) { let s = s + i; } s }; f(5)", 10},
+		{"let x = 10; let f = fn() { for (let i = 0; i < 3; let i = i + 1) { let x = x + 1; } x }; f() + x", 23},
+		{"let f = fn(a) { let g = fn() { a * 2 }; let a = 7; g() }; f(1)", 14},
+		{"let f = fn(a, b) { let tmp = a; let a = b; let b = tmp; a - b }; f(1, 3)", 2},
+	}
+
+	for _, tt := range tests {
+		for name, eval := range map[string]func(*ast.Program) object.Object{
+			"full": func(p *ast.Program) object.Object {
+				return evaluator.Eval(p, object.NewEnvironment(), nil, nil)
+			},
+			"middle": func(p *ast.Program) object.Object {
+				var ops int
+				return evaluator_middle.Eval(p, object.NewEnvironment(), &ops)
+			},
+			"simple": func(p *ast.Program) object.Object {
+				return evaluator_simple.Eval(p, object.NewEnvironment())
+			},
+		} {
+			unresolved := eval(parse(t, tt.input))
+			program := parse(t, tt.input)
+			if diagnostics := Resolve(program, evaluator.IsBuiltin); len(diagnostics) != 0 {
+				t.Fatalf("%q: unexpected diagnostics: %v", tt.input, diagnostics)
+			}
+			resolved := eval(program)
+			for _, obj := range []object.Object{unresolved, resolved} {
+				integer, ok := obj.(*object.Integer)
+				if !ok || integer.Value != tt.expected {
+					t.Errorf("%s: %q: expected %d. got=%s", name, tt.input, tt.expected, obj.Inspect())
+				}
+			}
+		}
+	}
+}
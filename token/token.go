@@ -1,5 +1,7 @@
 package token
 
+import "fmt"
+
 type TokenType string
 
 const (
@@ -28,12 +30,23 @@ const (
 	// Delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
+	ELLIPSIS  = "..."
+	DOT       = "."
+	ARROW     = "=>"
+
+	COALESCE       = "??"
+	OPTIONAL_INDEX = "?["
+	OPTIONAL_FIELD = "?."
 
 	LPAREN = "("
 	RPAREN = ")"
 	LBRACE = "{"
 	RBRACE = "}"
 
+	LBRACKET = "["
+	RBRACKET = "]"
+
 	// Keywords
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
@@ -43,22 +56,54 @@ const (
 	ELSE     = "ELSE"
 	FOR      = "FOR"
 	RETURN   = "RETURN"
+	THROW    = "THROW"
+	TRY      = "TRY"
+	CATCH    = "CATCH"
+	FINALLY  = "FINALLY"
+	NULL     = "NULL"
+	IS       = "IS"
+	STRUCT   = "STRUCT"
+	MATCH    = "MATCH"
+	MACRO    = "MACRO"
 )
 
+// Position is a location in the source. Line and Column are 1-based;
+// Column counts bytes.
+type Position struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+func (p Position) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
 type Token struct {
 	Type    TokenType
 	Literal string
+	Pos     Position // first character of the token
+	End     Position // just past the last character of the token
 }
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
-	"for":    FOR,
+	"fn":      FUNCTION,
+	"let":     LET,
+	"true":    TRUE,
+	"false":   FALSE,
+	"if":      IF,
+	"else":    ELSE,
+	"return":  RETURN,
+	"for":     FOR,
+	"throw":   THROW,
+	"try":     TRY,
+	"catch":   CATCH,
+	"finally": FINALLY,
+	"null":    NULL,
+	"is":      IS,
+	"struct":  STRUCT,
+	"match":   MATCH,
+	"macro":   MACRO,
 }
 
 func LookupIdent(identifier string) TokenType {
@@ -0,0 +1,809 @@
+package types
+
+import (
+	"fmt"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/parser"
+	"github.com/SebastiaanWouters/verigo/token"
+)
+
+// Diagnostic codes reported by the checker.
+const (
+	CodeOperator    = "T001"
+	CodeMismatch    = "T002"
+	CodeArity       = "T003"
+	CodeUnknownType = "T004"
+	CodeNotFunction = "T005"
+	CodeIndex       = "T006"
+	CodeStruct      = "T007"
+)
+
+// Check infers the types of program and reports the operations that would
+// fail. Names not declared by the program have the types given by globals,
+// or any if they are missing from it.
+func Check(program *ast.Program, globals map[string]Type) []parser.Diagnostic {
+	c := &checker{globals: globals}
+	c.push(nil)
+	for _, stmt := range program.Statements {
+		c.statement(stmt)
+	}
+	return c.diagnostics
+}
+
+type checker struct {
+	globals     map[string]Type
+	scopes      []*scope
+	diagnostics []parser.Diagnostic
+	// quiet suppresses reports while the body of a loop is checked to find
+	// the types its variables take.
+	quiet int
+}
+
+// scope holds the variables of a function, or of the program, in the state
+// reached at the point being checked.
+type scope struct {
+	vars map[string]variable
+	fn   *function
+}
+
+type variable struct {
+	typ      Type
+	declared Type // the annotation, if any
+}
+
+type function struct {
+	result  Type // the annotated result, if any
+	returns Type // join of the values returned
+}
+
+func (c *checker) push(fn *function) {
+	c.scopes = append(c.scopes, &scope{vars: map[string]variable{}, fn: fn})
+}
+
+func (c *checker) pop() {
+	c.scopes = c.scopes[:len(c.scopes)-1]
+}
+
+func (c *checker) current() *scope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+func (c *checker) lookup(name string) Type {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if v, ok := c.scopes[i].vars[name]; ok {
+			return v.typ
+		}
+	}
+	if t, ok := c.globals[name]; ok {
+		return t
+	}
+	return Any
+}
+
+// named returns the struct type a name in scope was declared as, if any.
+func (c *checker) named(name string) *Struct {
+	if sn, ok := c.lookup(name).(*StructName); ok {
+		return sn.Of
+	}
+	return nil
+}
+
+// snapshot copies the variables of the current scope.
+func (c *checker) snapshot() map[string]variable {
+	vars := make(map[string]variable, len(c.current().vars))
+	for name, v := range c.current().vars {
+		vars[name] = v
+	}
+	return vars
+}
+
+// merge sets the variables of the current scope to hold the types they have
+// on either of two paths.
+func (c *checker) merge(a, b map[string]variable) {
+	vars := map[string]variable{}
+	for name, v := range a {
+		if w, ok := b[name]; ok {
+			v.typ = join(v.typ, w.typ)
+		} else if v.declared == nil {
+			v.typ = Any
+		}
+		vars[name] = v
+	}
+	for name, w := range b {
+		if _, ok := a[name]; !ok {
+			if w.declared == nil {
+				w.typ = Any
+			}
+			vars[name] = w
+		}
+	}
+	c.current().vars = vars
+}
+
+func (c *checker) report(node ast.Node, code string, format string, a ...interface{}) {
+	if c.quiet > 0 {
+		return
+	}
+	tok := tokenOf(node)
+	c.diagnostics = append(c.diagnostics, parser.Diagnostic{
+		Code:     code,
+		Severity: parser.Error,
+		Message:  fmt.Sprintf(format, a...),
+		Pos:      tok.Pos,
+		End:      tok.End,
+	})
+}
+
+// annotation returns the type of an annotation, or nil if there is none or
+// it is invalid.
+func (c *checker) annotation(expr ast.TypeExpr) Type {
+	if expr == nil {
+		return nil
+	}
+	t, err := fromExpr(expr, c.named)
+	if err != nil {
+		c.report(expr, CodeUnknownType, "%s", err)
+		return nil
+	}
+	return t
+}
+
+// statement checks stmt and returns the type of its value.
+func (c *checker) statement(stmt ast.Statement) Type {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		c.let(stmt)
+		return Null
+	case *ast.ReturnStatement:
+		t := c.expression(stmt.ReturnValue)
+		if fn := c.current().fn; fn != nil {
+			fn.returns = join(fn.returns, t)
+			if fn.result != nil && !Assignable(t, fn.result) {
+				c.report(stmt.ReturnValue, CodeMismatch, "cannot use %s as %s in return", t, fn.result)
+			}
+		}
+		return t
+	case *ast.ThrowStatement:
+		c.expression(stmt.Value)
+		return Any
+	case *ast.StructStatement:
+		st := &Struct{Name: stmt.Name.Value}
+		for _, field := range stmt.Fields {
+			t := c.annotation(field.Type)
+			if t == nil {
+				t = Any
+			}
+			st.Fields = append(st.Fields, field.Value)
+			st.Types = append(st.Types, t)
+		}
+		c.current().vars[st.Name] = variable{typ: &StructName{Of: st}}
+		return Null
+	case *ast.ExpressionStatement:
+		return c.expression(stmt.Expression)
+	}
+	return Any
+}
+
+func (c *checker) let(stmt *ast.LetStatement) {
+	name := stmt.Name.Value
+	declared := c.annotation(stmt.Name.Type)
+	if declared == nil {
+		declared = c.current().vars[name].declared
+	}
+
+	// Functions may call themselves, so their signature is known before
+	// their body is checked.
+	if lit, ok := stmt.Value.(*ast.FunctionLiteral); ok && declared == nil {
+		c.current().vars[name] = variable{typ: c.signature(lit)}
+	}
+
+	t := c.expression(stmt.Value)
+	if declared != nil {
+		if !Assignable(t, declared) {
+			c.report(stmt.Value, CodeMismatch, "cannot use %s as %s in let %s", t, declared, name)
+		}
+		t = declared
+	}
+	c.current().vars[name] = variable{typ: t, declared: declared}
+}
+
+// signature returns the type of a function literal given by its
+// annotations alone.
+func (c *checker) signature(lit *ast.FunctionLiteral) *Func {
+	f := &Func{Variadic: lit.Variadic, Result: Any}
+	for _, param := range lit.Parameters {
+		t := Type(Any)
+		if param.Type != nil {
+			if pt, err := fromExpr(param.Type, c.named); err == nil {
+				t = pt
+			}
+		}
+		f.Params = append(f.Params, t)
+		if param.Default != nil {
+			f.Optional++
+		}
+	}
+	if lit.ReturnType != nil {
+		if rt, err := fromExpr(lit.ReturnType, c.named); err == nil {
+			f.Result = rt
+		}
+	}
+	return f
+}
+
+// block checks the statements of block and returns the type of its value.
+func (c *checker) block(block *ast.BlockStatement) Type {
+	var t Type = Null
+	for _, stmt := range block.Statements {
+		t = c.statement(stmt)
+	}
+	return t
+}
+
+func (c *checker) expression(exp ast.Expression) Type {
+	switch exp := exp.(type) {
+	case *ast.IntegerLiteral:
+		return Int
+	case *ast.StringLiteral:
+		return String
+	case *ast.Boolean:
+		return Bool
+	case *ast.NullLiteral:
+		return Null
+	case *ast.IsNullExpression:
+		c.expression(exp.Value)
+		return Bool
+	case *ast.FoldedExpression:
+		return c.expression(exp.Value)
+	case *ast.Identifier:
+		return c.lookup(exp.Value)
+	case *ast.PrefixExpression:
+		return c.prefix(exp)
+	case *ast.InfixExpression:
+		return c.infix(exp)
+	case *ast.IfExpression:
+		return c.ifExpression(exp)
+	case *ast.ForExpression:
+		c.forExpression(exp)
+		return Null
+	case *ast.TryExpression:
+		return c.tryExpression(exp)
+	case *ast.MatchExpression:
+		return c.matchExpression(exp)
+	case *ast.FunctionLiteral:
+		return c.function(exp)
+	case *ast.CallExpression:
+		return c.call(exp)
+	case *ast.ArrayLiteral:
+		var elem Type
+		for _, el := range exp.Elements {
+			elem = join(elem, c.expression(el))
+		}
+		if elem == nil {
+			elem = Any
+		}
+		return &Array{Elem: elem}
+	case *ast.HashLiteral:
+		var key, value Type
+		for _, k := range exp.Keys {
+			kt := c.expression(k)
+			if !hashable(kt) {
+				c.report(k, CodeIndex, "unusable as hash key: %s", objectType(kt))
+			}
+			key = join(key, kt)
+			value = join(value, c.expression(exp.Pairs[k]))
+		}
+		if key == nil || !hashable(key) {
+			key = Any
+		}
+		if value == nil {
+			value = Any
+		}
+		return &Hash{Key: key, Value: value}
+	case *ast.IndexExpression:
+		return c.index(exp)
+	case *ast.StructLiteral:
+		return c.structLiteral(exp)
+	case *ast.FieldExpression:
+		return c.field(exp)
+	}
+	return Any
+}
+
+func (c *checker) structLiteral(exp *ast.StructLiteral) Type {
+	var base Type
+	if exp.Base != nil {
+		base = c.expression(exp.Base)
+	}
+	values := make([]Type, len(exp.Values))
+	for i, value := range exp.Values {
+		values[i] = c.expression(value)
+	}
+
+	name := c.lookup(exp.Name.Value)
+	sn, ok := name.(*StructName)
+	if !ok {
+		if name != Any {
+			c.report(exp.Name, CodeStruct, "not a struct: %s", exp.Name.Value)
+		}
+		return Any
+	}
+	st := sn.Of
+	if base != nil && !Assignable(base, st) {
+		c.report(exp.Base, CodeMismatch, "cannot copy the fields of %s into %s", base, st)
+	}
+	for i, field := range exp.Fields {
+		t, ok := st.Field(field.Value)
+		if !ok {
+			c.report(field, CodeStruct, "unknown field %s of struct %s", field.Value, st.Name)
+			continue
+		}
+		if !Assignable(values[i], t) {
+			c.report(exp.Values[i], CodeMismatch, "cannot use %s as %s in field %s of %s",
+				values[i], t, field.Value, st.Name)
+		}
+	}
+	return st
+}
+
+func (c *checker) field(exp *ast.FieldExpression) Type {
+	left := c.expression(exp.Left)
+	if exp.Optional && left == Null {
+		return Null
+	}
+	switch left := left.(type) {
+	case *Struct:
+		if t, ok := left.Field(exp.Field.Value); ok {
+			return t
+		}
+		c.report(exp.Field, CodeStruct, "unknown field %s of struct %s", exp.Field.Value, left.Name)
+	case *Hash:
+		return left.Value
+	case Basic:
+		if left == Any {
+			return Any
+		}
+		c.report(exp.Left, CodeStruct, "field access not supported: %s", objectType(left))
+	default:
+		c.report(exp.Left, CodeStruct, "field access not supported: %s", objectType(left))
+	}
+	return Any
+}
+
+func (c *checker) prefix(exp *ast.PrefixExpression) Type {
+	right := c.expression(exp.Right)
+	switch exp.Operator {
+	case "!":
+		return Bool
+	case "-":
+		if right != Any && right != Int {
+			c.report(exp, CodeOperator, "unknown operator: -%s", objectType(right))
+		}
+		return Int
+	}
+	return Any
+}
+
+func (c *checker) infix(exp *ast.InfixExpression) Type {
+	left := c.expression(exp.Left)
+	right := c.expression(exp.Right)
+	op := exp.Operator
+
+	if op == "??" {
+		// Only null and values of type any may be null.
+		switch left {
+		case Null:
+			return right
+		case Any:
+			return Any
+		}
+		return left
+	}
+
+	comparison := op == "==" || op == "!=" || op == "<" || op == ">"
+	if left == Any || right == Any {
+		other := left
+		if left == Any {
+			other = right
+		}
+		switch {
+		case op == "==" || op == "!=":
+			return Bool
+		case comparison:
+			if other != Any && other != Int {
+				break
+			}
+			return Bool
+		case op == "+" && (other == Int || other == String):
+			return other
+		case op == "+":
+			if other != Any {
+				break
+			}
+			return Any
+		default:
+			if other != Any && other != Int {
+				break
+			}
+			return Int
+		}
+	} else {
+		switch {
+		case left == Int && right == Int:
+			if comparison {
+				return Bool
+			}
+			return Int
+		case left == String && right == String:
+			if op == "+" {
+				return String
+			}
+		case left == Bool && right == Bool:
+			if op == "==" || op == "!=" {
+				return Bool
+			}
+		case op == "==" || op == "!=":
+			return Bool
+		}
+	}
+
+	// Report the operand types the evaluators would see; an any operand is
+	// reported as the type of the other one.
+	lt, rt := left, right
+	if lt == Any {
+		lt = rt
+	}
+	if rt == Any {
+		rt = lt
+	}
+	c.report(exp, CodeOperator, "unknown operator: %s %s %s", objectType(lt), op, objectType(rt))
+	return Any
+}
+
+func (c *checker) ifExpression(exp *ast.IfExpression) Type {
+	c.expression(exp.Condition)
+	before := c.snapshot()
+
+	consequence := c.block(exp.Consequence)
+	afterConsequence := c.snapshot()
+
+	c.current().vars = before
+	var alternative Type = Null
+	if exp.Alternative != nil {
+		alternative = c.block(exp.Alternative)
+	}
+	c.merge(afterConsequence, c.current().vars)
+	return join(consequence, alternative)
+}
+
+// tryExpression checks the handler with the variables holding the types they
+// have before or after the body, as it may fail anywhere. A caught error is
+// a hash of its fields.
+func (c *checker) tryExpression(exp *ast.TryExpression) Type {
+	before := c.snapshot()
+	t := c.block(exp.Body)
+	if exp.Handler != nil {
+		afterBody := c.snapshot()
+		c.merge(before, afterBody)
+		c.current().vars[exp.Catch.Value] = variable{typ: &Hash{Key: String, Value: Any}}
+		t = join(t, c.block(exp.Handler))
+		c.merge(afterBody, c.current().vars)
+	}
+	if exp.Finally != nil {
+		c.block(exp.Finally)
+	}
+	return t
+}
+
+// matchExpression checks each arm with the variables holding the types they
+// have before the match, and the names its pattern binds those of the parts
+// of the subject they are bound to.
+func (c *checker) matchExpression(exp *ast.MatchExpression) Type {
+	subject := c.expression(exp.Subject)
+	before := c.snapshot()
+	after := before
+	var t Type
+	for i, arm := range exp.Arms {
+		c.current().vars = make(map[string]variable, len(before))
+		for name, v := range before {
+			c.current().vars[name] = v
+		}
+		c.pattern(arm.Pattern, subject)
+		if arm.Guard != nil {
+			c.expression(arm.Guard)
+		}
+		var value Type
+		if arm.Body != nil {
+			value = c.block(arm.Body)
+		} else {
+			value = c.expression(arm.Value)
+		}
+		t = join(t, value)
+		if i == 0 {
+			after = c.snapshot()
+		} else {
+			c.merge(after, c.current().vars)
+			after = c.snapshot()
+		}
+	}
+	// No arm may match, leaving the variables as they were.
+	c.merge(after, before)
+	if t == nil {
+		t = Any
+	}
+	return t
+}
+
+// pattern checks that p can match values of type t and binds the names it
+// binds.
+func (c *checker) pattern(p ast.Pattern, t Type) {
+	switch p := p.(type) {
+	case *ast.BindingPattern:
+		name := p.Name.Value
+		declared := c.current().vars[name].declared
+		if declared != nil {
+			if !Assignable(t, declared) {
+				c.report(p, CodeMismatch, "cannot use %s as %s in pattern binding %s", t, declared, name)
+			}
+			t = declared
+		}
+		c.current().vars[name] = variable{typ: t, declared: declared}
+	case *ast.LiteralPattern:
+		if _, ok := p.Value.(*ast.NullLiteral); ok {
+			return
+		}
+		lt := c.expression(p.Value)
+		if !Assignable(lt, t) {
+			c.report(p, CodeMismatch, "pattern %s can never match %s", p, t)
+		}
+	case *ast.ArrayPattern:
+		var elem Type = Any
+		switch st := t.(type) {
+		case *Array:
+			elem = st.Elem
+		default:
+			if t != Any {
+				c.report(p, CodeMismatch, "pattern %s can never match %s", p, t)
+			}
+		}
+		for _, el := range p.Elements {
+			c.pattern(el, elem)
+		}
+		if p.Rest != nil && p.Rest.Value != "_" {
+			c.pattern(&ast.BindingPattern{Name: p.Rest}, &Array{Elem: elem})
+		}
+	case *ast.HashPattern:
+		var value Type = Any
+		switch st := t.(type) {
+		case *Hash:
+			value = st.Value
+		default:
+			if t != Any {
+				c.report(p, CodeMismatch, "pattern %s can never match %s", p, t)
+			}
+		}
+		for _, v := range p.Values {
+			c.pattern(v, value)
+		}
+	}
+}
+
+func (c *checker) forExpression(exp *ast.ForExpression) {
+	c.statement(&exp.Variable)
+
+	// The first iteration is checked with the types the variables have on
+	// entry. Later ones are only checked to find the types they leave the
+	// variables with, as a variable whose type changes has type any by then.
+	before := c.snapshot()
+	c.loop(exp)
+	c.merge(before, c.current().vars)
+
+	entry := c.snapshot()
+	c.quiet++
+	c.loop(exp)
+	c.quiet--
+	c.merge(entry, c.current().vars)
+}
+
+func (c *checker) loop(exp *ast.ForExpression) {
+	c.expression(exp.Condition)
+	c.block(exp.Loop)
+	c.statement(&exp.Update)
+}
+
+func (c *checker) function(lit *ast.FunctionLiteral) Type {
+	f := &Func{Variadic: lit.Variadic}
+	fn := &function{result: c.annotation(lit.ReturnType)}
+	c.push(fn)
+	for i, param := range lit.Parameters {
+		t := c.annotation(param.Type)
+		if param.Default != nil {
+			f.Optional++
+			if dt := c.expression(param.Default); t != nil && !Assignable(dt, t) {
+				c.report(param.Default, CodeMismatch, "cannot use %s as %s in default of %s", dt, t, param.Value)
+			}
+		}
+		if t == nil {
+			t = Any
+		}
+		f.Params = append(f.Params, t)
+		if lit.Variadic && i == len(lit.Parameters)-1 {
+			// The rest parameter holds the remaining arguments.
+			t = &Array{Elem: t}
+		}
+		if param.Type == nil {
+			c.current().vars[param.Value] = variable{typ: t}
+			continue
+		}
+		c.current().vars[param.Value] = variable{typ: t, declared: t}
+	}
+
+	value := c.block(lit.Body)
+	if n := len(lit.Body.Statements); n > 0 {
+		if last, ok := lit.Body.Statements[n-1].(*ast.ExpressionStatement); ok {
+			fn.returns = join(fn.returns, value)
+			if fn.result != nil && !Assignable(value, fn.result) {
+				c.report(last.Expression, CodeMismatch, "cannot use %s as %s in return", value, fn.result)
+			}
+		}
+	}
+	c.pop()
+
+	switch {
+	case fn.result != nil:
+		f.Result = fn.result
+	case fn.returns != nil:
+		f.Result = fn.returns
+	default:
+		f.Result = Any
+	}
+	return f
+}
+
+// quote checks the unquoted parts of a quote, the only ones evaluated.
+func (c *checker) quote(exp *ast.CallExpression) Type {
+	if len(exp.Arguments) != 1 {
+		c.report(exp, CodeArity, "wrong number of arguments to `quote`. got=%d, want=1", len(exp.Arguments))
+	}
+	for _, arg := range exp.Arguments {
+		for _, unquoted := range ast.Unquotes(arg) {
+			c.expression(unquoted)
+		}
+	}
+	return Any
+}
+
+func (c *checker) call(exp *ast.CallExpression) Type {
+	if _, ok := ast.IsCallTo(exp, "quote"); ok {
+		return c.quote(exp)
+	}
+	callee := c.expression(exp.Function)
+	args := make([]Type, len(exp.Arguments))
+	for i, arg := range exp.Arguments {
+		args[i] = c.expression(arg)
+	}
+
+	f, ok := callee.(*Func)
+	if !ok {
+		if callee != Any {
+			c.report(exp.Function, CodeNotFunction, "not a function: %s", objectType(callee))
+		}
+		return Any
+	}
+
+	name := "function"
+	if ident, ok := exp.Function.(*ast.Identifier); ok {
+		name = "`" + ident.Value + "`"
+	}
+	switch min, max := f.arity(); {
+	case min == max && len(args) != min:
+		c.report(exp, CodeArity, "wrong number of arguments to %s. got=%d, want=%d", name, len(args), min)
+		return f.Result
+	case len(args) < min:
+		c.report(exp, CodeArity, "wrong number of arguments to %s. got=%d, want at least %d", name, len(args), min)
+		return f.Result
+	case max >= 0 && len(args) > max:
+		c.report(exp, CodeArity, "wrong number of arguments to %s. got=%d, want at most %d", name, len(args), max)
+		return f.Result
+	}
+	for i, arg := range args {
+		param := f.Params[len(f.Params)-1]
+		if i < len(f.Params) {
+			param = f.Params[i]
+		}
+		if !Assignable(arg, param) {
+			c.report(exp.Arguments[i], CodeMismatch, "cannot use %s as %s in argument %d to %s",
+				arg, param, i+1, name)
+		}
+	}
+	return f.Result
+}
+
+func (c *checker) index(exp *ast.IndexExpression) Type {
+	left := c.expression(exp.Left)
+	if exp.Optional && left == Null {
+		return Null
+	}
+	index := c.expression(exp.Index)
+	switch left := left.(type) {
+	case *Array:
+		if index == Any || index == Int {
+			return left.Elem
+		}
+	case *Hash:
+		if !hashable(index) {
+			c.report(exp.Index, CodeIndex, "unusable as hash key: %s", objectType(index))
+		}
+		return left.Value
+	case Basic:
+		if left == Any {
+			return Any
+		}
+	}
+	c.report(exp, CodeIndex, "index operator not supported: %s", objectType(left))
+	return Any
+}
+
+// tokenOf returns the token locating node in the source.
+func tokenOf(node ast.Node) token.Token {
+	switch node := node.(type) {
+	case *ast.Identifier:
+		return node.Token
+	case *ast.IntegerLiteral:
+		return node.Token
+	case *ast.StringLiteral:
+		return node.Token
+	case *ast.Boolean:
+		return node.Token
+	case *ast.NullLiteral:
+		return node.Token
+	case *ast.IsNullExpression:
+		return tokenOf(node.Value)
+	case *ast.PrefixExpression:
+		return node.Token
+	case *ast.InfixExpression:
+		return node.Token
+	case *ast.IfExpression:
+		return node.Token
+	case *ast.ForExpression:
+		return node.Token
+	case *ast.TryExpression:
+		return node.Token
+	case *ast.FunctionLiteral:
+		return node.Token
+	case *ast.CallExpression:
+		return tokenOf(node.Function)
+	case *ast.ArrayLiteral:
+		return node.Token
+	case *ast.HashLiteral:
+		return node.Token
+	case *ast.IndexExpression:
+		return tokenOf(node.Left)
+	case *ast.StructLiteral:
+		return node.Name.Token
+	case *ast.FieldExpression:
+		return tokenOf(node.Left)
+	case *ast.MatchExpression:
+		return node.Token
+	case *ast.LiteralPattern:
+		return tokenOf(node.Value)
+	case *ast.WildcardPattern:
+		return node.Token
+	case *ast.BindingPattern:
+		return node.Name.Token
+	case *ast.ArrayPattern:
+		return node.Token
+	case *ast.HashPattern:
+		return node.Token
+	case *ast.NamedType:
+		return node.Token
+	case *ast.ArrayType:
+		return node.Token
+	case *ast.HashType:
+		return node.Token
+	case *ast.FunctionType:
+		return node.Token
+	}
+	return token.Token{}
+}
@@ -0,0 +1,164 @@
+package types
+
+import (
+	"testing"
+
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/parser"
+)
+
+var testGlobals = map[string]Type{
+	"len":   MustParse("fn(any): int"),
+	"pow":   MustParse("fn(int, int): int"),
+	"print": MustParse("fn(...any): null"),
+}
+
+func check(t *testing.T, input string) []string {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parse errors for %q: %v", input, p.Errors())
+	}
+	var got []string
+	for _, d := range Check(program, testGlobals) {
+		got = append(got, d.String())
+	}
+	return got
+}
+
+func TestWellTyped(t *testing.T) {
+	tests := []string{
+		`let x: int = 1; let y = x * 2 + len("ab"); y < 3`,
+		`let s = "a" + "b"; s == 1; [1, "a"] != [2]`,
+		`let add = fn(a: int, b: int): int { a + b }; add(1, 2) - 3`,
+		`let f = fn(x) { x + 1 }; f(true)`,
+		`let fact = fn(n: int): int { if (n < 2) { return 1; } n * fact(n - 1) }; fact(5)`,
+		`let h = {"a": 1, 2: true}; h["a"]; h[true]`,
+		`let arr: [int] = [1, 2]; arr[0] + 1; let e: [string] = []`,
+		`let x = 1; if (x > 0) { let x = "s"; } x`,
+		`let s = 0; for (let i = 0; i < 10; let i = i + 1) { let s = s + i; } s * 2`,
+		`let apply = fn(f: fn(int): int, x: int): int { f(x) }; apply(fn(y) { y * 2 }, 3)`,
+		`print(); print(1, "a", true)`,
+		`let x = if (true) { 1 } else { "a" }; x + 1`,
+		`let id = fn(x) { x }; id(1) + id("a")`,
+		`let x: int = null ?? 1; let f = fn(y) { y ?? 0 }; x + f(null) + 1`,
+		`let h = {"a": 1}; h?["a"] + 1; let n = null; n?[0] is null; n == null`,
+		`struct P { x: int }; let p = P { x: 1 }; p?.x + 1; let n = null; n?.x is null`,
+		`let f = fn(a: int, b: int = a * 2): int { a + b }; f(1) + f(1, 2)`,
+		`let sum = fn(first: int, ...rest: int): int { first + len(rest) + rest[0] }; sum(1) + sum(1, 2, 3)`,
+		`struct P { x: int, y }; let p: P = P { x: 1, y: "a" }; let q = P { ...p, x: 2 }; p.x + q.x; p.y`,
+		`struct P { x: int }; let norm = fn(p: P): int { p.x * p.x }; norm(P { x: 3 }) + 1`,
+		`let h = {"a": 1}; h.a + 1; let f = fn(v) { v.anything }`,
+		`let q = quote(x + "a" - unquote(1 + 2)); q`,
+		`let n = match ([1, 2]) { [a, ...r] => a + r[0], _ => 0 }; n * 2`,
+		`let f = fn(v) { match (v) { 1 => "a", "b" => "c", [x] => x, {"k": k} => k, _ => null } }`,
+		`let h = {"k": 1}; match (h) { {"k": v} if v > 0 => v + 1, _ => 0 } - 1`,
+	}
+
+	for _, input := range tests {
+		if got := check(t, input); len(got) != 0 {
+			t.Errorf("%q: unexpected diagnostics: %v", input, got)
+		}
+	}
+}
+
+func TestIllTyped(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`1 + true`, "1:3: error[T001]: unknown operator: INTEGER + BOOLEAN"},
+		{`quote(unquote(1 + "a"))`, "1:17: error[T001]: unknown operator: INTEGER + STRING"},
+		{`match (1) { "a" => 1, _ => 2 }`, "1:13: error[T002]: pattern \"a\" can never match int"},
+		{`match ("s") { [x] => x, _ => 2 }`, "1:15: error[T002]: pattern [x] can never match string"},
+		{`match ([1]) { [x] => x + "a", _ => 2 }`, "1:24: error[T001]: unknown operator: INTEGER + STRING"},
+		{`let x: int = 1; match ("s") { x => x }`, "1:31: error[T002]: cannot use string as int in pattern binding x"},
+		{`"a" - "b"`, "1:5: error[T001]: unknown operator: STRING - STRING"},
+		{`-"a"`, "1:1: error[T001]: unknown operator: -STRING"},
+		{`null + 1`, "1:6: error[T001]: unknown operator: NULL + INTEGER"},
+		{`let f = fn(x) { x + true }`, "1:19: error[T001]: unknown operator: BOOLEAN + BOOLEAN"},
+		{`pow("a", 2)`, "1:5: error[T002]: cannot use string as int in argument 1 to `pow`"},
+		{`pow(1)`, "1:1: error[T003]: wrong number of arguments to `pow`. got=1, want=2"},
+		{`let x: int = "a"`, "1:14: error[T002]: cannot use string as int in let x"},
+		{`let x: int = 1; let x = "a"`, "1:25: error[T002]: cannot use string as int in let x"},
+		{`let x: integer = 1`, "1:8: error[T004]: unknown type integer"},
+		{`let f = fn(): string { 1 }`, "1:24: error[T002]: cannot use int as string in return"},
+		{`let f = fn(n: int): bool { if (n > 0) { return n; } false }`,
+			"1:48: error[T002]: cannot use int as bool in return"},
+		{`let f = fn(a: int) { a }; f("x")`, "1:29: error[T002]: cannot use string as int in argument 1 to `f`"},
+		{`let x = 5; x(1)`, "1:12: error[T005]: not a function: INTEGER"},
+		{`5[0]`, "1:1: error[T006]: index operator not supported: INTEGER"},
+		{`[1]["a"]`, "1:1: error[T006]: index operator not supported: ARRAY"},
+		{`{[1]: 2}`, "1:2: error[T006]: unusable as hash key: ARRAY"},
+		{`let s = 0; for (let i = 0; i < 3; let i = i + 1) { let s = s + "x"; }`,
+			"1:62: error[T001]: unknown operator: INTEGER + STRING"},
+		{`let f = fn(g: fn(int): int) { g(1) }; f(fn(s: string) { s })`,
+			"1:41: error[T002]: cannot use fn(string): string as fn(int): int in argument 1 to `f`"},
+		{`let f = fn(a, b = 1) { a }; f()`, "1:29: error[T003]: wrong number of arguments to `f`. got=0, want at least 1"},
+		{`let f = fn(a, b = 1) { a }; f(1, 2, 3)`, "1:29: error[T003]: wrong number of arguments to `f`. got=3, want at most 2"},
+		{`let f = fn(a: int = "x") { a }`, "1:21: error[T002]: cannot use string as int in default of a"},
+		{`let f = fn(...xs: int) { xs }; f(1, "a")`, "1:37: error[T002]: cannot use string as int in argument 2 to `f`"},
+		{`let f = fn(...xs: int) { xs + 1 }`, "1:29: error[T001]: unknown operator: ARRAY + INTEGER"},
+		{`struct P { x: int }; P { x: "a" }`, "1:29: error[T002]: cannot use string as int in field x of P"},
+		{`struct P { x }; P { z: 1 }`, "1:21: error[T007]: unknown field z of struct P"},
+		{`struct P { x }; let p = P { x: 1 }; p.z`, "1:39: error[T007]: unknown field z of struct P"},
+		{`let n = 1; n { x: 1 }`, "1:12: error[T007]: not a struct: n"},
+		{`5.x`, "1:1: error[T007]: field access not supported: INTEGER"},
+		{`struct P { x }; struct Q { x }; P { ...Q { x: 1 } }`, "1:40: error[T002]: cannot copy the fields of Q into P"},
+		{`struct P { x }; let f = fn(p: P) { p }; f(1)`, "1:43: error[T002]: cannot use int as P in argument 1 to `f`"},
+	}
+
+	for _, tt := range tests {
+		got := check(t, tt.input)
+		if len(got) != 1 || got[0] != tt.expected {
+			t.Errorf("%q: wrong diagnostics.\nexpected=%q\ngot=     %q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []string{
+		"int", "any", "[string]", "{string: [int]}", "fn(int, ...any): bool", "fn(): any",
+		"fn(fn(int): int): {bool: null}",
+	}
+	for _, input := range tests {
+		typ, err := Parse(input)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %s", input, err)
+			continue
+		}
+		if typ.String() != input {
+			t.Errorf("wrong type. expected=%q, got=%q", input, typ.String())
+		}
+	}
+
+	for _, input := range []string{"", "foo", "[int", "{[int]: int}", "fn(...int, int)", "int int"} {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("%q: expected an error", input)
+		}
+	}
+}
+
+func TestAssignable(t *testing.T) {
+	tests := []struct {
+		value, target string
+		expected      bool
+	}{
+		{"int", "int", true},
+		{"int", "any", true},
+		{"any", "string", true},
+		{"int", "string", false},
+		{"[any]", "[int]", true},
+		{"[int]", "[string]", false},
+		{"{string: int}", "{string: any}", true},
+		{"fn(any): int", "fn(int): int", true},
+		{"fn(int): int", "fn(int, int): int", false},
+		{"fn(int): string", "fn(int): int", false},
+	}
+	for _, tt := range tests {
+		if got := Assignable(MustParse(tt.value), MustParse(tt.target)); got != tt.expected {
+			t.Errorf("Assignable(%s, %s) = %t, want %t", tt.value, tt.target, got, tt.expected)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package types infers the types of Monkey programs and rejects those that
+// would fail with a type error when run.
+//
+// Type annotations are optional. Checking is gradual: what cannot be
+// inferred, such as an unannotated parameter, has type any, which is
+// compatible with every type, so that a program is only rejected for
+// operations that fail whatever the values of its untyped parts.
+package types
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/SebastiaanWouters/verigo/ast"
+	"github.com/SebastiaanWouters/verigo/lexer"
+	"github.com/SebastiaanWouters/verigo/parser"
+)
+
+// Type is the type of a Monkey value. Its String method returns it in the
+// syntax of annotations.
+type Type interface {
+	String() string
+}
+
+type Basic int
+
+const (
+	Any Basic = iota
+	Int
+	String
+	Bool
+	Null
+)
+
+var basicNames = map[string]Basic{
+	"any":    Any,
+	"int":    Int,
+	"string": String,
+	"bool":   Bool,
+	"null":   Null,
+}
+
+func (b Basic) String() string {
+	switch b {
+	case Int:
+		return "int"
+	case String:
+		return "string"
+	case Bool:
+		return "bool"
+	case Null:
+		return "null"
+	default:
+		return "any"
+	}
+}
+
+type Array struct {
+	Elem Type
+}
+
+func (a *Array) String() string { return "[" + a.Elem.String() + "]" }
+
+type Hash struct {
+	Key   Type
+	Value Type
+}
+
+func (h *Hash) String() string { return "{" + h.Key.String() + ": " + h.Value.String() + "}" }
+
+// Func is the type of functions. If Variadic is set, the last parameter
+// accepts any number of arguments. The Optional parameters before it have
+// default values, so their arguments may be omitted.
+type Func struct {
+	Params   []Type
+	Variadic bool
+	Optional int
+	Result   Type
+}
+
+// arity returns the least and the most number of arguments f accepts. The
+// most is -1 if f is variadic.
+func (f *Func) arity() (min, max int) {
+	if f.Variadic {
+		return len(f.Params) - f.Optional - 1, -1
+	}
+	return len(f.Params) - f.Optional, len(f.Params)
+}
+
+func (f *Func) String() string {
+	params := []string{}
+	for _, p := range f.Params {
+		params = append(params, p.String())
+	}
+	last := len(params)
+	if f.Variadic {
+		last--
+		params[last] = "..." + params[last]
+	}
+	for i := last - f.Optional; i < last; i++ {
+		params[i] += "?"
+	}
+	return "fn(" + strings.Join(params, ", ") + "): " + f.Result.String()
+}
+
+// Struct is the type of the values of a declared struct type. Fields not
+// annotated in the declaration have type any.
+type Struct struct {
+	Name   string
+	Fields []string
+	Types  []Type
+}
+
+func (s *Struct) String() string { return s.Name }
+
+// Field returns the type of the field called name.
+func (s *Struct) Field(name string) (Type, bool) {
+	for i, f := range s.Fields {
+		if f == name {
+			return s.Types[i], true
+		}
+	}
+	return nil, false
+}
+
+// StructName is the type of the name a struct declaration binds, which
+// stands for the struct type Of.
+type StructName struct {
+	Of *Struct
+}
+
+func (sn *StructName) String() string { return "struct " + sn.Of.Name }
+
+// objectType returns the name the evaluators use for values of t.
+func objectType(t Type) string {
+	switch t := t.(type) {
+	case Basic:
+		switch t {
+		case Int:
+			return "INTEGER"
+		case String:
+			return "STRING"
+		case Bool:
+			return "BOOLEAN"
+		case Null:
+			return "NULL"
+		}
+	case *Array:
+		return "ARRAY"
+	case *Hash:
+		return "HASH"
+	case *Func:
+		return "FUNCTION"
+	case *Struct:
+		return "STRUCT"
+	case *StructName:
+		return "STRUCT_TYPE"
+	}
+	return "any"
+}
+
+// Identical reports whether a and b are the same type.
+func Identical(a, b Type) bool {
+	return a.String() == b.String()
+}
+
+// Assignable reports whether a value of type v may be used where t is
+// expected.
+func Assignable(v, t Type) bool {
+	if v == Any || t == Any {
+		return true
+	}
+	switch t := t.(type) {
+	case Basic:
+		return v == t
+	case *Array:
+		va, ok := v.(*Array)
+		return ok && Assignable(va.Elem, t.Elem)
+	case *Hash:
+		vh, ok := v.(*Hash)
+		return ok && Assignable(vh.Key, t.Key) && Assignable(vh.Value, t.Value)
+	case *Func:
+		vf, ok := v.(*Func)
+		if !ok || len(vf.Params) != len(t.Params) || vf.Variadic != t.Variadic || vf.Optional != t.Optional {
+			return false
+		}
+		for i := range t.Params {
+			if !Assignable(t.Params[i], vf.Params[i]) {
+				return false
+			}
+		}
+		return Assignable(vf.Result, t.Result)
+	case *Struct:
+		vs, ok := v.(*Struct)
+		return ok && vs.Name == t.Name
+	}
+	return false
+}
+
+// join returns the most precise type of values that are either of type a
+// or of type b. A nil type is absent.
+func join(a, b Type) Type {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	case Identical(a, b):
+		return a
+	}
+	if aa, ok := a.(*Array); ok {
+		if ba, ok := b.(*Array); ok {
+			return &Array{Elem: join(aa.Elem, ba.Elem)}
+		}
+	}
+	if ah, ok := a.(*Hash); ok {
+		if bh, ok := b.(*Hash); ok {
+			return &Hash{Key: join(ah.Key, bh.Key), Value: join(ah.Value, bh.Value)}
+		}
+	}
+	return Any
+}
+
+func hashable(t Type) bool {
+	return t == Any || t == Int || t == String || t == Bool
+}
+
+// FromExpr returns the type denoted by an annotation.
+func FromExpr(expr ast.TypeExpr) (Type, error) {
+	return fromExpr(expr, nil)
+}
+
+// fromExpr is FromExpr with the struct types declared in the program,
+// which named looks up by name. named may be nil.
+func fromExpr(expr ast.TypeExpr, named func(string) *Struct) (Type, error) {
+	switch expr := expr.(type) {
+	case *ast.NamedType:
+		if t, ok := basicNames[expr.Name]; ok {
+			return t, nil
+		}
+		if named != nil {
+			if t := named(expr.Name); t != nil {
+				return t, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown type %s", expr.Name)
+	case *ast.ArrayType:
+		elem, err := fromExpr(expr.Elem, named)
+		if err != nil {
+			return nil, err
+		}
+		return &Array{Elem: elem}, nil
+	case *ast.HashType:
+		key, err := fromExpr(expr.Key, named)
+		if err != nil {
+			return nil, err
+		}
+		if !hashable(key) {
+			return nil, fmt.Errorf("unusable as hash key: %s", objectType(key))
+		}
+		value, err := fromExpr(expr.Value, named)
+		if err != nil {
+			return nil, err
+		}
+		return &Hash{Key: key, Value: value}, nil
+	case *ast.FunctionType:
+		f := &Func{Variadic: expr.Variadic, Result: Any}
+		for _, p := range expr.Parameters {
+			param, err := fromExpr(p, named)
+			if err != nil {
+				return nil, err
+			}
+			f.Params = append(f.Params, param)
+		}
+		if expr.Result != nil {
+			result, err := fromExpr(expr.Result, named)
+			if err != nil {
+				return nil, err
+			}
+			f.Result = result
+		}
+		return f, nil
+	}
+	return nil, fmt.Errorf("unexpected type expression %T", expr)
+}
+
+// Parse returns the type written s in the syntax of annotations.
+func Parse(s string) (Type, error) {
+	p := parser.New(lexer.New(s))
+	expr := p.ParseType()
+	if len(p.Errors()) != 0 {
+		return nil, errors.New(strings.Join(p.Errors(), "; "))
+	}
+	return FromExpr(expr)
+}
+
+// MustParse is like Parse but panics if s is not a valid type.
+func MustParse(s string) Type {
+	t, err := Parse(s)
+	if err != nil {
+		panic(fmt.Sprintf("types: %q: %s", s, err))
+	}
+	return t
+}
@@ -0,0 +1,58 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestReadWrite(t *testing.T) {
+	var buf bytes.Buffer
+	for _, msg := range []interface{}{map[string]string{"method": "exit"}, []int{1, 2}} {
+		if err := Write(&buf, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := buf.String(); !strings.HasPrefix(got, "Content-Length: 17\r\n\r\n{\"method\":\"exit\"}") {
+		t.Errorf("wrong framing. got=%q", got)
+	}
+	r := bufio.NewReader(&buf)
+	for _, expected := range []string{`{"method":"exit"}`, `[1,2]`} {
+		content, err := Read(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != expected {
+			t.Errorf("wrong content. expected=%q, got=%q", expected, content)
+		}
+	}
+	if _, err := Read(r); err != io.EOF {
+		t.Errorf("expected io.EOF at the end. got=%v", err)
+	}
+}
+
+func TestReadHeaders(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string // the content, or the error
+	}{
+		{"content-length: 2\r\nContent-Type: application/vscode-jsonrpc; charset=utf-8\r\n\r\n{}", "{}"},
+		{"Content-Length: x\r\n\r\n", `wire: bad Content-Length " x"`},
+		{"Content-Type: json\r\n\r\n{}", "wire: message without Content-Length"},
+		{"Content-Length 2\r\n\r\n{}", `wire: malformed header "Content-Length 2"`},
+		{"Content-Length: 5\r\n\r\n{}", "wire: reading content: unexpected EOF"},
+		{"Content-Length: 2\r\n", "wire: reading header: EOF"},
+	}
+	for _, tt := range tests {
+		content, err := Read(bufio.NewReader(strings.NewReader(tt.input)))
+		got := string(content)
+		if err != nil {
+			got = err.Error()
+		}
+		if got != tt.expected {
+			t.Errorf("%q: expected=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}